@@ -0,0 +1,310 @@
+package dnsdnssec
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	dnsresolver "github.com/binaryfigments/goharvest/dns/resolver"
+	"github.com/miekg/dns"
+	"golang.org/x/net/idna"
+)
+
+// Data struct. ChainValid covers one delegation: the DS set signed by the
+// DNSKEYs of the parent zone, the DNSKEY set signed by a key the DS set
+// vouches for and the SOA and NS sets signed by the DNSKEY set. The keys of
+// the parent are not validated up to the root.
+type Data struct {
+	Domain       string    `json:"domain,omitempty"`
+	Parent       string    `json:"parent,omitempty"`
+	CheckTime    time.Time `json:"time"`
+	DNSSEC       bool      `json:"dnssec"`
+	ChainValid   bool      `json:"chainvalid"`
+	DNSKEY       []*DNSKEY `json:"dnskey,omitempty"`
+	DS           []*DS     `json:"ds,omitempty"`
+	RRSIG        []*RRSIG  `json:"rrsig,omitempty"`
+	Error        string    `json:"error,omitempty"`
+	ErrorMessage string    `json:"errormessage,omitempty"`
+}
+
+// DNSKEY struct for a key in the zone's DNSKEY set
+type DNSKEY struct {
+	Algorithm     uint8  `json:"algorithm"`
+	AlgorithmName string `json:"algorithmname,omitempty"`
+	KeyTag        uint16 `json:"keytag"`
+	Flags         uint16 `json:"flags"`
+	Type          string `json:"type,omitempty"`
+	Protocol      uint8  `json:"protocol"`
+	PublicKey     string `json:"publickey,omitempty"`
+	DSMatch       bool   `json:"dsmatch"`
+}
+
+// DS struct for a DS record from the parent zone
+type DS struct {
+	KeyTag     uint16 `json:"keytag"`
+	Algorithm  uint8  `json:"algorithm"`
+	DigestType uint8  `json:"digesttype"`
+	Digest     string `json:"digest,omitempty"`
+	Valid      bool   `json:"valid"`
+}
+
+// RRSIG struct for a signature over one of the checked RRsets
+type RRSIG struct {
+	TypeCovered  string    `json:"typecovered,omitempty"`
+	Algorithm    uint8     `json:"algorithm"`
+	KeyTag       uint16    `json:"keytag"`
+	SignerName   string    `json:"signername,omitempty"`
+	Inception    time.Time `json:"inception"`
+	Expiration   time.Time `json:"expiration"`
+	Valid        bool      `json:"valid"`
+	ErrorMessage string    `json:"errormessage,omitempty"`
+}
+
+// Get function
+func Get(domain string, nameserver string) *Data {
+	return GetWithResolver(domain, dnsresolver.Parse(nameserver))
+}
+
+// GetWithResolver is Get with a custom resolver.
+func GetWithResolver(domain string, resolver dnsresolver.Resolver) *Data {
+	r := new(Data)
+	r.Domain = domain
+	r.CheckTime = time.Now()
+
+	// Valid domain name (ASCII or IDN)
+	domain, err := idna.ToASCII(domain)
+	if err != nil {
+		r.Error = "Failed"
+		r.ErrorMessage = err.Error()
+		return r
+	}
+
+	// Check the zone domain is in, which may be a delegated subzone
+	domain, err = zoneCut(domain, resolver)
+	if err != nil {
+		r.Error = "Failed"
+		r.ErrorMessage = err.Error()
+		return r
+	}
+	r.Domain = domain
+
+	keys, keysigs, err := resolveRRset(domain, dns.TypeDNSKEY, resolver)
+	if err != nil {
+		r.Error = "Failed"
+		r.ErrorMessage = err.Error()
+		return r
+	}
+
+	dsset, dssigs, err := resolveRRset(domain, dns.TypeDS, resolver)
+	if err != nil {
+		r.Error = "Failed"
+		r.ErrorMessage = err.Error()
+		return r
+	}
+
+	soa, soasigs, err := resolveRRset(domain, dns.TypeSOA, resolver)
+	if err != nil {
+		r.Error = "Failed"
+		r.ErrorMessage = err.Error()
+		return r
+	}
+
+	ns, nssigs, err := resolveRRset(domain, dns.TypeNS, resolver)
+	if err != nil {
+		r.Error = "Failed"
+		r.ErrorMessage = err.Error()
+		return r
+	}
+
+	var dnskeys []*dns.DNSKEY
+	for _, rr := range keys {
+		if k, ok := rr.(*dns.DNSKEY); ok {
+			dnskeys = append(dnskeys, k)
+			r.DNSKEY = append(r.DNSKEY, newDNSKEY(k))
+		}
+	}
+
+	// DS records from the parent point to the key signing keys
+	var ksks []*dns.DNSKEY
+	for _, rr := range dsset {
+		d, ok := rr.(*dns.DS)
+		if !ok {
+			continue
+		}
+		ds := &DS{
+			KeyTag:     d.KeyTag,
+			Algorithm:  d.Algorithm,
+			DigestType: d.DigestType,
+			Digest:     strings.ToLower(d.Digest),
+		}
+		for i, k := range dnskeys {
+			if k.KeyTag() != d.KeyTag || k.Algorithm != d.Algorithm {
+				continue
+			}
+			if c := k.ToDS(d.DigestType); c != nil && strings.EqualFold(c.Digest, d.Digest) {
+				ds.Valid = true
+				r.DNSKEY[i].DSMatch = true
+				ksks = append(ksks, k)
+			}
+		}
+		r.DS = append(r.DS, ds)
+	}
+
+	r.DNSSEC = len(dnskeys) > 0 && len(r.DS) > 0
+
+	// The DS set must be signed by a key of the parent zone.
+	var dsValid bool
+	if len(dssigs) > 0 {
+		r.Parent = strings.TrimSuffix(dssigs[0].SignerName, ".")
+		if !isParent(r.Parent, domain) {
+			r.Error = "Failed"
+			r.ErrorMessage = "DS records signed by " + r.Parent + ", not by a parent zone."
+			return r
+		}
+		parentKeys, _, err := resolveRRset(r.Parent, dns.TypeDNSKEY, resolver)
+		if err != nil {
+			r.Error = "Failed"
+			r.ErrorMessage = err.Error()
+			return r
+		}
+		var parentDNSKEYs []*dns.DNSKEY
+		for _, rr := range parentKeys {
+			if k, ok := rr.(*dns.DNSKEY); ok {
+				parentDNSKEYs = append(parentDNSKEYs, k)
+			}
+		}
+		dsValid = verifyRRset(r, dsset, dssigs, parentDNSKEYs)
+	}
+
+	// The DNSKEY set must be signed by a key the parent vouches for, the
+	// SOA and NS sets by any key in the (now trusted) DNSKEY set.
+	keysValid := verifyRRset(r, keys, keysigs, ksks)
+	soaValid := verifyRRset(r, soa, soasigs, dnskeys)
+	nsValid := verifyRRset(r, ns, nssigs, dnskeys)
+
+	r.ChainValid = r.DNSSEC && dsValid && len(ksks) > 0 && keysValid && soaValid && nsValid
+
+	return r
+}
+
+/*
+ * Used functions
+ */
+
+func newDNSKEY(k *dns.DNSKEY) *DNSKEY {
+	key := &DNSKEY{
+		Algorithm:     k.Algorithm,
+		AlgorithmName: dns.AlgorithmToString[k.Algorithm],
+		KeyTag:        k.KeyTag(),
+		Flags:         k.Flags,
+		Protocol:      k.Protocol,
+		PublicKey:     k.PublicKey,
+	}
+	switch {
+	case k.Flags&dns.SEP != 0 && k.Flags&dns.ZONE != 0:
+		key.Type = "KSK"
+	case k.Flags&dns.ZONE != 0:
+		key.Type = "ZSK"
+	}
+	return key
+}
+
+// verifyRRset checks every RRSIG over rrset against the given keys, adds the
+// results to r and reports whether at least one signature is valid.
+func verifyRRset(r *Data, rrset []dns.RR, sigs []*dns.RRSIG, keys []*dns.DNSKEY) bool {
+	var valid bool
+	now := time.Now()
+	for _, sig := range sigs {
+		s := &RRSIG{
+			TypeCovered: dns.TypeToString[sig.TypeCovered],
+			Algorithm:   sig.Algorithm,
+			KeyTag:      sig.KeyTag,
+			SignerName:  sig.SignerName,
+			Inception:   time.Unix(int64(sig.Inception), 0).UTC(),
+			Expiration:  time.Unix(int64(sig.Expiration), 0).UTC(),
+		}
+		err := verifySignature(sig, rrset, keys)
+		switch {
+		case err != nil:
+			s.ErrorMessage = err.Error()
+		case !sig.ValidityPeriod(now):
+			s.ErrorMessage = "Signature is not in its validity period."
+		default:
+			s.Valid = true
+			valid = true
+		}
+		r.RRSIG = append(r.RRSIG, s)
+	}
+	return valid
+}
+
+func verifySignature(sig *dns.RRSIG, rrset []dns.RR, keys []*dns.DNSKEY) error {
+	if len(rrset) == 0 {
+		return errors.New("no records to verify")
+	}
+	for _, k := range keys {
+		if k.KeyTag() != sig.KeyTag || k.Algorithm != sig.Algorithm {
+			continue
+		}
+		if err := sig.Verify(k, rrset); err == nil {
+			return nil
+		}
+	}
+	return errors.New("no matching key verifies the signature")
+}
+
+// isParent reports whether parent is an ancestor zone of domain, the root
+// included.
+func isParent(parent string, domain string) bool {
+	return !strings.EqualFold(dns.Fqdn(parent), dns.Fqdn(domain)) && dns.IsSubDomain(dns.Fqdn(parent), dns.Fqdn(domain))
+}
+
+// zoneCut returns the zone name belongs to: name itself when it has a SOA
+// record, otherwise the owner of the SOA record a negative answer carries.
+func zoneCut(name string, resolver dnsresolver.Resolver) (string, error) {
+	m := new(dns.Msg)
+	m.SetQuestion(dns.Fqdn(name), dns.TypeSOA)
+	m.MsgHdr.RecursionDesired = true
+	in, err := resolver.Exchange(m)
+	if err != nil {
+		return "", err
+	}
+	for _, rr := range in.Answer {
+		if soa, ok := rr.(*dns.SOA); ok && strings.EqualFold(soa.Hdr.Name, dns.Fqdn(name)) {
+			return strings.TrimSuffix(name, "."), nil
+		}
+	}
+	for _, rr := range in.Ns {
+		if soa, ok := rr.(*dns.SOA); ok && dns.IsSubDomain(soa.Hdr.Name, dns.Fqdn(name)) {
+			return strings.TrimSuffix(soa.Hdr.Name, "."), nil
+		}
+	}
+	return "", errors.New("no zone found for " + name)
+}
+
+// resolveRRset returns the records of qtype for name and the RRSIGs covering them.
+func resolveRRset(name string, qtype uint16, resolver dnsresolver.Resolver) ([]dns.RR, []*dns.RRSIG, error) {
+	m := new(dns.Msg)
+	m.SetQuestion(dns.Fqdn(name), qtype)
+	m.MsgHdr.RecursionDesired = true
+	m.SetEdns0(4096, true)
+	in, err := resolver.Exchange(m)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var rrset []dns.RR
+	var sigs []*dns.RRSIG
+	for _, ain := range in.Answer {
+		if sig, ok := ain.(*dns.RRSIG); ok {
+			if sig.TypeCovered == qtype {
+				sigs = append(sigs, sig)
+			}
+			continue
+		}
+		if ain.Header().Rrtype == qtype {
+			rrset = append(rrset, ain)
+		}
+	}
+	return rrset, sigs, nil
+}
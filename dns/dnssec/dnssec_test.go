@@ -0,0 +1,148 @@
+package dnsdnssec
+
+import (
+	"crypto"
+	"strings"
+	"testing"
+	"time"
+
+	dnsresolver "github.com/binaryfigments/goharvest/dns/resolver"
+	"github.com/miekg/dns"
+)
+
+// key returns a new ECDSA P-256 key for zone.
+func key(t *testing.T, zone string, flags uint16) (*dns.DNSKEY, crypto.Signer) {
+	t.Helper()
+	k := &dns.DNSKEY{
+		Hdr:       dns.RR_Header{Name: zone, Rrtype: dns.TypeDNSKEY, Class: dns.ClassINET, Ttl: 3600},
+		Flags:     flags,
+		Protocol:  3,
+		Algorithm: dns.ECDSAP256SHA256,
+	}
+	priv, err := k.Generate(256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return k, priv.(crypto.Signer)
+}
+
+// sign returns the signature of rrset by k, valid from inception to
+// expiration.
+func sign(t *testing.T, rrset []dns.RR, k *dns.DNSKEY, priv crypto.Signer, inception, expiration time.Time) *dns.RRSIG {
+	t.Helper()
+	sig := &dns.RRSIG{
+		Hdr:        dns.RR_Header{Name: rrset[0].Header().Name, Rrtype: dns.TypeRRSIG, Class: dns.ClassINET, Ttl: 3600},
+		Algorithm:  k.Algorithm,
+		SignerName: k.Hdr.Name,
+		KeyTag:     k.KeyTag(),
+		Inception:  uint32(inception.Unix()),
+		Expiration: uint32(expiration.Unix()),
+	}
+	if err := sig.Sign(priv, rrset); err != nil {
+		t.Fatal(err)
+	}
+	return sig
+}
+
+// zoneOptions break the signed zone of signedZone.
+type zoneOptions struct {
+	unsigned  bool // no DS and no signatures
+	badDigest bool // DS that matches no key
+	expired   bool // SOA signature expired
+	dsSigner  bool // DS signed by the child zone
+}
+
+// signedZone returns sub.example. delegated from example. with a KSK and a
+// ZSK, the DS set signed by the key of example.
+func signedZone(t *testing.T, o zoneOptions) *dnsresolver.Zone {
+	t.Helper()
+	z := dnsresolver.MustZone(
+		`example. 3600 IN SOA ns.example. hostmaster.example. 1 3600 600 86400 60`,
+		`sub.example. 3600 IN SOA ns.sub.example. hostmaster.example. 1 3600 600 86400 60`,
+		`sub.example. 3600 IN NS ns.sub.example.`,
+		`ns.sub.example. 3600 IN A 192.0.2.53`,
+	)
+	if o.unsigned {
+		return z
+	}
+
+	now := time.Now()
+	from, until := now.Add(-time.Hour), now.Add(24*time.Hour)
+	parent, parentPriv := key(t, "example.", dns.ZONE|dns.SEP)
+	ksk, kskPriv := key(t, "sub.example.", dns.ZONE|dns.SEP)
+	zsk, zskPriv := key(t, "sub.example.", dns.ZONE)
+
+	ds := ksk.ToDS(dns.SHA256)
+	if o.badDigest {
+		ds.Digest = strings.Repeat("00", 32)
+	}
+	dsSigner, dsPriv := parent, parentPriv
+	if o.dsSigner {
+		dsSigner, dsPriv = ksk, kskPriv
+	}
+	keys := []dns.RR{ksk, zsk}
+	z.Records = append(z.Records, parent, ksk, zsk, ds,
+		sign(t, []dns.RR{ds}, dsSigner, dsPriv, from, until),
+		sign(t, keys, ksk, kskPriv, from, until),
+	)
+
+	for _, rr := range z.Records[:3] {
+		if rr.Header().Name != "sub.example." {
+			continue
+		}
+		if _, ok := rr.(*dns.SOA); ok && o.expired {
+			z.Records = append(z.Records, sign(t, []dns.RR{rr}, zsk, zskPriv, now.Add(-48*time.Hour), now.Add(-24*time.Hour)))
+			continue
+		}
+		z.Records = append(z.Records, sign(t, []dns.RR{rr}, zsk, zskPriv, from, until))
+	}
+	return z
+}
+
+func TestGetWithResolver(t *testing.T) {
+	tests := []struct {
+		name       string
+		domain     string
+		options    zoneOptions
+		dnssec     bool
+		chainValid bool
+		err        string
+		sigError   string
+	}{
+		{name: "valid", domain: "sub.example", dnssec: true, chainValid: true},
+		{name: "name in the zone", domain: "www.sub.example", dnssec: true, chainValid: true},
+		{name: "unsigned", domain: "sub.example", options: zoneOptions{unsigned: true}},
+		{name: "DS matches no key", domain: "sub.example", options: zoneOptions{badDigest: true}, dnssec: true, sigError: "no matching key verifies the signature"},
+		{name: "expired signature", domain: "sub.example", options: zoneOptions{expired: true}, dnssec: true, sigError: "Signature is not in its validity period."},
+		{name: "DS signed by the zone", domain: "sub.example", options: zoneOptions{dsSigner: true}, err: "DS records signed by sub.example, not by a parent zone."},
+		{name: "no zone", domain: "example.org", err: "no zone found for example.org"},
+	}
+	for _, tt := range tests {
+		r := GetWithResolver(tt.domain, signedZone(t, tt.options))
+		if tt.err != "" {
+			if r.ErrorMessage != tt.err {
+				t.Errorf("%s: got error %q, want %q", tt.name, r.ErrorMessage, tt.err)
+			}
+			continue
+		}
+		if r.Error != "" {
+			t.Errorf("%s: %s", tt.name, r.ErrorMessage)
+			continue
+		}
+		if r.Domain != "sub.example" || r.DNSSEC != tt.dnssec || r.ChainValid != tt.chainValid {
+			t.Errorf("%s: got zone %s, DNSSEC %v and chain valid %v, want sub.example, %v and %v", tt.name, r.Domain, r.DNSSEC, r.ChainValid, tt.dnssec, tt.chainValid)
+		}
+		if tt.chainValid && (r.Parent != "example" || len(r.DNSKEY) != 2 || len(r.DS) != 1 || !r.DS[0].Valid || len(r.RRSIG) != 4) {
+			t.Errorf("%s: got parent %s, %d keys, DS %+v and %d signatures", tt.name, r.Parent, len(r.DNSKEY), r.DS, len(r.RRSIG))
+		}
+		var sigErrors []string
+		for _, sig := range r.RRSIG {
+			if sig.ErrorMessage != "" {
+				sigErrors = append(sigErrors, sig.ErrorMessage)
+			}
+		}
+		if strings.Join(sigErrors, "\n") != tt.sigError {
+			t.Errorf("%s: got signature errors %q, want %q", tt.name, sigErrors, tt.sigError)
+		}
+	}
+}
@@ -3,6 +3,7 @@ package dnsns
 import (
 	"time"
 
+	dnsresolver "github.com/binaryfigments/goharvest/dns/resolver"
 	"github.com/miekg/dns"
 )
 
@@ -17,13 +18,17 @@ type Data struct {
 
 // Get for checking soa
 func Get(domain string, nameserver string) *Data {
+	return GetWithResolver(domain, dnsresolver.Parse(nameserver))
+}
+
+// GetWithResolver is Get with a custom resolver.
+func GetWithResolver(domain string, resolver dnsresolver.Resolver) *Data {
 	r := new(Data)
 	r.Domain = domain
 	m := new(dns.Msg)
 	m.SetQuestion(dns.Fqdn(domain), dns.TypeNS)
-	c := new(dns.Client)
 	m.MsgHdr.RecursionDesired = true
-	in, _, err := c.Exchange(m, nameserver+":53")
+	in, err := resolver.Exchange(m)
 	if err != nil {
 		r.Error = "Failed"
 		r.ErrorMessage = err.Error()
@@ -3,6 +3,7 @@ package dnsnsec
 import (
 	"time"
 
+	dnsresolver "github.com/binaryfigments/goharvest/dns/resolver"
 	"github.com/miekg/dns"
 	"golang.org/x/net/idna"
 	"golang.org/x/net/publicsuffix"
@@ -27,6 +28,11 @@ type NSEC struct {
 
 // Get function
 func Get(domain string, nameserver string) *Data {
+	return GetWithResolver(domain, dnsresolver.Parse(nameserver))
+}
+
+// GetWithResolver is Get with a custom resolver.
+func GetWithResolver(domain string, resolver dnsresolver.Resolver) *Data {
 	r := new(Data)
 	r.Domain = domain
 	r.CheckTime = time.Now()
@@ -47,19 +53,19 @@ func Get(domain string, nameserver string) *Data {
 		return r
 	}
 
-	nsec, _ := resolveDomainNSEC(domain, nameserver)
+	nsec, _ := resolveDomainNSEC(domain, resolver)
 	if nsec != nil {
 		r.NSEC.Type = "nsec"
 		r.NSEC.NSEC = nsec
 	}
 
-	nsec3, _ := resolveDomainNSEC3(domain, nameserver)
+	nsec3, _ := resolveDomainNSEC3(domain, resolver)
 	if nsec3 != nil {
 		r.NSEC.Type = "nsec3"
 		r.NSEC.NSEC3 = nsec3
 	}
 
-	nsec3param, _ := resolveDomainNSEC3PARAM(domain, nameserver)
+	nsec3param, _ := resolveDomainNSEC3PARAM(domain, resolver)
 	if nsec3param != nil {
 		r.NSEC.Type = "nsec3param"
 		r.NSEC.NSEC3PARAM = nsec3param
@@ -73,14 +79,13 @@ func Get(domain string, nameserver string) *Data {
  * TODO: Rewrite
  */
 
-func resolveDomainNSEC(domain string, nameserver string) (*dns.NSEC, error) {
+func resolveDomainNSEC(domain string, resolver dnsresolver.Resolver) (*dns.NSEC, error) {
 	var answer *dns.NSEC
 	m := new(dns.Msg)
 	m.SetQuestion(dns.Fqdn(domain), dns.TypeNSEC)
 	m.MsgHdr.RecursionDesired = true
 	m.SetEdns0(4096, true)
-	c := new(dns.Client)
-	in, _, err := c.Exchange(m, nameserver+":53")
+	in, err := resolver.Exchange(m)
 	if err != nil {
 		return nil, err
 	}
@@ -93,14 +98,13 @@ func resolveDomainNSEC(domain string, nameserver string) (*dns.NSEC, error) {
 	return nil, nil
 }
 
-func resolveDomainNSEC3(domain string, nameserver string) (*dns.NSEC3, error) {
+func resolveDomainNSEC3(domain string, resolver dnsresolver.Resolver) (*dns.NSEC3, error) {
 	var answer *dns.NSEC3
 	m := new(dns.Msg)
 	m.SetQuestion(dns.Fqdn(domain), dns.TypeNSEC3)
 	m.MsgHdr.RecursionDesired = true
 	m.SetEdns0(4096, true)
-	c := new(dns.Client)
-	in, _, err := c.Exchange(m, nameserver+":53")
+	in, err := resolver.Exchange(m)
 	if err != nil {
 		return nil, err
 	}
@@ -113,14 +117,13 @@ func resolveDomainNSEC3(domain string, nameserver string) (*dns.NSEC3, error) {
 	return nil, nil
 }
 
-func resolveDomainNSEC3PARAM(domain string, nameserver string) (*dns.NSEC3PARAM, error) {
+func resolveDomainNSEC3PARAM(domain string, resolver dnsresolver.Resolver) (*dns.NSEC3PARAM, error) {
 	var answer *dns.NSEC3PARAM
 	m := new(dns.Msg)
 	m.SetQuestion(dns.Fqdn(domain), dns.TypeNSEC3PARAM)
 	m.MsgHdr.RecursionDesired = true
 	m.SetEdns0(4096, true)
-	c := new(dns.Client)
-	in, _, err := c.Exchange(m, nameserver+":53")
+	in, err := resolver.Exchange(m)
 	if err != nil {
 		return nil, err
 	}
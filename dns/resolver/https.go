@@ -0,0 +1,120 @@
+package dnsresolver
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// HTTPSClient is a Resolver for DNS over HTTPS (RFC 8484) using the DNS
+// wire format.
+type HTTPSClient struct {
+	URL        string        `json:"url,omitempty"`     // e.g. https://dns.google/dns-query
+	Method     string        `json:"method,omitempty"`  // "POST" (default) or "GET"
+	Timeout    time.Duration `json:"timeout,omitempty"` // per attempt
+	Retries    int           `json:"retries,omitempty"` // extra attempts after a network error
+	DNSSEC     bool          `json:"dnssec,omitempty"`  // set the DO bit
+	HTTPClient *http.Client  `json:"-"`                 // optional, for custom TLS settings
+}
+
+const dnsMessage = "application/dns-message"
+
+// NewHTTPS returns a HTTPSClient for url with the default settings.
+func NewHTTPS(url string) *HTTPSClient {
+	return &HTTPSClient{
+		URL:     url,
+		Method:  http.MethodPost,
+		Timeout: DefaultTimeout,
+		Retries: DefaultRetries,
+	}
+}
+
+// Exchange sends m to the DoH server. m itself is left as it is.
+func (c *HTTPSClient) Exchange(m *dns.Msg) (*dns.Msg, error) {
+	id := m.Id
+	m = m.Copy()
+	if m.IsEdns0() == nil {
+		m.SetEdns0(dns.DefaultMsgSize, c.DNSSEC)
+	}
+
+	// RFC 8484 4.1: use ID 0 for cache friendliness, the answer gets the ID
+	// of m back.
+	m.Id = 0
+	wire, err := m.Pack()
+	if err != nil {
+		return nil, err
+	}
+
+	hc := c.HTTPClient
+	if hc == nil {
+		hc = &http.Client{Timeout: c.Timeout}
+	}
+
+	var in *dns.Msg
+	for i := 0; i <= c.Retries; i++ {
+		in, err = c.exchange(hc, wire)
+		if err == nil {
+			in.Id = id
+			return in, nil
+		}
+	}
+	return nil, err
+}
+
+func (c *HTTPSClient) exchange(hc *http.Client, wire []byte) (*dns.Msg, error) {
+	var (
+		req *http.Request
+		err error
+	)
+	switch c.Method {
+	case http.MethodGet:
+		sep := "?"
+		if strings.Contains(c.URL, "?") {
+			sep = "&"
+		}
+		req, err = http.NewRequest(http.MethodGet, c.URL+sep+"dns="+base64.RawURLEncoding.EncodeToString(wire), nil)
+	case "", http.MethodPost:
+		req, err = http.NewRequest(http.MethodPost, c.URL, bytes.NewReader(wire))
+		if err == nil {
+			req.Header.Set("Content-Type", dnsMessage)
+		}
+	default:
+		return nil, errors.New("unsupported DoH method: " + c.Method)
+	}
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", dnsMessage)
+
+	resp, err := hc.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New("DoH server returned " + resp.Status)
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, dns.MaxMsgSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > dns.MaxMsgSize {
+		return nil, errors.New("DoH response is larger than " + strconv.Itoa(dns.MaxMsgSize) + " bytes")
+	}
+
+	in := new(dns.Msg)
+	if err := in.Unpack(body); err != nil {
+		return nil, err
+	}
+	return in, nil
+}
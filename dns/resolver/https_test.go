@@ -0,0 +1,123 @@
+package dnsresolver
+
+import (
+	"encoding/base64"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+// dohHandler answers every A query with 192.0.2.1 and records the method,
+// DNS ID and EDNS0 of the last query. With large set it sends more than a
+// DNS message can hold.
+type dohHandler struct {
+	method string
+	id     uint16
+	edns   bool
+	status int
+	large  bool
+}
+
+func (h *dohHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.method = r.Method
+	if h.status != 0 {
+		w.WriteHeader(h.status)
+		return
+	}
+	if h.large {
+		w.Header().Set("Content-Type", dnsMessage)
+		w.Write(make([]byte, dns.MaxMsgSize+1))
+		return
+	}
+	var wire []byte
+	var err error
+	switch r.Method {
+	case http.MethodGet:
+		wire, err = base64.RawURLEncoding.DecodeString(r.URL.Query().Get("dns"))
+	case http.MethodPost:
+		if r.Header.Get("Content-Type") != dnsMessage {
+			http.Error(w, "bad content type", http.StatusUnsupportedMediaType)
+			return
+		}
+		wire, err = ioutil.ReadAll(r.Body)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	m := new(dns.Msg)
+	if err := m.Unpack(wire); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	h.id = m.Id
+	h.edns = m.IsEdns0() != nil
+	reply := new(dns.Msg)
+	reply.SetReply(m)
+	reply.Answer = append(reply.Answer, &dns.A{
+		Hdr: dns.RR_Header{Name: m.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+		A:   net.ParseIP("192.0.2.1"),
+	})
+	out, _ := reply.Pack()
+	w.Header().Set("Content-Type", dnsMessage)
+	w.Write(out)
+}
+
+func TestHTTPSClient(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		status int
+		large  bool
+		err    string
+	}{
+		{name: "post", method: http.MethodPost},
+		{name: "get", method: http.MethodGet},
+		{name: "server error", method: http.MethodPost, status: http.StatusInternalServerError, err: "DoH server returned 500"},
+		{name: "unsupported method", method: http.MethodPut, err: "unsupported DoH method: PUT"},
+		{name: "large response", method: http.MethodPost, large: true, err: "DoH response is larger than 65535 bytes"},
+	}
+	for _, tt := range tests {
+		h := &dohHandler{status: tt.status, large: tt.large}
+		srv := httptest.NewTLSServer(h)
+
+		c := NewHTTPS(srv.URL + "/dns-query")
+		c.Method = tt.method
+		c.Retries = 0
+		c.HTTPClient = srv.Client()
+
+		m := new(dns.Msg)
+		m.SetQuestion("example.com.", dns.TypeA)
+		m.Id = 4711
+		in, err := c.Exchange(m)
+		srv.Close()
+
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%s: got error %v, want %q", tt.name, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if h.method != tt.method {
+			t.Errorf("%s: server got %s", tt.name, h.method)
+		}
+		if h.id != 0 || in.Id != 4711 || m.Id != 4711 {
+			t.Errorf("%s: got ID %d on the wire and %d in the reply, want 0 and 4711", tt.name, h.id, in.Id)
+		}
+		if !h.edns || m.IsEdns0() != nil {
+			t.Errorf("%s: got EDNS0 %v on the wire and %v in the query of the caller, want only on the wire", tt.name, h.edns, m.IsEdns0() != nil)
+		}
+		if len(in.Answer) != 1 || in.Answer[0].(*dns.A).A.String() != "192.0.2.1" {
+			t.Errorf("%s: got answer %v", tt.name, in.Answer)
+		}
+	}
+}
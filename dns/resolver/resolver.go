@@ -0,0 +1,192 @@
+package dnsresolver
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// Resolver sends a DNS query and returns the response. All DNS based
+// packages do their lookups through a Resolver, so a fake one can be used
+// in tests and a tuned one in production.
+type Resolver interface {
+	Exchange(m *dns.Msg) (*dns.Msg, error)
+}
+
+// ResolverFunc is an adapter to use an ordinary function as a Resolver.
+type ResolverFunc func(m *dns.Msg) (*dns.Msg, error)
+
+// Exchange calls f(m).
+func (f ResolverFunc) Exchange(m *dns.Msg) (*dns.Msg, error) {
+	return f(m)
+}
+
+// Client is a Resolver for plain DNS and DNS over TLS (RFC 7858). Over UDP
+// a truncated response is retried over TCP.
+type Client struct {
+	Nameserver string        `json:"nameserver,omitempty"` // host or host:port, port 53 or 853 when omitted
+	Net        string        `json:"net,omitempty"`        // "udp" (default), "tcp" or "tcp-tls"
+	Timeout    time.Duration `json:"timeout,omitempty"`    // per attempt
+	Retries    int           `json:"retries,omitempty"`    // extra attempts after a network error
+	UDPSize    uint16        `json:"udpsize,omitempty"`    // EDNS0 buffer size, 0 disables EDNS0
+	DNSSEC     bool          `json:"dnssec,omitempty"`     // set the DO bit
+	TLSConfig  *tls.Config   `json:"-"`                    // optional, for "tcp-tls"
+}
+
+// Defaults for New.
+const (
+	DefaultTimeout = 2 * time.Second
+	DefaultRetries = 2
+	DefaultUDPSize = 4096
+)
+
+// New returns a Client for nameserver with the default settings.
+func New(nameserver string) *Client {
+	return &Client{
+		Nameserver: nameserver,
+		Net:        "udp",
+		Timeout:    DefaultTimeout,
+		Retries:    DefaultRetries,
+		UDPSize:    DefaultUDPSize,
+	}
+}
+
+// NewTLS returns a DNS over TLS Client for nameserver with the default
+// settings.
+func NewTLS(nameserver string) *Client {
+	c := New(nameserver)
+	c.Net = "tcp-tls"
+	return c
+}
+
+// Exchange sends m to the nameserver. EDNS0 is added to a copy of m when m
+// does not carry an OPT record already and UDPSize is set.
+func (c *Client) Exchange(m *dns.Msg) (*dns.Msg, error) {
+	if m.IsEdns0() == nil && c.UDPSize > 0 {
+		m = m.Copy()
+		m.SetEdns0(c.UDPSize, c.DNSSEC)
+	}
+
+	network := c.Net
+	if network == "" {
+		network = "udp"
+	}
+
+	in, err := c.exchange(m, network)
+	if err == nil && in.Truncated && network == "udp" {
+		in, err = c.exchange(m, "tcp")
+	}
+	return in, err
+}
+
+func (c *Client) exchange(m *dns.Msg, network string) (*dns.Msg, error) {
+	client := &dns.Client{
+		Net:     network,
+		Timeout: c.Timeout,
+	}
+	if c.UDPSize > 0 {
+		client.UDPSize = c.UDPSize
+	}
+
+	port := "53"
+	if network == "tcp-tls" {
+		port = "853"
+		client.TLSConfig = c.TLSConfig
+		if client.TLSConfig == nil {
+			host, _, err := net.SplitHostPort(Address(c.Nameserver, port))
+			if err != nil {
+				return nil, err
+			}
+			client.TLSConfig = &tls.Config{ServerName: host}
+		}
+	}
+
+	var (
+		in  *dns.Msg
+		err error
+	)
+	for i := 0; i <= c.Retries; i++ {
+		in, _, err = client.Exchange(m, Address(c.Nameserver, port))
+		if err == nil {
+			return in, nil
+		}
+	}
+	return nil, err
+}
+
+// Address adds port to nameserver when it has none.
+func Address(nameserver string, port string) string {
+	if _, _, err := net.SplitHostPort(nameserver); err == nil {
+		return nameserver
+	}
+	return net.JoinHostPort(nameserver, port)
+}
+
+// Transports for Default and Parse.
+const (
+	TransportUDP   = "udp"
+	TransportTCP   = "tcp"
+	TransportTLS   = "tls"
+	TransportHTTPS = "https"
+)
+
+// Default is the transport Parse uses for a nameserver without a scheme.
+// Setting it to TransportTLS or TransportHTTPS switches every Get function
+// that takes a nameserver string to DoT or DoH.
+var Default = TransportUDP
+
+// Parse returns a Resolver for nameserver. The transport is taken from the
+// scheme, otherwise from Default:
+//
+//	8.8.8.8                          plain DNS using Default
+//	udp://8.8.8.8, tcp://8.8.8.8:53  plain DNS
+//	tls://dns.google                 DNS over TLS on port 853
+//	https://dns.google/dns-query     DNS over HTTPS using POST
+//	https+get://dns.google/dns-query DNS over HTTPS using GET
+//
+// A nameserver that can not be parsed gives a Resolver that returns the
+// error on every Exchange.
+func Parse(nameserver string) Resolver {
+	scheme := Default
+	host := nameserver
+	if i := strings.Index(nameserver, "://"); i >= 0 {
+		scheme = strings.ToLower(nameserver[:i])
+		host = nameserver[i+3:]
+	}
+
+	switch scheme {
+	case TransportUDP:
+		return New(host)
+	case TransportTCP:
+		c := New(host)
+		c.Net = "tcp"
+		return c
+	case TransportTLS:
+		return NewTLS(host)
+	case TransportHTTPS, "https+get", "https+post":
+		if !strings.Contains(nameserver, "://") {
+			nameserver = "https://" + Address(host, "443") + "/dns-query"
+		}
+		u, err := url.Parse(nameserver)
+		if err != nil {
+			return errorResolver(err)
+		}
+		c := NewHTTPS("https://" + u.Host + u.RequestURI())
+		if scheme == "https+get" {
+			c.Method = "GET"
+		}
+		return c
+	}
+	return errorResolver(errors.New("unknown DNS transport: " + scheme))
+}
+
+func errorResolver(err error) Resolver {
+	return ResolverFunc(func(m *dns.Msg) (*dns.Msg, error) {
+		return nil, err
+	})
+}
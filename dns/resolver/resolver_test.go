@@ -0,0 +1,167 @@
+package dnsresolver
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		nameserver string
+		net        string
+		address    string
+		url        string
+		method     string
+	}{
+		{nameserver: "8.8.8.8", net: "udp", address: "8.8.8.8"},
+		{nameserver: "udp://8.8.8.8", net: "udp", address: "8.8.8.8"},
+		{nameserver: "tcp://8.8.8.8:5353", net: "tcp", address: "8.8.8.8:5353"},
+		{nameserver: "tls://dns.google", net: "tcp-tls", address: "dns.google"},
+		{nameserver: "https://dns.google/dns-query", url: "https://dns.google/dns-query", method: "POST"},
+		{nameserver: "https+get://dns.google/resolve?ct", url: "https://dns.google/resolve?ct", method: "GET"},
+	}
+	for _, tt := range tests {
+		switch r := Parse(tt.nameserver).(type) {
+		case *Client:
+			if r.Net != tt.net || r.Nameserver != tt.address {
+				t.Errorf("%s: got %s %s, want %s %s", tt.nameserver, r.Net, r.Nameserver, tt.net, tt.address)
+			}
+		case *HTTPSClient:
+			if r.URL != tt.url || r.Method != tt.method {
+				t.Errorf("%s: got %s %s, want %s %s", tt.nameserver, r.Method, r.URL, tt.method, tt.url)
+			}
+		default:
+			t.Errorf("%s: got %T", tt.nameserver, r)
+		}
+	}
+
+	if _, err := Parse("quic://dns.google").Exchange(new(dns.Msg)); err == nil || err.Error() != "unknown DNS transport: quic" {
+		t.Errorf("unknown transport: got %v", err)
+	}
+}
+
+func TestAddress(t *testing.T) {
+	tests := []struct {
+		nameserver string
+		want       string
+	}{
+		{"192.0.2.1", "192.0.2.1:53"},
+		{"192.0.2.1:5353", "192.0.2.1:5353"},
+		{"2001:db8::1", "[2001:db8::1]:53"},
+		{"[2001:db8::1]:5353", "[2001:db8::1]:5353"},
+		{"dns.example", "dns.example:53"},
+	}
+	for _, tt := range tests {
+		if got := Address(tt.nameserver, "53"); got != tt.want {
+			t.Errorf("%s: got %s, want %s", tt.nameserver, got, tt.want)
+		}
+	}
+}
+
+// answer returns a handler that answers every query with 192.0.2.1, or
+// with an empty truncated reply.
+func answer(truncate bool) dns.HandlerFunc {
+	return func(w dns.ResponseWriter, m *dns.Msg) {
+		reply := new(dns.Msg)
+		reply.SetReply(m)
+		if truncate {
+			reply.Truncated = true
+		} else {
+			reply.Answer = append(reply.Answer, &dns.A{
+				Hdr: dns.RR_Header{Name: m.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+				A:   net.ParseIP("192.0.2.1"),
+			})
+		}
+		w.WriteMsg(reply)
+	}
+}
+
+// TestClientTruncated checks a truncated UDP answer is retried over TCP.
+func TestClientTruncated(t *testing.T) {
+	udp, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := udp.LocalAddr().(*net.UDPAddr).Port
+	tcp, err := net.Listen("tcp", udp.LocalAddr().String())
+	if err != nil {
+		udp.Close()
+		t.Skip("TCP port of the UDP listener is taken:", err)
+	}
+	udpServer := &dns.Server{PacketConn: udp, Handler: answer(true)}
+	tcpServer := &dns.Server{Listener: tcp, Handler: answer(false)}
+	go udpServer.ActivateAndServe()
+	go tcpServer.ActivateAndServe()
+	defer udpServer.Shutdown()
+	defer tcpServer.Shutdown()
+
+	c := New(net.JoinHostPort("127.0.0.1", strconv.Itoa(port)))
+	c.Timeout = time.Second
+	m := new(dns.Msg)
+	m.SetQuestion("example.com.", dns.TypeA)
+	in, err := c.Exchange(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if in.Truncated || len(in.Answer) != 1 {
+		t.Errorf("got truncated %v and %d answers, want the TCP answer", in.Truncated, len(in.Answer))
+	}
+	if m.IsEdns0() != nil {
+		t.Error("EDNS0 was added to the query of the caller")
+	}
+}
+
+func TestClientTLS(t *testing.T) {
+	// The httptest certificate is valid for example.com and 127.0.0.1.
+	https := httptest.NewTLSServer(nil)
+	https.Close()
+	roots := x509.NewCertPool()
+	roots.AddCert(https.Certificate())
+
+	l, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: https.TLS.Certificates})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &dns.Server{Listener: l, Net: "tcp-tls", Handler: answer(false)}
+	go server.ActivateAndServe()
+	defer server.Shutdown()
+
+	tests := []struct {
+		name   string
+		config *tls.Config
+		err    string
+	}{
+		{"trusted", &tls.Config{RootCAs: roots, ServerName: "example.com"}, ""},
+		{"other name", &tls.Config{RootCAs: roots, ServerName: "dns.example.net"}, "certificate is valid for"},
+		{"system roots", nil, "certificate"},
+	}
+	for _, tt := range tests {
+		c := NewTLS(l.Addr().String())
+		c.Timeout = time.Second
+		c.TLSConfig = tt.config
+		m := new(dns.Msg)
+		m.SetQuestion("example.com.", dns.TypeA)
+		in, err := c.Exchange(m)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%s: got error %v, want %q", tt.name, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if len(in.Answer) != 1 {
+			t.Errorf("%s: got %d answers", tt.name, len(in.Answer))
+		}
+	}
+}
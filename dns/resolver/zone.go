@@ -0,0 +1,88 @@
+package dnsresolver
+
+import (
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// Zone is a Resolver that answers from records held in memory, for tests
+// and offline checks. A name without any record is NXDOMAIN, a name with
+// records of other types only gets an empty answer, and both carry the SOA
+// record of the closest enclosing zone in the authority section. The RRSIG
+// records covering the question are added when the query has the DO bit
+// set.
+type Zone struct {
+	Records           []dns.RR
+	AuthenticatedData bool // AD bit of every response
+}
+
+// NewZone returns a Zone with records in zone file syntax, one per string.
+func NewZone(records ...string) (*Zone, error) {
+	z := new(Zone)
+	for _, s := range records {
+		rr, err := dns.NewRR(s)
+		if err != nil {
+			return nil, err
+		}
+		if rr != nil {
+			z.Records = append(z.Records, rr)
+		}
+	}
+	return z, nil
+}
+
+// MustZone is NewZone for fixed records, it panics on an invalid record.
+func MustZone(records ...string) *Zone {
+	z, err := NewZone(records...)
+	if err != nil {
+		panic(err)
+	}
+	return z
+}
+
+// Exchange answers the question of m from the records.
+func (z *Zone) Exchange(m *dns.Msg) (*dns.Msg, error) {
+	r := new(dns.Msg)
+	r.SetReply(m)
+	r.AuthenticatedData = z.AuthenticatedData
+	if len(m.Question) != 1 {
+		r.Rcode = dns.RcodeFormatError
+		return r, nil
+	}
+
+	q := m.Question[0]
+	do := m.IsEdns0() != nil && m.IsEdns0().Do()
+	r.Rcode = dns.RcodeNameError
+	for _, rr := range z.Records {
+		if !strings.EqualFold(rr.Header().Name, q.Name) {
+			continue
+		}
+		r.Rcode = dns.RcodeSuccess
+		if rr.Header().Rrtype == q.Qtype {
+			r.Answer = append(r.Answer, rr)
+		} else if sig, ok := rr.(*dns.RRSIG); ok && do && sig.TypeCovered == q.Qtype {
+			r.Answer = append(r.Answer, rr)
+		}
+	}
+	if len(r.Answer) == 0 {
+		if soa := z.soa(q.Name); soa != nil {
+			r.Ns = append(r.Ns, soa)
+		}
+	}
+	return r, nil
+}
+
+// soa returns the SOA record of the closest zone that name is in.
+func (z *Zone) soa(name string) dns.RR {
+	var closest dns.RR
+	for _, rr := range z.Records {
+		if rr.Header().Rrtype != dns.TypeSOA || !dns.IsSubDomain(rr.Header().Name, name) {
+			continue
+		}
+		if closest == nil || dns.CountLabel(rr.Header().Name) > dns.CountLabel(closest.Header().Name) {
+			closest = rr
+		}
+	}
+	return closest
+}
@@ -0,0 +1,69 @@
+package dnsresolver
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestZone(t *testing.T) {
+	z := MustZone(
+		`example.com. 60 IN A 192.0.2.1`,
+		`example.com. 60 IN A 192.0.2.2`,
+		`example.com. 60 IN RRSIG A 13 2 60 20300101000000 20200101000000 12345 example.com. AAAA`,
+		`example.com. 60 IN MX 10 mail.example.com.`,
+		`example.com. 60 IN SOA ns.example.com. hostmaster.example.com. 1 3600 600 86400 60`,
+		`sub.example.com. 60 IN SOA ns.example.com. hostmaster.example.com. 1 3600 600 86400 60`,
+	)
+
+	tests := []struct {
+		name   string
+		qname  string
+		qtype  uint16
+		do     bool
+		rcode  int
+		answer int
+		soa    string // owner of the SOA record in the authority section
+	}{
+		{"records", "example.com.", dns.TypeA, false, dns.RcodeSuccess, 2, ""},
+		{"case insensitive", "EXAMPLE.com.", dns.TypeMX, false, dns.RcodeSuccess, 1, ""},
+		{"signatures with DO", "example.com.", dns.TypeA, true, dns.RcodeSuccess, 3, ""},
+		{"no signatures of other types", "example.com.", dns.TypeMX, true, dns.RcodeSuccess, 1, ""},
+		{"no data", "example.com.", dns.TypeTXT, false, dns.RcodeSuccess, 0, "example.com."},
+		{"no name", "www.example.com.", dns.TypeA, false, dns.RcodeNameError, 0, "example.com."},
+		{"closest zone", "www.sub.example.com.", dns.TypeA, false, dns.RcodeNameError, 0, "sub.example.com."},
+		{"no zone", "example.org.", dns.TypeA, false, dns.RcodeNameError, 0, ""},
+	}
+	for _, tt := range tests {
+		m := new(dns.Msg)
+		m.SetQuestion(tt.qname, tt.qtype)
+		if tt.do {
+			m.SetEdns0(4096, true)
+		}
+		in, err := z.Exchange(m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if in.Id != m.Id || in.Rcode != tt.rcode || len(in.Answer) != tt.answer {
+			t.Errorf("%s: got rcode %s and %d answers, want %s and %d", tt.name, dns.RcodeToString[in.Rcode], len(in.Answer), dns.RcodeToString[tt.rcode], tt.answer)
+		}
+		soa := ""
+		if len(in.Ns) == 1 {
+			soa = in.Ns[0].Header().Name
+		}
+		if soa != tt.soa || len(in.Ns) > 1 {
+			t.Errorf("%s: got authority %v, want the SOA of %q", tt.name, in.Ns, tt.soa)
+		}
+	}
+
+	z.AuthenticatedData = true
+	m := new(dns.Msg)
+	m.SetQuestion("example.com.", dns.TypeA)
+	if in, _ := z.Exchange(m); !in.AuthenticatedData {
+		t.Error("AD bit not set")
+	}
+
+	if _, err := NewZone(`example.com. 60 IN A not-an-address`); err == nil {
+		t.Error("invalid record: got no error")
+	}
+}
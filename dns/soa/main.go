@@ -1,6 +1,7 @@
 package dnssoa
 
 import (
+	dnsresolver "github.com/binaryfigments/goharvest/dns/resolver"
 	"github.com/miekg/dns"
 )
 
@@ -24,16 +25,21 @@ type SOA struct {
 
 // Get for checking soa
 func Get(domain string, nameserver string) *Data {
+	return GetWithResolver(domain, dnsresolver.Parse(nameserver))
+}
+
+// GetWithResolver is Get with a custom resolver.
+func GetWithResolver(domain string, resolver dnsresolver.Resolver) *Data {
 	r := new(Data)
 	r.Domain = domain
 	m := new(dns.Msg)
 	m.SetQuestion(dns.Fqdn(domain), dns.TypeSOA)
-	c := new(dns.Client)
 	m.MsgHdr.RecursionDesired = true
-	in, _, err := c.Exchange(m, nameserver+":53")
+	in, err := resolver.Exchange(m)
 	if err != nil {
 		r.Error = "Failed"
 		r.ErrorMessage = err.Error()
+		return r
 	}
 	s := new(SOA)
 	for _, ain := range in.Answer {
@@ -0,0 +1,357 @@
+package emailarc
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	dnsresolver "github.com/binaryfigments/goharvest/dns/resolver"
+	emaildkim "github.com/binaryfigments/goharvest/email/dkim"
+)
+
+// Data struct with the ARC chain of a message (RFC 8617)
+type Data struct {
+	CheckTime    time.Time `json:"time"`
+	Result       string    `json:"result,omitempty"`
+	Instances    int       `json:"instances"`
+	OldestPass   int       `json:"oldestpass,omitempty"`
+	Sets         []*Set    `json:"sets,omitempty"`
+	Reason       string    `json:"reason,omitempty"`
+	Error        string    `json:"error,omitempty"`
+	ErrorMessage string    `json:"errormessage,omitempty"`
+}
+
+// Set struct for the three header fields of one ARC instance
+type Set struct {
+	Instance              int                  `json:"instance"`
+	AuthenticationResults string               `json:"authenticationresults,omitempty"`
+	MessageSignature      *emaildkim.Signature `json:"messagesignature,omitempty"`
+	Seal                  *Seal                `json:"seal,omitempty"`
+	ErrorMessage          string               `json:"errormessage,omitempty"`
+}
+
+// Seal struct for an ARC-Seal header field
+type Seal struct {
+	Domain          string         `json:"domain,omitempty"`
+	Selector        string         `json:"selector,omitempty"`
+	Algorithm       string         `json:"algorithm,omitempty"`
+	ChainValidation string         `json:"cv,omitempty"`
+	Timestamp       time.Time      `json:"timestamp,omitempty"`
+	Result          string         `json:"result,omitempty"`
+	Key             *emaildkim.Key `json:"key,omitempty"`
+	ErrorMessage    string         `json:"errormessage,omitempty"`
+}
+
+// Chain validation states (RFC 8617 section 4.4)
+const (
+	ChainNone = "none"
+	ChainPass = "pass"
+	ChainFail = "fail"
+)
+
+// MaxInstances is the highest allowed instance number.
+const MaxInstances = 50
+
+// ValidateFile validates the ARC chain of the message in file.
+func ValidateFile(file string, nameserver string) *Data {
+	f, err := os.Open(file)
+	if err != nil {
+		r := new(Data)
+		r.CheckTime = time.Now()
+		r.Error = "Failed"
+		r.ErrorMessage = err.Error()
+		return r
+	}
+	defer f.Close()
+	return Validate(f, nameserver)
+}
+
+// Validate validates the ARC chain of the message read from r.
+func Validate(r io.Reader, nameserver string) *Data {
+	return ValidateWithResolver(r, dnsresolver.Parse(nameserver))
+}
+
+// ValidateWithResolver is Validate with a custom resolver. It follows the
+// validator actions of RFC 8617 section 5.2.
+func ValidateWithResolver(r io.Reader, resolver dnsresolver.Resolver) *Data {
+	d := new(Data)
+	d.CheckTime = time.Now()
+
+	msg, err := emaildkim.ReadMessage(r)
+	if err != nil {
+		d.Error = "Failed"
+		d.ErrorMessage = err.Error()
+		return d
+	}
+
+	sets, headers, err := collectSets(msg)
+	d.Sets = sets
+	d.Instances = len(sets)
+	if err != nil {
+		return d.fail(err.Error())
+	}
+	if len(sets) == 0 {
+		d.Result = ChainNone
+		return d
+	}
+
+	last := sets[len(sets)-1]
+	if last.Seal.ChainValidation == ChainFail {
+		return d.fail("instance " + strconv.Itoa(last.Instance) + " sealed a failed chain")
+	}
+	for _, set := range sets {
+		want := ChainPass
+		if set.Instance == 1 {
+			want = ChainNone
+		}
+		if set.Seal.ChainValidation != want {
+			return d.fail("instance " + strconv.Itoa(set.Instance) + " has cv=" + set.Seal.ChainValidation + ", expected cv=" + want)
+		}
+	}
+
+	// Every message signature is verified so the oldest instance that still
+	// validates can be reported, only the newest one decides the result.
+	for i := len(sets) - 1; i >= 0; i-- {
+		sets[i].MessageSignature = emaildkim.VerifySignature(msg, headers[i].ams, messageSignature, resolver)
+	}
+	if last.MessageSignature.Result != emaildkim.ResultPass {
+		return d.fail("ARC-Message-Signature of instance " + strconv.Itoa(last.Instance) + ": " + last.MessageSignature.ErrorMessage)
+	}
+	for i := len(sets) - 1; i >= 0 && sets[i].MessageSignature.Result == emaildkim.ResultPass; i-- {
+		d.OldestPass = sets[i].Instance
+	}
+
+	failed := ""
+	for i := len(sets) - 1; i >= 0; i-- {
+		verifySeal(sets[i].Seal, headers[:i+1], resolver)
+		if sets[i].Seal.Result != emaildkim.ResultPass && failed == "" {
+			failed = "ARC-Seal of instance " + strconv.Itoa(sets[i].Instance) + ": " + sets[i].Seal.ErrorMessage
+		}
+	}
+	if failed != "" {
+		return d.fail(failed)
+	}
+
+	d.Result = ChainPass
+	return d
+}
+
+/*
+ * Used functions
+ */
+
+func (d *Data) fail(reason string) *Data {
+	d.Result = ChainFail
+	d.Reason = reason
+	return d
+}
+
+// setHeaders holds the raw header fields of an ARC set.
+type setHeaders struct {
+	aar, ams, as *emaildkim.Header
+}
+
+// collectSets groups the ARC header fields by instance. Every instance from
+// 1 up to the highest must have exactly one field of each kind.
+func collectSets(msg *emaildkim.Message) ([]*Set, []*setHeaders, error) {
+	byInstance := make(map[int]*setHeaders)
+	for _, h := range msg.Headers {
+		var field **emaildkim.Header
+		name := strings.ToLower(h.Name)
+		if name != "arc-authentication-results" && name != "arc-message-signature" && name != "arc-seal" {
+			continue
+		}
+		instance, err := parseInstance(name, h.Value)
+		if err != nil {
+			return nil, nil, errors.New(h.Name + ": " + err.Error())
+		}
+		if instance < 1 || instance > MaxInstances {
+			return nil, nil, errors.New(h.Name + ": instance " + strconv.Itoa(instance) + " is out of range")
+		}
+		set := byInstance[instance]
+		if set == nil {
+			set = new(setHeaders)
+			byInstance[instance] = set
+		}
+		switch name {
+		case "arc-authentication-results":
+			field = &set.aar
+		case "arc-message-signature":
+			field = &set.ams
+		default:
+			field = &set.as
+		}
+		if *field != nil {
+			return nil, nil, errors.New("instance " + strconv.Itoa(instance) + " has more than one " + h.Name)
+		}
+		*field = h
+	}
+
+	var instances []int
+	for instance := range byInstance {
+		instances = append(instances, instance)
+	}
+	sort.Ints(instances)
+
+	var sets []*Set
+	var headers []*setHeaders
+	for i, instance := range instances {
+		if instance != i+1 {
+			return sets, headers, errors.New("instance " + strconv.Itoa(i+1) + " is missing")
+		}
+		h := byInstance[instance]
+		set := &Set{Instance: instance}
+		sets = append(sets, set)
+		headers = append(headers, h)
+		switch {
+		case h.aar == nil:
+			set.ErrorMessage = "missing ARC-Authentication-Results"
+		case h.ams == nil:
+			set.ErrorMessage = "missing ARC-Message-Signature"
+		case h.as == nil:
+			set.ErrorMessage = "missing ARC-Seal"
+		}
+		if set.ErrorMessage != "" {
+			return sets, headers, errors.New("instance " + strconv.Itoa(instance) + ": " + set.ErrorMessage)
+		}
+		set.AuthenticationResults = strings.TrimSpace(h.aar.Value[strings.Index(h.aar.Value, ";")+1:])
+		set.Seal = parseSeal(h.as)
+	}
+	return sets, headers, nil
+}
+
+// parseInstance returns the i= tag. In ARC-Authentication-Results it is
+// the first element, followed by the authentication results.
+func parseInstance(name string, value string) (int, error) {
+	if name == "arc-authentication-results" {
+		if i := strings.Index(value, ";"); i >= 0 {
+			value = value[:i]
+		}
+	}
+	tags, err := emaildkim.ParseTags(value)
+	if err != nil {
+		return 0, err
+	}
+	i, ok := tags["i"]
+	if !ok {
+		return 0, errors.New("missing required tag i")
+	}
+	instance, err := strconv.Atoi(i)
+	if err != nil {
+		return 0, errors.New("invalid instance: " + i)
+	}
+	return instance, nil
+}
+
+func parseSeal(h *emaildkim.Header) *Seal {
+	s := new(Seal)
+	tags, err := emaildkim.ParseTags(h.Value)
+	if err != nil {
+		s.Result = emaildkim.ResultPermError
+		s.ErrorMessage = err.Error()
+		return s
+	}
+	s.Domain = strings.ToLower(tags["d"])
+	s.Selector = tags["s"]
+	s.Algorithm = strings.ToLower(tags["a"])
+	s.ChainValidation = strings.ToLower(tags["cv"])
+	if t, err := strconv.ParseInt(tags["t"], 10, 64); err == nil {
+		s.Timestamp = time.Unix(t, 0).UTC()
+	}
+	return s
+}
+
+// messageSignature is the format of an ARC-Message-Signature, a DKIM
+// signature without v= whose i= tag is the instance (RFC 8617 section
+// 4.1.2).
+var messageSignature = &emaildkim.SignatureFormat{
+	Required: []string{"a", "b", "bh", "d", "h", "s"},
+	Check: func(s *emaildkim.Signature, tags map[string]string) error {
+		for _, name := range s.Headers {
+			if strings.EqualFold(name, "ARC-Seal") {
+				return errors.New("ARC-Seal must not be signed")
+			}
+		}
+		return nil
+	},
+	SignedData: emaildkim.SignedData,
+}
+
+// verifySeal verifies an ARC-Seal over the sets up to and including its own
+// instance, always with relaxed header canonicalization (RFC 8617 section
+// 5.1.1).
+func verifySeal(s *Seal, sets []*setHeaders, resolver dnsresolver.Resolver) {
+	if s.Result != "" {
+		return
+	}
+	own := sets[len(sets)-1].as
+	tags, _ := emaildkim.ParseTags(own.Value)
+	for _, tag := range []string{"a", "b", "cv", "d", "s"} {
+		if _, ok := tags[tag]; !ok {
+			s.Result = emaildkim.ResultPermError
+			s.ErrorMessage = "missing required tag " + tag
+			return
+		}
+	}
+	if _, ok := tags["h"]; ok {
+		s.Result = emaildkim.ResultPermError
+		s.ErrorMessage = "h= is not allowed in an ARC-Seal"
+		return
+	}
+
+	key, result, err := lookupKey(s.Selector, s.Domain, s.Algorithm, resolver)
+	s.Key = key
+	if err != nil {
+		s.Result = result
+		s.ErrorMessage = err.Error()
+		return
+	}
+
+	sig, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(tags["b"]), ""))
+	if err != nil {
+		s.Result = emaildkim.ResultPermError
+		s.ErrorMessage = "invalid signature: " + err.Error()
+		return
+	}
+
+	var data bytes.Buffer
+	for i, set := range sets {
+		for _, h := range []*emaildkim.Header{set.aar, set.ams, set.as} {
+			if i == len(sets)-1 && h == own {
+				data.WriteString(emaildkim.CanonicalHeader(emaildkim.RemoveSignature(h), true))
+				continue
+			}
+			data.WriteString(emaildkim.CanonicalHeader(h, true))
+			data.WriteString("\r\n")
+		}
+	}
+	if err := emaildkim.VerifyData(key, s.Algorithm, data.Bytes(), sig); err != nil {
+		s.Result = emaildkim.ResultFail
+		s.ErrorMessage = err.Error()
+		return
+	}
+	s.Result = emaildkim.ResultPass
+}
+
+// lookupKey fetches the key the same way DKIM does. On error it also
+// returns the result: temperror for a failed lookup, permerror for a missing
+// or unusable key.
+func lookupKey(selector string, domain string, algorithm string, resolver dnsresolver.Resolver) (*emaildkim.Key, string, error) {
+	key, err := emaildkim.LookupKey(selector, domain, resolver)
+	if err != nil {
+		return nil, emaildkim.ResultTempError, err
+	}
+	if key == nil {
+		return nil, emaildkim.ResultPermError, errors.New("no key for selector " + selector)
+	}
+	if err := emaildkim.CheckKey(key, algorithm, true); err != nil {
+		return key, emaildkim.ResultPermError, err
+	}
+	return key, "", nil
+}
@@ -0,0 +1,115 @@
+package emailarc
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"strconv"
+	"strings"
+	"testing"
+
+	dnsresolver "github.com/binaryfigments/goharvest/dns/resolver"
+)
+
+const body = "Hello\r\n"
+
+// hop is one ARC instance added by an intermediary: the chain validation
+// it seals, the Subject the message had when it signed and the selector of
+// its seal.
+type hop struct {
+	cv       string
+	subject  string
+	selector string
+}
+
+// relaxed canonicalizes a header field the way the signer does.
+func relaxed(name string, value string) string {
+	return strings.ToLower(name) + ":" + strings.Join(strings.Fields(value), " ")
+}
+
+// sealHops returns the ARC header fields, newest first, that the hops add to
+// a message with the body. Every hop signs the From and Subject fields with
+// key and seals the chain before it.
+func sealHops(key ed25519.PrivateKey, hops []hop) []string {
+	sign := func(data string) string {
+		digest := sha256.Sum256([]byte(data))
+		return base64.StdEncoding.EncodeToString(ed25519.Sign(key, digest[:]))
+	}
+	bh := sha256.Sum256([]byte(body))
+
+	var sealed string
+	var fields []string
+	for i, h := range hops {
+		n := strconv.Itoa(i + 1)
+		selector := h.selector
+		if selector == "" {
+			selector = "arc"
+		}
+		aar := " i=" + n + "; mx" + n + ".example.net; spf=pass smtp.mailfrom=example.com"
+		ams := " i=" + n + "; a=ed25519-sha256; c=relaxed/relaxed; d=example.net; s=arc;\r\n h=From:Subject; bh=" + base64.StdEncoding.EncodeToString(bh[:]) + "; b="
+		ams += sign(relaxed("From", "Joe <joe@example.com>") + "\r\n" + relaxed("Subject", h.subject) + "\r\n" + relaxed("ARC-Message-Signature", ams))
+		as := " i=" + n + "; a=ed25519-sha256; cv=" + h.cv + "; d=example.net; s=" + selector + "; b="
+		sealed += relaxed("ARC-Authentication-Results", aar) + "\r\n" + relaxed("ARC-Message-Signature", ams) + "\r\n"
+		as += sign(sealed + relaxed("ARC-Seal", as))
+		sealed += relaxed("ARC-Seal", as) + "\r\n"
+		fields = append([]string{"ARC-Seal:" + as, "ARC-Message-Signature:" + ams, "ARC-Authentication-Results:" + aar}, fields...)
+	}
+	return fields
+}
+
+// message returns a message with the ARC fields above its From and Subject.
+func message(fields []string, subject string) string {
+	return strings.Join(append(fields, ""), "\r\n") + "From: Joe <joe@example.com>\r\nSubject: " + subject + "\r\n\r\n" + body
+}
+
+func TestValidateWithResolver(t *testing.T) {
+	pub, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	z := dnsresolver.MustZone(`arc._domainkey.example.net. 60 IN TXT "v=DKIM1; k=ed25519; p=` + base64.StdEncoding.EncodeToString(pub) + `"`)
+
+	one := sealHops(key, []hop{{cv: "none", subject: "hi"}})
+	three := sealHops(key, []hop{{cv: "none", subject: "hi"}, {cv: "pass", subject: "hi"}, {cv: "pass", subject: "hi"}})
+	// A mailing list changed the Subject after the first hop.
+	list := sealHops(key, []hop{{cv: "none", subject: "hi"}, {cv: "pass", subject: "[list] hi"}, {cv: "pass", subject: "[list] hi"}})
+
+	tests := []struct {
+		name       string
+		message    string
+		result     string
+		instances  int
+		oldestPass int
+		reason     string
+	}{
+		{name: "no ARC", message: message(nil, "hi"), result: ChainNone},
+		{name: "one hop", message: message(one, "hi"), result: ChainPass, instances: 1, oldestPass: 1},
+		{name: "three hops", message: message(three, "hi"), result: ChainPass, instances: 3, oldestPass: 1},
+		{name: "modified after the first hop", message: message(list, "[list] hi"), result: ChainPass, instances: 3, oldestPass: 2},
+		{name: "modified after the last hop", message: message(three, "changed"), result: ChainFail, instances: 3, reason: "ARC-Message-Signature of instance 3: "},
+		{name: "body modified", message: message(three, "hi") + "more\r\n", result: ChainFail, instances: 3, reason: "ARC-Message-Signature of instance 3: body hash did not verify"},
+		{name: "failed chain sealed", message: message(sealHops(key, []hop{{cv: "none", subject: "hi"}, {cv: "fail", subject: "hi"}}), "hi"), result: ChainFail, instances: 2, reason: "instance 2 sealed a failed chain"},
+		{name: "first hop cv=pass", message: message(sealHops(key, []hop{{cv: "pass", subject: "hi"}}), "hi"), result: ChainFail, instances: 1, reason: "instance 1 has cv=pass, expected cv=none"},
+		{name: "later hop cv=none", message: message(sealHops(key, []hop{{cv: "none", subject: "hi"}, {cv: "none", subject: "hi"}}), "hi"), result: ChainFail, instances: 2, reason: "instance 2 has cv=none, expected cv=pass"},
+		{name: "seal without key", message: message(sealHops(key, []hop{{cv: "none", subject: "hi"}, {cv: "pass", subject: "hi", selector: "none"}}), "hi"), result: ChainFail, instances: 2, oldestPass: 1, reason: "ARC-Seal of instance 2: no key for selector none"},
+		{name: "sealed field modified", message: message(append(append([]string{}, three[:8]...), "ARC-Authentication-Results: i=1; mx1.example.net; spf=fail"), "hi"), result: ChainFail, instances: 3, oldestPass: 1, reason: "ARC-Seal of instance 3: signature did not verify"},
+		{name: "instance gap", message: message(append(append([]string{}, three[:3]...), three[6:]...), "hi"), result: ChainFail, instances: 1, reason: "instance 2 is missing"},
+		{name: "duplicate seal", message: message(append([]string{three[6]}, one...), "hi"), result: ChainFail, reason: "instance 1 has more than one ARC-Seal"},
+		{name: "missing signature", message: message([]string{one[0], one[2]}, "hi"), result: ChainFail, instances: 1, reason: "instance 1: missing ARC-Message-Signature"},
+		{name: "instance out of range", message: message(append([]string{"ARC-Seal: i=51; a=ed25519-sha256; cv=pass; d=example.net; s=arc; b=AAAA"}, one...), "hi"), result: ChainFail, reason: "ARC-Seal: instance 51 is out of range"},
+	}
+	for _, tt := range tests {
+		d := ValidateWithResolver(strings.NewReader(tt.message), z)
+		if d.Error != "" {
+			t.Errorf("%s: %s", tt.name, d.ErrorMessage)
+			continue
+		}
+		if d.Result != tt.result || d.Instances != tt.instances || d.OldestPass != tt.oldestPass {
+			t.Errorf("%s: got %s with %d instances and oldest pass %d, want %s with %d and %d", tt.name, d.Result, d.Instances, d.OldestPass, tt.result, tt.instances, tt.oldestPass)
+		}
+		if !strings.HasPrefix(d.Reason, tt.reason) || tt.reason == "" && d.Reason != "" {
+			t.Errorf("%s: got reason %q, want %q", tt.name, d.Reason, tt.reason)
+		}
+	}
+}
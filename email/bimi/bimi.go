@@ -0,0 +1,326 @@
+package emailbimi
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	dnsresolver "github.com/binaryfigments/goharvest/dns/resolver"
+	emaildmarc "github.com/binaryfigments/goharvest/email/dmarc"
+	"github.com/miekg/dns"
+	"golang.org/x/net/idna"
+	"golang.org/x/net/publicsuffix"
+)
+
+// Data struct with the BIMI record, logo and VMC of a domain
+type Data struct {
+	Domain       string    `json:"domain,omitempty"`
+	CheckTime    time.Time `json:"time"`
+	Record       string    `json:"record,omitempty"`
+	Inherited    bool      `json:"inherited"`
+	BIMI         []string  `json:"bimi,omitempty"`
+	Parsed       *Record   `json:"parsed,omitempty"`
+	DMARCPolicy  string    `json:"dmarcpolicy,omitempty"`
+	Logo         *Logo     `json:"logo,omitempty"`
+	VMC          *VMC      `json:"vmc,omitempty"`
+	Error        string    `json:"error,omitempty"`
+	ErrorMessage string    `json:"errormessage,omitempty"`
+}
+
+// Record struct for a parsed BIMI assertion record
+type Record struct {
+	Raw       string   `json:"raw,omitempty"`
+	Version   string   `json:"v,omitempty"`
+	Location  string   `json:"l,omitempty"`
+	Authority string   `json:"a,omitempty"`
+	Declined  bool     `json:"declined"`
+	Valid     bool     `json:"valid"`
+	Errors    []string `json:"errors,omitempty"`
+}
+
+// DefaultSelector is the selector senders use unless a message names one.
+const DefaultSelector = "default"
+
+// DefaultClient fetches logos and VMCs.
+var DefaultClient = &http.Client{Timeout: 10 * time.Second}
+
+// Get function of this package to get the BIMI record, logo and VMC
+func Get(domain string, nameserver string) *Data {
+	return GetWithResolver(domain, dnsresolver.Parse(nameserver))
+}
+
+// GetWithResolver is Get with a custom resolver.
+func GetWithResolver(domain string, resolver dnsresolver.Resolver) *Data {
+	return GetWithClient(domain, resolver, DefaultClient)
+}
+
+// GetWithClient is Get with a custom resolver and HTTP client. The record
+// is looked up at the domain and at its organizational domain when the
+// domain has none, like DMARC.
+func GetWithClient(domain string, resolver dnsresolver.Resolver, client *http.Client) *Data {
+	r := new(Data)
+	r.CheckTime = time.Now()
+
+	domain, err := idna.ToASCII(strings.TrimSuffix(strings.ToLower(domain), "."))
+	if err != nil {
+		r.Error = "Failed"
+		r.ErrorMessage = err.Error()
+		return r
+	}
+	r.Domain = domain
+
+	orgdomain, err := publicsuffix.EffectiveTLDPlusOne(domain)
+	if err != nil {
+		r.Error = "Failed"
+		r.ErrorMessage = err.Error()
+		return r
+	}
+
+	r.Record = DefaultSelector + "._bimi." + domain
+	r.BIMI, err = lookupBIMI(resolver, r.Record)
+	if err == nil && len(r.BIMI) == 0 && domain != orgdomain {
+		r.Record = DefaultSelector + "._bimi." + orgdomain
+		r.Inherited = true
+		r.BIMI, err = lookupBIMI(resolver, r.Record)
+	}
+	if err != nil {
+		r.Error = "Failed"
+		r.ErrorMessage = err.Error()
+		return r
+	}
+
+	// Check for records
+	if len(r.BIMI) < 1 {
+		r.Error = "Failed"
+		r.ErrorMessage = "No BIMI records."
+		return r
+	}
+
+	if len(r.BIMI) > 1 {
+		r.Error = "Failed"
+		r.ErrorMessage = "Multiple BIMI records."
+		return r
+	}
+
+	r.Parsed = Parse(r.BIMI[0])
+	if !r.Parsed.Valid {
+		r.Error = "Failed"
+		r.ErrorMessage = "Invalid BIMI record: " + strings.Join(r.Parsed.Errors, ", ")
+		return r
+	}
+	if r.Parsed.Declined {
+		r.Error = "Failed"
+		r.ErrorMessage = "Domain declined to publish a BIMI logo."
+		return r
+	}
+
+	// A logo is only shown for mail under an enforced DMARC policy.
+	dmarc := emaildmarc.GetWithResolver(domain, resolver)
+	if len(dmarc.Parsed) != 1 {
+		r.Error = "Failed"
+		r.ErrorMessage = "No usable DMARC record: " + dmarc.ErrorMessage
+		return r
+	}
+	r.DMARCPolicy = dmarc.Policy
+	if err := checkDMARC(dmarc); err != nil {
+		r.Error = "Failed"
+		r.ErrorMessage = err.Error()
+		return r
+	}
+
+	if r.Parsed.Location != "" {
+		r.Logo = GetLogo(r.Parsed.Location, client)
+	}
+	if r.Parsed.Authority != "" {
+		r.VMC = GetVMC(r.Parsed.Authority, domain, orgdomain, client)
+		if r.Logo != nil && r.Logo.Hash != "" && len(r.VMC.LogoHashes) > 0 {
+			r.VMC.LogoMatch = containsString(r.VMC.LogoHashes, r.Logo.Hash)
+		}
+	}
+
+	switch {
+	case r.Logo != nil && r.Logo.ErrorMessage != "":
+		r.Error = "Failed"
+		r.ErrorMessage = "Logo: " + r.Logo.ErrorMessage
+	case r.Logo != nil && !r.Logo.Valid:
+		r.Error = "Failed"
+		r.ErrorMessage = "Logo is not SVG Tiny PS: " + strings.Join(r.Logo.Errors, ", ")
+	case r.VMC != nil && r.VMC.ErrorMessage != "":
+		r.Error = "Failed"
+		r.ErrorMessage = "VMC: " + r.VMC.ErrorMessage
+	case r.VMC != nil && !r.VMC.Valid:
+		r.Error = "Failed"
+		r.ErrorMessage = "Invalid VMC: " + strings.Join(r.VMC.Errors, ", ")
+	case r.VMC != nil && r.Logo != nil && !r.VMC.LogoMatch:
+		r.Error = "Failed"
+		r.ErrorMessage = "Logo does not match the logo in the VMC."
+	}
+	return r
+}
+
+var version = regexp.MustCompile(`^v[ \t]*=[ \t]*BIMI1[ \t]*(;|$)`)
+
+// IsBIMI reports whether a TXT record is a BIMI record, that is whether it
+// starts with v=BIMI1.
+func IsBIMI(txt string) bool {
+	return version.MatchString(strings.TrimLeft(txt, " \t"))
+}
+
+// Parse parses and validates a BIMI record. A record with an empty l= and
+// no a= declines to show a logo.
+func Parse(record string) *Record {
+	r := &Record{Raw: record}
+
+	if !IsBIMI(record) {
+		r.addError("record does not start with v=BIMI1")
+		return r
+	}
+
+	seen := make(map[string]bool)
+	for _, spec := range strings.Split(record, ";") {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+		eq := strings.Index(spec, "=")
+		if eq < 0 {
+			r.addError("invalid tag: " + spec)
+			continue
+		}
+		tag := strings.ToLower(strings.TrimSpace(spec[:eq]))
+		value := strings.TrimSpace(spec[eq+1:])
+		if seen[tag] {
+			r.addError("duplicate tag: " + tag)
+			continue
+		}
+		seen[tag] = true
+
+		switch tag {
+		case "v":
+			r.Version = value
+		case "l":
+			if value != "" && !isHTTPS(value) {
+				r.addError("l must be an https URI: " + value)
+				continue
+			}
+			r.Location = value
+		case "a":
+			if value != "" && !isHTTPS(value) {
+				r.addError("a must be an https URI: " + value)
+				continue
+			}
+			r.Authority = value
+		default:
+			// Unknown tags are ignored.
+		}
+	}
+
+	if !seen["l"] && r.Authority == "" {
+		r.addError("missing required tag l")
+	}
+	r.Declined = r.Location == "" && r.Authority == ""
+
+	r.Valid = len(r.Errors) == 0
+	return r
+}
+
+func (r *Record) addError(err string) {
+	r.Errors = append(r.Errors, err)
+}
+
+/*
+ * Used functions
+ */
+
+// checkDMARC requires the policy of the domain to be p=quarantine with
+// pct=100 or p=reject. When the record is the one of the organizational
+// domain, p and sp must both be enforced, as sp covers every subdomain.
+func checkDMARC(dmarc *emaildmarc.Data) error {
+	record := dmarc.Parsed[0]
+	if !record.Valid {
+		return errors.New("invalid DMARC record: " + strings.Join(record.Errors, ", "))
+	}
+	policies := []string{dmarc.Policy}
+	if dmarc.Record == "_dmarc."+dmarc.OrganizationalDomain {
+		policies = append(policies, record.Policy, record.SubdomainPolicy)
+	}
+	for _, policy := range policies {
+		if policy != "quarantine" && policy != "reject" {
+			return errors.New("DMARC policy " + policy + " is not enforced, BIMI needs quarantine or reject")
+		}
+		if policy == "quarantine" && record.Percentage != 100 {
+			return errors.New("DMARC policy quarantine applies to less than 100% of the mail")
+		}
+	}
+	return nil
+}
+
+func isHTTPS(uri string) bool {
+	u, err := url.Parse(uri)
+	return err == nil && strings.EqualFold(u.Scheme, "https") && u.Host != ""
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// fetch gets uri and reads at most limit bytes of the body.
+func fetch(uri string, client *http.Client, limit int64) ([]byte, string, error) {
+	resp, err := client.Get(uri)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", errors.New("fetch of " + uri + " returned " + resp.Status)
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, limit+1))
+	if err != nil {
+		return nil, "", err
+	}
+	if int64(len(body)) > limit {
+		return nil, "", errors.New(uri + " is too large")
+	}
+	return body, resp.Header.Get("Content-Type"), nil
+}
+
+// lookupBIMI returns the TXT records at name that start with v=BIMI1, a non
+// existing name gives no records and no error.
+func lookupBIMI(resolver dnsresolver.Resolver, name string) ([]string, error) {
+	m := new(dns.Msg)
+	m.SetQuestion(dns.Fqdn(name), dns.TypeTXT)
+	m.SetEdns0(4096, true)
+	m.MsgHdr.RecursionDesired = true
+	in, err := resolver.Exchange(m)
+	if err != nil {
+		return nil, err
+	}
+
+	var records []string
+	switch rcode := in.MsgHdr.Rcode; rcode {
+	case dns.RcodeSuccess:
+		for _, ain := range in.Answer {
+			if a, ok := ain.(*dns.TXT); ok {
+				record := strings.Join(a.Txt, "")
+				if IsBIMI(record) {
+					records = append(records, record)
+				}
+			}
+		}
+	case dns.RcodeNameError:
+	default:
+		return nil, errors.New("DNS lookup for " + name + " failed: " + dns.RcodeToString[rcode])
+	}
+	return records, nil
+}
@@ -0,0 +1,126 @@
+package emailbimi
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	dnsresolver "github.com/binaryfigments/goharvest/dns/resolver"
+	emaildmarc "github.com/binaryfigments/goharvest/email/dmarc"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		record   string
+		valid    bool
+		declined bool
+		errors   string
+	}{
+		{"v=BIMI1; l=https://example.com/logo.svg", true, false, ""},
+		{"v=BIMI1; l=https://example.com/logo.svg; a=https://example.com/vmc.pem", true, false, ""},
+		{"v=BIMI1; l=; a=https://example.com/vmc.pem", true, false, ""},
+		{"v=BIMI1; l=", true, true, ""},
+		{"v=BIMI1;", false, true, "missing required tag l"},
+		{"v=BIMI1; l=http://example.com/logo.svg", false, true, "l must be an https URI: http://example.com/logo.svg"},
+		{"v=BIMI1; l=https://example.com/a.svg; l=https://example.com/b.svg", false, false, "duplicate tag: l"},
+		{"v=DMARC1; p=reject", false, false, "record does not start with v=BIMI1"},
+	}
+	for _, tt := range tests {
+		r := Parse(tt.record)
+		if r.Valid != tt.valid || r.Valid && r.Declined != tt.declined || strings.Join(r.Errors, ", ") != tt.errors {
+			t.Errorf("%q: got valid %v declined %v errors %q", tt.record, r.Valid, r.Declined, r.Errors)
+		}
+	}
+}
+
+func TestCheckDMARC(t *testing.T) {
+	z := dnsresolver.MustZone(
+		`_dmarc.reject.example. 60 IN TXT "v=DMARC1; p=reject"`,
+		`_dmarc.sp-none.example. 60 IN TXT "v=DMARC1; p=reject; sp=none"`,
+		`_dmarc.own.sp-none.example. 60 IN TXT "v=DMARC1; p=reject"`,
+		`_dmarc.quarantine.example. 60 IN TXT "v=DMARC1; p=quarantine"`,
+		`_dmarc.pct.example. 60 IN TXT "v=DMARC1; p=quarantine; pct=50"`,
+		`_dmarc.none.example. 60 IN TXT "v=DMARC1; p=none; sp=reject"`,
+	)
+
+	tests := []struct {
+		domain string
+		err    string
+	}{
+		{"reject.example", ""},
+		{"mail.reject.example", ""},
+		{"sp-none.example", "DMARC policy none is not enforced"},
+		{"mail.sp-none.example", "DMARC policy none is not enforced"},
+		{"own.sp-none.example", ""},
+		{"quarantine.example", ""},
+		{"pct.example", "applies to less than 100% of the mail"},
+		{"none.example", "DMARC policy none is not enforced"},
+		{"mail.none.example", "DMARC policy none is not enforced"},
+	}
+	for _, tt := range tests {
+		dmarc := emaildmarc.GetWithResolver(tt.domain, z)
+		if len(dmarc.Parsed) != 1 {
+			t.Fatalf("%s: %s", tt.domain, dmarc.ErrorMessage)
+		}
+		err := checkDMARC(dmarc)
+		if tt.err == "" && err != nil || tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+			t.Errorf("%s: got %v, want %q", tt.domain, err, tt.err)
+		}
+	}
+}
+
+func TestGetWithClient(t *testing.T) {
+	other := strings.Replace(logo, "red", "blue", 1)
+	files := map[string][]byte{
+		"/logo.svg":  []byte(logo),
+		"/other.svg": []byte(other),
+		"/bad.svg":   []byte(`<svg xmlns="http://www.w3.org/2000/svg"><title>Bad</title></svg>`),
+		"/vmc.pem":   markCertificate(t, "example.com", true, logotype(t, true, "image/svg+xml", dataURI(t, logo))),
+	}
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if file, ok := files[r.URL.Path]; ok {
+			w.Write(file)
+			return
+		}
+		http.NotFound(w, r)
+	}))
+	defer srv.Close()
+
+	z := dnsresolver.MustZone(
+		`_dmarc.example.com. 60 IN TXT "v=DMARC1; p=reject"`,
+		`default._bimi.example.com. 60 IN TXT "v=BIMI1; l=`+srv.URL+`/logo.svg; a=`+srv.URL+`/vmc.pem"`,
+		`default._bimi.other.example.com. 60 IN TXT "v=BIMI1; l=`+srv.URL+`/other.svg; a=`+srv.URL+`/vmc.pem"`,
+		`default._bimi.bad.example.com. 60 IN TXT "v=BIMI1; l=`+srv.URL+`/bad.svg"`,
+		`default._bimi.declined.example.com. 60 IN TXT "v=BIMI1; l="`,
+		`_dmarc.none.example. 60 IN TXT "v=DMARC1; p=none"`,
+		`default._bimi.none.example. 60 IN TXT "v=BIMI1; l=`+srv.URL+`/logo.svg"`,
+	)
+
+	tests := []struct {
+		domain    string
+		inherited bool
+		err       string
+	}{
+		{domain: "example.com"},
+		{domain: "mail.example.com", inherited: true},
+		{domain: "other.example.com", err: "Logo does not match the logo in the VMC."},
+		{domain: "bad.example.com", err: "Logo is not SVG Tiny PS: version must be 1.2, baseProfile must be tiny-ps"},
+		{domain: "declined.example.com", err: "Domain declined to publish a BIMI logo."},
+		{domain: "none.example", err: "DMARC policy none is not enforced"},
+		{domain: "missing.example", err: "No BIMI records."},
+	}
+	for _, tt := range tests {
+		r := GetWithClient(tt.domain, z, srv.Client())
+		if tt.err == "" && r.Error != "" || !strings.HasPrefix(r.ErrorMessage, tt.err) {
+			t.Errorf("%s: got error %q, want %q", tt.domain, r.ErrorMessage, tt.err)
+			continue
+		}
+		if r.Inherited != tt.inherited {
+			t.Errorf("%s: got inherited %v, want %v", tt.domain, r.Inherited, tt.inherited)
+		}
+		if tt.err == "" && (r.VMC == nil || !r.VMC.LogoMatch || r.Logo.Hash != logoHash(logo)) {
+			t.Errorf("%s: got logo %+v and VMC %+v", tt.domain, r.Logo, r.VMC)
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package emailbimi
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// Logo struct for a fetched BIMI logo and its SVG Tiny PS checks
+type Logo struct {
+	URL          string   `json:"url,omitempty"`
+	ContentType  string   `json:"contenttype,omitempty"`
+	Size         int      `json:"size"`
+	Hash         string   `json:"sha256,omitempty"`
+	Title        string   `json:"title,omitempty"`
+	Valid        bool     `json:"valid"`
+	Errors       []string `json:"errors,omitempty"`
+	ErrorMessage string   `json:"errormessage,omitempty"`
+}
+
+// MaxLogoSize is the largest logo mailbox providers accept.
+const MaxLogoSize = 32 * 1024
+
+// forbiddenElements are not part of the SVG Tiny PS profile: no scripting,
+// animation, multimedia, embedded documents or raster images.
+var forbiddenElements = map[string]bool{
+	"script": true, "foreignObject": true, "image": true,
+	"animate": true, "animateColor": true, "animateMotion": true,
+	"animateTransform": true, "set": true, "discard": true,
+	"audio": true, "video": true, "animation": true,
+	"handler": true, "listener": true, "prefetch": true,
+	"iframe": true, "embed": true, "object": true,
+}
+
+// GetLogo fetches the logo at uri and checks it.
+func GetLogo(uri string, client *http.Client) *Logo {
+	l := &Logo{URL: uri}
+
+	body, contentType, err := fetch(uri, client, MaxLogoSize*4)
+	if err != nil {
+		l.ErrorMessage = err.Error()
+		return l
+	}
+	l.ContentType = contentType
+
+	svg, err := gunzip(body)
+	if err != nil {
+		l.ErrorMessage = err.Error()
+		return l
+	}
+	checked := CheckSVG(svg)
+	checked.URL = l.URL
+	checked.ContentType = l.ContentType
+	if len(body) > MaxLogoSize {
+		checked.addError("logo is larger than 32 KB")
+		checked.Valid = false
+	}
+	return checked
+}
+
+// CheckSVG checks an SVG document against the SVG Tiny PS profile: the
+// root is a version 1.2 tiny-ps svg element without x or y, it has a title
+// and it has no forbidden elements, event handlers or external references.
+func CheckSVG(svg []byte) *Logo {
+	l := new(Logo)
+	l.Size = len(svg)
+	sum := sha256.Sum256(svg)
+	l.Hash = hex.EncodeToString(sum[:])
+
+	decoder := xml.NewDecoder(bytes.NewReader(svg))
+	depth := 0
+	root := false
+	inTitle := false
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			l.addError("invalid XML: " + err.Error())
+			break
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			depth++
+			if depth == 1 {
+				root = true
+				l.checkRoot(t)
+			}
+			if depth == 2 && t.Name.Local == "title" && l.Title == "" {
+				inTitle = true
+			}
+			if forbiddenElements[t.Name.Local] {
+				l.addError("element " + t.Name.Local + " is not allowed")
+			}
+			for _, attr := range t.Attr {
+				name := attr.Name.Local
+				if strings.HasPrefix(strings.ToLower(name), "on") {
+					l.addError("event handler " + name + " is not allowed")
+				}
+				if name == "href" && !strings.HasPrefix(strings.TrimSpace(attr.Value), "#") {
+					l.addError("external reference " + attr.Value + " is not allowed")
+				}
+			}
+		case xml.EndElement:
+			depth--
+			inTitle = false
+		case xml.CharData:
+			if inTitle {
+				l.Title += strings.TrimSpace(string(t))
+			}
+		}
+	}
+
+	if !root {
+		l.addError("no svg element")
+	}
+	if l.Title == "" {
+		l.addError("missing title")
+	}
+	l.Valid = len(l.Errors) == 0
+	return l
+}
+
+func (l *Logo) checkRoot(t xml.StartElement) {
+	if t.Name.Local != "svg" || t.Name.Space != "http://www.w3.org/2000/svg" {
+		l.addError("root element is not an SVG svg element")
+		return
+	}
+	attrs := make(map[string]string)
+	for _, attr := range t.Attr {
+		if attr.Name.Space == "" {
+			attrs[attr.Name.Local] = attr.Value
+		}
+	}
+	if attrs["version"] != "1.2" {
+		l.addError("version must be 1.2")
+	}
+	if attrs["baseProfile"] != "tiny-ps" {
+		l.addError("baseProfile must be tiny-ps")
+	}
+	for _, attr := range []string{"x", "y"} {
+		if _, ok := attrs[attr]; ok {
+			l.addError(attr + " is not allowed on the svg element")
+		}
+	}
+	if viewBox := strings.Fields(strings.Replace(attrs["viewBox"], ",", " ", -1)); len(viewBox) == 4 && viewBox[2] != viewBox[3] {
+		l.addError("logo is not square")
+	}
+}
+
+func (l *Logo) addError(err string) {
+	l.Errors = append(l.Errors, err)
+}
+
+// gunzip decompresses gzip data, other data is returned as it is.
+func gunzip(data []byte) ([]byte, error) {
+	if len(data) < 2 || data[0] != 0x1f || data[1] != 0x8b {
+		return data, nil
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	return ioutil.ReadAll(io.LimitReader(zr, MaxLogoSize*4))
+}
@@ -0,0 +1,193 @@
+package emailbimi
+
+import (
+	"crypto/sha256"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	pkicertificate "github.com/binaryfigments/goharvest/pki/certificate"
+	"github.com/zmap/zcrypto/x509"
+)
+
+// VMC struct for a Verified Mark Certificate and its checks
+type VMC struct {
+	URL          string              `json:"url,omitempty"`
+	Subject      string              `json:"subject,omitempty"`
+	Issuer       string              `json:"issuer,omitempty"`
+	NotBefore    time.Time           `json:"notbefore,omitempty"`
+	NotAfter     time.Time           `json:"notafter,omitempty"`
+	DNSNames     []string            `json:"dnsnames,omitempty"`
+	BIMIUsage    bool                `json:"bimiusage"`
+	Logotype     bool                `json:"logotype"`
+	LogoHashes   []string            `json:"logohashes,omitempty"`
+	LogoMatch    bool                `json:"logomatch"`
+	Valid        bool                `json:"valid"`
+	Errors       []string            `json:"errors,omitempty"`
+	Parsed       []*x509.Certificate `json:"parsed,omitempty"`
+	ErrorMessage string              `json:"errormessage,omitempty"`
+}
+
+// Object identifiers of the BIMI extended key usage and the logotype
+// extension (RFC 9399).
+const (
+	OIDBIMIUsage = "1.3.6.1.5.5.7.3.31"
+	OIDLogotype  = "1.3.6.1.5.5.7.1.12"
+)
+
+// MaxVMCSize is the largest PEM bundle that is fetched.
+const MaxVMCSize = 1024 * 1024
+
+// GetVMC fetches the PEM bundle at uri and checks the leaf certificate is
+// a mark certificate for domain or orgdomain.
+func GetVMC(uri string, domain string, orgdomain string, client *http.Client) *VMC {
+	v := &VMC{URL: uri}
+
+	body, _, err := fetch(uri, client, MaxVMCSize)
+	if err != nil {
+		v.ErrorMessage = err.Error()
+		return v
+	}
+	v.Parsed, err = pkicertificate.ParseChain(body)
+	if err != nil {
+		v.ErrorMessage = err.Error()
+		return v
+	}
+
+	leaf := v.Parsed[0]
+	v.Subject = leaf.Subject.String()
+	v.Issuer = leaf.Issuer.String()
+	v.NotBefore = leaf.NotBefore
+	v.NotAfter = leaf.NotAfter
+	v.DNSNames = leaf.DNSNames
+
+	for _, oid := range leaf.UnknownExtKeyUsage {
+		if oid.String() == OIDBIMIUsage {
+			v.BIMIUsage = true
+		}
+	}
+	for _, ext := range leaf.Extensions {
+		if ext.Id.String() == OIDLogotype {
+			v.Logotype = true
+			v.LogoHashes, err = logoHashes(ext.Value)
+			if err != nil {
+				v.addError("invalid logotype extension: " + err.Error())
+			}
+		}
+	}
+
+	if !v.BIMIUsage {
+		v.addError("no BIMI extended key usage")
+	}
+	if !v.Logotype {
+		v.addError("no logotype extension")
+	} else if err == nil && len(v.LogoHashes) == 0 {
+		v.addError("logotype extension has no embedded SVG logo")
+	}
+	now := time.Now()
+	if now.Before(v.NotBefore) || now.After(v.NotAfter) {
+		v.addError("certificate is not valid at this time")
+	}
+	matched := false
+	for _, name := range v.DNSNames {
+		name = strings.ToLower(name)
+		if name == domain || name == orgdomain {
+			matched = true
+		}
+	}
+	if !matched {
+		v.addError("certificate is not issued for " + domain)
+	}
+
+	v.Valid = len(v.Errors) == 0
+	return v
+}
+
+func (v *VMC) addError(err string) {
+	v.Errors = append(v.Errors, err)
+}
+
+// LogotypeExtn (RFC 3709 section 4.1) with the parts a VMC uses: the
+// subject logo, directly embedded as images. The module uses implicit tags.
+type logotypeExtn struct {
+	CommunityLogos asn1.RawValue `asn1:"optional,explicit,tag:0"`
+	IssuerLogo     asn1.RawValue `asn1:"optional,explicit,tag:1"`
+	SubjectLogo    asn1.RawValue `asn1:"optional,explicit,tag:2"`
+	OtherLogos     asn1.RawValue `asn1:"optional,explicit,tag:3"`
+}
+
+type logotypeData struct {
+	Images []logotypeImage `asn1:"optional"`
+	Audio  asn1.RawValue   `asn1:"optional,tag:1"`
+}
+
+type logotypeImage struct {
+	Details logotypeDetails
+	Info    asn1.RawValue `asn1:"optional"`
+}
+
+type logotypeDetails struct {
+	MediaType string `asn1:"ia5"`
+	Hashes    []hashAlgAndValue
+	URIs      []string
+}
+
+type hashAlgAndValue struct {
+	Algorithm pkix.AlgorithmIdentifier
+	Value     []byte
+}
+
+const svgDataURI = "data:image/svg+xml;base64,"
+
+// logoHashes returns the SHA-256 of every SVG logo embedded as a data URI
+// in the subject logo of a logotype extension.
+func logoHashes(value []byte) ([]string, error) {
+	var extn logotypeExtn
+	if rest, err := asn1.Unmarshal(value, &extn); err != nil {
+		return nil, err
+	} else if len(rest) > 0 {
+		return nil, errors.New("trailing data")
+	}
+	if len(extn.SubjectLogo.Bytes) == 0 {
+		return nil, nil
+	}
+	// The explicitly tagged LogotypeInfo is a choice of direct [0]
+	// LogotypeData or indirect [1] LogotypeReference, which only links to
+	// the logo.
+	var info asn1.RawValue
+	if _, err := asn1.Unmarshal(extn.SubjectLogo.Bytes, &info); err != nil {
+		return nil, err
+	}
+	if info.Class != asn1.ClassContextSpecific || info.Tag != 0 {
+		return nil, nil
+	}
+	var data logotypeData
+	if _, err := asn1.UnmarshalWithParams(info.FullBytes, &data, "tag:0"); err != nil {
+		return nil, err
+	}
+
+	var hashes []string
+	for _, image := range data.Images {
+		for _, uri := range image.Details.URIs {
+			if !strings.HasPrefix(uri, svgDataURI) {
+				continue
+			}
+			raw, err := base64.StdEncoding.DecodeString(uri[len(svgDataURI):])
+			if err != nil {
+				return nil, err
+			}
+			svg, err := gunzip(raw)
+			if err != nil {
+				return nil, err
+			}
+			sum := sha256.Sum256(svg)
+			hashes = append(hashes, hex.EncodeToString(sum[:]))
+		}
+	}
+	return hashes, nil
+}
@@ -0,0 +1,205 @@
+package emailbimi
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const logo = `<?xml version="1.0" encoding="UTF-8"?>
+<svg xmlns="http://www.w3.org/2000/svg" version="1.2" baseProfile="tiny-ps" viewBox="0 0 100 100"><title>Example</title><circle cx="50" cy="50" r="40" fill="red"/></svg>`
+
+func logoHash(svg string) string {
+	sum := sha256.Sum256([]byte(svg))
+	return hex.EncodeToString(sum[:])
+}
+
+// dataURI returns svg gzip compressed in a data URI as a VMC embeds it.
+func dataURI(t *testing.T, svg string) string {
+	t.Helper()
+	var b bytes.Buffer
+	w := gzip.NewWriter(&b)
+	w.Write([]byte(svg))
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return svgDataURI + base64.StdEncoding.EncodeToString(b.Bytes())
+}
+
+// logotype returns a LogotypeExtn with the subject logo as a direct image
+// of mediaType at the uris, or as an indirect reference.
+func logotype(t *testing.T, direct bool, mediaType string, uris ...string) []byte {
+	t.Helper()
+	sha256ID := pkix.AlgorithmIdentifier{Algorithm: asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}}
+	var info []byte
+	var err error
+	if direct {
+		type details struct {
+			MediaType string `asn1:"ia5"`
+			Hashes    []hashAlgAndValue
+			URIs      []asn1.RawValue
+		}
+		type image struct{ Details details }
+		data := struct{ Images []image }{[]image{{details{
+			MediaType: mediaType,
+			Hashes:    []hashAlgAndValue{{Algorithm: sha256ID, Value: make([]byte, 32)}},
+			URIs:      ia5s(uris),
+		}}}}
+		info, err = asn1.MarshalWithParams(data, "tag:0")
+	} else {
+		info, err = asn1.MarshalWithParams(struct {
+			Hashes []hashAlgAndValue
+			URIs   []asn1.RawValue
+		}{[]hashAlgAndValue{{Algorithm: sha256ID, Value: make([]byte, 32)}}, ia5s(uris)}, "tag:1")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	extn, err := asn1.Marshal(logotypeExtn{
+		SubjectLogo: asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 2, IsCompound: true, Bytes: info},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return extn
+}
+
+// ia5s returns the strings as IA5Strings, which a []string field can not
+// be marshalled to.
+func ia5s(s []string) []asn1.RawValue {
+	var values []asn1.RawValue
+	for _, v := range s {
+		values = append(values, asn1.RawValue{Tag: asn1.TagIA5String, Bytes: []byte(v)})
+	}
+	return values
+}
+
+func TestLogoHashes(t *testing.T) {
+	uri := dataURI(t, logo)
+	ia5, err := asn1.MarshalWithParams(uri, "ia5")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		value  []byte
+		hashes []string
+		err    string
+	}{
+		{"embedded SVG", logotype(t, true, "image/svg+xml", uri), []string{logoHash(logo)}, ""},
+		{"two URIs", logotype(t, true, "image/svg+xml", "https://example.com/logo.svg", svgDataURI+base64.StdEncoding.EncodeToString([]byte(logo))), []string{logoHash(logo)}, ""},
+		{"indirect", logotype(t, false, "", "https://example.com/logo.json"), nil, ""},
+		{"no subject logo", []byte{0x30, 0}, nil, ""},
+		{"broken base64", logotype(t, true, "image/svg+xml", svgDataURI+"!!"), nil, "illegal base64 data"},
+		{"bare data URI", ia5, nil, "structure error"},
+	}
+	for _, tt := range tests {
+		hashes, err := logoHashes(tt.value)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%s: got error %v, want %q", tt.name, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || strings.Join(hashes, " ") != strings.Join(tt.hashes, " ") {
+			t.Errorf("%s: got %v (%v), want %v", tt.name, hashes, err, tt.hashes)
+		}
+	}
+}
+
+// markCertificate returns a PEM mark certificate for dnsName with the
+// logotype extension, which is left out when nil.
+func markCertificate(t *testing.T, dnsName string, bimiUsage bool, extension []byte) []byte {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "Example"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     []string{dnsName},
+	}
+	if bimiUsage {
+		template.UnknownExtKeyUsage = []asn1.ObjectIdentifier{{1, 3, 6, 1, 5, 5, 7, 3, 31}}
+	}
+	if extension != nil {
+		template.ExtraExtensions = []pkix.Extension{{Id: asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 1, 12}, Value: extension}}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+func TestGetVMC(t *testing.T) {
+	embedded := logotype(t, true, "image/svg+xml", dataURI(t, logo))
+	certs := map[string][]byte{
+		"/valid.pem":       markCertificate(t, "example.com", true, embedded),
+		"/no-usage.pem":    markCertificate(t, "example.com", false, embedded),
+		"/no-logotype.pem": markCertificate(t, "example.com", true, nil),
+		"/indirect.pem":    markCertificate(t, "example.com", true, logotype(t, false, "", "https://example.com/logo.json")),
+		"/broken.pem":      markCertificate(t, "example.com", true, []byte{0x30, 0x03, 0xa2, 0x01}),
+		"/other.pem":       markCertificate(t, "other.example", true, embedded),
+		"/garbage.pem":     []byte("not a certificate"),
+	}
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if cert, ok := certs[r.URL.Path]; ok {
+			w.Write(cert)
+			return
+		}
+		http.NotFound(w, r)
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		path   string
+		errors string
+		err    string
+	}{
+		{"/valid.pem", "", ""},
+		{"/no-usage.pem", "no BIMI extended key usage", ""},
+		{"/no-logotype.pem", "no logotype extension", ""},
+		{"/indirect.pem", "logotype extension has no embedded SVG logo", ""},
+		{"/broken.pem", "invalid logotype extension: ", ""},
+		{"/other.pem", "certificate is not issued for mail.example.com", ""},
+		{"/garbage.pem", "", "invalid certificate"},
+		{"/missing.pem", "", "returned 404 Not Found"},
+	}
+	for _, tt := range tests {
+		v := GetVMC(srv.URL+tt.path, "mail.example.com", "example.com", srv.Client())
+		if tt.err != "" {
+			if !strings.Contains(v.ErrorMessage, tt.err) {
+				t.Errorf("%s: got error %q, want %q", tt.path, v.ErrorMessage, tt.err)
+			}
+			continue
+		}
+		if errors := strings.Join(v.Errors, "|"); !strings.HasPrefix(errors, tt.errors) || (tt.errors == "") != (errors == "") || v.Valid != (tt.errors == "") {
+			t.Errorf("%s: got errors %q, want %q", tt.path, v.Errors, tt.errors)
+		}
+	}
+
+	v := GetVMC(srv.URL+"/valid.pem", "example.com", "example.com", srv.Client())
+	if !v.BIMIUsage || !v.Logotype || len(v.LogoHashes) != 1 || v.LogoHashes[0] != logoHash(logo) || v.Subject != "CN=Example" {
+		t.Errorf("valid: got %+v", v)
+	}
+}
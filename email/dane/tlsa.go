@@ -1,292 +1,334 @@
 package emailtlsa
 
 import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"net/smtp"
 	"strconv"
+	"strings"
+	"time"
 
+	dnsresolver "github.com/binaryfigments/goharvest/dns/resolver"
+	emailmx "github.com/binaryfigments/goharvest/email/mx"
 	"github.com/miekg/dns"
-	"golang.org/x/net/publicsuffix"
 )
 
 // Data struct
 type Data struct {
-	Domain       string `json:"domain,omitempty"`
-	DomainKey    string `json:"domainkey,omitempty"`
-	Error        string `json:"error,omitempty"`
-	ErrorMessage string `json:"errormessage,omitempty"`
+	Domain       string    `json:"domain,omitempty"`
+	CheckTime    time.Time `json:"time"`
+	MX           []*MX     `json:"mx,omitempty"`
+	Error        string    `json:"error,omitempty"`
+	ErrorMessage string    `json:"errormessage,omitempty"`
 }
 
-// ----
+// MX struct with the DANE result for one mail server
+type MX struct {
+	Server       string  `json:"server,omitempty"`
+	Preference   uint16  `json:"preference,omitempty"`
+	Record       string  `json:"record,omitempty"`
+	DNSSEC       bool    `json:"dnssec"`
+	TLSA         []*TLSA `json:"tlsa,omitempty"`
+	Result       string  `json:"result,omitempty"`
+	Error        string  `json:"error,omitempty"`
+	ErrorMessage string  `json:"errormessage,omitempty"`
+}
 
-func Run2(domain string, startnameserver string, checkCerts string) (*checkdata.Message, error) {
-	msg := new(checkdata.Message)
-	nameServer := startnameserver + ":53"
-	msg.Question.JobTime = time.Now()
-	msg.Question.JobDomain = domain
+// TLSA struct for a TLSA record and how it matched the presented chain
+type TLSA struct {
+	Usage        uint8  `json:"usage"`
+	Selector     uint8  `json:"selector"`
+	MatchingType uint8  `json:"matchingtype"`
+	Certificate  string `json:"certificate,omitempty"`
+	Match        bool   `json:"match"`
+	Depth        int    `json:"depth"`
+	ErrorMessage string `json:"errormessage,omitempty"`
+}
 
-	// Valid domain name (ASCII or IDN)
-	domain, err := idna.ToASCII(domain)
-	if err != nil {
-		msg.Question.JobStatus = "Failed"
-		msg.Question.JobMessage = "Non ASCII or IDN characters in domain."
-		return msg, err
-	}
+// Results for a mail server. Insecure means TLSA records were found but
+// not DNSSEC validated, RFC 7672 treats them as unusable so the server is
+// not checked. Unusable means every record has a usage SMTP does not
+// support.
+const (
+	ResultPass     = "Pass"
+	ResultFail     = "Fail"
+	ResultNone     = "None"
+	ResultInsecure = "Insecure"
+	ResultUnusable = "Unusable"
+)
 
-	// Validate
-	domain, err = publicsuffix.EffectiveTLDPlusOne(domain)
-	if err != nil {
-		msg.Question.JobStatus = "Failed"
-		msg.Question.JobMessage = "Domain not OK"
-		return msg, err
-	}
+// Get resolves the MX records for domain, fetches their TLSA records and
+// matches them against the certificate chain offered after STARTTLS.
+func Get(domain string, nameserver string) *Data {
+	return GetWithResolver(domain, dnsresolver.Parse(nameserver))
+}
 
-	// Check DNS!
-	domainstate := checkDomainState(domain, nameServer)
-	if domainstate != "OK" {
-		// log.Println(domainstate)
-		msg.Question.JobStatus = "Failed"
-		msg.Question.JobMessage = domainstate
-		return msg, err
+// GetWithResolver is Get with a custom resolver.
+func GetWithResolver(domain string, resolver dnsresolver.Resolver) *Data {
+	r := new(Data)
+	r.Domain = domain
+	r.CheckTime = time.Now()
+
+	mx := emailmx.GetWithResolver(domain, resolver)
+	if mx.Error != "" {
+		r.Error = mx.Error
+		r.ErrorMessage = mx.ErrorMessage
+		return r
 	}
+	r.Domain = mx.Domain
 
-	// Get MX records
-	mxrecords, err := resolveMxTlsa(domain, nameServer, checkCerts)
-	msg.Answer.MxRecords = mxrecords
-	if msg.Answer.MxRecords == nil {
-		fmt.Printf("[X] No MX records found for  %v\n", domain)
+	if len(mx.Records) == 0 {
+		r.Error = "Failed"
+		r.ErrorMessage = "No MX records."
+		return r
 	}
 
-	// Get TLSA records and Certs
-	for _, mx := range msg.Answer.MxRecords {
-		hostname := strings.TrimSuffix(mx.Mx, ".")
-		hosnameport := hostname + ":25"
-		checktlsamx := "_25._tcp." + hostname
-
-		domainmxtlsa, err := resolveTLSARecords(checktlsamx, nameServer)
-		if err != nil {
-			mx.TLSA = domainmxtlsa
-		} else {
-			mx.TLSA = domainmxtlsa
-
-			if checkCerts == "yes" {
-				for _, tlsar := range domainmxtlsa {
-					certinfo, err := getCertInfo(hosnameport, tlsar.Selector, tlsar.MatchingType)
-					if err != nil {
-						tlsar.ServerCertificate = certinfo
-					} else {
-						tlsar.ServerCertificate = certinfo
-					}
-				}
-			}
-
-		}
+	for _, record := range mx.Records {
+		r.MX = append(r.MX, checkMX(record, resolver))
 	}
 
-	msg.Question.JobStatus = "OK"
-	msg.Question.JobMessage = "Job done!"
-
-	return msg, err
+	return r
 }
 
 /*
  * Used functions
- * TODO: Rewrite
  */
 
-func resolveMxTlsa(domain string, nameserver string, checkCerts string) ([]*checkdata.MxRecords, error) {
-	answer := []*checkdata.MxRecords{}
+func checkMX(record *emailmx.Records, resolver dnsresolver.Resolver) *MX {
+	hostname := strings.TrimSuffix(record.Server, ".")
+	r := &MX{
+		Server:     record.Server,
+		Preference: record.Preference,
+		Record:     "_25._tcp." + hostname,
+	}
 
-	m := new(dns.Msg)
-	m.SetQuestion(dns.Fqdn(domain), dns.TypeMX)
-	m.MsgHdr.RecursionDesired = true
-	c := new(dns.Client)
-	in, _, err := c.Exchange(m, nameserver)
+	tlsa, ad, err := resolveTLSARecords(r.Record, resolver)
 	if err != nil {
-		return answer, err
+		r.Result = ResultFail
+		r.Error = "Failed"
+		r.ErrorMessage = err.Error()
+		return r
+	}
+	r.DNSSEC = ad
+	if len(tlsa) == 0 {
+		r.Result = ResultNone
+		return r
 	}
-	for _, ain := range in.Answer {
-		if a, ok := ain.(*dns.MX); ok {
-			mxs := new(checkdata.MxRecords)
-			mxs.Mx = a.Mx
-			mxs.Preference = a.Preference
-			answer = append(answer, mxs)
+	if !ad {
+		r.Result = ResultInsecure
+		r.ErrorMessage = "TLSA records are not DNSSEC validated."
+		for _, t := range tlsa {
+			r.TLSA = append(r.TLSA, newTLSA(t))
 		}
+		return r
 	}
-	return answer, nil
-}
 
-// resolveTLSARecords for checking TLSA
-func resolveTLSARecords(record string, nameserver string) ([]*checkdata.Tlsa, error) {
-	answer := []*checkdata.Tlsa{}
-	m := new(dns.Msg)
-	m.SetQuestion(dns.Fqdn(record), dns.TypeTLSA)
-	c := new(dns.Client)
-	m.MsgHdr.RecursionDesired = true
-	in, _, err := c.Exchange(m, nameserver)
-	if err != nil {
-		return answer, err
+	usable := false
+	for _, t := range tlsa {
+		if smtpUsage(t) == nil {
+			usable = true
+		}
 	}
-	for _, value := range in.Answer {
-		if tlsa, ok := value.(*dns.TLSA); ok {
-			//
-			tlsar := new(checkdata.Tlsa)
-			tlsar.Record = record
-			tlsar.Certificate = tlsa.Certificate
-			tlsar.MatchingType = tlsa.MatchingType
-			tlsar.Selector = tlsa.Selector
-			tlsar.Usage = tlsa.Usage
-			answer = append(answer, tlsar)
-
-			//
+	if !usable {
+		r.Result = ResultUnusable
+		r.ErrorMessage = "No TLSA record has a usage SMTP supports."
+		for _, t := range tlsa {
+			result := newTLSA(t)
+			result.ErrorMessage = smtpUsage(t).Error()
+			r.TLSA = append(r.TLSA, result)
 		}
+		return r
 	}
-	return answer, nil
-}
-
-
-// ------
-
-func Run3(domain string, startnameserver string, checkCerts string) (*checkdata.Message, error) {
-	msg := new(checkdata.Message)
-	nameServer := startnameserver + ":53"
-	msg.Question.JobTime = time.Now()
-	msg.Question.JobDomain = domain
 
-	// Valid domain name (ASCII or IDN)
-	domain, err := idna.ToASCII(domain)
+	chain, err := getCertificates(hostname, 25)
 	if err != nil {
-		msg.Question.JobStatus = "Failed"
-		msg.Question.JobMessage = "Non ASCII or IDN characters in domain."
-		return msg, err
+		r.Result = ResultFail
+		r.Error = "Failed"
+		r.ErrorMessage = err.Error()
+		for _, t := range tlsa {
+			r.TLSA = append(r.TLSA, newTLSA(t))
+		}
+		return r
 	}
 
-	// Validate
-	domain, err = publicsuffix.EffectiveTLDPlusOne(domain)
-	if err != nil {
-		msg.Question.JobStatus = "Failed"
-		msg.Question.JobMessage = "Domain not OK"
-		return msg, err
+	r.Result = ResultFail
+	for _, t := range tlsa {
+		result := newTLSA(t)
+		if err := smtpUsage(t); err != nil {
+			result.ErrorMessage = err.Error()
+			r.TLSA = append(r.TLSA, result)
+			continue
+		}
+		result.Depth, err = matchTLSA(t, hostname, chain, nil)
+		if err != nil {
+			result.ErrorMessage = err.Error()
+		} else {
+			result.Match = true
+			r.Result = ResultPass
+		}
+		r.TLSA = append(r.TLSA, result)
 	}
 
-	// Check DNS!
-	domainstate := checkDomainState(domain, nameServer)
-	if domainstate != "OK" {
-		// log.Println(domainstate)
-		msg.Question.JobStatus = "Failed"
-		msg.Question.JobMessage = domainstate
-		return msg, err
+	return r
+}
+
+func newTLSA(t *dns.TLSA) *TLSA {
+	return &TLSA{
+		Usage:        t.Usage,
+		Selector:     t.Selector,
+		MatchingType: t.MatchingType,
+		Certificate:  t.Certificate,
+		Depth:        -1,
 	}
+}
 
-	// Get MX records
-	mxrecords, err := resolveMxTlsa(domain, nameServer, checkCerts)
-	msg.Answer.MxRecords = mxrecords
-	if msg.Answer.MxRecords == nil {
-		fmt.Printf("[X] No MX records found for  %v\n", domain)
+// smtpUsage returns an error for the PKIX-TA and PKIX-EE usages, which SMTP
+// clients treat as unusable (RFC 7672 section 3.1.3).
+func smtpUsage(t *dns.TLSA) error {
+	if t.Usage == 0 || t.Usage == 1 {
+		return errors.New("TLSA usage " + strconv.Itoa(int(t.Usage)) + " (PKIX) is unusable for SMTP")
 	}
+	return nil
+}
 
-	// Get TLSA records and Certs
-	for _, mx := range msg.Answer.MxRecords {
-		hostname := strings.TrimSuffix(mx.Mx, ".")
-		hosnameport := hostname + ":25"
-		checktlsamx := "_25._tcp." + hostname
+// matchTLSA matches a TLSA record against the presented chain following
+// RFC 6698 and returns the depth of the matching certificate. The PKIX
+// usages validate against roots, nil means the system store.
+func matchTLSA(t *dns.TLSA, hostname string, chain []*x509.Certificate, roots *x509.CertPool) (int, error) {
+	switch t.Selector {
+	case 0, 1:
+	default:
+		return -1, errors.New("unknown TLSA selector")
+	}
+	switch t.MatchingType {
+	case 0, 1, 2:
+	default:
+		return -1, errors.New("unknown TLSA matching type")
+	}
 
-		domainmxtlsa, err := resolveTLSARecords(checktlsamx, nameServer)
+	switch t.Usage {
+	case 0: // PKIX-TA
+		// The trust anchor has to be on a validated path, a served
+		// certificate outside of it does not count.
+		paths, err := verifyPKIX(hostname, chain, roots)
 		if err != nil {
-			mx.TLSA = domainmxtlsa
-		} else {
-			mx.TLSA = domainmxtlsa
-
-			if checkCerts == "yes" {
-				for _, tlsar := range domainmxtlsa {
-					certinfo, err := getCertInfo(hosnameport, tlsar.Selector, tlsar.MatchingType)
-					if err != nil {
-						tlsar.ServerCertificate = certinfo
-					} else {
-						tlsar.ServerCertificate = certinfo
-					}
+			return -1, err
+		}
+		for _, path := range paths {
+			for i, cert := range path[1:] {
+				if certificateMatch(t, cert) {
+					return i + 1, nil
 				}
 			}
-
 		}
+		return -1, errors.New("no certificate of the validated chain matches")
+	case 1: // PKIX-EE
+		if certificateMatch(t, chain[0]) {
+			if _, err := verifyPKIX(hostname, chain, roots); err != nil {
+				return 0, err
+			}
+			return 0, nil
+		}
+	case 2: // DANE-TA
+		for i, cert := range chain[1:] {
+			if certificateMatch(t, cert) {
+				anchor := x509.NewCertPool()
+				anchor.AddCert(cert)
+				if _, err := verifyPKIX(hostname, chain[:i+1], anchor); err != nil {
+					return i + 1, err
+				}
+				return i + 1, nil
+			}
+		}
+	case 3: // DANE-EE
+		if certificateMatch(t, chain[0]) {
+			return 0, nil
+		}
+	default:
+		return -1, errors.New("unknown TLSA usage")
 	}
+	return -1, errors.New("no certificate in the chain matches")
+}
 
-	msg.Question.JobStatus = "OK"
-	msg.Question.JobMessage = "Job done!"
+func certificateMatch(t *dns.TLSA, cert *x509.Certificate) bool {
+	data, err := dns.CertificateToDANE(t.Selector, t.MatchingType, cert)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(data, t.Certificate)
+}
 
-	return msg, err
+// verifyPKIX validates the chain for hostname and returns the validated
+// paths. With nil roots the system store is used.
+func verifyPKIX(hostname string, chain []*x509.Certificate, roots *x509.CertPool) ([][]*x509.Certificate, error) {
+	intermediates := x509.NewCertPool()
+	for _, cert := range chain[1:] {
+		intermediates.AddCert(cert)
+	}
+	return chain[0].Verify(x509.VerifyOptions{
+		DNSName:       hostname,
+		Roots:         roots,
+		Intermediates: intermediates,
+	})
 }
 
-/*
- * Used functions
- * TODO: Rewrite
- */
+// getCertificates connects to the mail server, issues STARTTLS and returns
+// the presented chain.
+func getCertificates(hostname string, port int) ([]*x509.Certificate, error) {
+	address := net.JoinHostPort(hostname, strconv.Itoa(port))
+	conn, err := net.DialTimeout("tcp", address, 5*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Now().Add(15 * time.Second))
+
+	c, err := smtp.NewClient(conn, hostname)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	defer c.Close()
 
-func resolveMxTlsa(domain string, nameserver string, checkCerts string) ([]*checkdata.MxRecords, error) {
-	answer := []*checkdata.MxRecords{}
+	if ok, _ := c.Extension("STARTTLS"); !ok {
+		return nil, errors.New("STARTTLS not offered")
+	}
 
-	m := new(dns.Msg)
-	m.SetQuestion(dns.Fqdn(domain), dns.TypeMX)
-	m.MsgHdr.RecursionDesired = true
-	c := new(dns.Client)
-	in, _, err := c.Exchange(m, nameserver)
+	err = c.StartTLS(&tls.Config{
+		InsecureSkipVerify: true,
+		ServerName:         hostname,
+	})
 	if err != nil {
-		return answer, err
+		return nil, err
 	}
-	for _, ain := range in.Answer {
-		if a, ok := ain.(*dns.MX); ok {
-			mxs := new(checkdata.MxRecords)
-			mxs.Mx = a.Mx
-			mxs.Preference = a.Preference
-
-			/*
-				hostname := strings.TrimSuffix(a.Mx, ".")
-				hosnameport := hostname + ":25"
-				checktlsamx := "_25._tcp." + hostname
-				domainmxtlsa, err := resolveTLSARecord(checktlsamx, nameserver)
-				if err != nil {
-					mxs.TLSA = domainmxtlsa
-				} else {
-					mxs.TLSA = domainmxtlsa
-					if checkCerts == "yes" {
-						certinfo, err := getCertInfo(hosnameport, mxs.TLSA.Selector, mxs.TLSA.MatchingType)
-						if err != nil {
-							mxs.CertInfo = certinfo
-						} else {
-							mxs.CertInfo = certinfo
-						}
-					}
-				}
-			*/
 
-			answer = append(answer, mxs)
-		}
+	cs, ok := c.TLSConnectionState()
+	if !ok || len(cs.PeerCertificates) == 0 {
+		return nil, errors.New("no certificates presented")
 	}
-	return answer, nil
+	c.Quit()
+
+	return cs.PeerCertificates, nil
 }
 
-// resolveTLSARecords for checking TLSA
-func resolveTLSARecords(record string, nameserver string) ([]*checkdata.Tlsa, error) {
-	answer := []*checkdata.Tlsa{}
+// resolveTLSARecords for checking TLSA, also reports if the answer was
+// DNSSEC validated by the resolver.
+func resolveTLSARecords(record string, resolver dnsresolver.Resolver) ([]*dns.TLSA, bool, error) {
+	var answer []*dns.TLSA
 	m := new(dns.Msg)
 	m.SetQuestion(dns.Fqdn(record), dns.TypeTLSA)
-	c := new(dns.Client)
 	m.MsgHdr.RecursionDesired = true
-	in, _, err := c.Exchange(m, nameserver)
+	m.MsgHdr.AuthenticatedData = true
+	m.SetEdns0(4096, true)
+	in, err := resolver.Exchange(m)
 	if err != nil {
-		return answer, err
+		return nil, false, err
 	}
 	for _, value := range in.Answer {
 		if tlsa, ok := value.(*dns.TLSA); ok {
-			//
-			tlsar := new(checkdata.Tlsa)
-			tlsar.Record = record
-			tlsar.Certificate = tlsa.Certificate
-			tlsar.MatchingType = tlsa.MatchingType
-			tlsar.Selector = tlsa.Selector
-			tlsar.Usage = tlsa.Usage
-			answer = append(answer, tlsar)
-
-			//
+			answer = append(answer, tlsa)
 		}
 	}
-	return answer, nil
-}
\ No newline at end of file
+	return answer, in.MsgHdr.AuthenticatedData, nil
+}
@@ -0,0 +1,160 @@
+package emailtlsa
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	dnsresolver "github.com/binaryfigments/goharvest/dns/resolver"
+	"github.com/miekg/dns"
+)
+
+func TestGetWithResolver(t *testing.T) {
+	z := dnsresolver.MustZone(
+		`example.com. 60 IN MX 10 mx1.example.com.`,
+		`example.com. 60 IN MX 20 mx2.example.com.`,
+		`_25._tcp.mx1.example.com. 60 IN TLSA 3 1 1 0000000000000000000000000000000000000000000000000000000000000000`,
+	)
+	failing := dnsresolver.ResolverFunc(func(m *dns.Msg) (*dns.Msg, error) {
+		if m.Question[0].Qtype == dns.TypeTLSA {
+			return nil, errors.New("timeout")
+		}
+		return z.Exchange(m)
+	})
+
+	pkix := dnsresolver.MustZone(
+		`example.com. 60 IN MX 10 mx1.example.com.`,
+		`_25._tcp.mx1.example.com. 60 IN TLSA 1 1 1 0000000000000000000000000000000000000000000000000000000000000000`,
+		`_25._tcp.mx1.example.com. 60 IN TLSA 0 1 1 0000000000000000000000000000000000000000000000000000000000000000`,
+	)
+	pkix.AuthenticatedData = true
+
+	tests := []struct {
+		name     string
+		resolver dnsresolver.Resolver
+		results  []string
+	}{
+		{"not validated", z, []string{ResultInsecure, ResultNone}},
+		{"PKIX usages only", pkix, []string{ResultUnusable}},
+		{"lookup error", failing, []string{ResultFail, ResultFail}},
+	}
+	for _, tt := range tests {
+		d := GetWithResolver("example.com", tt.resolver)
+		if d.Error != "" {
+			t.Errorf("%s: %s", tt.name, d.ErrorMessage)
+			continue
+		}
+		var results []string
+		for _, mx := range d.MX {
+			results = append(results, mx.Result)
+		}
+		if strings.Join(results, " ") != strings.Join(tt.results, " ") {
+			t.Errorf("%s: got %v, want %v", tt.name, results, tt.results)
+		}
+	}
+
+	d := GetWithResolver("example.com", dnsresolver.MustZone(`example.com. 60 IN A 192.0.2.1`))
+	if d.ErrorMessage != "No MX records." {
+		t.Errorf("no MX: got %q", d.ErrorMessage)
+	}
+}
+
+// certificate returns a certificate for name signed by parent, or
+// self-signed when parent is nil.
+func certificate(t *testing.T, name string, ca bool, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		BasicConstraintsValid: true,
+		IsCA:                  ca,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	if !ca {
+		template.DNSNames = []string{name}
+	}
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+func TestMatchTLSA(t *testing.T) {
+	ca, caKey := certificate(t, "Test CA", true, nil, nil)
+	leaf, _ := certificate(t, "mx.example.com", false, ca, caKey)
+	other, _ := certificate(t, "other.example.com", false, nil, nil)
+	chain := []*x509.Certificate{leaf, ca}
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+
+	tlsa := func(usage, selector, matchingType uint8, cert *x509.Certificate) *dns.TLSA {
+		data, err := dns.CertificateToDANE(selector, matchingType, cert)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return &dns.TLSA{Usage: usage, Selector: selector, MatchingType: matchingType, Certificate: data}
+	}
+
+	tests := []struct {
+		name  string
+		tlsa  *dns.TLSA
+		chain []*x509.Certificate
+		roots *x509.CertPool
+		depth int
+		err   string
+	}{
+		{"DANE-EE SPKI SHA-256", tlsa(3, 1, 1, leaf), chain, nil, 0, ""},
+		{"DANE-EE full certificate", tlsa(3, 0, 0, leaf), chain, nil, 0, ""},
+		{"DANE-EE other certificate", tlsa(3, 1, 1, other), chain, nil, -1, "no certificate in the chain matches"},
+		{"DANE-TA SHA-512", tlsa(2, 0, 2, ca), chain, nil, 1, ""},
+		{"DANE-TA not issuer", tlsa(2, 1, 1, other), []*x509.Certificate{leaf, other}, nil, 1, "unknown authority"},
+		{"PKIX-TA", tlsa(0, 1, 1, ca), chain, roots, 1, ""},
+		{"PKIX-TA root not served", tlsa(0, 1, 1, ca), []*x509.Certificate{leaf}, roots, 1, ""},
+		{"PKIX-TA served outside the path", tlsa(0, 1, 1, other), []*x509.Certificate{leaf, ca, other}, roots, -1, "no certificate of the validated chain matches"},
+		{"PKIX-TA untrusted", tlsa(0, 1, 1, ca), chain, nil, -1, "unknown authority"},
+		{"PKIX-EE", tlsa(1, 1, 1, leaf), chain, roots, 0, ""},
+		{"PKIX-EE untrusted", tlsa(1, 1, 1, leaf), chain, nil, 0, "unknown authority"},
+		{"unknown selector", &dns.TLSA{Usage: 3, Selector: 2, MatchingType: 1}, chain, nil, -1, "unknown TLSA selector"},
+		{"unknown usage", &dns.TLSA{Usage: 4, Selector: 1, MatchingType: 1}, chain, nil, -1, "unknown TLSA usage"},
+	}
+	for _, tt := range tests {
+		depth, err := matchTLSA(tt.tlsa, "mx.example.com", tt.chain, tt.roots)
+		if depth != tt.depth {
+			t.Errorf("%s: got depth %d, want %d", tt.name, depth, tt.depth)
+		}
+		if tt.err == "" && err != nil || tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+			t.Errorf("%s: got error %v, want %q", tt.name, err, tt.err)
+		}
+	}
+}
+
+func TestSMTPUsage(t *testing.T) {
+	for usage, usable := range []bool{false, false, true, true} {
+		err := smtpUsage(&dns.TLSA{Usage: uint8(usage)})
+		if (err == nil) != usable {
+			t.Errorf("usage %d: got %v, want usable %v", usage, err, usable)
+		}
+	}
+}
@@ -3,6 +3,7 @@ package emaildkim
 import (
 	"strconv"
 
+	dnsresolver "github.com/binaryfigments/goharvest/dns/resolver"
 	"github.com/miekg/dns"
 	"golang.org/x/net/publicsuffix"
 )
@@ -11,6 +12,7 @@ import (
 type Data struct {
 	Domain       string `json:"domain,omitempty"`
 	DomainKey    string `json:"domainkey,omitempty"`
+	Selectors    []*Key `json:"selectors,omitempty"`
 	Error        string `json:"error,omitempty"`
 	ErrorMessage string `json:"errormessage,omitempty"`
 }
@@ -21,6 +23,17 @@ type Data struct {
 
 // Get function of this package to get the DKIM record
 func Get(domain string, nameserver string) *Data {
+	return GetWithResolver(domain, dnsresolver.Parse(nameserver))
+}
+
+// GetWithResolver is Get with a custom resolver.
+func GetWithResolver(domain string, resolver dnsresolver.Resolver) *Data {
+	return GetWithSelectors(domain, DefaultSelectors, resolver)
+}
+
+// GetWithSelectors is Get probing the given selectors instead of
+// DefaultSelectors.
+func GetWithSelectors(domain string, selectors []string, resolver dnsresolver.Resolver) *Data {
 	r := new(Data)
 
 	domain, err := publicsuffix.EffectiveTLDPlusOne(domain)
@@ -36,8 +49,7 @@ func Get(domain string, nameserver string) *Data {
 	m.SetQuestion(dns.Fqdn(r.Domain), dns.TypeA)
 	m.SetEdns0(4096, true)
 	m.MsgHdr.RecursionDesired = true
-	c := new(dns.Client)
-	in, _, err := c.Exchange(m, nameserver+":53")
+	in, err := resolver.Exchange(m)
 	if err != nil {
 		r.Error = "Failed"
 		r.ErrorMessage = err.Error()
@@ -61,5 +73,18 @@ func Get(domain string, nameserver string) *Data {
 		r.DomainKey = "Code: " + strconv.Itoa(rcode)
 	}
 
+	// Probe the selectors, a selector without key record is left out.
+	for _, selector := range selectors {
+		key, err := LookupKey(selector, domain, resolver)
+		if err != nil {
+			r.Error = "Failed"
+			r.ErrorMessage = err.Error()
+			continue
+		}
+		if key != nil {
+			r.Selectors = append(r.Selectors, key)
+		}
+	}
+
 	return r
 }
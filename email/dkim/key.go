@@ -0,0 +1,224 @@
+package emaildkim
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"strings"
+
+	dnsresolver "github.com/binaryfigments/goharvest/dns/resolver"
+	"github.com/miekg/dns"
+)
+
+// Key struct for a parsed DKIM key record (RFC 6376 section 3.6.1)
+type Key struct {
+	Selector       string           `json:"selector,omitempty"`
+	Record         string           `json:"record,omitempty"`
+	Raw            string           `json:"raw,omitempty"`
+	Version        string           `json:"v,omitempty"`
+	KeyType        string           `json:"k,omitempty"`
+	HashAlgorithms []string         `json:"h,omitempty"`
+	ServiceTypes   []string         `json:"s,omitempty"`
+	Flags          []string         `json:"t,omitempty"`
+	Notes          string           `json:"n,omitempty"`
+	PublicKey      string           `json:"p,omitempty"`
+	Bits           int              `json:"bits,omitempty"`
+	Revoked        bool             `json:"revoked"`
+	Weak           bool             `json:"weak"`
+	Testing        bool             `json:"testing"`
+	Valid          bool             `json:"valid"`
+	Errors         []string         `json:"errors,omitempty"`
+	Key            crypto.PublicKey `json:"-"`
+}
+
+// MinRSABits is the smallest RSA key size that is not reported as weak.
+const MinRSABits = 1024
+
+// DefaultSelectors are the selectors Get probes, the defaults of common
+// mail providers and signing software.
+var DefaultSelectors = []string{
+	"default", "dkim", "mail", "email", "smtp",
+	"google", "selector1", "selector2",
+	"k1", "k2", "k3", "s1", "s2", "s1024", "s2048",
+	"mandrill", "mxvault", "mailjet", "sendgrid", "amazonses",
+	"everlytickey1", "everlytickey2", "sig1", "zoho", "zmail",
+	"protonmail", "protonmail2", "protonmail3",
+	"fm1", "fm2", "fm3", "key1", "key2", "dk", "mx", "pm",
+}
+
+// ParseKey parses a DKIM key record and decodes its public key.
+func ParseKey(record string) *Key {
+	k := &Key{
+		Raw:            record,
+		KeyType:        "rsa",
+		HashAlgorithms: []string{"*"},
+		ServiceTypes:   []string{"*"},
+	}
+
+	seen := make(map[string]bool)
+	hasKey := false
+	for i, spec := range strings.Split(record, ";") {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+		eq := strings.Index(spec, "=")
+		if eq < 0 {
+			k.addError("invalid tag: " + spec)
+			continue
+		}
+		tag := strings.TrimSpace(spec[:eq])
+		value := strings.TrimSpace(spec[eq+1:])
+		if seen[tag] {
+			k.addError("duplicate tag: " + tag)
+			continue
+		}
+		seen[tag] = true
+
+		switch tag {
+		case "v":
+			if i != 0 {
+				k.addError("v must be the first tag")
+			}
+			if value != "DKIM1" {
+				k.addError("unknown version: " + value)
+			}
+			k.Version = value
+		case "k":
+			k.KeyType = strings.ToLower(value)
+		case "h":
+			k.HashAlgorithms = splitList(value)
+		case "s":
+			k.ServiceTypes = splitList(value)
+		case "t":
+			k.Flags = splitList(value)
+		case "n":
+			k.Notes = value
+		case "p":
+			hasKey = true
+			k.PublicKey = strings.Join(strings.Fields(value), "")
+		default:
+			// Unknown tags must be ignored (RFC 6376 section 3.2).
+		}
+	}
+
+	for _, flag := range k.Flags {
+		if flag == "y" {
+			k.Testing = true
+		}
+	}
+
+	switch {
+	case !hasKey:
+		k.addError("missing required tag p")
+	case k.PublicKey == "":
+		k.Revoked = true
+	default:
+		if err := k.decode(); err != nil {
+			k.addError(err.Error())
+		}
+	}
+
+	if k.KeyType == "rsa" && k.Bits > 0 && k.Bits < MinRSABits {
+		k.Weak = true
+	}
+
+	k.Valid = len(k.Errors) == 0 && !k.Revoked
+	return k
+}
+
+func (k *Key) addError(err string) {
+	k.Errors = append(k.Errors, err)
+}
+
+// decode decodes the base64 public key according to the key type.
+func (k *Key) decode() error {
+	data, err := base64.StdEncoding.DecodeString(k.PublicKey)
+	if err != nil {
+		return errors.New("invalid base64 in p: " + err.Error())
+	}
+
+	switch k.KeyType {
+	case "rsa":
+		// Usually a SubjectPublicKeyInfo, some signers publish a bare
+		// RSAPublicKey.
+		if pub, err := x509.ParsePKIXPublicKey(data); err == nil {
+			rsapub, ok := pub.(*rsa.PublicKey)
+			if !ok {
+				return errors.New("key type is rsa but p holds another key type")
+			}
+			k.Key = rsapub
+			k.Bits = rsapub.N.BitLen()
+			return nil
+		}
+		rsapub, err := x509.ParsePKCS1PublicKey(data)
+		if err != nil {
+			return errors.New("invalid RSA public key")
+		}
+		k.Key = rsapub
+		k.Bits = rsapub.N.BitLen()
+	case "ed25519":
+		if len(data) != ed25519.PublicKeySize {
+			return errors.New("invalid Ed25519 public key")
+		}
+		k.Key = ed25519.PublicKey(data)
+		k.Bits = 256
+	default:
+		return errors.New("unknown key type: " + k.KeyType)
+	}
+	return nil
+}
+
+func splitList(value string) []string {
+	var list []string
+	for _, item := range strings.Split(value, ":") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// LookupKey fetches and parses the key record of selector for domain. It
+// returns nil without error when there is no key record.
+func LookupKey(selector string, domain string, resolver dnsresolver.Resolver) (*Key, error) {
+	name := selector + "._domainkey." + strings.TrimSuffix(domain, ".")
+	m := new(dns.Msg)
+	m.SetQuestion(dns.Fqdn(name), dns.TypeTXT)
+	m.SetEdns0(4096, true)
+	m.MsgHdr.RecursionDesired = true
+	in, err := resolver.Exchange(m)
+	if err != nil {
+		return nil, err
+	}
+
+	switch rcode := in.MsgHdr.Rcode; rcode {
+	case dns.RcodeSuccess:
+	case dns.RcodeNameError:
+		return nil, nil
+	default:
+		return nil, errors.New("DNS lookup for " + name + " failed: " + dns.RcodeToString[rcode])
+	}
+
+	var records []string
+	for _, ain := range in.Answer {
+		if a, ok := ain.(*dns.TXT); ok {
+			records = append(records, strings.Join(a.Txt, ""))
+		}
+	}
+	if len(records) == 0 {
+		return nil, nil
+	}
+
+	k := ParseKey(records[0])
+	if len(records) > 1 {
+		k.addError("multiple key records")
+		k.Valid = false
+	}
+	k.Selector = selector
+	k.Record = name
+	return k, nil
+}
@@ -0,0 +1,29 @@
+package emaildkim
+
+import "testing"
+
+func TestParseKey(t *testing.T) {
+	const rsa1024 = "MIGfMA0GCSqGSIb3DQEBAQUAA4GNADCBiQKBgQDwIRP/UC3SBsEmGqZ9ZJW3/DkMoGeLnQg1fWn7/zYtIxN2SnFCjxOCKG9v3b4jYfcTNh5ijSsq631uBItLa7od+v/RtdC2UzJ1lWT947qR+Rcac2gbto/NMqJ0fzfVjH4OuKhitdY9tf6mcwGjaNBcWToIMmPSPDdQPNUYckcQ2QIDAQAB"
+
+	tests := []struct {
+		record  string
+		keyType string
+		bits    int
+		valid   bool
+		revoked bool
+		testing bool
+	}{
+		{record: "v=DKIM1; k=rsa; p=" + rsa1024, keyType: "rsa", bits: 1024, valid: true},
+		{record: "v=DKIM1; k=ed25519; p=11qYAYKxCrfVS/7TyWQHOg7hcvPapiMlrwIaaPcHURo=", keyType: "ed25519", bits: 256, valid: true},
+		{record: "v=DKIM1; t=y; p=" + rsa1024, keyType: "rsa", bits: 1024, valid: true, testing: true},
+		{record: "v=DKIM1; p=", keyType: "rsa", revoked: true},
+		{record: "v=DKIM1; k=rsa; p=bm90IGEga2V5", keyType: "rsa"},
+		{record: "v=DKIM2; p=" + rsa1024, keyType: "rsa", bits: 1024},
+	}
+	for _, tt := range tests {
+		k := ParseKey(tt.record)
+		if k.KeyType != tt.keyType || k.Bits != tt.bits || k.Valid != tt.valid || k.Revoked != tt.revoked || k.Testing != tt.testing {
+			t.Errorf("%.40s: got k=%s bits=%d valid=%v revoked=%v testing=%v %v", tt.record, k.KeyType, k.Bits, k.Valid, k.Revoked, k.Testing, k.Errors)
+		}
+	}
+}
@@ -0,0 +1,534 @@
+package emaildkim
+
+import (
+	"bufio"
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	dnsresolver "github.com/binaryfigments/goharvest/dns/resolver"
+)
+
+// Verification struct with the results for every DKIM-Signature of a message
+type Verification struct {
+	CheckTime    time.Time    `json:"time"`
+	Signatures   []*Signature `json:"signatures,omitempty"`
+	Error        string       `json:"error,omitempty"`
+	ErrorMessage string       `json:"errormessage,omitempty"`
+}
+
+// Signature struct for one DKIM-Signature header
+type Signature struct {
+	Domain           string    `json:"domain,omitempty"`
+	Selector         string    `json:"selector,omitempty"`
+	Identity         string    `json:"identity,omitempty"`
+	Algorithm        string    `json:"algorithm,omitempty"`
+	Canonicalization string    `json:"canonicalization,omitempty"`
+	Headers          []string  `json:"headers,omitempty"`
+	BodyLength       int64     `json:"bodylength,omitempty"`
+	Timestamp        time.Time `json:"timestamp,omitempty"`
+	Expiration       time.Time `json:"expiration,omitempty"`
+	BodyHashValid    bool      `json:"bodyhashvalid"`
+	Result           string    `json:"result,omitempty"`
+	Key              *Key      `json:"key,omitempty"`
+	ErrorMessage     string    `json:"errormessage,omitempty"`
+}
+
+// Results of a signature (RFC 8601 section 2.7.1)
+const (
+	ResultPass      = "pass"
+	ResultFail      = "fail"
+	ResultTempError = "temperror"
+	ResultPermError = "permerror"
+)
+
+// Message struct for a raw RFC 5322 message split in header fields and body
+type Message struct {
+	Headers []*Header
+	Body    []byte
+}
+
+// Header struct for a header field, Raw holds the field as it appeared
+// including folding but without the final CRLF.
+type Header struct {
+	Name  string
+	Value string
+	Raw   string
+}
+
+// VerifyFile verifies the DKIM signatures of the message in file.
+func VerifyFile(file string, nameserver string) *Verification {
+	f, err := os.Open(file)
+	if err != nil {
+		r := new(Verification)
+		r.CheckTime = time.Now()
+		r.Error = "Failed"
+		r.ErrorMessage = err.Error()
+		return r
+	}
+	defer f.Close()
+	return Verify(f, nameserver)
+}
+
+// Verify verifies the DKIM signatures of the message read from r.
+func Verify(r io.Reader, nameserver string) *Verification {
+	return VerifyWithResolver(r, dnsresolver.Parse(nameserver))
+}
+
+// VerifyWithResolver is Verify with a custom resolver.
+func VerifyWithResolver(r io.Reader, resolver dnsresolver.Resolver) *Verification {
+	v := new(Verification)
+	v.CheckTime = time.Now()
+
+	msg, err := ReadMessage(r)
+	if err != nil {
+		v.Error = "Failed"
+		v.ErrorMessage = err.Error()
+		return v
+	}
+
+	for _, h := range msg.Headers {
+		if strings.EqualFold(h.Name, "DKIM-Signature") {
+			v.Signatures = append(v.Signatures, VerifySignature(msg, h, DKIMSignature, resolver))
+		}
+	}
+
+	if len(v.Signatures) == 0 {
+		v.Error = "Failed"
+		v.ErrorMessage = "No DKIM signatures."
+	}
+	return v
+}
+
+// SignatureFormat struct with what sets a kind of DKIM signature apart:
+// the tags it requires, its own checks of the tags and the data it signs.
+// Check runs after the common tags are parsed, an error is a permerror.
+type SignatureFormat struct {
+	Required   []string
+	Check      func(s *Signature, tags map[string]string) error
+	SignedData func(msg *Message, names []string, signature *Header, relaxed bool) []byte
+}
+
+// DKIMSignature is the format of a DKIM-Signature (RFC 6376).
+var DKIMSignature = &SignatureFormat{
+	Required: []string{"v", "a", "b", "bh", "d", "h", "s"},
+	Check: func(s *Signature, tags map[string]string) error {
+		if tags["v"] != "1" {
+			return errors.New("unknown version: " + tags["v"])
+		}
+		if !containsFold(s.Headers, "From") {
+			return errors.New("From is not signed")
+		}
+		s.Identity = tags["i"]
+		if s.Identity == "" {
+			s.Identity = "@" + s.Domain
+		}
+		at := strings.LastIndex(s.Identity, "@")
+		identityDomain := strings.ToLower(s.Identity[at+1:])
+		if at < 0 || (identityDomain != s.Domain && !strings.HasSuffix(identityDomain, "."+s.Domain)) {
+			return errors.New("identity " + s.Identity + " is not in domain " + s.Domain)
+		}
+		return nil
+	},
+	SignedData: SignedData,
+}
+
+// VerifySignature verifies the signature in header field h of msg as a
+// signature of format. The key must allow an identity in a subdomain unless
+// the format set an Identity in the signing domain itself or none.
+func VerifySignature(msg *Message, h *Header, format *SignatureFormat, resolver dnsresolver.Resolver) *Signature {
+	s := &Signature{BodyLength: -1}
+	fail := func(result string, err string) *Signature {
+		s.Result = result
+		s.ErrorMessage = err
+		return s
+	}
+
+	tags, err := ParseTags(h.Value)
+	if err != nil {
+		return fail(ResultPermError, err.Error())
+	}
+	for _, tag := range format.Required {
+		if _, ok := tags[tag]; !ok {
+			return fail(ResultPermError, "missing required tag "+tag)
+		}
+	}
+
+	s.Domain = strings.ToLower(tags["d"])
+	s.Selector = tags["s"]
+	s.Algorithm = strings.ToLower(tags["a"])
+	s.Canonicalization = tags["c"]
+	if s.Canonicalization == "" {
+		s.Canonicalization = "simple/simple"
+	}
+	for _, name := range strings.Split(tags["h"], ":") {
+		s.Headers = append(s.Headers, strings.TrimSpace(name))
+	}
+	if t, err := strconv.ParseInt(tags["t"], 10, 64); err == nil {
+		s.Timestamp = time.Unix(t, 0).UTC()
+	}
+	if x, err := strconv.ParseInt(tags["x"], 10, 64); err == nil {
+		s.Expiration = time.Unix(x, 0).UTC()
+	}
+
+	headerCanon, bodyCanon, err := parseCanonicalization(s.Canonicalization)
+	if err != nil {
+		return fail(ResultPermError, err.Error())
+	}
+	if format.Check != nil {
+		if err := format.Check(s, tags); err != nil {
+			return fail(ResultPermError, err.Error())
+		}
+	}
+	exactIdentity := true
+	if at := strings.LastIndex(s.Identity, "@"); at >= 0 {
+		exactIdentity = strings.EqualFold(s.Identity[at+1:], s.Domain)
+	}
+	if !s.Expiration.IsZero() && time.Now().After(s.Expiration) {
+		return fail(ResultPermError, "signature expired")
+	}
+	if l, ok := tags["l"]; ok {
+		if s.BodyLength, err = strconv.ParseInt(l, 10, 64); err != nil || s.BodyLength < 0 {
+			return fail(ResultPermError, "invalid body length: "+l)
+		}
+	}
+
+	// Body hash
+	body := CanonicalBody(msg.Body, bodyCanon == "relaxed")
+	if s.BodyLength >= 0 {
+		if s.BodyLength > int64(len(body)) {
+			return fail(ResultPermError, "body length is longer than the body")
+		}
+		body = body[:s.BodyLength]
+	}
+	sum, err := BodyHash(body, s.Algorithm)
+	if err != nil {
+		return fail(ResultPermError, err.Error())
+	}
+	bh, err := base64.StdEncoding.DecodeString(stripWSP(tags["bh"]))
+	if err != nil {
+		return fail(ResultPermError, "invalid body hash: "+err.Error())
+	}
+	s.BodyHashValid = bytes.Equal(sum, bh)
+
+	// Key
+	key, err := LookupKey(s.Selector, s.Domain, resolver)
+	if err != nil {
+		return fail(ResultTempError, err.Error())
+	}
+	if key == nil {
+		return fail(ResultPermError, "no key for selector "+s.Selector)
+	}
+	s.Key = key
+	if err := CheckKey(key, s.Algorithm, exactIdentity); err != nil {
+		return fail(ResultPermError, err.Error())
+	}
+
+	if !s.BodyHashValid {
+		return fail(ResultFail, "body hash did not verify")
+	}
+
+	sig, err := base64.StdEncoding.DecodeString(stripWSP(tags["b"]))
+	if err != nil {
+		return fail(ResultPermError, "invalid signature: "+err.Error())
+	}
+	data := format.SignedData(msg, s.Headers, h, headerCanon == "relaxed")
+	if err := VerifyData(key, s.Algorithm, data, sig); err != nil {
+		return fail(ResultFail, err.Error())
+	}
+
+	s.Result = ResultPass
+	return s
+}
+
+/*
+ * Used functions
+ */
+
+// CheckKey checks the key record is usable for a signature with algorithm,
+// exactIdentity tells whether the signing identity is in the signing domain
+// itself as the s flag requires.
+func CheckKey(key *Key, algorithm string, exactIdentity bool) error {
+	if key.Revoked {
+		return errors.New("key is revoked")
+	}
+	if !key.Valid {
+		return errors.New("invalid key record: " + strings.Join(key.Errors, ", "))
+	}
+	if !strings.HasPrefix(algorithm, key.KeyType+"-") {
+		return errors.New("key type " + key.KeyType + " does not match algorithm " + algorithm)
+	}
+	hash := algorithm[strings.Index(algorithm, "-")+1:]
+	if !containsFold(key.HashAlgorithms, "*") && !containsFold(key.HashAlgorithms, hash) {
+		return errors.New("key does not allow hash " + hash)
+	}
+	if !containsFold(key.ServiceTypes, "*") && !containsFold(key.ServiceTypes, "email") {
+		return errors.New("key is not for email")
+	}
+	if containsFold(key.Flags, "s") && !exactIdentity {
+		return errors.New("key requires the identity to be in the signing domain itself")
+	}
+	return nil
+}
+
+func parseCanonicalization(c string) (string, string, error) {
+	parts := strings.SplitN(strings.ToLower(c), "/", 2)
+	header, body := parts[0], "simple"
+	if len(parts) == 2 {
+		body = parts[1]
+	}
+	for _, canon := range []string{header, body} {
+		if canon != "simple" && canon != "relaxed" {
+			return "", "", errors.New("unknown canonicalization: " + c)
+		}
+	}
+	return header, body, nil
+}
+
+func hashAlgorithm(algorithm string) (crypto.Hash, error) {
+	switch algorithm {
+	case "rsa-sha256", "ed25519-sha256":
+		return crypto.SHA256, nil
+	case "rsa-sha1":
+		return crypto.SHA1, nil
+	}
+	return 0, errors.New("unknown algorithm: " + algorithm)
+}
+
+// BodyHash hashes a canonicalized body with the hash of the DKIM algorithm.
+func BodyHash(body []byte, algorithm string) ([]byte, error) {
+	hash, err := hashAlgorithm(algorithm)
+	if err != nil {
+		return nil, err
+	}
+	h := hash.New()
+	h.Write(body)
+	return h.Sum(nil), nil
+}
+
+// VerifyData verifies signature over data with key and the DKIM algorithm
+// (rsa-sha256, rsa-sha1 or ed25519-sha256).
+func VerifyData(key *Key, algorithm string, data []byte, signature []byte) error {
+	hash, err := hashAlgorithm(algorithm)
+	if err != nil {
+		return err
+	}
+	var digest []byte
+	if hash == crypto.SHA1 {
+		sum := sha1.Sum(data)
+		digest = sum[:]
+	} else {
+		sum := sha256.Sum256(data)
+		digest = sum[:]
+	}
+
+	switch pub := key.Key.(type) {
+	case *rsa.PublicKey:
+		if err := rsa.VerifyPKCS1v15(pub, hash, digest, signature); err != nil {
+			return errors.New("signature did not verify")
+		}
+	case ed25519.PublicKey:
+		if !ed25519.Verify(pub, digest, signature) {
+			return errors.New("signature did not verify")
+		}
+	default:
+		return errors.New("unsupported key")
+	}
+	return nil
+}
+
+// SignedData returns the canonicalized header fields listed in names
+// followed by the signature header itself with an empty b= tag.
+func SignedData(msg *Message, names []string, signature *Header, relaxed bool) []byte {
+	var data bytes.Buffer
+	for _, h := range msg.SelectHeaders(names) {
+		data.WriteString(CanonicalHeader(h, relaxed))
+		data.WriteString("\r\n")
+	}
+	data.WriteString(CanonicalHeader(RemoveSignature(signature), relaxed))
+	return data.Bytes()
+}
+
+// SelectHeaders returns the header fields for names as RFC 6376 section
+// 5.4.2 describes: a name that occurs more than once selects the fields
+// from the bottom up, names without (remaining) field are skipped.
+func (m *Message) SelectHeaders(names []string) []*Header {
+	used := make(map[*Header]bool)
+	var selected []*Header
+	for _, name := range names {
+		for i := len(m.Headers) - 1; i >= 0; i-- {
+			h := m.Headers[i]
+			if !used[h] && strings.EqualFold(h.Name, strings.TrimSpace(name)) {
+				used[h] = true
+				selected = append(selected, h)
+				break
+			}
+		}
+	}
+	return selected
+}
+
+var signatureTag = regexp.MustCompile(`(^|;)([ \t\r\n]*b[ \t\r\n]*=)[^;]*`)
+
+// RemoveSignature returns a copy of h with the value of the b= tag removed.
+func RemoveSignature(h *Header) *Header {
+	value := signatureTag.ReplaceAllString(h.Value, "$1$2")
+	return &Header{
+		Name:  h.Name,
+		Value: value,
+		Raw:   h.Raw[:len(h.Raw)-len(h.Value)] + value,
+	}
+}
+
+// CanonicalHeader canonicalizes a header field without the final CRLF.
+func CanonicalHeader(h *Header, relaxed bool) string {
+	if !relaxed {
+		return h.Raw
+	}
+	value := strings.Replace(h.Value, "\r\n", "", -1)
+	value = strings.Join(strings.FieldsFunc(value, isWSP), " ")
+	return strings.ToLower(strings.TrimRight(h.Name, " \t")) + ":" + value
+}
+
+// CanonicalBody canonicalizes a message body.
+func CanonicalBody(body []byte, relaxed bool) []byte {
+	lines := strings.Split(string(body), "\r\n")
+	if relaxed {
+		for i, line := range lines {
+			lines[i] = strings.TrimRight(strings.Join(splitWSP(line), " "), " ")
+		}
+	}
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	if len(lines) == 0 {
+		if relaxed {
+			return []byte{}
+		}
+		return []byte("\r\n")
+	}
+	return []byte(strings.Join(lines, "\r\n") + "\r\n")
+}
+
+// splitWSP splits line on runs of whitespace, keeping an empty first field
+// for leading whitespace so it is reduced to one space.
+func splitWSP(line string) []string {
+	fields := strings.FieldsFunc(line, isWSP)
+	if line != "" && isWSP(rune(line[0])) {
+		fields = append([]string{""}, fields...)
+	}
+	return fields
+}
+
+func isWSP(r rune) bool {
+	return r == ' ' || r == '\t'
+}
+
+func stripWSP(s string) string {
+	return strings.Join(strings.Fields(s), "")
+}
+
+func containsFold(list []string, s string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseTags parses a tag=value list (RFC 6376 section 3.2).
+func ParseTags(value string) (map[string]string, error) {
+	tags := make(map[string]string)
+	for _, spec := range strings.Split(value, ";") {
+		if strings.TrimSpace(spec) == "" {
+			continue
+		}
+		eq := strings.Index(spec, "=")
+		if eq < 0 {
+			return nil, errors.New("invalid tag: " + strings.TrimSpace(spec))
+		}
+		tag := strings.TrimSpace(spec[:eq])
+		if _, ok := tags[tag]; ok {
+			return nil, errors.New("duplicate tag: " + tag)
+		}
+		tags[tag] = strings.TrimSpace(spec[eq+1:])
+	}
+	return tags, nil
+}
+
+// ReadMessage reads a raw message. Bare LF line endings, as in most saved
+// .eml files, are converted to CRLF.
+func ReadMessage(r io.Reader) (*Message, error) {
+	raw, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	raw = bytes.Replace(raw, []byte("\r\n"), []byte("\n"), -1)
+	raw = bytes.Replace(raw, []byte("\n"), []byte("\r\n"), -1)
+
+	msg := new(Message)
+	header := raw
+	if i := bytes.Index(raw, []byte("\r\n\r\n")); i >= 0 {
+		header = raw[:i+2]
+		msg.Body = raw[i+4:]
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(header))
+	scanner.Buffer(make([]byte, 64*1024), len(header)+1)
+	scanner.Split(scanCRLF)
+	var current *Header
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			break
+		}
+		if isWSP(rune(line[0])) {
+			if current == nil {
+				return nil, errors.New("message starts with a continuation line")
+			}
+			current.Value += "\r\n" + line
+			current.Raw += "\r\n" + line
+			continue
+		}
+		colon := strings.Index(line, ":")
+		if colon <= 0 {
+			return nil, errors.New("invalid header line: " + line)
+		}
+		current = &Header{
+			Name:  line[:colon],
+			Value: line[colon+1:],
+			Raw:   line,
+		}
+		msg.Headers = append(msg.Headers, current)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(msg.Headers) == 0 {
+		return nil, errors.New("message has no header fields")
+	}
+	return msg, nil
+}
+
+func scanCRLF(data []byte, atEOF bool) (int, []byte, error) {
+	if i := bytes.Index(data, []byte("\r\n")); i >= 0 {
+		return i + 2, data[:i], nil
+	}
+	if atEOF && len(data) > 0 {
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
@@ -0,0 +1,147 @@
+package emaildkim
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	dnsresolver "github.com/binaryfigments/goharvest/dns/resolver"
+)
+
+// signed returns a message with a simple/simple signature in header field
+// name over the From and Subject fields. tags are the tags before h=, bh=
+// and b=, sign signs the SHA-256 digest of the signed data.
+func signed(name string, tags string, sign func(digest []byte) []byte) string {
+	const from, subject, body = "From: Joe <joe@example.com>", "Subject: hi", "Hello\r\n"
+	bh := sha256.Sum256([]byte(body))
+	field := name + ": " + tags + ";\r\n h=From:Subject; bh=" + base64.StdEncoding.EncodeToString(bh[:]) + "; b="
+	digest := sha256.Sum256([]byte(from + "\r\n" + subject + "\r\n" + field))
+	b := base64.StdEncoding.EncodeToString(sign(digest[:]))
+	return field + b[:20] + "\r\n " + b[20:] + "\r\n" + from + "\r\n" + subject + "\r\n\r\n" + body
+}
+
+func TestVerifyWithResolver(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := base64.StdEncoding.EncodeToString(der)
+	edPub, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	z := dnsresolver.MustZone(
+		`rsa._domainkey.example.com. 60 IN TXT "v=DKIM1; k=rsa; p=`+p[:200]+`" "`+p[200:]+`"`,
+		`ed._domainkey.example.com. 60 IN TXT "v=DKIM1; k=ed25519; p=`+base64.StdEncoding.EncodeToString(edPub)+`"`,
+		`strict._domainkey.example.com. 60 IN TXT "v=DKIM1; k=ed25519; t=s; p=`+base64.StdEncoding.EncodeToString(edPub)+`"`,
+	)
+	signRSA := func(digest []byte) []byte {
+		sig, err := rsa.SignPKCS1v15(rand.Reader, rsaKey, crypto.SHA256, digest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return sig
+	}
+	signEd := func(digest []byte) []byte {
+		return ed25519.Sign(edKey, digest)
+	}
+
+	tests := []struct {
+		name    string
+		message string
+		want    string
+		err     string
+	}{
+		{"rsa", signed("DKIM-Signature", "v=1; a=rsa-sha256; d=example.com; s=rsa", signRSA), ResultPass, ""},
+		{"ed25519", signed("DKIM-Signature", "v=1; a=ed25519-sha256; d=example.com; s=ed", signEd), ResultPass, ""},
+		{"tampered body", signed("DKIM-Signature", "v=1; a=rsa-sha256; d=example.com; s=rsa", signRSA) + "more\r\n", ResultFail, "body hash did not verify"},
+		{"wrong key", signed("DKIM-Signature", "v=1; a=ed25519-sha256; d=example.com; s=ed", signRSA), ResultFail, "signature did not verify"},
+		{"no key", signed("DKIM-Signature", "v=1; a=rsa-sha256; d=example.com; s=none", signRSA), ResultPermError, "no key for selector none"},
+		{"version", signed("DKIM-Signature", "v=2; a=rsa-sha256; d=example.com; s=rsa", signRSA), ResultPermError, "unknown version: 2"},
+		{"identity", signed("DKIM-Signature", "v=1; a=rsa-sha256; d=example.com; s=rsa; i=joe@example.org", signRSA), ResultPermError, "is not in domain"},
+		{"strict key", signed("DKIM-Signature", "v=1; a=ed25519-sha256; d=example.com; s=strict; i=@mail.example.com", signEd), ResultPermError, "identity to be in the signing domain"},
+		{"missing tag", signed("DKIM-Signature", "v=1; a=rsa-sha256; d=example.com", signRSA), ResultPermError, "missing required tag s"},
+	}
+	for _, tt := range tests {
+		v := VerifyWithResolver(strings.NewReader(tt.message), z)
+		if len(v.Signatures) != 1 {
+			t.Errorf("%s: got %d signatures (%s)", tt.name, len(v.Signatures), v.ErrorMessage)
+			continue
+		}
+		s := v.Signatures[0]
+		if s.Result != tt.want || !strings.Contains(s.ErrorMessage, tt.err) {
+			t.Errorf("%s: got %s (%s), want %s (%s)", tt.name, s.Result, s.ErrorMessage, tt.want, tt.err)
+		}
+	}
+}
+
+func TestVerifySignatureFormat(t *testing.T) {
+	pub, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	z := dnsresolver.MustZone(`ed._domainkey.example.com. 60 IN TXT "v=DKIM1; k=ed25519; p=` + base64.StdEncoding.EncodeToString(pub) + `"`)
+	format := &SignatureFormat{
+		Required:   []string{"a", "b", "bh", "d", "h", "s"},
+		SignedData: SignedData,
+	}
+	msg, err := ReadMessage(strings.NewReader(signed("X-Signature", "i=1; a=ed25519-sha256; d=example.com; s=ed", func(digest []byte) []byte {
+		return ed25519.Sign(key, digest)
+	})))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		format *SignatureFormat
+		want   string
+	}{
+		{"custom format", format, ResultPass},
+		{"as DKIM-Signature", DKIMSignature, ResultPermError},
+	}
+	for _, tt := range tests {
+		if s := VerifySignature(msg, msg.Headers[0], tt.format, z); s.Result != tt.want {
+			t.Errorf("%s: got %s (%s), want %s", tt.name, s.Result, s.ErrorMessage, tt.want)
+		}
+	}
+}
+
+func TestCanonicalization(t *testing.T) {
+	msg, err := ReadMessage(strings.NewReader("A: X\r\nB : Y\t\r\n\tZ  \r\n\r\n C \r\nD \t E\r\n\r\n\r\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		relaxed bool
+		headers string
+		body    string
+	}{
+		{"simple", false, "A: X\r\nB : Y\t\r\n\tZ  \r\n", " C \r\nD \t E\r\n"},
+		{"relaxed", true, "a:X\r\nb:Y Z\r\n", " C\r\nD E\r\n"},
+	}
+	for _, tt := range tests {
+		var headers string
+		for _, h := range msg.Headers {
+			headers += CanonicalHeader(h, tt.relaxed) + "\r\n"
+		}
+		if headers != tt.headers {
+			t.Errorf("%s headers: got %q, want %q", tt.name, headers, tt.headers)
+		}
+		if body := string(CanonicalBody(msg.Body, tt.relaxed)); body != tt.body {
+			t.Errorf("%s body: got %q, want %q", tt.name, body, tt.body)
+		}
+	}
+}
@@ -1,62 +1,85 @@
 package emaildmarc
 
 import (
+	"errors"
+	"net/url"
 	"strings"
 
+	dnsresolver "github.com/binaryfigments/goharvest/dns/resolver"
 	"github.com/miekg/dns"
+	"golang.org/x/net/idna"
 	"golang.org/x/net/publicsuffix"
 )
 
 // Data struct
 type Data struct {
-	Record       string   `json:"domain,omitempty"`
+	Record               string               `json:"domain,omitempty"`
+	OrganizationalDomain string               `json:"orgdomain,omitempty"`
+	Inherited            bool                 `json:"inherited"`
+	Policy               string               `json:"policy,omitempty"`
+	DMARC                []string             `json:"dmarc,omitempty"`
+	Parsed               []*Record            `json:"parsed,omitempty"`
+	ReportDestinations   []*ReportDestination `json:"reportdestinations,omitempty"`
+	Error                string               `json:"error,omitempty"`
+	ErrorMessage         string               `json:"errormessage,omitempty"`
+}
+
+// ReportDestination struct for a rua or ruf address and, when it is on a
+// foreign domain, its authorization record (RFC 7489 section 7.1)
+type ReportDestination struct {
+	Tag          string   `json:"tag,omitempty"`
+	URI          string   `json:"uri,omitempty"`
+	Domain       string   `json:"domain,omitempty"`
+	External     bool     `json:"external"`
+	Authorized   bool     `json:"authorized"`
+	Record       string   `json:"record,omitempty"`
 	DMARC        []string `json:"dmarc,omitempty"`
-	Error        string   `json:"error,omitempty"`
 	ErrorMessage string   `json:"errormessage,omitempty"`
 }
 
 // Get function of this package to get the DMARC record
 func Get(domain string, nameserver string) *Data {
+	return GetWithResolver(domain, dnsresolver.Parse(nameserver))
+}
+
+// GetWithResolver is Get with a custom resolver. The policy is looked up at
+// the domain itself first and at the organizational domain when the domain
+// has none (RFC 7489 section 6.6.3).
+func GetWithResolver(domain string, resolver dnsresolver.Resolver) *Data {
 	r := new(Data)
 
-	domain, err := publicsuffix.EffectiveTLDPlusOne(domain)
+	domain, err := idna.ToASCII(strings.TrimSuffix(strings.ToLower(domain), "."))
 	if err != nil {
 		r.Error = "Failed"
 		r.ErrorMessage = err.Error()
+		return r
 	}
 
-	r.Record = "_dmarc." + domain
-
-	m := new(dns.Msg)
-	m.SetQuestion(dns.Fqdn(r.Record), dns.TypeTXT)
-	m.SetEdns0(4096, true)
-	m.MsgHdr.RecursionDesired = true
-	c := new(dns.Client)
-	in, _, err := c.Exchange(m, nameserver+":53")
+	orgdomain, err := publicsuffix.EffectiveTLDPlusOne(domain)
 	if err != nil {
 		r.Error = "Failed"
 		r.ErrorMessage = err.Error()
 		return r
 	}
+	r.OrganizationalDomain = orgdomain
 
-	switch rcode := in.MsgHdr.Rcode; rcode {
-	case dns.RcodeSuccess:
-		for _, ain := range in.Answer {
-			if a, ok := ain.(*dns.TXT); ok {
-
-				dmarcrecord := strings.Join(a.Txt, " ")
-				if caseInsenstiveContains(dmarcrecord, "v=DMARC1") == true {
-					r.DMARC = append(r.DMARC, dmarcrecord)
-				}
-
-			}
-		}
-	default:
+	r.Record = "_dmarc." + domain
+	r.DMARC, err = lookupDMARC(resolver, r.Record)
+	if err == nil && len(r.DMARC) == 0 && domain != orgdomain {
+		r.Record = "_dmarc." + orgdomain
+		r.Inherited = true
+		r.DMARC, err = lookupDMARC(resolver, r.Record)
+	}
+	if err != nil {
 		r.Error = "Failed"
-		r.ErrorMessage = "No DMARC records."
+		r.ErrorMessage = err.Error()
 		return r
 	}
 
+	for _, record := range r.DMARC {
+		r.Parsed = append(r.Parsed, Parse(record))
+	}
+
 	// Check for records
 	if len(r.DMARC) < 1 {
 		r.Error = "Failed"
@@ -64,9 +87,118 @@ func Get(domain string, nameserver string) *Data {
 		return r
 	}
 
+	if len(r.DMARC) > 1 {
+		r.Error = "Failed"
+		r.ErrorMessage = "Multiple DMARC records."
+		return r
+	}
+
+	record := r.Parsed[0]
+	r.Policy = record.Policy
+	if r.Inherited {
+		r.Policy = record.SubdomainPolicy
+	}
+
+	policydomain := strings.TrimPrefix(r.Record, "_dmarc.")
+	for _, uri := range record.RUA {
+		r.ReportDestinations = append(r.ReportDestinations, verifyDestination(resolver, policydomain, orgdomain, "rua", uri))
+	}
+	for _, uri := range record.RUF {
+		r.ReportDestinations = append(r.ReportDestinations, verifyDestination(resolver, policydomain, orgdomain, "ruf", uri))
+	}
+
+	if !record.Valid {
+		r.Error = "Failed"
+		r.ErrorMessage = "Invalid DMARC record: " + strings.Join(record.Errors, ", ")
+		return r
+	}
+
+	for _, destination := range r.ReportDestinations {
+		if destination.External && !destination.Authorized {
+			r.Error = "Failed"
+			r.ErrorMessage = "Report destination " + destination.Domain + " has not authorized reports for " + policydomain + "."
+			return r
+		}
+	}
+
 	return r
 }
 
-func caseInsenstiveContains(a, b string) bool {
-	return strings.Contains(strings.ToUpper(a), strings.ToUpper(b))
+/*
+ * Used functions
+ */
+
+// verifyDestination checks a mailto: report URI. An address outside the
+// organizational domain has to be authorized by a DMARC record at
+// <policydomain>._report._dmarc.<destination>.
+func verifyDestination(resolver dnsresolver.Resolver, policydomain string, orgdomain string, tag string, uri string) *ReportDestination {
+	d := &ReportDestination{Tag: tag, URI: uri}
+
+	address := uri
+	if i := strings.LastIndex(address, "!"); i >= 0 {
+		address = address[:i]
+	}
+	u, err := url.Parse(address)
+	if err != nil || !strings.EqualFold(u.Scheme, "mailto") {
+		d.ErrorMessage = "Only mailto: destinations are checked."
+		return d
+	}
+	at := strings.LastIndex(u.Opaque, "@")
+	if at < 0 {
+		d.ErrorMessage = "Invalid mailto address."
+		return d
+	}
+	d.Domain = strings.ToLower(u.Opaque[at+1:])
+
+	destorg, err := publicsuffix.EffectiveTLDPlusOne(d.Domain)
+	if err != nil {
+		d.ErrorMessage = err.Error()
+		return d
+	}
+	if destorg == orgdomain {
+		d.Authorized = true
+		return d
+	}
+
+	d.External = true
+	d.Record = policydomain + "._report._dmarc." + d.Domain
+	d.DMARC, err = lookupDMARC(resolver, d.Record)
+	if err != nil {
+		d.ErrorMessage = err.Error()
+		return d
+	}
+	d.Authorized = len(d.DMARC) > 0
+	return d
+}
+
+// lookupDMARC returns the TXT records at name that start with v=DMARC1, a
+// non existing name gives no records and no error.
+func lookupDMARC(resolver dnsresolver.Resolver, name string) ([]string, error) {
+	m := new(dns.Msg)
+	m.SetQuestion(dns.Fqdn(name), dns.TypeTXT)
+	m.SetEdns0(4096, true)
+	m.MsgHdr.RecursionDesired = true
+	in, err := resolver.Exchange(m)
+	if err != nil {
+		return nil, err
+	}
+
+	var records []string
+	switch rcode := in.MsgHdr.Rcode; rcode {
+	case dns.RcodeSuccess:
+		for _, ain := range in.Answer {
+			if a, ok := ain.(*dns.TXT); ok {
+
+				dmarcrecord := strings.Join(a.Txt, "")
+				if IsDMARC(dmarcrecord) {
+					records = append(records, dmarcrecord)
+				}
+
+			}
+		}
+	case dns.RcodeNameError:
+	default:
+		return nil, errors.New("DNS lookup for " + name + " failed: " + dns.RcodeToString[rcode])
+	}
+	return records, nil
 }
@@ -0,0 +1,63 @@
+package emaildmarc
+
+import (
+	"strings"
+	"testing"
+
+	dnsresolver "github.com/binaryfigments/goharvest/dns/resolver"
+)
+
+func TestGetWithResolver(t *testing.T) {
+	z := dnsresolver.MustZone(
+		`_dmarc.example.com. 60 IN TXT "v=DMARC1; p=reject; sp=quarantine; rua=mailto:d@example.com,mailto:x@reports.example.net!10m"`,
+		`example.com._report._dmarc.reports.example.net. 60 IN TXT "v=DMARC1"`,
+		`_dmarc.unauthorized.example. 60 IN TXT "v=DMARC1; p=none; rua=mailto:x@reports.example.net"`,
+		`_dmarc.invalid.example. 60 IN TXT "v=DMARC1; p=maybe"`,
+		`_dmarc.two.example. 60 IN TXT "v=DMARC1; p=none"`,
+		`_dmarc.two.example. 60 IN TXT "v=DMARC1; p=reject"`,
+	)
+
+	tests := []struct {
+		domain    string
+		policy    string
+		inherited bool
+		err       string
+	}{
+		{domain: "example.com", policy: "reject"},
+		{domain: "mail.sub.example.com", policy: "quarantine", inherited: true},
+		{domain: "unauthorized.example", policy: "none", err: "has not authorized reports"},
+		{domain: "invalid.example", err: "Invalid DMARC record"},
+		{domain: "two.example", err: "Multiple DMARC records."},
+		{domain: "none.example", err: "No DMARC records."},
+	}
+	for _, tt := range tests {
+		d := GetWithResolver(tt.domain, z)
+		if d.Policy != tt.policy || d.Inherited != tt.inherited {
+			t.Errorf("%s: got policy %q inherited %v, want %q %v", tt.domain, d.Policy, d.Inherited, tt.policy, tt.inherited)
+		}
+		if tt.err == "" && d.Error != "" || !strings.Contains(d.ErrorMessage, tt.err) {
+			t.Errorf("%s: got error %q, want %q", tt.domain, d.ErrorMessage, tt.err)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		record string
+		valid  bool
+		policy string
+		pct    int
+	}{
+		{"v=DMARC1; p=none", true, "none", 100},
+		{"v=DMARC1; p=quarantine; pct=50", true, "quarantine", 50},
+		{"v=DMARC1; p=reject; pct=150", false, "reject", 100},
+		{"v=DMARC1; p=maybe", false, "", 100},
+		{"v=DMARC1", false, "", 100},
+	}
+	for _, tt := range tests {
+		r := Parse(tt.record)
+		if r.Valid != tt.valid || r.Policy != tt.policy || r.Percentage != tt.pct {
+			t.Errorf("%q: got valid %v p=%q pct=%d %v, want %v p=%q pct=%d", tt.record, r.Valid, r.Policy, r.Percentage, r.Errors, tt.valid, tt.policy, tt.pct)
+		}
+	}
+}
@@ -0,0 +1,215 @@
+package emaildmarc
+
+import (
+	"net/mail"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Record struct for a parsed DMARC record
+type Record struct {
+	Raw               string   `json:"raw,omitempty"`
+	Version           string   `json:"v,omitempty"`
+	Policy            string   `json:"p,omitempty"`
+	SubdomainPolicy   string   `json:"sp,omitempty"`
+	NonExistentPolicy string   `json:"np,omitempty"`
+	Percentage        int      `json:"pct"`
+	RUA               []string `json:"rua,omitempty"`
+	RUF               []string `json:"ruf,omitempty"`
+	ADKIM             string   `json:"adkim,omitempty"`
+	ASPF              string   `json:"aspf,omitempty"`
+	FO                []string `json:"fo,omitempty"`
+	RF                []string `json:"rf,omitempty"`
+	RI                uint32   `json:"ri"`
+	Valid             bool     `json:"valid"`
+	Errors            []string `json:"errors,omitempty"`
+}
+
+var version = regexp.MustCompile(`^v[ \t]*=[ \t]*DMARC1[ \t]*(;|$)`)
+
+// IsDMARC reports whether a TXT record is a DMARC record, that is whether
+// it starts with the v=DMARC1 tag.
+func IsDMARC(txt string) bool {
+	return version.MatchString(strings.TrimLeft(txt, " \t"))
+}
+
+// Parse parses and validates a DMARC record. Tags that are not given get
+// their default value from RFC 7489 section 6.3, except sp and np which
+// default to the value of p.
+func Parse(record string) *Record {
+	r := &Record{
+		Raw:        record,
+		Percentage: 100,
+		ADKIM:      "r",
+		ASPF:       "r",
+		FO:         []string{"0"},
+		RF:         []string{"afrf"},
+		RI:         86400,
+	}
+
+	if !IsDMARC(record) {
+		r.addError("record does not start with v=DMARC1")
+		return r
+	}
+
+	seen := make(map[string]bool)
+	for i, spec := range strings.Split(record, ";") {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+		eq := strings.Index(spec, "=")
+		if eq < 0 {
+			r.addError("invalid tag: " + spec)
+			continue
+		}
+		tag := strings.ToLower(strings.TrimSpace(spec[:eq]))
+		value := strings.TrimSpace(spec[eq+1:])
+		if seen[tag] {
+			r.addError("duplicate tag: " + tag)
+			continue
+		}
+		seen[tag] = true
+
+		switch tag {
+		case "v":
+			if i != 0 {
+				r.addError("v must be the first tag")
+			}
+			r.Version = value
+		case "p":
+			r.Policy = r.parsePolicy(tag, value)
+		case "sp":
+			r.SubdomainPolicy = r.parsePolicy(tag, value)
+		case "np":
+			r.NonExistentPolicy = r.parsePolicy(tag, value)
+		case "pct":
+			pct, err := strconv.Atoi(value)
+			if err != nil || pct < 0 || pct > 100 {
+				r.addError("pct must be a number from 0 to 100: " + value)
+				continue
+			}
+			r.Percentage = pct
+		case "rua":
+			r.RUA = r.parseURIs(tag, value)
+		case "ruf":
+			r.RUF = r.parseURIs(tag, value)
+		case "adkim", "aspf":
+			mode := strings.ToLower(value)
+			if mode != "r" && mode != "s" {
+				r.addError(tag + " must be r or s: " + value)
+				continue
+			}
+			if tag == "adkim" {
+				r.ADKIM = mode
+			} else {
+				r.ASPF = mode
+			}
+		case "fo":
+			var options []string
+			for _, option := range strings.Split(value, ":") {
+				option = strings.ToLower(strings.TrimSpace(option))
+				switch option {
+				case "0", "1", "d", "s":
+					options = append(options, option)
+				default:
+					r.addError("unknown fo option: " + option)
+				}
+			}
+			if len(options) > 0 {
+				r.FO = options
+			}
+		case "rf":
+			var formats []string
+			for _, format := range strings.Split(value, ":") {
+				format = strings.ToLower(strings.TrimSpace(format))
+				if format != "afrf" {
+					r.addError("unknown rf format: " + format)
+					continue
+				}
+				formats = append(formats, format)
+			}
+			if len(formats) > 0 {
+				r.RF = formats
+			}
+		case "ri":
+			ri, err := strconv.ParseUint(value, 10, 32)
+			if err != nil {
+				r.addError("ri must be a number of seconds: " + value)
+				continue
+			}
+			r.RI = uint32(ri)
+		default:
+			r.addError("unknown tag: " + tag)
+		}
+	}
+
+	if !seen["p"] {
+		r.addError("missing required tag p")
+	}
+	if r.SubdomainPolicy == "" {
+		r.SubdomainPolicy = r.Policy
+	}
+	if r.NonExistentPolicy == "" {
+		r.NonExistentPolicy = r.SubdomainPolicy
+	}
+
+	r.Valid = len(r.Errors) == 0
+	return r
+}
+
+func (r *Record) addError(err string) {
+	r.Errors = append(r.Errors, err)
+}
+
+func (r *Record) parsePolicy(tag string, value string) string {
+	policy := strings.ToLower(value)
+	switch policy {
+	case "none", "quarantine", "reject":
+		return policy
+	}
+	r.addError(tag + " must be none, quarantine or reject: " + value)
+	return ""
+}
+
+// parseURIs parses a comma separated list of DMARC URIs, an URI with an
+// optional !size limit.
+func (r *Record) parseURIs(tag string, value string) []string {
+	var uris []string
+	for _, uri := range strings.Split(value, ",") {
+		uri = strings.TrimSpace(uri)
+		if uri == "" {
+			r.addError("empty URI in " + tag)
+			continue
+		}
+		address := uri
+		if i := strings.LastIndex(uri, "!"); i >= 0 {
+			address = uri[:i]
+			if !validSize(uri[i+1:]) {
+				r.addError("invalid size limit in " + tag + ": " + uri)
+				continue
+			}
+		}
+		u, err := url.Parse(address)
+		if err != nil || u.Scheme == "" {
+			r.addError("invalid URI in " + tag + ": " + uri)
+			continue
+		}
+		if strings.EqualFold(u.Scheme, "mailto") {
+			if _, err := mail.ParseAddress(u.Opaque); err != nil {
+				r.addError("invalid mailto address in " + tag + ": " + uri)
+				continue
+			}
+		}
+		uris = append(uris, uri)
+	}
+	return uris
+}
+
+var sizeLimit = regexp.MustCompile(`^[0-9]+[kKmMgGtT]?$`)
+
+func validSize(size string) bool {
+	return sizeLimit.MatchString(size)
+}
@@ -0,0 +1,294 @@
+package emaildmarc
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"encoding/xml"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/text/encoding/charmap"
+)
+
+// AggregateReport struct for an aggregate report (RFC 7489 appendix C)
+type AggregateReport struct {
+	XMLName         xml.Name        `xml:"feedback" json:"-"`
+	Version         string          `xml:"version" json:"version,omitempty"`
+	Metadata        ReportMetadata  `xml:"report_metadata" json:"metadata"`
+	PolicyPublished PolicyPublished `xml:"policy_published" json:"policypublished"`
+	Records         []*ReportRecord `xml:"record" json:"records,omitempty"`
+}
+
+// ReportMetadata struct for the reporting organization and period
+type ReportMetadata struct {
+	OrgName          string          `xml:"org_name" json:"orgname,omitempty"`
+	Email            string          `xml:"email" json:"email,omitempty"`
+	ExtraContactInfo string          `xml:"extra_contact_info" json:"extracontactinfo,omitempty"`
+	ReportID         string          `xml:"report_id" json:"reportid,omitempty"`
+	DateRange        ReportDateRange `xml:"date_range" json:"daterange"`
+	Errors           []string        `xml:"error" json:"errors,omitempty"`
+}
+
+// ReportDateRange struct with the begin and end of a report in Unix time
+type ReportDateRange struct {
+	Begin int64 `xml:"begin" json:"begin"`
+	End   int64 `xml:"end" json:"end"`
+}
+
+// PolicyPublished struct for the DMARC policy the receiver found
+type PolicyPublished struct {
+	Domain          string `xml:"domain" json:"domain,omitempty"`
+	ADKIM           string `xml:"adkim" json:"adkim,omitempty"`
+	ASPF            string `xml:"aspf" json:"aspf,omitempty"`
+	Policy          string `xml:"p" json:"p,omitempty"`
+	SubdomainPolicy string `xml:"sp" json:"sp,omitempty"`
+	Percentage      int    `xml:"pct" json:"pct"`
+	FO              string `xml:"fo" json:"fo,omitempty"`
+}
+
+// ReportRecord struct for the messages from one source with one outcome
+type ReportRecord struct {
+	Row         ReportRow         `xml:"row" json:"row"`
+	Identifiers ReportIdentifiers `xml:"identifiers" json:"identifiers"`
+	AuthResults ReportAuthResults `xml:"auth_results" json:"authresults"`
+}
+
+// ReportRow struct with the source, message count and evaluated policy
+type ReportRow struct {
+	SourceIP        string          `xml:"source_ip" json:"sourceip,omitempty"`
+	Count           int64           `xml:"count" json:"count"`
+	PolicyEvaluated PolicyEvaluated `xml:"policy_evaluated" json:"policyevaluated"`
+}
+
+// PolicyEvaluated struct with the disposition and the aligned results
+type PolicyEvaluated struct {
+	Disposition string          `xml:"disposition" json:"disposition,omitempty"`
+	DKIM        string          `xml:"dkim" json:"dkim,omitempty"`
+	SPF         string          `xml:"spf" json:"spf,omitempty"`
+	Reasons     []*PolicyReason `xml:"reason" json:"reasons,omitempty"`
+}
+
+// PolicyReason struct for a local policy override
+type PolicyReason struct {
+	Type    string `xml:"type" json:"type,omitempty"`
+	Comment string `xml:"comment" json:"comment,omitempty"`
+}
+
+// ReportIdentifiers struct with the identifiers of the messages
+type ReportIdentifiers struct {
+	EnvelopeTo   string `xml:"envelope_to" json:"envelopeto,omitempty"`
+	EnvelopeFrom string `xml:"envelope_from" json:"envelopefrom,omitempty"`
+	HeaderFrom   string `xml:"header_from" json:"headerfrom,omitempty"`
+}
+
+// ReportAuthResults struct with the raw DKIM and SPF results
+type ReportAuthResults struct {
+	DKIM []*DKIMAuthResult `xml:"dkim" json:"dkim,omitempty"`
+	SPF  []*SPFAuthResult  `xml:"spf" json:"spf,omitempty"`
+}
+
+// DKIMAuthResult struct for one DKIM signature
+type DKIMAuthResult struct {
+	Domain      string `xml:"domain" json:"domain,omitempty"`
+	Selector    string `xml:"selector" json:"selector,omitempty"`
+	Result      string `xml:"result" json:"result,omitempty"`
+	HumanResult string `xml:"human_result" json:"humanresult,omitempty"`
+}
+
+// SPFAuthResult struct for the SPF check
+type SPFAuthResult struct {
+	Domain string `xml:"domain" json:"domain,omitempty"`
+	Scope  string `xml:"scope" json:"scope,omitempty"`
+	Result string `xml:"result" json:"result,omitempty"`
+}
+
+// AggregateSummary struct with the messages of reports added up
+type AggregateSummary struct {
+	Reports       int                    `json:"reports"`
+	Messages      int64                  `json:"messages"`
+	Organizations map[string]int64       `json:"organizations,omitempty"`
+	SourceIPs     map[string]int64       `json:"sourceips,omitempty"`
+	Dispositions  map[string]int64       `json:"dispositions,omitempty"`
+	DKIM          map[string]int64       `json:"dkim,omitempty"`
+	SPF           map[string]int64       `json:"spf,omitempty"`
+	Rows          []*AggregateSummaryRow `json:"rows,omitempty"`
+}
+
+// AggregateSummaryRow struct for the messages with the same source IP,
+// reporting organization, disposition and DKIM and SPF alignment
+type AggregateSummaryRow struct {
+	SourceIP     string `json:"sourceip,omitempty"`
+	Organization string `json:"organization,omitempty"`
+	Disposition  string `json:"disposition,omitempty"`
+	DKIM         string `json:"dkim,omitempty"`
+	SPF          string `json:"spf,omitempty"`
+	Messages     int64  `json:"messages"`
+}
+
+// MaxReportSize limits the size of a report, compressed and decompressed.
+// A larger report is an error.
+const MaxReportSize = 64 * 1024 * 1024
+
+var errReportTooLarge = errors.New("report too large, more than " + strconv.Itoa(MaxReportSize) + " bytes")
+
+// ParseAggregateReportFile parses the reports in file.
+func ParseAggregateReportFile(file string) ([]*AggregateReport, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParseAggregateReport(f)
+}
+
+// ParseAggregateReport parses an XML report. Reports are usually sent as
+// a .gz or .zip attachment, both are detected by their magic number. A zip
+// archive may hold more than one report.
+func ParseAggregateReport(r io.Reader) ([]*AggregateReport, error) {
+	data, err := readReport(r)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case bytes.HasPrefix(data, []byte{0x1f, 0x8b}):
+		zr, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		report, err := decodeReport(zr)
+		if err != nil {
+			return nil, err
+		}
+		return []*AggregateReport{report}, nil
+	case bytes.HasPrefix(data, []byte("PK\x03\x04")):
+		zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			return nil, err
+		}
+		var reports []*AggregateReport
+		for _, f := range zr.File {
+			if f.FileInfo().IsDir() || !strings.HasSuffix(strings.ToLower(f.Name), ".xml") {
+				continue
+			}
+			rc, err := f.Open()
+			if err != nil {
+				return nil, err
+			}
+			report, err := decodeReport(rc)
+			rc.Close()
+			if err != nil {
+				return nil, errors.New(f.Name + ": " + err.Error())
+			}
+			reports = append(reports, report)
+		}
+		if len(reports) == 0 {
+			return nil, errors.New("zip archive holds no XML report")
+		}
+		return reports, nil
+	}
+
+	report, err := decodeReport(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	return []*AggregateReport{report}, nil
+}
+
+// SummarizeAggregateReports adds up the messages of reports per source IP,
+// disposition, DKIM and SPF alignment and reporting organization.
+func SummarizeAggregateReports(reports ...*AggregateReport) *AggregateSummary {
+	s := &AggregateSummary{
+		Organizations: make(map[string]int64),
+		SourceIPs:     make(map[string]int64),
+		Dispositions:  make(map[string]int64),
+		DKIM:          make(map[string]int64),
+		SPF:           make(map[string]int64),
+	}
+
+	rows := make(map[AggregateSummaryRow]*AggregateSummaryRow)
+	for _, report := range reports {
+		s.Reports++
+		org := report.Metadata.OrgName
+		for _, record := range report.Records {
+			row := record.Row
+			evaluated := row.PolicyEvaluated
+			s.Messages += row.Count
+			s.Organizations[org] += row.Count
+			s.SourceIPs[row.SourceIP] += row.Count
+			s.Dispositions[evaluated.Disposition] += row.Count
+			s.DKIM[evaluated.DKIM] += row.Count
+			s.SPF[evaluated.SPF] += row.Count
+
+			key := AggregateSummaryRow{
+				SourceIP:     row.SourceIP,
+				Organization: org,
+				Disposition:  evaluated.Disposition,
+				DKIM:         evaluated.DKIM,
+				SPF:          evaluated.SPF,
+			}
+			if rows[key] == nil {
+				summary := key
+				rows[key] = &summary
+				s.Rows = append(s.Rows, &summary)
+			}
+			rows[key].Messages += row.Count
+		}
+	}
+
+	sort.SliceStable(s.Rows, func(i, j int) bool {
+		return s.Rows[i].Messages > s.Rows[j].Messages
+	})
+	return s
+}
+
+/*
+ * Used functions
+ */
+
+// readReport reads r up to MaxReportSize.
+func readReport(r io.Reader) ([]byte, error) {
+	data, err := ioutil.ReadAll(io.LimitReader(r, MaxReportSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > MaxReportSize {
+		return nil, errReportTooLarge
+	}
+	return data, nil
+}
+
+func decodeReport(r io.Reader) (*AggregateReport, error) {
+	data, err := readReport(r)
+	if err != nil {
+		return nil, err
+	}
+	report := new(AggregateReport)
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	decoder.CharsetReader = charsetReader
+	if err := decoder.Decode(report); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// charsetReader decodes the legacy encodings some reporters declare, the
+// XML decoder handles UTF-8 itself.
+func charsetReader(label string, input io.Reader) (io.Reader, error) {
+	switch strings.ToLower(label) {
+	case "us-ascii", "ascii":
+		return input, nil
+	case "iso-8859-1", "iso8859-1", "latin1", "l1":
+		return charmap.ISO8859_1.NewDecoder().Reader(input), nil
+	case "windows-1252", "cp1252":
+		return charmap.Windows1252.NewDecoder().Reader(input), nil
+	}
+	return nil, errors.New("unsupported charset " + label)
+}
@@ -0,0 +1,120 @@
+package emaildmarc
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"strings"
+	"testing"
+)
+
+const aggregateReport = `<?xml version="1.0" encoding="UTF-8" ?>
+<feedback>
+  <report_metadata><org_name>receiver.example</org_name><email>dmarc@receiver.example</email><report_id>123</report_id><date_range><begin>1700000000</begin><end>1700086399</end></date_range></report_metadata>
+  <policy_published><domain>example.com</domain><adkim>r</adkim><aspf>r</aspf><p>reject</p><sp>reject</sp><pct>100</pct></policy_published>
+  <record><row><source_ip>192.0.2.1</source_ip><count>10</count><policy_evaluated><disposition>none</disposition><dkim>pass</dkim><spf>pass</spf></policy_evaluated></row>
+    <identifiers><header_from>example.com</header_from></identifiers>
+    <auth_results><dkim><domain>example.com</domain><selector>s1</selector><result>pass</result></dkim><spf><domain>example.com</domain><result>pass</result></spf></auth_results></record>
+  <record><row><source_ip>198.51.100.9</source_ip><count>3</count><policy_evaluated><disposition>reject</disposition><dkim>fail</dkim><spf>fail</spf><reason><type>local_policy</type></reason></policy_evaluated></row>
+    <identifiers><header_from>example.com</header_from></identifiers>
+    <auth_results><spf><domain>evil.example</domain><result>softfail</result></spf></auth_results></record>
+</feedback>`
+
+func gzipped(t *testing.T, data []byte) []byte {
+	t.Helper()
+	var b bytes.Buffer
+	w := gzip.NewWriter(&b)
+	if _, err := w.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+func zipped(t *testing.T, files map[string]string) []byte {
+	t.Helper()
+	var b bytes.Buffer
+	w := zip.NewWriter(&b)
+	for name, data := range files {
+		f, err := w.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Write([]byte(data))
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+func TestParseAggregateReport(t *testing.T) {
+	// "Société" in ISO-8859-1 and Windows-1252, where 0x80 is the euro sign.
+	latin1 := strings.Replace(aggregateReport, `encoding="UTF-8"`, `encoding="ISO-8859-1"`, 1)
+	latin1 = strings.Replace(latin1, "receiver.example</org_name>", "Soci\xe9t\xe9</org_name>", 1)
+	cp1252 := strings.Replace(aggregateReport, `encoding="UTF-8"`, `encoding="windows-1252"`, 1)
+	cp1252 = strings.Replace(cp1252, "receiver.example</org_name>", "\x80 receiver</org_name>", 1)
+	bomb := gzipped(t, append([]byte(aggregateReport), bytes.Repeat([]byte(" "), MaxReportSize)...))
+
+	tests := []struct {
+		name    string
+		in      []byte
+		reports int
+		org     string
+		err     string
+	}{
+		{name: "xml", in: []byte(aggregateReport), reports: 1, org: "receiver.example"},
+		{name: "gzip", in: gzipped(t, []byte(aggregateReport)), reports: 1, org: "receiver.example"},
+		{name: "zip", in: zipped(t, map[string]string{"a.xml": aggregateReport, "b.xml": aggregateReport, "readme.txt": "x"}), reports: 2, org: "receiver.example"},
+		{name: "zip without xml", in: zipped(t, map[string]string{"readme.txt": "x"}), err: "zip archive holds no XML report"},
+		{name: "latin1", in: []byte(latin1), reports: 1, org: "Société"},
+		{name: "windows-1252", in: []byte(cp1252), reports: 1, org: "€ receiver"},
+		{name: "unknown charset", in: []byte(strings.Replace(aggregateReport, "UTF-8", "EBCDIC", 1)), err: "unsupported charset EBCDIC"},
+		{name: "decompressed too large", in: bomb, err: "report too large"},
+		{name: "not a report", in: []byte("<x/>"), err: "expected element type <feedback>"},
+	}
+	for _, tt := range tests {
+		reports, err := ParseAggregateReport(bytes.NewReader(tt.in))
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%s: got error %v, want %q", tt.name, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if len(reports) != tt.reports || reports[0].Metadata.OrgName != tt.org {
+			t.Errorf("%s: got %d reports from %q, want %d from %q", tt.name, len(reports), reports[0].Metadata.OrgName, tt.reports, tt.org)
+		}
+	}
+}
+
+func TestSummarizeAggregateReports(t *testing.T) {
+	reports, err := ParseAggregateReport(bytes.NewReader([]byte(aggregateReport)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := SummarizeAggregateReports(reports[0], reports[0])
+
+	tests := []struct {
+		name string
+		got  int64
+		want int64
+	}{
+		{"reports", int64(s.Reports), 2},
+		{"messages", s.Messages, 26},
+		{"rejected", s.Dispositions["reject"], 6},
+		{"dkim pass", s.DKIM["pass"], 20},
+		{"rows", int64(len(s.Rows)), 2},
+		{"first row", s.Rows[0].Messages, 20},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("%s: got %d, want %d", tt.name, tt.got, tt.want)
+		}
+	}
+}
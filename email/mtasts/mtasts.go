@@ -0,0 +1,351 @@
+package emailmtasts
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	dnsresolver "github.com/binaryfigments/goharvest/dns/resolver"
+	emailmx "github.com/binaryfigments/goharvest/email/mx"
+	"github.com/miekg/dns"
+	"golang.org/x/net/idna"
+)
+
+// Data struct with the MTA-STS record, policy and MX check of a domain
+type Data struct {
+	Domain       string    `json:"domain,omitempty"`
+	CheckTime    time.Time `json:"time"`
+	Record       string    `json:"record,omitempty"`
+	TXT          []string  `json:"txt,omitempty"`
+	ID           string    `json:"id,omitempty"`
+	PolicyURL    string    `json:"policyurl,omitempty"`
+	Policy       *Policy   `json:"policy,omitempty"`
+	MX           []*MX     `json:"mx,omitempty"`
+	Error        string    `json:"error,omitempty"`
+	ErrorMessage string    `json:"errormessage,omitempty"`
+}
+
+// Policy struct for a parsed MTA-STS policy (RFC 8461 section 3.2)
+type Policy struct {
+	Raw     string   `json:"raw,omitempty"`
+	Version string   `json:"version,omitempty"`
+	Mode    string   `json:"mode,omitempty"`
+	MX      []string `json:"mx,omitempty"`
+	MaxAge  int      `json:"max_age"`
+	Valid   bool     `json:"valid"`
+	Errors  []string `json:"errors,omitempty"`
+}
+
+// MX struct for an MX host and the policy pattern it matches
+type MX struct {
+	Server     string `json:"server,omitempty"`
+	Preference uint16 `json:"preference,omitempty"`
+	Match      bool   `json:"match"`
+	Pattern    string `json:"pattern,omitempty"`
+}
+
+// Policy modes
+const (
+	ModeEnforce = "enforce"
+	ModeTesting = "testing"
+	ModeNone    = "none"
+)
+
+// Limits from RFC 8461: the largest max_age and the largest policy body
+// a sender should accept.
+const (
+	MaxMaxAge     = 31557600
+	MaxPolicySize = 64 * 1024
+)
+
+// DefaultClient fetches policies, it does not follow redirects as RFC 8461
+// section 3.3 requires.
+var DefaultClient = &http.Client{
+	Timeout: 10 * time.Second,
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+// Get function of this package to get the MTA-STS record and policy
+func Get(domain string, nameserver string) *Data {
+	return GetWithResolver(domain, dnsresolver.Parse(nameserver))
+}
+
+// GetWithResolver is Get with a custom resolver.
+func GetWithResolver(domain string, resolver dnsresolver.Resolver) *Data {
+	return GetWithClient(domain, resolver, DefaultClient)
+}
+
+// GetWithClient is Get with a custom resolver and HTTP client.
+func GetWithClient(domain string, resolver dnsresolver.Resolver, client *http.Client) *Data {
+	r := new(Data)
+	r.CheckTime = time.Now()
+
+	domain, err := idna.ToASCII(strings.TrimSuffix(strings.ToLower(domain), "."))
+	if err != nil {
+		r.Error = "Failed"
+		r.ErrorMessage = err.Error()
+		return r
+	}
+	r.Domain = domain
+
+	// The TXT record announces the policy and its id.
+	r.Record = "_mta-sts." + domain
+	r.TXT, err = lookupSTS(resolver, r.Record)
+	if err != nil {
+		r.Error = "Failed"
+		r.ErrorMessage = err.Error()
+		return r
+	}
+	if len(r.TXT) < 1 {
+		r.Error = "Failed"
+		r.ErrorMessage = "No MTA-STS records."
+		return r
+	}
+	if len(r.TXT) > 1 {
+		r.Error = "Failed"
+		r.ErrorMessage = "Multiple MTA-STS records."
+		return r
+	}
+	r.ID, err = parseRecord(r.TXT[0])
+	if err != nil {
+		r.Error = "Failed"
+		r.ErrorMessage = "Invalid MTA-STS record: " + err.Error()
+		return r
+	}
+
+	// The policy itself.
+	r.PolicyURL = "https://mta-sts." + domain + "/.well-known/mta-sts.txt"
+	r.Policy, err = FetchPolicy(r.PolicyURL, client)
+	if err != nil {
+		r.Error = "Failed"
+		r.ErrorMessage = err.Error()
+		return r
+	}
+
+	// Every MX of the policy domain itself has to be covered by the
+	// policy.
+	mx := emailmx.GetExactWithResolver(domain, resolver)
+	if mx.Error != "" {
+		r.Error = mx.Error
+		r.ErrorMessage = mx.ErrorMessage
+		return r
+	}
+	var unmatched []string
+	for _, record := range mx.Records {
+		m := &MX{Server: strings.TrimSuffix(strings.ToLower(record.Server), "."), Preference: record.Preference}
+		m.Pattern, m.Match = r.Policy.Match(m.Server)
+		if !m.Match {
+			unmatched = append(unmatched, m.Server)
+		}
+		r.MX = append(r.MX, m)
+	}
+
+	if !r.Policy.Valid {
+		r.Error = "Failed"
+		r.ErrorMessage = "Invalid MTA-STS policy: " + strings.Join(r.Policy.Errors, ", ")
+		return r
+	}
+	if len(unmatched) > 0 && r.Policy.Mode != ModeNone {
+		r.Error = "Failed"
+		r.ErrorMessage = "MX not covered by the MTA-STS policy: " + strings.Join(unmatched, ", ")
+		return r
+	}
+	return r
+}
+
+// FetchPolicy fetches and parses the policy at url. Only a 200 response is
+// accepted, redirects are not followed by DefaultClient.
+func FetchPolicy(url string, client *http.Client) (*Policy, error) {
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New("policy fetch for " + url + " returned " + resp.Status)
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, MaxPolicySize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > MaxPolicySize {
+		return nil, errors.New("policy is larger than 64 KB")
+	}
+
+	p := ParsePolicy(string(body))
+	if mediatype, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type")); err != nil || mediatype != "text/plain" {
+		p.addError("policy is not served as text/plain")
+	}
+	return p, nil
+}
+
+// ParsePolicy parses and validates a policy body of key: value lines.
+func ParsePolicy(body string) *Policy {
+	p := &Policy{Raw: body}
+
+	seen := make(map[string]bool)
+	scanner := bufio.NewScanner(strings.NewReader(body))
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		colon := strings.Index(line, ":")
+		if colon < 0 {
+			p.addError("invalid line: " + line)
+			continue
+		}
+		key := strings.TrimSpace(line[:colon])
+		value := strings.TrimSpace(line[colon+1:])
+		if key != "mx" && seen[key] {
+			p.addError("duplicate key: " + key)
+			continue
+		}
+		seen[key] = true
+
+		switch key {
+		case "version":
+			if value != "STSv1" {
+				p.addError("unknown version: " + value)
+			}
+			p.Version = value
+		case "mode":
+			switch value {
+			case ModeEnforce, ModeTesting, ModeNone:
+				p.Mode = value
+			default:
+				p.addError("mode must be enforce, testing or none: " + value)
+			}
+		case "max_age":
+			age, err := strconv.Atoi(value)
+			if err != nil || age < 0 || age > MaxMaxAge {
+				p.addError("max_age must be a number from 0 to 31557600: " + value)
+				continue
+			}
+			p.MaxAge = age
+		case "mx":
+			if !mxPattern.MatchString(value) {
+				p.addError("invalid mx pattern: " + value)
+				continue
+			}
+			p.MX = append(p.MX, strings.ToLower(strings.TrimSuffix(value, ".")))
+		default:
+			// Unknown keys are ignored (RFC 8461 section 3.2).
+		}
+	}
+
+	for _, key := range []string{"version", "mode", "max_age"} {
+		if !seen[key] {
+			p.addError("missing required key " + key)
+		}
+	}
+	if len(p.MX) == 0 && p.Mode != ModeNone {
+		p.addError("missing required key mx")
+	}
+
+	p.Valid = len(p.Errors) == 0
+	return p
+}
+
+func (p *Policy) addError(err string) {
+	p.Errors = append(p.Errors, err)
+	p.Valid = false
+}
+
+// Match returns the first mx pattern that matches host. A wildcard pattern
+// *.example.com matches exactly one label in front of example.com.
+func (p *Policy) Match(host string) (string, bool) {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	for _, pattern := range p.MX {
+		if strings.HasPrefix(pattern, "*.") {
+			dot := strings.Index(host, ".")
+			if dot > 0 && host[dot+1:] == pattern[2:] {
+				return pattern, true
+			}
+			continue
+		}
+		if host == pattern {
+			return pattern, true
+		}
+	}
+	return "", false
+}
+
+/*
+ * Used functions
+ */
+
+var (
+	mxPattern = regexp.MustCompile(`^(\*\.)?[A-Za-z0-9]([A-Za-z0-9-]*[A-Za-z0-9])?(\.[A-Za-z0-9]([A-Za-z0-9-]*[A-Za-z0-9])?)+\.?$`)
+	stsID     = regexp.MustCompile(`^[A-Za-z0-9]{1,32}$`)
+)
+
+// parseRecord validates a v=STSv1 record and returns its id.
+func parseRecord(record string) (string, error) {
+	id := ""
+	for i, field := range strings.Split(record, ";") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		eq := strings.Index(field, "=")
+		if eq < 0 {
+			return "", errors.New("invalid field: " + field)
+		}
+		key, value := field[:eq], field[eq+1:]
+		switch {
+		case i == 0 && key != "v":
+			return "", errors.New("v must be the first field")
+		case key == "v" && value != "STSv1":
+			return "", errors.New("unknown version: " + value)
+		case key == "id":
+			if !stsID.MatchString(value) {
+				return "", errors.New("id must be 1 to 32 letters or digits: " + value)
+			}
+			id = value
+		}
+	}
+	if id == "" {
+		return "", errors.New("missing required field id")
+	}
+	return id, nil
+}
+
+// lookupSTS returns the TXT records at name that start with v=STSv1.
+func lookupSTS(resolver dnsresolver.Resolver, name string) ([]string, error) {
+	m := new(dns.Msg)
+	m.SetQuestion(dns.Fqdn(name), dns.TypeTXT)
+	m.SetEdns0(4096, true)
+	m.MsgHdr.RecursionDesired = true
+	in, err := resolver.Exchange(m)
+	if err != nil {
+		return nil, err
+	}
+
+	var records []string
+	switch rcode := in.MsgHdr.Rcode; rcode {
+	case dns.RcodeSuccess:
+		for _, ain := range in.Answer {
+			if a, ok := ain.(*dns.TXT); ok {
+				record := strings.Join(a.Txt, "")
+				if strings.HasPrefix(record, "v=STSv1;") || record == "v=STSv1" {
+					records = append(records, record)
+				}
+			}
+		}
+	case dns.RcodeNameError:
+	default:
+		return nil, errors.New("DNS lookup for " + name + " failed: " + dns.RcodeToString[rcode])
+	}
+	return records, nil
+}
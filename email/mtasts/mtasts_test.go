@@ -0,0 +1,143 @@
+package emailmtasts
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	dnsresolver "github.com/binaryfigments/goharvest/dns/resolver"
+)
+
+// policyServer serves the policy of every mta-sts.<domain> host from
+// policies and returns a client that connects to it for any host name.
+func policyServer(t *testing.T, policies map[string]string) *http.Client {
+	t.Helper()
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := strings.TrimPrefix(r.Host, "mta-sts.")
+		policy, ok := policies[host]
+		switch {
+		case r.URL.Path != "/.well-known/mta-sts.txt" || !ok:
+			http.NotFound(w, r)
+		case strings.HasPrefix(policy, "redirect "):
+			http.Redirect(w, r, strings.TrimPrefix(policy, "redirect "), http.StatusFound)
+		case strings.HasPrefix(policy, "html "):
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte(strings.TrimPrefix(policy, "html ")))
+		default:
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			w.Write([]byte(policy))
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	client := srv.Client()
+	transport := client.Transport.(*http.Transport)
+	transport.TLSClientConfig.InsecureSkipVerify = true
+	transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return (&net.Dialer{}).DialContext(ctx, network, srv.Listener.Addr().String())
+	}
+	client.CheckRedirect = DefaultClient.CheckRedirect
+	return client
+}
+
+func TestGetWithClient(t *testing.T) {
+	const enforce = "version: STSv1\r\nmode: enforce\r\nmx: mail.example.com\r\nmx: *.example.net\r\nmax_age: 86400\r\n"
+	client := policyServer(t, map[string]string{
+		"example.com":     enforce,
+		"sub.example.com": "version: STSv1\nmode: enforce\nmx: mx.sub.example.com\nmax_age: 86400\n",
+		"uncovered.com":   enforce,
+		"testing.com":     "version: STSv1\nmode: testing\nmx: mail.example.com\nmax_age: 86400\n",
+		"redirect.com":    "redirect https://mta-sts.example.com/.well-known/mta-sts.txt",
+		"html.com":        "html " + enforce,
+		"large.com":       strings.Repeat("#", MaxPolicySize+1),
+	})
+	z := dnsresolver.MustZone(
+		`_mta-sts.example.com. 60 IN TXT "v=STSv1; id=20240101T000000"`,
+		`example.com. 60 IN MX 10 mail.example.com.`,
+		`example.com. 60 IN MX 20 mx1.example.net.`,
+		`_mta-sts.sub.example.com. 60 IN TXT "v=STSv1; id=1"`,
+		`sub.example.com. 60 IN MX 10 mx.sub.example.com.`,
+		`_mta-sts.uncovered.com. 60 IN TXT "v=STSv1; id=1"`,
+		`uncovered.com. 60 IN MX 10 a.b.example.net.`,
+		`_mta-sts.testing.com. 60 IN TXT "v=STSv1; id=1"`,
+		`testing.com. 60 IN MX 10 other.example.org.`,
+		`_mta-sts.redirect.com. 60 IN TXT "v=STSv1; id=1"`,
+		`_mta-sts.html.com. 60 IN TXT "v=STSv1; id=1"`,
+		`_mta-sts.large.com. 60 IN TXT "v=STSv1; id=1"`,
+		`_mta-sts.noid.com. 60 IN TXT "v=STSv1"`,
+		`_mta-sts.two.com. 60 IN TXT "v=STSv1; id=1"`,
+		`_mta-sts.two.com. 60 IN TXT "v=STSv1; id=2"`,
+	)
+
+	tests := []struct {
+		domain string
+		mx     int
+		err    string
+	}{
+		{domain: "example.com", mx: 2},
+		{domain: "sub.example.com", mx: 1},
+		{domain: "uncovered.com", mx: 1, err: "MX not covered by the MTA-STS policy: a.b.example.net"},
+		{domain: "testing.com", mx: 1, err: "MX not covered"},
+		{domain: "redirect.com", err: "returned 302 Found"},
+		{domain: "html.com", mx: 0, err: "policy is not served as text/plain"},
+		{domain: "large.com", err: "policy is larger than 64 KB"},
+		{domain: "noid.com", err: "missing required field id"},
+		{domain: "two.com", err: "Multiple MTA-STS records."},
+		{domain: "none.com", err: "No MTA-STS records."},
+	}
+	for _, tt := range tests {
+		d := GetWithClient(tt.domain, z, client)
+		if tt.err == "" && d.Error != "" || !strings.Contains(d.ErrorMessage, tt.err) {
+			t.Errorf("%s: got error %q, want %q", tt.domain, d.ErrorMessage, tt.err)
+		}
+		if len(d.MX) != tt.mx {
+			t.Errorf("%s: got %d MX, want %d", tt.domain, len(d.MX), tt.mx)
+		}
+	}
+}
+
+func TestParsePolicy(t *testing.T) {
+	tests := []struct {
+		body   string
+		valid  bool
+		mode   string
+		errors int
+	}{
+		{"version: STSv1\nmode: enforce\nmx: mail.example.com\nmax_age: 86400\n", true, ModeEnforce, 0},
+		{"version: STSv1\nmode: none\nmax_age: 86400\n", true, ModeNone, 0},
+		{"version: STSv1\nmode: testing\nmax_age: 99999999\n", false, ModeTesting, 2},
+		{"version: STSv2\nmode: strict\nmx: *.*.example.com\n", false, "", 5},
+		{"version: STSv1\nversion: STSv1\nmode: enforce\nmx: a.example\nmax_age: 1\nextension: x\n", false, ModeEnforce, 1},
+	}
+	for _, tt := range tests {
+		p := ParsePolicy(tt.body)
+		if p.Valid != tt.valid || p.Mode != tt.mode || len(p.Errors) != tt.errors {
+			t.Errorf("%q: got valid %v mode %q %q, want %v %q and %d errors", tt.body, p.Valid, p.Mode, p.Errors, tt.valid, tt.mode, tt.errors)
+		}
+	}
+}
+
+func TestPolicyMatch(t *testing.T) {
+	p := &Policy{MX: []string{"mail.example.com", "*.example.net"}}
+
+	tests := []struct {
+		host    string
+		pattern string
+	}{
+		{"mail.example.com", "mail.example.com"},
+		{"MAIL.example.com.", "mail.example.com"},
+		{"mx1.example.net", "*.example.net"},
+		{"a.b.example.net", ""},
+		{"example.net", ""},
+		{"mail.example.org", ""},
+	}
+	for _, tt := range tests {
+		pattern, ok := p.Match(tt.host)
+		if pattern != tt.pattern || ok != (tt.pattern != "") {
+			t.Errorf("%s: got %q %v, want %q", tt.host, pattern, ok, tt.pattern)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package emailmx
 
 import (
+	dnsresolver "github.com/binaryfigments/goharvest/dns/resolver"
 	"github.com/miekg/dns"
 	"golang.org/x/net/idna"
 	"golang.org/x/net/publicsuffix"
@@ -20,6 +21,11 @@ type Records struct {
 }
 
 func Get(domain string, nameserver string) *Data {
+	return GetWithResolver(domain, dnsresolver.Parse(nameserver))
+}
+
+// GetWithResolver is Get with a custom resolver.
+func GetWithResolver(domain string, resolver dnsresolver.Resolver) *Data {
 	r := new(Data)
 
 	domain, err := idna.ToASCII(domain)
@@ -37,12 +43,35 @@ func Get(domain string, nameserver string) *Data {
 		return r
 	}
 
+	return lookup(r, domain, resolver)
+}
+
+// GetExactWithResolver is GetWithResolver for domain itself instead of its
+// registered domain, as MTA-STS needs for a policy of a subdomain.
+func GetExactWithResolver(domain string, resolver dnsresolver.Resolver) *Data {
+	r := new(Data)
+
+	domain, err := idna.ToASCII(domain)
+	if err != nil {
+		r.Error = "Failed"
+		r.ErrorMessage = err.Error()
+		return r
+	}
+	if _, ok := dns.IsDomainName(domain); !ok {
+		r.Error = "Failed"
+		r.ErrorMessage = "Invalid domain " + domain + "."
+		return r
+	}
+
+	return lookup(r, domain, resolver)
+}
+
+func lookup(r *Data, domain string, resolver dnsresolver.Resolver) *Data {
 	r.Domain = domain
 	m := new(dns.Msg)
 	m.SetQuestion(dns.Fqdn(domain), dns.TypeMX)
 	m.MsgHdr.RecursionDesired = true
-	c := new(dns.Client)
-	in, _, err := c.Exchange(m, nameserver+":53")
+	in, err := resolver.Exchange(m)
 	if err != nil {
 		r.Error = "Failed"
 		r.ErrorMessage = err.Error()
@@ -0,0 +1,213 @@
+package emailsmtp
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net/textproto"
+	"strings"
+	"time"
+
+	dnsresolver "github.com/binaryfigments/goharvest/dns/resolver"
+	emailmx "github.com/binaryfigments/goharvest/email/mx"
+)
+
+// RelayData struct with the relay and backscatter check of every MX
+type RelayData struct {
+	Domain       string    `json:"domain,omitempty"`
+	CheckTime    time.Time `json:"time"`
+	OpenRelay    bool      `json:"openrelay"`
+	Backscatter  bool      `json:"backscatter"`
+	Servers      []*Relay  `json:"servers,omitempty"`
+	Error        string    `json:"error,omitempty"`
+	ErrorMessage string    `json:"errormessage,omitempty"`
+}
+
+// Relay struct with the relay and backscatter check of one SMTP server
+type Relay struct {
+	Host         string          `json:"host,omitempty"`
+	Preference   uint16          `json:"preference,omitempty"`
+	Port         int             `json:"port,omitempty"`
+	OpenRelay    bool            `json:"openrelay"`
+	Backscatter  bool            `json:"backscatter"`
+	Attempts     []*RelayAttempt `json:"attempts,omitempty"`
+	Warnings     []string        `json:"warnings,omitempty"`
+	Error        string          `json:"error,omitempty"`
+	ErrorMessage string          `json:"errormessage,omitempty"`
+}
+
+// RelayAttempt struct for one MAIL FROM/RCPT TO transaction
+type RelayAttempt struct {
+	Test     string `json:"test"`
+	MailFrom string `json:"mailfrom"`
+	RcptTo   string `json:"rcptto"`
+	Code     int    `json:"code,omitempty"`
+	Reply    string `json:"reply,omitempty"`
+	Accepted bool   `json:"accepted"`
+}
+
+// Tests of a relay check
+const (
+	TestRelayExternal    = "relay-external-sender"
+	TestRelayLocal       = "relay-local-sender"
+	TestRelayNullSender  = "relay-null-sender"
+	TestRelayPercentHack = "relay-percent-hack"
+	TestUnknownRecipient = "unknown-recipient"
+)
+
+// RelaySenderDomain and RelayRecipientDomain are the external domains the
+// relay tests use. The defaults are reserved, so nothing can be delivered
+// even if a server accepts, and DATA is never sent.
+var (
+	RelaySenderDomain    = "example.org"
+	RelayRecipientDomain = "example.net"
+)
+
+// GetRelay function of this package to check the MX hosts of a domain for
+// open relaying and backscatter. It has to be called explicitly, it is not
+// part of Get.
+func GetRelay(domain string, nameserver string) *RelayData {
+	return GetRelayWithResolver(domain, dnsresolver.Parse(nameserver))
+}
+
+// GetRelayWithResolver is GetRelay with a custom resolver.
+func GetRelayWithResolver(domain string, resolver dnsresolver.Resolver) *RelayData {
+	r := new(RelayData)
+	r.CheckTime = time.Now()
+
+	mx := emailmx.GetWithResolver(domain, resolver)
+	r.Domain = mx.Domain
+	if mx.Error != "" {
+		r.Error = mx.Error
+		r.ErrorMessage = mx.ErrorMessage
+		return r
+	}
+	if len(mx.Records) == 0 {
+		r.Error = "Failed"
+		r.ErrorMessage = "No MX records."
+		return r
+	}
+
+	for _, record := range mx.Records {
+		s := CheckRelay(strings.TrimSuffix(record.Server, "."), DefaultPort, r.Domain)
+		s.Preference = record.Preference
+		r.OpenRelay = r.OpenRelay || s.OpenRelay
+		r.Backscatter = r.Backscatter || s.Backscatter
+		r.Servers = append(r.Servers, s)
+	}
+	return r
+}
+
+// CheckRelay tries to relay through host to an external domain and sends
+// a recipient that does not exist in domain. Every transaction ends at RCPT
+// TO and is reset, no message is sent. An accepted percent hack recipient
+// is in the local domain, so it is only a warning: the server may as well
+// accept every local recipient and bounce later.
+func CheckRelay(host string, port int, domain string) *Relay {
+	s := &Relay{Host: host, Port: port}
+
+	sess, _, err := dial(host, port, DefaultTimeout)
+	if err != nil {
+		s.Error = "Failed"
+		s.ErrorMessage = err.Error()
+		return s
+	}
+	defer sess.close()
+
+	if _, err := sess.ehlo(HelloName); err != nil {
+		if _, _, err := sess.cmd(250, "HELO %s", HelloName); err != nil {
+			s.Error = "Failed"
+			s.ErrorMessage = "HELO: " + err.Error()
+			return s
+		}
+	}
+
+	probe := "probe-" + randomHex()
+	external := probe + "@" + RelayRecipientDomain
+	attempts := []*RelayAttempt{
+		{Test: TestRelayExternal, MailFrom: probe + "@" + RelaySenderDomain, RcptTo: external},
+		{Test: TestRelayLocal, MailFrom: "postmaster@" + domain, RcptTo: external},
+		{Test: TestRelayNullSender, MailFrom: "", RcptTo: external},
+		{Test: TestRelayPercentHack, MailFrom: probe + "@" + RelaySenderDomain, RcptTo: probe + "%" + RelayRecipientDomain + "@" + domain},
+		{Test: TestUnknownRecipient, MailFrom: probe + "@" + RelaySenderDomain, RcptTo: "no-such-user-" + randomHex() + "@" + domain},
+	}
+
+	for _, a := range attempts {
+		if err := sess.transaction(a); err != nil {
+			s.Error = "Failed"
+			s.ErrorMessage = a.Test + ": " + err.Error()
+			return s
+		}
+		s.Attempts = append(s.Attempts, a)
+		if a.Accepted {
+			switch a.Test {
+			case TestUnknownRecipient:
+				s.Backscatter = true
+			case TestRelayPercentHack:
+				// A local recipient, judged below.
+			default:
+				s.OpenRelay = true
+			}
+		}
+	}
+
+	if percent := s.attempt(TestRelayPercentHack); percent != nil && percent.Accepted {
+		if s.Backscatter {
+			s.Warnings = append(s.Warnings, "Percent hack recipient accepted, but so is an unknown local recipient.")
+		} else {
+			s.Warnings = append(s.Warnings, "Percent hack recipient accepted while an unknown local recipient is rejected, the server may relay it.")
+		}
+	}
+	return s
+}
+
+/*
+ * Used functions
+ */
+
+func (s *Relay) attempt(test string) *RelayAttempt {
+	for _, a := range s.Attempts {
+		if a.Test == test {
+			return a
+		}
+	}
+	return nil
+}
+
+// transaction runs MAIL FROM and RCPT TO and resets. A rejected MAIL FROM
+// or RCPT TO is a result, only a broken connection is an error.
+func (s *session) transaction(a *RelayAttempt) error {
+	defer s.cmd(250, "RSET")
+
+	code, msg, err := s.cmd(250, "MAIL FROM:<%s>", a.MailFrom)
+	if err != nil {
+		if replyCode(err) == 0 {
+			return err
+		}
+		a.Code, a.Reply = code, "MAIL FROM: "+msg
+		return nil
+	}
+
+	code, msg, err = s.cmd(25, "RCPT TO:<%s>", a.RcptTo)
+	if err != nil && replyCode(err) == 0 {
+		return err
+	}
+	a.Code, a.Reply = code, msg
+	a.Accepted = code/100 == 2
+	return nil
+}
+
+// replyCode returns the code of an SMTP error reply, or 0 for other errors.
+func replyCode(err error) int {
+	var protoErr *textproto.Error
+	if errors.As(err, &protoErr) {
+		return protoErr.Code
+	}
+	return 0
+}
+
+func randomHex() string {
+	b := make([]byte, 6)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
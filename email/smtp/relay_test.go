@@ -0,0 +1,188 @@
+package emailsmtp
+
+import (
+	"bufio"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+)
+
+// smtpServer runs an SMTP server on localhost that accepts a recipient when
+// accept returns true for the sender and recipient, and returns its port.
+// A nil accept drops the connection at MAIL FROM.
+func smtpServer(t *testing.T, accept func(from, rcpt string) bool) int {
+	return stubServer(t, &stub{extensions: []string{"8BITMIME"}, accept: accept})
+}
+
+// stub configures the SMTP server of stubServer. It greets with banner,
+// 220 mx.example.com ESMTP by default, and answers EHLO with extensions. A
+// STARTTLS command upgrades with cert, or is refused without one, after
+// which EHLO answers with tlsExtensions.
+type stub struct {
+	banner        string
+	extensions    []string
+	cert          *tls.Certificate
+	tlsExtensions []string
+	accept        func(from, rcpt string) bool
+}
+
+// stubServer runs the SMTP server of s on localhost and returns its port.
+func stubServer(t *testing.T, s *stub) int {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return l.Addr().(*net.TCPAddr).Port
+}
+
+func (s *stub) serve(conn net.Conn) {
+	defer func() { conn.Close() }()
+	r := bufio.NewReader(conn)
+	if s.banner == "" {
+		fmt.Fprint(conn, "220 mx.example.com ESMTP\r\n")
+	} else {
+		fmt.Fprint(conn, s.banner+"\r\n")
+	}
+	extensions := s.extensions
+	var from string
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		verb := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+		switch {
+		case verb == "EHLO":
+			fmt.Fprint(conn, "250-mx.example.com")
+			for _, extension := range extensions {
+				fmt.Fprint(conn, "\r\n250-"+extension)
+			}
+			fmt.Fprint(conn, "\r\n250 HELP\r\n")
+		case verb == "STARTTLS":
+			if s.cert == nil {
+				fmt.Fprint(conn, "454 4.7.0 TLS not available\r\n")
+				continue
+			}
+			fmt.Fprint(conn, "220 2.0.0 Ready to start TLS\r\n")
+			tlsConn := tls.Server(conn, &tls.Config{Certificates: []tls.Certificate{*s.cert}})
+			if err := tlsConn.Handshake(); err != nil {
+				return
+			}
+			conn = tlsConn
+			r = bufio.NewReader(conn)
+			extensions = s.tlsExtensions
+		case strings.HasPrefix(strings.ToUpper(line), "MAIL FROM:"):
+			if s.accept == nil {
+				return
+			}
+			from = strings.Trim(line[len("MAIL FROM:"):], "<>")
+			fmt.Fprint(conn, "250 2.1.0 Ok\r\n")
+		case strings.HasPrefix(strings.ToUpper(line), "RCPT TO:"):
+			if s.accept(from, strings.Trim(line[len("RCPT TO:"):], "<>")) {
+				fmt.Fprint(conn, "250 2.1.5 Ok\r\n")
+			} else {
+				fmt.Fprint(conn, "554 5.7.1 Relay access denied\r\n")
+			}
+		case verb == "RSET":
+			from = ""
+			fmt.Fprint(conn, "250 2.0.0 Ok\r\n")
+		case verb == "QUIT":
+			fmt.Fprint(conn, "221 2.0.0 Bye\r\n")
+			return
+		default:
+			fmt.Fprint(conn, "502 5.5.2 Error: command not recognized\r\n")
+		}
+	}
+}
+
+func TestCheckRelay(t *testing.T) {
+	local := func(rcpt string) bool {
+		return strings.HasSuffix(rcpt, "@example.com")
+	}
+
+	tests := []struct {
+		name        string
+		accept      func(from, rcpt string) bool
+		openRelay   bool
+		backscatter bool
+		warning     string
+		err         string
+	}{
+		{
+			name:   "closed",
+			accept: func(from, rcpt string) bool { return false },
+		},
+		{
+			name: "local recipients only",
+			accept: func(from, rcpt string) bool {
+				return local(rcpt) && !strings.Contains(rcpt, "%") && !strings.HasPrefix(rcpt, "no-such-user-")
+			},
+		},
+		{
+			name:      "open relay",
+			accept:    func(from, rcpt string) bool { return !strings.HasPrefix(rcpt, "no-such-user-") },
+			openRelay: true,
+			warning:   "unknown local recipient is rejected",
+		},
+		{
+			name: "relay for a local sender",
+			accept: func(from, rcpt string) bool {
+				return local(from) || local(rcpt) && !strings.Contains(rcpt, "%") && !strings.HasPrefix(rcpt, "no-such-user-")
+			},
+			openRelay: true,
+		},
+		{
+			name:    "percent hack only",
+			accept:  func(from, rcpt string) bool { return local(rcpt) && !strings.HasPrefix(rcpt, "no-such-user-") },
+			warning: "the server may relay it",
+		},
+		{
+			name:        "accept all local recipients",
+			accept:      func(from, rcpt string) bool { return local(rcpt) },
+			backscatter: true,
+			warning:     "but so is an unknown local recipient",
+		},
+		{
+			name: "connection dropped",
+			err:  TestRelayExternal + ": ",
+		},
+	}
+	for _, tt := range tests {
+		s := CheckRelay("127.0.0.1", smtpServer(t, tt.accept), "example.com")
+		if tt.err != "" {
+			if !strings.HasPrefix(s.ErrorMessage, tt.err) {
+				t.Errorf("%s: got error %q, want %q", tt.name, s.ErrorMessage, tt.err)
+			}
+			continue
+		}
+		if s.Error != "" {
+			t.Errorf("%s: %s", tt.name, s.ErrorMessage)
+			continue
+		}
+		if len(s.Attempts) != 5 {
+			t.Errorf("%s: got %d attempts, want 5", tt.name, len(s.Attempts))
+		}
+		if s.OpenRelay != tt.openRelay || s.Backscatter != tt.backscatter {
+			t.Errorf("%s: got open relay %v and backscatter %v, want %v and %v", tt.name, s.OpenRelay, s.Backscatter, tt.openRelay, tt.backscatter)
+		}
+		warnings := strings.Join(s.Warnings, "\n")
+		if tt.warning == "" && warnings != "" || !strings.Contains(warnings, tt.warning) {
+			t.Errorf("%s: got warnings %q, want %q", tt.name, warnings, tt.warning)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package emailsmtp
+
+import (
+	"crypto/tls"
+	"net"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// session is a plain SMTP conversation, net/smtp hides the banner and the
+// raw EHLO response which the probes need.
+type session struct {
+	host    string
+	conn    net.Conn
+	text    *textproto.Conn
+	timeout time.Duration
+}
+
+// dial connects to host:port and reads the 220 banner.
+func dial(host string, port int, timeout time.Duration) (*session, string, error) {
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(host, strconv.Itoa(port)), timeout)
+	if err != nil {
+		return nil, "", err
+	}
+	s := &session{host: host, conn: conn, text: textproto.NewConn(conn), timeout: timeout}
+	s.deadline()
+
+	_, banner, err := s.text.ReadResponse(220)
+	if err != nil {
+		conn.Close()
+		return nil, banner, err
+	}
+	return s, banner, nil
+}
+
+func (s *session) deadline() {
+	s.conn.SetDeadline(time.Now().Add(s.timeout))
+}
+
+// cmd sends a command and reads the reply. A reply with another code than
+// expect gives a *textproto.Error together with the code and message.
+func (s *session) cmd(expect int, format string, args ...interface{}) (int, string, error) {
+	s.deadline()
+	id, err := s.text.Cmd(format, args...)
+	if err != nil {
+		return 0, "", err
+	}
+	s.text.StartResponse(id)
+	defer s.text.EndResponse(id)
+	return s.text.ReadResponse(expect)
+}
+
+// ehlo returns the extension lines of the EHLO response.
+func (s *session) ehlo(name string) ([]string, error) {
+	_, msg, err := s.cmd(250, "EHLO %s", name)
+	if err != nil {
+		return nil, err
+	}
+	lines := strings.Split(msg, "\n")
+	return lines[1:], nil
+}
+
+// startTLS upgrades the connection. The certificate is not verified here,
+// the caller inspects the connection state.
+func (s *session) startTLS() (*tls.ConnectionState, error) {
+	if _, _, err := s.cmd(220, "STARTTLS"); err != nil {
+		return nil, err
+	}
+	tlsConn := tls.Client(s.conn, &tls.Config{
+		ServerName:         s.host,
+		InsecureSkipVerify: true,
+	})
+	s.deadline()
+	if err := tlsConn.Handshake(); err != nil {
+		return nil, err
+	}
+	s.conn = tlsConn
+	s.text = textproto.NewConn(tlsConn)
+	state := tlsConn.ConnectionState()
+	return &state, nil
+}
+
+// close ends the conversation politely.
+func (s *session) close() {
+	s.cmd(221, "QUIT")
+	s.conn.Close()
+}
@@ -0,0 +1,227 @@
+package emailsmtp
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"strconv"
+	"strings"
+	"time"
+
+	dnsresolver "github.com/binaryfigments/goharvest/dns/resolver"
+	emailmx "github.com/binaryfigments/goharvest/email/mx"
+)
+
+// Data struct with the SMTP probe of every MX of a domain
+type Data struct {
+	Domain       string    `json:"domain,omitempty"`
+	CheckTime    time.Time `json:"time"`
+	Servers      []*Server `json:"servers,omitempty"`
+	Error        string    `json:"error,omitempty"`
+	ErrorMessage string    `json:"errormessage,omitempty"`
+}
+
+// Server struct with the capabilities of one SMTP server
+type Server struct {
+	Host               string   `json:"host,omitempty"`
+	Preference         uint16   `json:"preference,omitempty"`
+	Port               int      `json:"port,omitempty"`
+	Banner             string   `json:"banner,omitempty"`
+	Extensions         []string `json:"extensions,omitempty"`
+	Size               int64    `json:"size,omitempty"`
+	Pipelining         bool     `json:"pipelining"`
+	EightBitMIME       bool     `json:"8bitmime"`
+	SMTPUTF8           bool     `json:"smtputf8"`
+	RequireTLS         bool     `json:"requiretls"`
+	StartTLS           bool     `json:"starttls"`
+	Auth               []string `json:"auth,omitempty"`
+	PlaintextAuth      bool     `json:"plaintextauth"`
+	TLS                *TLS     `json:"tls,omitempty"`
+	ExtensionsAfterTLS []string `json:"extensionsaftertls,omitempty"`
+	AuthAfterTLS       []string `json:"authaftertls,omitempty"`
+	Error              string   `json:"error,omitempty"`
+	ErrorMessage       string   `json:"errormessage,omitempty"`
+}
+
+// TLS struct with the negotiated STARTTLS session
+type TLS struct {
+	Version     string `json:"version,omitempty"`
+	CipherSuite string `json:"ciphersuite,omitempty"`
+	Verified    bool   `json:"verified"`
+	VerifyError string `json:"verifyerror,omitempty"`
+	Error       string `json:"error,omitempty"`
+}
+
+// DefaultPort is the SMTP port of MX hosts.
+const DefaultPort = 25
+
+// DefaultTimeout is the timeout of every step of a conversation.
+var DefaultTimeout = 10 * time.Second
+
+// HelloName is the name sent with EHLO.
+var HelloName = "localhost"
+
+// Get function of this package to probe the MX hosts of a domain
+func Get(domain string, nameserver string) *Data {
+	return GetWithResolver(domain, dnsresolver.Parse(nameserver))
+}
+
+// GetWithResolver is Get with a custom resolver.
+func GetWithResolver(domain string, resolver dnsresolver.Resolver) *Data {
+	r := new(Data)
+	r.CheckTime = time.Now()
+
+	mx := emailmx.GetWithResolver(domain, resolver)
+	r.Domain = mx.Domain
+	if mx.Error != "" {
+		r.Error = mx.Error
+		r.ErrorMessage = mx.ErrorMessage
+		return r
+	}
+	if len(mx.Records) == 0 {
+		r.Error = "Failed"
+		r.ErrorMessage = "No MX records."
+		return r
+	}
+
+	for _, record := range mx.Records {
+		s := Probe(strings.TrimSuffix(record.Server, "."), DefaultPort)
+		s.Preference = record.Preference
+		r.Servers = append(r.Servers, s)
+	}
+	return r
+}
+
+// Probe records the banner and EHLO extensions of host, upgrades with
+// STARTTLS when offered and records the extensions after the upgrade.
+func Probe(host string, port int) *Server {
+	s := &Server{Host: host, Port: port}
+
+	sess, banner, err := dial(host, port, DefaultTimeout)
+	s.Banner = banner
+	if err != nil {
+		s.Error = "Failed"
+		s.ErrorMessage = err.Error()
+		return s
+	}
+	defer sess.close()
+
+	s.Extensions, err = sess.ehlo(HelloName)
+	if err != nil {
+		s.Error = "Failed"
+		s.ErrorMessage = "EHLO: " + err.Error()
+		return s
+	}
+	s.parseExtensions(s.Extensions)
+	s.Auth = authMechanisms(s.Extensions)
+	for _, mechanism := range s.Auth {
+		if mechanism == "PLAIN" || mechanism == "LOGIN" {
+			s.PlaintextAuth = true
+		}
+	}
+
+	if !s.StartTLS {
+		return s
+	}
+
+	s.TLS = new(TLS)
+	state, err := sess.startTLS()
+	if err != nil {
+		s.TLS.Error = err.Error()
+		return s
+	}
+	s.TLS.Version = tlsVersion(state.Version)
+	s.TLS.CipherSuite = tls.CipherSuiteName(state.CipherSuite)
+	if err := verify(host, state); err != nil {
+		s.TLS.VerifyError = err.Error()
+	} else {
+		s.TLS.Verified = true
+	}
+
+	s.ExtensionsAfterTLS, err = sess.ehlo(HelloName)
+	if err != nil {
+		s.TLS.Error = "EHLO after STARTTLS: " + err.Error()
+		return s
+	}
+	s.AuthAfterTLS = authMechanisms(s.ExtensionsAfterTLS)
+	return s
+}
+
+/*
+ * Used functions
+ */
+
+// parseExtensions sets the flags for the extensions of the first EHLO.
+func (s *Server) parseExtensions(extensions []string) {
+	for _, line := range extensions {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		switch strings.ToUpper(fields[0]) {
+		case "SIZE":
+			if len(fields) > 1 {
+				s.Size, _ = strconv.ParseInt(fields[1], 10, 64)
+			}
+		case "PIPELINING":
+			s.Pipelining = true
+		case "8BITMIME":
+			s.EightBitMIME = true
+		case "SMTPUTF8":
+			s.SMTPUTF8 = true
+		case "REQUIRETLS":
+			s.RequireTLS = true
+		case "STARTTLS":
+			s.StartTLS = true
+		}
+	}
+}
+
+// authMechanisms returns the mechanisms of the AUTH extension, some old
+// servers advertise them as AUTH=.
+func authMechanisms(extensions []string) []string {
+	var mechanisms []string
+	seen := make(map[string]bool)
+	for _, line := range extensions {
+		upper := strings.ToUpper(line)
+		if !strings.HasPrefix(upper, "AUTH ") && !strings.HasPrefix(upper, "AUTH=") {
+			continue
+		}
+		for _, mechanism := range strings.Fields(upper[5:]) {
+			if !seen[mechanism] {
+				seen[mechanism] = true
+				mechanisms = append(mechanisms, mechanism)
+			}
+		}
+	}
+	return mechanisms
+}
+
+// verify verifies the peer chain against the system roots for host.
+func verify(host string, state *tls.ConnectionState) error {
+	certs := state.PeerCertificates
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+	_, err := certs[0].Verify(x509.VerifyOptions{
+		DNSName:       host,
+		Intermediates: intermediates,
+	})
+	return err
+}
+
+func tlsVersion(version uint16) string {
+	switch version {
+	case tls.VersionSSL30:
+		return "SSLv3"
+	case tls.VersionTLS10:
+		return "TLSv1.0"
+	case tls.VersionTLS11:
+		return "TLSv1.1"
+	case tls.VersionTLS12:
+		return "TLSv1.2"
+	case tls.VersionTLS13:
+		return "TLSv1.3"
+	}
+	return "0x" + strconv.FormatUint(uint64(version), 16)
+}
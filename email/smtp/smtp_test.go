@@ -0,0 +1,139 @@
+package emailsmtp
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+)
+
+// selfSigned returns a self-signed certificate for mx.example.com.
+func selfSigned(t *testing.T) *tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "mx.example.com"},
+		DNSNames:     []string{"mx.example.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func TestProbe(t *testing.T) {
+	cert := selfSigned(t)
+	submission := []string{"SIZE 52428800", "PIPELINING", "8BITMIME", "SMTPUTF8", "AUTH PLAIN LOGIN", "STARTTLS"}
+
+	tests := []struct {
+		name string
+		stub *stub
+		want *Server
+		err  string
+	}{
+		{
+			name: "plaintext AUTH before STARTTLS",
+			stub: &stub{extensions: submission, cert: cert, tlsExtensions: []string{"SIZE 52428800", "AUTH PLAIN LOGIN CRAM-MD5"}},
+			want: &Server{
+				Banner:             "mx.example.com ESMTP",
+				Size:               52428800,
+				Pipelining:         true,
+				EightBitMIME:       true,
+				SMTPUTF8:           true,
+				StartTLS:           true,
+				Auth:               []string{"PLAIN", "LOGIN"},
+				PlaintextAuth:      true,
+				ExtensionsAfterTLS: []string{"SIZE 52428800", "AUTH PLAIN LOGIN CRAM-MD5", "HELP"},
+				AuthAfterTLS:       []string{"PLAIN", "LOGIN", "CRAM-MD5"},
+			},
+		},
+		{
+			name: "AUTH only after STARTTLS",
+			stub: &stub{extensions: []string{"REQUIRETLS", "STARTTLS"}, cert: cert, tlsExtensions: []string{"AUTH=PLAIN", "AUTH PLAIN"}},
+			want: &Server{
+				Banner:             "mx.example.com ESMTP",
+				RequireTLS:         true,
+				StartTLS:           true,
+				ExtensionsAfterTLS: []string{"AUTH=PLAIN", "AUTH PLAIN", "HELP"},
+				AuthAfterTLS:       []string{"PLAIN"},
+			},
+		},
+		{
+			name: "no STARTTLS",
+			stub: &stub{banner: "220-mx.example.com ESMTP\r\n220 no UCE", extensions: []string{"auth=login", "size"}},
+			want: &Server{
+				Banner:        "mx.example.com ESMTP\nno UCE",
+				Auth:          []string{"LOGIN"},
+				PlaintextAuth: true,
+			},
+		},
+		{
+			name: "STARTTLS refused",
+			stub: &stub{extensions: []string{"STARTTLS"}},
+			want: &Server{Banner: "mx.example.com ESMTP", StartTLS: true},
+			err:  "TLS not available",
+		},
+		{
+			name: "rejected",
+			stub: &stub{banner: "554 5.3.2 mx.example.com busy"},
+			want: &Server{Banner: "5.3.2 mx.example.com busy", Error: "Failed"},
+			err:  "554",
+		},
+	}
+	for _, tt := range tests {
+		s := Probe("127.0.0.1", stubServer(t, tt.stub))
+		got := []interface{}{s.Banner, s.Size, s.Pipelining, s.EightBitMIME, s.SMTPUTF8, s.RequireTLS, s.StartTLS, strings.Join(s.Auth, " "), s.PlaintextAuth, strings.Join(s.ExtensionsAfterTLS, "|"), strings.Join(s.AuthAfterTLS, " "), s.Error}
+		w := tt.want
+		want := []interface{}{w.Banner, w.Size, w.Pipelining, w.EightBitMIME, w.SMTPUTF8, w.RequireTLS, w.StartTLS, strings.Join(w.Auth, " "), w.PlaintextAuth, strings.Join(w.ExtensionsAfterTLS, "|"), strings.Join(w.AuthAfterTLS, " "), w.Error}
+		for i := range got {
+			if got[i] != want[i] {
+				t.Errorf("%s: got %v, want %v", tt.name, got, want)
+				break
+			}
+		}
+		if s.Error == "" && strings.Join(s.Extensions, "|") != strings.Join(append(append([]string{}, tt.stub.extensions...), "HELP"), "|") {
+			t.Errorf("%s: got extensions %q", tt.name, s.Extensions)
+		}
+
+		errorMessage := s.ErrorMessage
+		if s.TLS != nil {
+			errorMessage = s.TLS.Error
+		}
+		if tt.err == "" && errorMessage != "" || !strings.Contains(errorMessage, tt.err) {
+			t.Errorf("%s: got error %q, want %q", tt.name, errorMessage, tt.err)
+		}
+		if tt.stub.cert != nil && (s.TLS == nil || s.TLS.Version != "TLSv1.3" || s.TLS.Verified || s.TLS.VerifyError == "") {
+			t.Errorf("%s: got TLS %+v", tt.name, s.TLS)
+		}
+	}
+}
+
+func TestAuthMechanisms(t *testing.T) {
+	tests := []struct {
+		extensions []string
+		want       string
+	}{
+		{[]string{"AUTH PLAIN LOGIN", "AUTH=LOGIN"}, "PLAIN LOGIN"},
+		{[]string{"auth cram-md5 xoauth2"}, "CRAM-MD5 XOAUTH2"},
+		{[]string{"AUTHENTICATE", "8BITMIME"}, ""},
+		{nil, ""},
+	}
+	for _, tt := range tests {
+		if got := strings.Join(authMechanisms(tt.extensions), " "); got != tt.want {
+			t.Errorf("%q: got %q, want %q", tt.extensions, got, tt.want)
+		}
+	}
+}
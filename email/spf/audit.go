@@ -0,0 +1,214 @@
+package emailspf
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	dnsresolver "github.com/binaryfigments/goharvest/dns/resolver"
+)
+
+// Audit struct with the expanded include/redirect tree of a domain
+type Audit struct {
+	Domain       string    `json:"domain,omitempty"`
+	CheckTime    time.Time `json:"time"`
+	Result       string    `json:"result,omitempty"`
+	Reason       string    `json:"reason,omitempty"`
+	Lookups      int       `json:"lookups"`
+	VoidLookups  int       `json:"voidlookups"`
+	Tree         *Node     `json:"tree,omitempty"`
+	Error        string    `json:"error,omitempty"`
+	ErrorMessage string    `json:"errormessage,omitempty"`
+}
+
+// Node struct for one SPF record in the tree
+type Node struct {
+	Domain       string   `json:"domain,omitempty"`
+	Term         string   `json:"term,omitempty"`
+	Records      []string `json:"records,omitempty"`
+	Parsed       *Record  `json:"parsed,omitempty"`
+	Lookups      int      `json:"lookups"`
+	VoidLookups  int      `json:"voidlookups"`
+	Children     []*Node  `json:"children,omitempty"`
+	Problems     []string `json:"problems,omitempty"`
+	ErrorMessage string   `json:"errormessage,omitempty"`
+}
+
+// ResultOK is the Audit result when no limit is exceeded.
+const ResultOK = "ok"
+
+// GetAudit expands the SPF record of domain following every include: and
+// redirect=, counts the DNS querying terms and void lookups of the whole
+// tree and reports the first reason the record would give a permerror.
+func GetAudit(domain string, nameserver string) *Audit {
+	return GetAuditWithResolver(domain, dnsresolver.Parse(nameserver))
+}
+
+// GetAuditWithResolver is GetAudit with a custom resolver.
+func GetAuditWithResolver(domain string, resolver dnsresolver.Resolver) *Audit {
+	r := new(Audit)
+	r.Domain = domain
+	r.CheckTime = time.Now()
+
+	a := &auditor{resolver: resolver, audit: r}
+	r.Tree = a.expand(strings.TrimSuffix(domain, "."), "", nil)
+
+	switch {
+	case r.Result != "":
+	case r.Lookups > MaxLookups:
+		a.fail(ResultPermError, "more than 10 DNS lookups ("+strconv.Itoa(r.Lookups)+")")
+	case r.VoidLookups > MaxVoidLookups:
+		a.fail(ResultPermError, "more than 2 void lookups ("+strconv.Itoa(r.VoidLookups)+")")
+	default:
+		r.Result = ResultOK
+	}
+	if r.Result != ResultOK {
+		r.Error = "Failed"
+		r.ErrorMessage = r.Reason
+	}
+	return r
+}
+
+/*
+ * Used functions
+ */
+
+type auditor struct {
+	resolver dnsresolver.Resolver
+	audit    *Audit
+}
+
+// fail records the first problem that decides the result.
+func (a *auditor) fail(result string, reason string) {
+	if a.audit.Result == "" {
+		a.audit.Result = result
+		a.audit.Reason = reason
+	}
+}
+
+func (a *auditor) expand(domain string, term string, path []string) *Node {
+	n := &Node{Domain: domain, Term: term}
+
+	for _, seen := range path {
+		if strings.EqualFold(seen, domain) {
+			n.ErrorMessage = "include loop: " + strings.Join(append(path, domain), " -> ")
+			a.fail(ResultPermError, n.ErrorMessage)
+			return n
+		}
+	}
+	path = append(path, domain)
+
+	records, err := lookupSPF(a.resolver, domain)
+	switch {
+	case err != nil:
+		n.ErrorMessage = domain + ": " + err.Error()
+		a.fail(ResultTempError, n.ErrorMessage)
+		return n
+	case len(records) == 0:
+		n.ErrorMessage = "no SPF record for " + domain
+		if term == "" {
+			a.fail(ResultNone, n.ErrorMessage)
+		} else {
+			a.fail(ResultPermError, n.ErrorMessage)
+		}
+		return n
+	}
+	n.Records = records
+	if len(records) > 1 {
+		n.ErrorMessage = "multiple SPF records for " + domain
+		a.fail(ResultPermError, n.ErrorMessage)
+		return n
+	}
+
+	record, err := Parse(records[0])
+	n.Parsed = record
+	if err != nil {
+		n.ErrorMessage = domain + ": " + err.Error()
+		a.fail(ResultPermError, n.ErrorMessage)
+		return n
+	}
+
+	ctx := &macroContext{domain: domain}
+	for _, m := range record.Mechanisms {
+		switch m.Name {
+		case "include":
+			a.count(n)
+			if hasMacro(m.Value) {
+				n.Problems = append(n.Problems, m.String()+": target uses macros, not expanded")
+				continue
+			}
+			n.Children = append(n.Children, a.expand(strings.TrimSuffix(m.Value, "."), m.String(), path))
+		case "a", "mx", "exists":
+			a.count(n)
+			if hasMacro(m.Value) {
+				continue
+			}
+			target, _ := expandMacros(m.Value, ctx, false)
+			if target == "" {
+				target = domain
+			}
+			a.checkVoid(n, m, target)
+		case "ptr":
+			a.count(n)
+			n.Problems = append(n.Problems, m.String()+": use of ptr is discouraged (RFC 7208 section 5.5)")
+		}
+	}
+
+	if record.Redirect != "" {
+		hasAll := false
+		for _, m := range record.Mechanisms {
+			if m.Name == "all" {
+				hasAll = true
+				break
+			}
+		}
+		switch {
+		case hasAll:
+			n.Problems = append(n.Problems, "redirect= is ignored because the record has an all mechanism")
+		case hasMacro(record.Redirect):
+			a.count(n)
+			n.Problems = append(n.Problems, "redirect="+record.Redirect+": target uses macros, not expanded")
+		default:
+			a.count(n)
+			n.Children = append(n.Children, a.expand(strings.TrimSuffix(record.Redirect, "."), "redirect="+record.Redirect, path))
+		}
+	}
+
+	return n
+}
+
+func (a *auditor) count(n *Node) {
+	n.Lookups++
+	a.audit.Lookups++
+}
+
+// checkVoid does the lookup for an a, mx or exists mechanism and counts it
+// when it returns no records or NXDOMAIN.
+func (a *auditor) checkVoid(n *Node, m *Mechanism, target string) {
+	var err error
+	switch m.Name {
+	case "mx":
+		var hosts []string
+		hosts, err = lookupMX(a.resolver, target)
+		if len(hosts) > MaxLookups {
+			n.Problems = append(n.Problems, m.String()+": more than 10 MX records")
+			a.fail(ResultPermError, m.String()+": more than 10 MX records")
+		}
+	case "a":
+		_, err = lookupIP(a.resolver, target, false)
+		if err == errVoid {
+			_, err = lookupIP(a.resolver, target, true)
+		}
+	default:
+		_, err = lookupIP(a.resolver, target, false)
+	}
+	if err == errVoid {
+		n.VoidLookups++
+		a.audit.VoidLookups++
+		n.Problems = append(n.Problems, m.String()+": void lookup")
+	}
+}
+
+func hasMacro(spec string) bool {
+	return strings.Contains(spec, "%")
+}
@@ -0,0 +1,44 @@
+package emailspf
+
+import (
+	"testing"
+
+	dnsresolver "github.com/binaryfigments/goharvest/dns/resolver"
+)
+
+func TestGetAudit(t *testing.T) {
+	z := dnsresolver.MustZone(
+		`loop.example. 60 IN TXT "v=spf1 include:loop2.example -all"`,
+		`loop2.example. 60 IN TXT "v=spf1 include:loop.example -all"`,
+		`big.example. 60 IN TXT "v=spf1 include:a.example include:b.example a mx -all"`,
+		`big.example. 60 IN A 192.0.2.1`,
+		`a.example. 60 IN TXT "v=spf1 a:x1.example a:x2.example a:x3.example mx:x4.example ~all"`,
+		`b.example. 60 IN TXT "v=spf1 exists:%{i}.b.example a a a ?all"`,
+		`b.example. 60 IN A 192.0.2.1`,
+		`ok.example. 60 IN TXT "v=spf1 ip4:192.0.2.1 include:b.example -all"`,
+		`redir.example. 60 IN TXT "v=spf1 ip4:192.0.2.1 redirect=b.example"`,
+		`redir-all.example. 60 IN TXT "v=spf1 ip4:192.0.2.1 -all redirect=b.example"`,
+	)
+
+	tests := []struct {
+		domain  string
+		want    string
+		lookups int
+	}{
+		{"loop.example", ResultPermError, 2},
+		{"big.example", ResultPermError, 12},
+		{"ok.example", ResultOK, 5},
+		{"redir.example", ResultOK, 5},
+		{"redir-all.example", ResultOK, 0},
+		{"no.example", ResultNone, 0},
+	}
+	for _, tt := range tests {
+		a := GetAuditWithResolver(tt.domain, z)
+		if a.Result != tt.want {
+			t.Errorf("%s: got %s (%s), want %s", tt.domain, a.Result, a.Reason, tt.want)
+		}
+		if a.Lookups != tt.lookups {
+			t.Errorf("%s: got %d lookups, want %d", tt.domain, a.Lookups, tt.lookups)
+		}
+	}
+}
@@ -0,0 +1,484 @@
+package emailspf
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"time"
+
+	dnsresolver "github.com/binaryfigments/goharvest/dns/resolver"
+	"github.com/miekg/dns"
+)
+
+// Results of check_host() (RFC 7208 section 2.6)
+const (
+	ResultNone      = "none"
+	ResultNeutral   = "neutral"
+	ResultPass      = "pass"
+	ResultFail      = "fail"
+	ResultSoftFail  = "softfail"
+	ResultTempError = "temperror"
+	ResultPermError = "permerror"
+)
+
+// Processing limits (RFC 7208 section 4.6.4)
+const (
+	MaxLookups     = 10
+	MaxVoidLookups = 2
+)
+
+// Check struct with the outcome of check_host()
+type Check struct {
+	IP           string    `json:"ip,omitempty"`
+	Domain       string    `json:"domain,omitempty"`
+	Sender       string    `json:"sender,omitempty"`
+	CheckTime    time.Time `json:"time"`
+	Result       string    `json:"result,omitempty"`
+	Mechanism    string    `json:"mechanism,omitempty"`
+	Explanation  string    `json:"explanation,omitempty"`
+	Lookups      int       `json:"lookups"`
+	VoidLookups  int       `json:"voidlookups"`
+	Error        string    `json:"error,omitempty"`
+	ErrorMessage string    `json:"errormessage,omitempty"`
+}
+
+// CheckHost evaluates the SPF policy of domain for a message from ip with
+// MAIL FROM sender, as the check_host() function of RFC 7208.
+func CheckHost(ip net.IP, domain string, sender string, nameserver string) *Check {
+	return CheckHostWithResolver(ip, domain, sender, dnsresolver.Parse(nameserver))
+}
+
+// CheckHostWithResolver is CheckHost with a custom resolver.
+func CheckHostWithResolver(ip net.IP, domain string, sender string, resolver dnsresolver.Resolver) *Check {
+	r := new(Check)
+	r.IP = ip.String()
+	r.Domain = domain
+	r.Sender = sender
+	r.CheckTime = time.Now()
+
+	c := &checker{
+		resolver: resolver,
+		ctx:      macroContext{sender: sender, ip: ip},
+	}
+	result, mechanism, err := c.checkHost(domain)
+	r.Result = result
+	r.Mechanism = mechanism
+	r.Explanation = c.explanation
+	r.Lookups = c.lookups
+	r.VoidLookups = c.voidLookups
+	if err != nil {
+		r.Error = "Failed"
+		r.ErrorMessage = err.Error()
+	}
+	return r
+}
+
+/*
+ * Used functions
+ */
+
+type checker struct {
+	resolver    dnsresolver.Resolver
+	ctx         macroContext
+	lookups     int
+	voidLookups int
+	includes    int
+	explanation string
+}
+
+var (
+	errTemp = errors.New("DNS lookup failed")
+	errVoid = errors.New("void lookup")
+)
+
+func (c *checker) checkHost(domain string) (string, string, error) {
+	domain = strings.TrimSuffix(domain, ".")
+	if _, ok := dns.IsDomainName(domain); !ok || !strings.Contains(domain, ".") {
+		return ResultNone, "", errors.New("invalid domain: " + domain)
+	}
+
+	records, err := lookupSPF(c.resolver, domain)
+	switch {
+	case err == errTemp:
+		return ResultTempError, "", err
+	case err != nil:
+		return ResultNone, "", err
+	case len(records) == 0:
+		return ResultNone, "", errors.New("no SPF record for " + domain)
+	case len(records) > 1:
+		return ResultPermError, "", errors.New("multiple SPF records for " + domain)
+	}
+
+	record, err := Parse(records[0])
+	if err != nil {
+		return ResultPermError, "", err
+	}
+
+	ctx := c.ctx
+	ctx.domain = domain
+
+	for _, m := range record.Mechanisms {
+		match, err := c.match(m, &ctx)
+		if err != nil {
+			if err == errTemp {
+				return ResultTempError, m.String(), err
+			}
+			return ResultPermError, m.String(), err
+		}
+		if !match {
+			continue
+		}
+		result := qualifierResult(m.Qualifier)
+		// exp= of an included record is ignored, only the record that
+		// decides the result explains it (RFC 7208 section 6.2).
+		if result == ResultFail && record.Exp != "" && c.includes == 0 && c.explanation == "" {
+			c.explanation = c.explain(record.Exp, &ctx)
+		}
+		return result, m.String(), nil
+	}
+
+	if record.Redirect != "" {
+		if err := c.countLookup(); err != nil {
+			return ResultPermError, "redirect=" + record.Redirect, err
+		}
+		target, err := c.targetName(record.Redirect, &ctx)
+		if err != nil {
+			return ResultPermError, "redirect=" + record.Redirect, err
+		}
+		result, mechanism, err := c.checkHost(target)
+		if result == ResultNone {
+			return ResultPermError, "redirect=" + record.Redirect, errors.New("redirect target " + target + " has no SPF record")
+		}
+		return result, mechanism, err
+	}
+
+	return ResultNeutral, "", nil
+}
+
+func (c *checker) match(m *Mechanism, ctx *macroContext) (bool, error) {
+	ip := c.ctx.ip
+	switch m.Name {
+	case "all":
+		return true, nil
+
+	case "ip4", "ip6":
+		network := net.ParseIP(m.Value)
+		if m.Name == "ip4" {
+			return matchIP(ip, network, m.CIDR4, 32), nil
+		}
+		return matchIP(ip, network, m.CIDR6, 128), nil
+
+	case "include":
+		if err := c.countLookup(); err != nil {
+			return false, err
+		}
+		target, err := c.targetName(m.Value, ctx)
+		if err != nil {
+			return false, err
+		}
+		c.includes++
+		result, _, err := c.checkHost(target)
+		c.includes--
+		switch result {
+		case ResultPass:
+			return true, nil
+		case ResultFail, ResultSoftFail, ResultNeutral:
+			return false, nil
+		case ResultTempError:
+			return false, errTemp
+		case ResultNone:
+			return false, errors.New("included domain " + target + " has no SPF record")
+		}
+		return false, err
+
+	case "a":
+		if err := c.countLookup(); err != nil {
+			return false, err
+		}
+		target, err := c.targetName(m.Value, ctx)
+		if err != nil {
+			return false, err
+		}
+		ips, err := lookupIP(c.resolver, target, ip.To4() == nil)
+		if err = c.checkVoid(err); err != nil {
+			return false, err
+		}
+		return matchAny(ip, ips, m), nil
+
+	case "mx":
+		if err := c.countLookup(); err != nil {
+			return false, err
+		}
+		target, err := c.targetName(m.Value, ctx)
+		if err != nil {
+			return false, err
+		}
+		hosts, err := lookupMX(c.resolver, target)
+		if err = c.checkVoid(err); err != nil {
+			return false, err
+		}
+		if len(hosts) > MaxLookups {
+			return false, errors.New("too many MX records for " + target)
+		}
+		for _, host := range hosts {
+			ips, err := lookupIP(c.resolver, host, ip.To4() == nil)
+			if err == errTemp {
+				return false, err
+			}
+			if matchAny(ip, ips, m) {
+				return true, nil
+			}
+		}
+		return false, nil
+
+	case "ptr":
+		if err := c.countLookup(); err != nil {
+			return false, err
+		}
+		target, err := c.targetName(m.Value, ctx)
+		if err != nil {
+			return false, err
+		}
+		names, err := lookupPTR(c.resolver, ip)
+		if err == errTemp {
+			return false, nil
+		}
+		if err = c.checkVoid(err); err != nil {
+			return false, err
+		}
+		if len(names) > MaxLookups {
+			names = names[:MaxLookups]
+		}
+		for _, name := range names {
+			name = strings.TrimSuffix(name, ".")
+			if !strings.EqualFold(name, target) && !strings.HasSuffix(strings.ToLower(name), "."+strings.ToLower(target)) {
+				continue
+			}
+			ips, err := lookupIP(c.resolver, name, ip.To4() == nil)
+			if err != nil {
+				continue
+			}
+			for _, addr := range ips {
+				if addr.Equal(ip) {
+					return true, nil
+				}
+			}
+		}
+		return false, nil
+
+	case "exists":
+		if err := c.countLookup(); err != nil {
+			return false, err
+		}
+		target, err := c.targetName(m.Value, ctx)
+		if err != nil {
+			return false, err
+		}
+		ips, err := lookupIP(c.resolver, target, false)
+		if err = c.checkVoid(err); err != nil {
+			return false, err
+		}
+		return len(ips) > 0, nil
+	}
+
+	return false, errors.New("unknown mechanism: " + m.Name)
+}
+
+func (c *checker) countLookup() error {
+	c.lookups++
+	if c.lookups > MaxLookups {
+		return errors.New("more than 10 DNS lookups")
+	}
+	return nil
+}
+
+// checkVoid counts lookups that returned no records or NXDOMAIN and turns
+// them into an empty answer until the void lookup limit is exceeded.
+func (c *checker) checkVoid(err error) error {
+	if err != errVoid {
+		return err
+	}
+	c.voidLookups++
+	if c.voidLookups > MaxVoidLookups {
+		return errors.New("more than 2 void lookups")
+	}
+	return nil
+}
+
+func (c *checker) targetName(spec string, ctx *macroContext) (string, error) {
+	if spec == "" {
+		return ctx.domain, nil
+	}
+	target, err := expandMacros(spec, ctx, false)
+	if err != nil {
+		return "", err
+	}
+	return truncateDomain(target), nil
+}
+
+// explain fetches and expands the exp= explanation, errors give no
+// explanation.
+func (c *checker) explain(spec string, ctx *macroContext) string {
+	target, err := c.targetName(spec, ctx)
+	if err != nil {
+		return ""
+	}
+	txt, err := lookupTXT(c.resolver, target)
+	if err != nil || len(txt) != 1 {
+		return ""
+	}
+	explanation, err := expandMacros(txt[0], ctx, true)
+	if err != nil {
+		return ""
+	}
+	return explanation
+}
+
+func qualifierResult(qualifier string) string {
+	switch qualifier {
+	case "-":
+		return ResultFail
+	case "~":
+		return ResultSoftFail
+	case "?":
+		return ResultNeutral
+	}
+	return ResultPass
+}
+
+func matchAny(ip net.IP, ips []net.IP, m *Mechanism) bool {
+	for _, addr := range ips {
+		if matchIP(ip, addr, m.CIDR4, 32) || matchIP(ip, addr, m.CIDR6, 128) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchIP reports whether ip is in network/ones, bits selects the family.
+func matchIP(ip net.IP, network net.IP, ones int, bits int) bool {
+	if network == nil {
+		return false
+	}
+	if bits == 32 {
+		ip, network = ip.To4(), network.To4()
+		if ip == nil || network == nil {
+			return false
+		}
+	} else if ip.To4() != nil || network.To4() != nil {
+		return false
+	}
+	ipnet := net.IPNet{IP: network.Mask(net.CIDRMask(ones, bits)), Mask: net.CIDRMask(ones, bits)}
+	return ipnet.Contains(ip)
+}
+
+/*
+ * DNS lookups
+ */
+
+func query(resolver dnsresolver.Resolver, name string, qtype uint16) ([]dns.RR, error) {
+	m := new(dns.Msg)
+	m.SetQuestion(dns.Fqdn(name), qtype)
+	m.MsgHdr.RecursionDesired = true
+	in, err := resolver.Exchange(m)
+	if err != nil {
+		return nil, errTemp
+	}
+	switch in.MsgHdr.Rcode {
+	case dns.RcodeSuccess:
+	case dns.RcodeNameError:
+		return nil, errVoid
+	default:
+		return nil, errTemp
+	}
+	var answer []dns.RR
+	for _, rr := range in.Answer {
+		if rr.Header().Rrtype == qtype {
+			answer = append(answer, rr)
+		}
+	}
+	if len(answer) == 0 {
+		return nil, errVoid
+	}
+	return answer, nil
+}
+
+// lookupTXT returns the TXT records of name, void lookups give no error.
+func lookupTXT(resolver dnsresolver.Resolver, name string) ([]string, error) {
+	rrs, err := query(resolver, name, dns.TypeTXT)
+	if err == errVoid {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var txt []string
+	for _, rr := range rrs {
+		// SPF records zijn langer en kunnen dus in meerdere delen teruggegeven worden.
+		txt = append(txt, strings.Join(rr.(*dns.TXT).Txt, ""))
+	}
+	return txt, nil
+}
+
+func lookupSPF(resolver dnsresolver.Resolver, name string) ([]string, error) {
+	txt, err := lookupTXT(resolver, name)
+	if err != nil {
+		return nil, err
+	}
+	var records []string
+	for _, record := range txt {
+		if IsSPF(record) {
+			records = append(records, record)
+		}
+	}
+	return records, nil
+}
+
+func lookupIP(resolver dnsresolver.Resolver, name string, ipv6 bool) ([]net.IP, error) {
+	qtype := dns.TypeA
+	if ipv6 {
+		qtype = dns.TypeAAAA
+	}
+	rrs, err := query(resolver, name, qtype)
+	if err != nil {
+		return nil, err
+	}
+	var ips []net.IP
+	for _, rr := range rrs {
+		switch a := rr.(type) {
+		case *dns.A:
+			ips = append(ips, a.A)
+		case *dns.AAAA:
+			ips = append(ips, a.AAAA)
+		}
+	}
+	return ips, nil
+}
+
+func lookupMX(resolver dnsresolver.Resolver, name string) ([]string, error) {
+	rrs, err := query(resolver, name, dns.TypeMX)
+	if err != nil {
+		return nil, err
+	}
+	var hosts []string
+	for _, rr := range rrs {
+		hosts = append(hosts, rr.(*dns.MX).Mx)
+	}
+	return hosts, nil
+}
+
+func lookupPTR(resolver dnsresolver.Resolver, ip net.IP) ([]string, error) {
+	name, err := dns.ReverseAddr(ip.String())
+	if err != nil {
+		return nil, err
+	}
+	rrs, err := query(resolver, name, dns.TypePTR)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, rr := range rrs {
+		names = append(names, rr.(*dns.PTR).Ptr)
+	}
+	return names, nil
+}
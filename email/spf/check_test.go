@@ -0,0 +1,114 @@
+package emailspf
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	dnsresolver "github.com/binaryfigments/goharvest/dns/resolver"
+)
+
+func TestCheckHost(t *testing.T) {
+	z := dnsresolver.MustZone(
+		`example.com. 60 IN TXT "v=spf1 ip4:192.0.2.0/24 include:_spf.example.net mx -all"`,
+		`example.com. 60 IN TXT "unrelated"`,
+		`example.com. 60 IN MX 10 mail.example.com.`,
+		`mail.example.com. 60 IN A 198.51.100.7`,
+		`_spf.example.net. 60 IN TXT "v=spf1 ip6:2001:db8::/32 exists:%{ir}.%{v}._spf.%{d} ~all"`,
+		`9.9.0.203.in-addr._spf._spf.example.net. 60 IN A 127.0.0.2`,
+		`redir.example. 60 IN TXT "v=spf1 redirect=example.com"`,
+		`redir-all.example. 60 IN TXT "v=spf1 ?all redirect=example.com"`,
+		`redir-none.example. 60 IN TXT "v=spf1 redirect=none.example"`,
+		`bad.example. 60 IN TXT "v=spf1 foo:bar -all"`,
+		`two.example. 60 IN TXT "v=spf1 -all"`,
+		`two.example. 60 IN TXT "v=spf1 +all"`,
+		`void.example. 60 IN TXT "v=spf1 a:v1.example a:v2.example a:v3.example -all"`,
+	)
+
+	tests := []struct {
+		ip     string
+		domain string
+		want   string
+	}{
+		{"192.0.2.5", "example.com", ResultPass},
+		{"198.51.100.7", "example.com", ResultPass},
+		{"2001:db8::1", "example.com", ResultPass},
+		{"203.0.9.9", "example.com", ResultPass},
+		{"203.0.113.1", "example.com", ResultFail},
+		{"203.0.113.1", "redir.example", ResultFail},
+		{"192.0.2.1", "redir.example", ResultPass},
+		{"192.0.2.1", "redir-all.example", ResultNeutral},
+		{"192.0.2.1", "redir-none.example", ResultPermError},
+		{"192.0.2.1", "bad.example", ResultPermError},
+		{"192.0.2.1", "two.example", ResultPermError},
+		{"192.0.2.1", "none.example", ResultNone},
+		{"192.0.2.1", "void.example", ResultPermError},
+	}
+	for _, tt := range tests {
+		r := CheckHostWithResolver(net.ParseIP(tt.ip), tt.domain, "user@"+tt.domain, z)
+		if r.Result != tt.want {
+			t.Errorf("%s from %s: got %s (%s), want %s", tt.domain, tt.ip, r.Result, r.ErrorMessage, tt.want)
+		}
+	}
+}
+
+func TestCheckHostExplanation(t *testing.T) {
+	z := dnsresolver.MustZone(
+		`top.example. 60 IN TXT "v=spf1 -all exp=why.top.example"`,
+		`why.top.example. 60 IN TXT "%{i} is not allowed for %{d}"`,
+		`inc.example. 60 IN TXT "v=spf1 include:inner.example -all"`,
+		`inner.example. 60 IN TXT "v=spf1 -all exp=why.inner.example"`,
+		`why.inner.example. 60 IN TXT "explained by the include"`,
+		`redir.example. 60 IN TXT "v=spf1 redirect=target.example"`,
+		`target.example. 60 IN TXT "v=spf1 -all exp=why.target.example"`,
+		`why.target.example. 60 IN TXT "explained by the redirect"`,
+	)
+
+	tests := []struct {
+		domain string
+		want   string
+	}{
+		{"top.example", "192.0.2.1 is not allowed for top.example"},
+		{"inc.example", ""},
+		{"redir.example", "explained by the redirect"},
+	}
+	for _, tt := range tests {
+		r := CheckHostWithResolver(net.ParseIP("192.0.2.1"), tt.domain, "user@"+tt.domain, z)
+		if r.Result != ResultFail {
+			t.Errorf("%s: got %s (%s), want fail", tt.domain, r.Result, r.ErrorMessage)
+		}
+		if r.Explanation != tt.want {
+			t.Errorf("%s: got explanation %q, want %q", tt.domain, r.Explanation, tt.want)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		record string
+		want   string
+		err    bool
+	}{
+		{"v=spf1 -all", "-all", false},
+		{"v=spf1 a/24//64 mx:mail.example.com/28 -ip4:10.0.0.1 ?include:x.example", "a/24//64 mx:mail.example.com/28 -ip4:10.0.0.1 ?include:x.example", false},
+		{"v=spf1 foo:bar", "", true},
+		{"v=spf2 -all", "", true},
+	}
+	for _, tt := range tests {
+		record, err := Parse(tt.record)
+		if (err != nil) != tt.err {
+			t.Errorf("%q: got error %v, want error %v", tt.record, err, tt.err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		var terms []string
+		for _, m := range record.Mechanisms {
+			terms = append(terms, m.String())
+		}
+		if got := strings.Join(terms, " "); got != tt.want {
+			t.Errorf("%q: got %q, want %q", tt.record, got, tt.want)
+		}
+	}
+}
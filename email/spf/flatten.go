@@ -0,0 +1,507 @@
+package emailspf
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	dnsresolver "github.com/binaryfigments/goharvest/dns/resolver"
+	"github.com/miekg/dns"
+)
+
+// Flattened struct with an SPF record rewritten to ip4:/ip6: mechanisms
+type Flattened struct {
+	Domain       string        `json:"domain,omitempty"`
+	CheckTime    time.Time     `json:"time"`
+	Records      []*FlatRecord `json:"records,omitempty"`
+	Lookups      int           `json:"lookups"`
+	Warnings     []string      `json:"warnings,omitempty"`
+	Error        string        `json:"error,omitempty"`
+	ErrorMessage string        `json:"errormessage,omitempty"`
+}
+
+// FlatRecord struct for one TXT record of the flattened chain
+type FlatRecord struct {
+	Name    string   `json:"name"`
+	Value   string   `json:"value"`
+	Strings []string `json:"strings"`
+	Size    int      `json:"size"`
+}
+
+// Size limits for a flattened record: TXT strings are at most 255 bytes
+// and the whole response has to fit in a classic 512 byte UDP message.
+const (
+	MaxStringLength = 255
+	MaxResponseSize = 512
+)
+
+// GetFlatten audits the SPF record of domain and flattens it.
+func GetFlatten(domain string, nameserver string) *Flattened {
+	return GetFlattenWithResolver(domain, dnsresolver.Parse(nameserver))
+}
+
+// GetFlattenWithResolver is GetFlatten with a custom resolver.
+func GetFlattenWithResolver(domain string, resolver dnsresolver.Resolver) *Flattened {
+	return Flatten(GetAuditWithResolver(domain, resolver), resolver)
+}
+
+// Flatten rewrites the expanded tree of an audit into a chain of TXT
+// records. The record at the domain itself keeps the terms in their
+// original order, with the runs of pass terms that do not fit replaced by
+// an include of _spfN.<domain>, which includes _spfN+1.<domain> and so on.
+// a and mx mechanisms are resolved, so the result has to be regenerated
+// when those addresses change.
+func Flatten(audit *Audit, resolver dnsresolver.Resolver) *Flattened {
+	r := new(Flattened)
+	r.Domain = strings.TrimSuffix(audit.Domain, ".")
+	r.CheckTime = time.Now()
+
+	if audit.Tree == nil || audit.Tree.Parsed == nil || (audit.Result != ResultOK && audit.Lookups <= MaxLookups && audit.VoidLookups <= MaxVoidLookups) {
+		r.Error = "Failed"
+		r.ErrorMessage = "SPF record can not be flattened: " + audit.Reason
+		return r
+	}
+
+	f := &flattener{resolver: resolver, flat: r, seen: make(map[string]bool)}
+	if err := f.node(audit.Tree, "+", true); err != nil {
+		r.Error = "Failed"
+		r.ErrorMessage = err.Error()
+		return r
+	}
+
+	if err := f.pack(); err != nil {
+		r.Error = "Failed"
+		r.ErrorMessage = err.Error()
+		return r
+	}
+	return r
+}
+
+/*
+ * Used functions
+ */
+
+type flatTerm struct {
+	text    string
+	movable bool
+}
+
+type flattener struct {
+	resolver dnsresolver.Resolver
+	flat     *Flattened
+	terms    []flatTerm
+	all      string
+	exp      string
+	seen     map[string]bool
+}
+
+func (f *flattener) warn(warning string) {
+	f.flat.Warnings = append(f.flat.Warnings, warning)
+}
+
+// add appends a term once, only pass terms can be moved into the chain.
+func (f *flattener) add(text string, movable bool) {
+	if f.seen[text] {
+		return
+	}
+	f.seen[text] = true
+	f.terms = append(f.terms, flatTerm{text: text, movable: movable && !strings.ContainsAny(text[:1], "-~?")})
+}
+
+// node flattens the record of n. The top record keeps its own qualifiers,
+// included records only contribute their pass terms with the qualifier of
+// the include.
+func (f *flattener) node(n *Node, qualifier string, top bool) error {
+	if n.Parsed == nil {
+		return errors.New("can not flatten " + n.Domain + ": " + n.ErrorMessage)
+	}
+	record := n.Parsed
+	ctx := &macroContext{domain: n.Domain}
+	child := 0
+
+	q := func(m *Mechanism) (string, bool) {
+		if top {
+			return m.Qualifier, true
+		}
+		return qualifier, m.Qualifier == "+"
+	}
+	prefix := func(q string) string {
+		if q == "+" {
+			return ""
+		}
+		return q
+	}
+
+	hasAll := false
+	for _, m := range record.Mechanisms {
+		mq, ok := q(m)
+		switch m.Name {
+		case "all":
+			hasAll = true
+			if top {
+				f.all = prefix(mq) + "all"
+			} else if ok {
+				f.add(prefix(mq)+"all", false)
+				f.warn(n.Domain + ": +all in an included record matches everything")
+			}
+		case "ip4", "ip6":
+			if ok {
+				f.add(prefix(mq)+strings.TrimLeft(m.String(), "+-~?"), true)
+			}
+		case "a", "mx":
+			if !ok {
+				continue
+			}
+			if hasMacro(m.Value) {
+				f.keep(n, m, mq, top)
+				continue
+			}
+			target, _ := expandMacros(m.Value, ctx, false)
+			if target == "" {
+				target = n.Domain
+			}
+			ips, err := f.resolve(m.Name, target)
+			if err != nil {
+				return errors.New(n.Domain + ": " + m.String() + ": " + err.Error())
+			}
+			for _, ip := range ips {
+				if ip.To4() != nil {
+					f.add(prefix(mq)+"ip4:"+ip.String()+cidrSuffix(m.CIDR4, 32), true)
+				} else {
+					f.add(prefix(mq)+"ip6:"+ip.String()+cidrSuffix(m.CIDR6, 128), true)
+				}
+			}
+		case "include":
+			if hasMacro(m.Value) {
+				if ok {
+					f.keep(n, m, mq, top)
+				}
+				continue
+			}
+			c := n.Children[child]
+			child++
+			if !ok {
+				continue
+			}
+			if term := blocking(c); term != "" {
+				text := prefix(mq) + "include:" + m.Value
+				f.warn(c.Domain + ": " + term + " comes before a pass term, " + text + " is kept")
+				f.add(text, false)
+				continue
+			}
+			if err := f.node(c, mq, false); err != nil {
+				return err
+			}
+		default: // exists, ptr
+			if ok {
+				f.keep(n, m, mq, top)
+			}
+		}
+	}
+
+	if record.Redirect != "" && !hasMacro(record.Redirect) && child < len(n.Children) {
+		if !hasAll {
+			if err := f.node(n.Children[child], qualifier, top); err != nil {
+				return err
+			}
+		}
+	} else if record.Redirect != "" && !hasAll {
+		if top {
+			f.warn(n.Domain + ": redirect=" + record.Redirect + " uses macros and is kept")
+			f.add("redirect="+record.Redirect, false)
+		} else {
+			// An included record passes when its redirect target passes,
+			// which is what an include of the target does.
+			m := &Mechanism{Qualifier: qualifier, Name: "include", Value: record.Redirect}
+			f.warn(n.Domain + ": redirect=" + record.Redirect + " uses macros and is kept as " + m.String())
+			if strings.Contains(m.Value, "%{d}") || strings.Contains(m.Value, "%{D}") {
+				f.warn(n.Domain + ": " + m.String() + " depends on the current domain, its meaning changes")
+			}
+			f.add(m.String(), false)
+		}
+	}
+
+	if top && record.Exp != "" && f.exp == "" {
+		f.exp = "exp=" + record.Exp
+	}
+	return nil
+}
+
+// blocking returns the first term of the record of n, following its
+// includes and redirect, that does not pass but comes before a term that
+// does. Only the pass terms of an included record are flattened, so an
+// address such a term excludes would pass.
+func blocking(n *Node) string {
+	first := ""
+	for _, t := range evalOrder(n) {
+		if !t.pass && first == "" {
+			first = t.text
+		}
+		if t.pass && first != "" {
+			return first
+		}
+	}
+	return ""
+}
+
+type evalTerm struct {
+	text string
+	pass bool
+}
+
+// evalOrder lists the terms of the record of n in the order check_host()
+// evaluates them, with the terms of a redirect target after the record.
+func evalOrder(n *Node) []evalTerm {
+	if n.Parsed == nil {
+		return nil
+	}
+	var terms []evalTerm
+	child := 0
+	for _, m := range n.Parsed.Mechanisms {
+		if m.Name == "include" && !hasMacro(m.Value) {
+			child++
+		}
+		terms = append(terms, evalTerm{text: m.String(), pass: m.Qualifier == "+"})
+		if m.Name == "all" {
+			return terms
+		}
+	}
+	if r := n.Parsed.Redirect; r != "" {
+		if !hasMacro(r) && child < len(n.Children) {
+			return append(terms, evalOrder(n.Children[child])...)
+		}
+		terms = append(terms, evalTerm{text: "redirect=" + r, pass: true})
+	}
+	return terms
+}
+
+// keep copies a term that can not be flattened into the top record.
+func (f *flattener) keep(n *Node, m *Mechanism, q string, top bool) {
+	text := strings.TrimLeft(m.String(), "+-~?")
+	if q != "+" {
+		text = q + text
+	}
+	if !top && (m.Value == "" || strings.Contains(m.Value, "%{d}") || strings.Contains(m.Value, "%{D}")) {
+		f.warn(n.Domain + ": " + m.String() + " depends on the current domain, kept as is but its meaning changes")
+	} else {
+		f.warn(n.Domain + ": " + m.String() + " can not be flattened and is kept")
+	}
+	f.add(text, false)
+}
+
+func (f *flattener) resolve(name string, target string) ([]net.IP, error) {
+	hosts := []string{target}
+	if name == "mx" {
+		var err error
+		hosts, err = lookupMX(f.resolver, target)
+		if err != nil && err != errVoid {
+			return nil, err
+		}
+	}
+	var ips []net.IP
+	for _, host := range hosts {
+		for _, ipv6 := range []bool{false, true} {
+			addrs, err := lookupIP(f.resolver, host, ipv6)
+			if err != nil && err != errVoid {
+				return nil, err
+			}
+			ips = append(ips, addrs...)
+		}
+	}
+	if len(ips) == 0 {
+		f.warn(name + ":" + target + " has no addresses and is left out")
+	}
+	return ips, nil
+}
+
+func cidrSuffix(ones int, bits int) string {
+	if ones == bits {
+		return ""
+	}
+	return "/" + strconv.Itoa(ones)
+}
+
+// termRun is a run of consecutive pass terms, terms[start:end].
+type termRun struct {
+	start, end int
+	size       int
+	moved      bool
+}
+
+// pack distributes the terms over the top record and the include chains.
+// check_host() takes the first term that matches, so the order is kept: a
+// run of pass terms that does not fit is replaced by an include of a chain
+// with the run, which passes exactly where one of its terms would.
+func (f *flattener) pack() error {
+	domain := f.flat.Domain
+	var tail []string
+	if f.all != "" {
+		tail = append(tail, f.all)
+	}
+	if f.exp != "" {
+		tail = append(tail, f.exp)
+	}
+
+	var runs []*termRun
+	for i, t := range f.terms {
+		if !t.movable {
+			continue
+		}
+		if n := len(runs); n > 0 && runs[n-1].end == i {
+			runs[n-1].end++
+			runs[n-1].size += len(t.text) + 1
+			continue
+		}
+		runs = append(runs, &termRun{start: i, end: i + 1, size: len(t.text) + 1})
+	}
+
+	// Move the largest runs until the top record fits.
+	for {
+		top, chains, err := f.layout(runs, tail)
+		if err != nil {
+			return err
+		}
+		if fits(domain, spfValue(top)) {
+			f.flat.Records = append(f.flat.Records, newFlatRecord(domain, spfValue(top)))
+			f.flat.Records = append(f.flat.Records, chains...)
+			break
+		}
+		var largest *termRun
+		for _, r := range runs {
+			if !r.moved && (largest == nil || r.size >= largest.size) {
+				largest = r
+			}
+		}
+		if largest == nil {
+			return errors.New("terms that can not be flattened do not fit in one record")
+		}
+		largest.moved = true
+	}
+
+	for _, record := range f.flat.Records {
+		parsed, err := Parse(record.Value)
+		if err != nil {
+			return errors.New(record.Name + ": " + err.Error())
+		}
+		for _, m := range parsed.Mechanisms {
+			switch m.Name {
+			case "include", "a", "mx", "ptr", "exists":
+				f.flat.Lookups++
+			}
+		}
+		if parsed.Redirect != "" {
+			f.flat.Lookups++
+		}
+	}
+	if f.flat.Lookups > MaxLookups {
+		f.warn("flattened record still needs more than 10 DNS lookups")
+	}
+	return nil
+}
+
+// layout returns the terms of the top record, with every moved run
+// replaced by an include of its chain, and the records of the chains.
+func (f *flattener) layout(runs []*termRun, tail []string) ([]string, []*FlatRecord, error) {
+	moved := make(map[int]*termRun)
+	for _, r := range runs {
+		if r.moved {
+			moved[r.start] = r
+		}
+	}
+
+	var top []string
+	var chains []*FlatRecord
+	for i := 0; i < len(f.terms); {
+		r := moved[i]
+		if r == nil {
+			top = append(top, f.terms[i].text)
+			i++
+			continue
+		}
+		var rest []string
+		for _, t := range f.terms[r.start:r.end] {
+			rest = append(rest, t.text)
+		}
+		records, err := chain(f.flat.Domain, len(chains)+1, rest)
+		if err != nil {
+			return nil, nil, err
+		}
+		top = append(top, "include:"+records[0].Name)
+		chains = append(chains, records...)
+		i = r.end
+	}
+	return append(top, tail...), chains, nil
+}
+
+// chain packs terms in records _spfN.<domain> from N = first on, every
+// record includes the next one.
+func chain(domain string, first int, rest []string) ([]*FlatRecord, error) {
+	chainName := func(i int) string {
+		return "_spf" + strconv.Itoa(i) + "." + domain
+	}
+	var records []*FlatRecord
+	for i := first; len(rest) > 0; i++ {
+		name := chainName(i)
+		var terms []string
+		for len(rest) > 0 {
+			next := append(append([]string{}, terms...), rest[0])
+			if len(rest) > 1 {
+				next = append(next, "include:"+chainName(i+1))
+			}
+			if !fits(name, spfValue(next)) {
+				break
+			}
+			terms = append(terms, rest[0])
+			rest = rest[1:]
+		}
+		if len(terms) == 0 {
+			return nil, errors.New("term does not fit in a record: " + rest[0])
+		}
+		if len(rest) > 0 {
+			terms = append(terms, "include:"+chainName(i+1))
+		}
+		records = append(records, newFlatRecord(name, spfValue(terms)))
+	}
+	return records, nil
+}
+
+func spfValue(terms []string) string {
+	return strings.Join(append([]string{"v=spf1"}, terms...), " ")
+}
+
+// txtStrings splits value in strings of at most MaxStringLength bytes.
+func txtStrings(value string) []string {
+	var s []string
+	for len(value) > MaxStringLength {
+		s = append(s, value[:MaxStringLength])
+		value = value[MaxStringLength:]
+	}
+	return append(s, value)
+}
+
+// responseSize is the size of an uncompressed response with the record.
+func responseSize(name string, value string) int {
+	m := new(dns.Msg)
+	m.SetQuestion(dns.Fqdn(name), dns.TypeTXT)
+	m.Response = true
+	m.Answer = append(m.Answer, &dns.TXT{
+		Hdr: dns.RR_Header{Name: dns.Fqdn(name), Rrtype: dns.TypeTXT, Class: dns.ClassINET, Ttl: 3600},
+		Txt: txtStrings(value),
+	})
+	return m.Len()
+}
+
+func fits(name string, value string) bool {
+	return responseSize(name, value) <= MaxResponseSize
+}
+
+func newFlatRecord(name string, value string) *FlatRecord {
+	return &FlatRecord{
+		Name:    name,
+		Value:   value,
+		Strings: txtStrings(value),
+		Size:    responseSize(name, value),
+	}
+}
@@ -0,0 +1,149 @@
+package emailspf
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+
+	dnsresolver "github.com/binaryfigments/goharvest/dns/resolver"
+	"github.com/miekg/dns"
+)
+
+func TestFlatten(t *testing.T) {
+	z := dnsresolver.MustZone(
+		`plain.example. 60 IN TXT "v=spf1 ip4:192.0.2.1 include:a.example mx ~all"`,
+		`plain.example. 60 IN MX 10 mx.plain.example.`,
+		`mx.plain.example. 60 IN A 192.0.2.2`,
+		`mx.plain.example. 60 IN AAAA 2001:db8::25`,
+		`a.example. 60 IN TXT "v=spf1 -ip4:10.0.0.1 ip6:2001:db8:1::/48 redirect=c.example"`,
+		`c.example. 60 IN TXT "v=spf1 ip4:198.51.100.0/24 -all"`,
+		`b.example. 60 IN TXT "v=spf1 include:pass.example ip4:192.0.2.3"`,
+		`pass.example. 60 IN TXT "v=spf1 ip6:2001:db8:1::/48 redirect=c.example"`,
+		`order.example. 60 IN TXT "v=spf1 ip4:10.0.0.0/8 -ip4:10.1.1.1 ~all"`,
+		`macro.example. 60 IN TXT "v=spf1 include:m.example -all"`,
+		`m.example. 60 IN TXT "v=spf1 ip4:192.0.2.9 redirect=%{l}.r.example"`,
+		`top-macro.example. 60 IN TXT "v=spf1 ip4:192.0.2.9 redirect=%{l}.r.example"`,
+	)
+
+	tests := []struct {
+		domain  string
+		want    string
+		warning string
+	}{
+		{
+			domain:  "plain.example",
+			want:    "v=spf1 ip4:192.0.2.1 include:a.example ip4:192.0.2.2 ip6:2001:db8::25 ~all",
+			warning: "a.example: -ip4:10.0.0.1 comes before a pass term, include:a.example is kept",
+		},
+		{
+			domain: "b.example",
+			want:   "v=spf1 ip6:2001:db8:1::/48 ip4:198.51.100.0/24 ip4:192.0.2.3",
+		},
+		{
+			domain: "order.example",
+			want:   "v=spf1 ip4:10.0.0.0/8 -ip4:10.1.1.1 ~all",
+		},
+		{
+			domain:  "macro.example",
+			want:    "v=spf1 ip4:192.0.2.9 include:%{l}.r.example -all",
+			warning: "m.example: redirect=%{l}.r.example uses macros and is kept as include:%{l}.r.example",
+		},
+		{
+			domain:  "top-macro.example",
+			want:    "v=spf1 ip4:192.0.2.9 redirect=%{l}.r.example",
+			warning: "top-macro.example: redirect=%{l}.r.example uses macros and is kept",
+		},
+	}
+	for _, tt := range tests {
+		f := GetFlattenWithResolver(tt.domain, z)
+		if f.Error != "" {
+			t.Errorf("%s: %s", tt.domain, f.ErrorMessage)
+			continue
+		}
+		if len(f.Records) != 1 || f.Records[0].Value != tt.want {
+			t.Errorf("%s: got %+v, want %q", tt.domain, f.Records, tt.want)
+		}
+		if tt.warning != "" && !strings.Contains(strings.Join(f.Warnings, "\n"), tt.warning) {
+			t.Errorf("%s: got warnings %q, want %q", tt.domain, f.Warnings, tt.warning)
+		}
+	}
+}
+
+// flatZone returns z with the SPF record of domain replaced by the
+// flattened records.
+func flatZone(z *dnsresolver.Zone, domain string, records []*FlatRecord) *dnsresolver.Zone {
+	flat := new(dnsresolver.Zone)
+	for _, rr := range z.Records {
+		if rr.Header().Rrtype != dns.TypeTXT || rr.Header().Name != dns.Fqdn(domain) {
+			flat.Records = append(flat.Records, rr)
+		}
+	}
+	for _, r := range records {
+		flat.Records = append(flat.Records, &dns.TXT{
+			Hdr: dns.RR_Header{Name: dns.Fqdn(r.Name), Rrtype: dns.TypeTXT, Class: dns.ClassINET, Ttl: 60},
+			Txt: r.Strings,
+		})
+	}
+	return flat
+}
+
+func TestFlattenKeepsResults(t *testing.T) {
+	var big []string
+	for i := 1; i <= 60; i++ {
+		big = append(big, "ip4:203.0.113."+strconv.Itoa(i))
+	}
+	z := dnsresolver.MustZone(
+		`order.example. 60 IN TXT "v=spf1 ip4:10.0.0.0/8 -ip4:10.1.1.1 include:big.example -ip4:203.0.113.5 ip4:203.0.113.0/24 ~all"`,
+		`big.example. 60 IN TXT "v=spf1 include:big1.example include:big2.example -all"`,
+		`big1.example. 60 IN TXT "v=spf1 `+strings.Join(big[:30], " ")+`"`,
+		`big2.example. 60 IN TXT "v=spf1 `+strings.Join(big[30:], " ")+`"`,
+		`deny.example. 60 IN TXT "v=spf1 include:a.example ip4:192.0.2.1 -all"`,
+		`a.example. 60 IN TXT "v=spf1 -ip4:10.0.0.1 ip4:10.0.0.0/8 -all"`,
+	)
+
+	tests := []struct {
+		domain  string
+		records int
+		results map[string]string
+	}{
+		{
+			domain:  "order.example",
+			records: 4,
+			results: map[string]string{
+				"10.1.1.1":     "pass",
+				"10.2.2.2":     "pass",
+				"203.0.113.5":  "pass",
+				"203.0.113.99": "pass",
+				"192.0.2.1":    "softfail",
+			},
+		},
+		{
+			domain:  "deny.example",
+			records: 1,
+			results: map[string]string{
+				"10.0.0.1":  "fail",
+				"10.0.0.2":  "pass",
+				"192.0.2.1": "pass",
+			},
+		},
+	}
+	for _, tt := range tests {
+		f := GetFlattenWithResolver(tt.domain, z)
+		if f.Error != "" {
+			t.Errorf("%s: %s", tt.domain, f.ErrorMessage)
+			continue
+		}
+		if len(f.Records) != tt.records {
+			t.Errorf("%s: got %d records, want %d", tt.domain, len(f.Records), tt.records)
+		}
+		flat := flatZone(z, tt.domain, f.Records)
+		for ip, want := range tt.results {
+			before := CheckHostWithResolver(net.ParseIP(ip), tt.domain, "user@"+tt.domain, z)
+			after := CheckHostWithResolver(net.ParseIP(ip), tt.domain, "user@"+tt.domain, flat)
+			if before.Result != want || after.Result != want {
+				t.Errorf("%s %s: got %s before and %s after flattening, want %s", tt.domain, ip, before.Result, after.Result, want)
+			}
+		}
+	}
+}
@@ -0,0 +1,450 @@
+package emailspf
+
+import (
+	"errors"
+	"net"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Record struct for a parsed SPF record
+type Record struct {
+	Raw          string       `json:"raw,omitempty"`
+	Mechanisms   []*Mechanism `json:"mechanisms,omitempty"`
+	Modifiers    []*Modifier  `json:"modifiers,omitempty"`
+	Redirect     string       `json:"redirect,omitempty"`
+	Exp          string       `json:"exp,omitempty"`
+	ErrorMessage string       `json:"errormessage,omitempty"`
+}
+
+// Mechanism struct for a directive like "-ip4:192.0.2.0/24"
+type Mechanism struct {
+	Qualifier string `json:"qualifier"`
+	Name      string `json:"name"`
+	Value     string `json:"value,omitempty"`
+	CIDR4     int    `json:"cidr4,omitempty"`
+	CIDR6     int    `json:"cidr6,omitempty"`
+}
+
+// Modifier struct for a name=value term
+type Modifier struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// String returns the mechanism as it would appear in a record.
+func (m *Mechanism) String() string {
+	s := m.Name
+	if m.Qualifier != "+" {
+		s = m.Qualifier + s
+	}
+	if m.Value != "" {
+		s += ":" + m.Value
+	}
+	switch m.Name {
+	case "ip4":
+		if m.CIDR4 != 32 {
+			s += "/" + strconv.Itoa(m.CIDR4)
+		}
+	case "ip6":
+		if m.CIDR6 != 128 {
+			s += "/" + strconv.Itoa(m.CIDR6)
+		}
+	case "a", "mx":
+		if m.CIDR4 != 32 {
+			s += "/" + strconv.Itoa(m.CIDR4)
+		}
+		if m.CIDR6 != 128 {
+			s += "//" + strconv.Itoa(m.CIDR6)
+		}
+	}
+	return s
+}
+
+var (
+	dualCIDR     = regexp.MustCompile(`^(.*?)(?:/(\d+))?(?://(\d+))?$`)
+	modifierName = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9\-_.]*$`)
+)
+
+// IsSPF reports whether a TXT record is an SPF version 1 record.
+func IsSPF(txt string) bool {
+	if len(txt) < 6 || !strings.EqualFold(txt[:6], "v=spf1") {
+		return false
+	}
+	return len(txt) == 6 || txt[6] == ' '
+}
+
+// Parse parses an SPF record. On a syntax error the record parsed so far
+// is returned together with the error, which is also set in ErrorMessage.
+func Parse(record string) (*Record, error) {
+	r := new(Record)
+	r.Raw = record
+	err := r.parse()
+	if err != nil {
+		r.ErrorMessage = err.Error()
+	}
+	return r, err
+}
+
+func (r *Record) parse() error {
+	if !IsSPF(r.Raw) {
+		return errors.New("not an SPF record")
+	}
+
+	for _, term := range strings.Fields(r.Raw[6:]) {
+		if i := strings.IndexAny(term, "=:/"); i > 0 && term[i] == '=' {
+			name := strings.ToLower(term[:i])
+			value := term[i+1:]
+			if !modifierName.MatchString(term[:i]) {
+				return errors.New("invalid modifier name: " + term)
+			}
+			if err := checkMacroString(value); err != nil {
+				return errors.New("invalid modifier " + term + ": " + err.Error())
+			}
+			switch name {
+			case "redirect":
+				if r.Redirect != "" {
+					return errors.New("redirect modifier appears more than once")
+				}
+				if value == "" {
+					return errors.New("empty redirect modifier")
+				}
+				r.Redirect = value
+			case "exp":
+				if r.Exp != "" {
+					return errors.New("exp modifier appears more than once")
+				}
+				if value == "" {
+					return errors.New("empty exp modifier")
+				}
+				r.Exp = value
+			}
+			r.Modifiers = append(r.Modifiers, &Modifier{Name: name, Value: value})
+			continue
+		}
+
+		m, err := parseMechanism(term)
+		if err != nil {
+			return err
+		}
+		r.Mechanisms = append(r.Mechanisms, m)
+	}
+	return nil
+}
+
+func parseMechanism(term string) (*Mechanism, error) {
+	m := &Mechanism{Qualifier: "+", CIDR4: 32, CIDR6: 128}
+	if strings.ContainsAny(term[:1], "+-~?") {
+		m.Qualifier = term[:1]
+		term = term[1:]
+	}
+
+	name := term
+	var value string
+	hasValue := false
+	if i := strings.IndexAny(term, ":/"); i >= 0 {
+		name = term[:i]
+		value = term[i:]
+		if term[i] == ':' {
+			value = term[i+1:]
+			hasValue = true
+		}
+	}
+	m.Name = strings.ToLower(name)
+
+	switch m.Name {
+	case "all":
+		if value != "" || hasValue {
+			return nil, errors.New("invalid mechanism: " + term)
+		}
+	case "include", "exists":
+		if !hasValue || value == "" {
+			return nil, errors.New("missing domain in mechanism: " + term)
+		}
+		if err := checkMacroString(value); err != nil {
+			return nil, errors.New("invalid mechanism " + term + ": " + err.Error())
+		}
+		m.Value = value
+	case "ptr":
+		if strings.HasPrefix(value, "/") || (hasValue && value == "") {
+			return nil, errors.New("invalid mechanism: " + term)
+		}
+		if err := checkMacroString(value); err != nil {
+			return nil, errors.New("invalid mechanism " + term + ": " + err.Error())
+		}
+		m.Value = value
+	case "a", "mx":
+		parts := dualCIDR.FindStringSubmatch(value)
+		domain := parts[1]
+		if hasValue && domain == "" {
+			return nil, errors.New("missing domain in mechanism: " + term)
+		}
+		if !hasValue && domain != "" {
+			return nil, errors.New("invalid mechanism: " + term)
+		}
+		if err := checkMacroString(domain); err != nil {
+			return nil, errors.New("invalid mechanism " + term + ": " + err.Error())
+		}
+		m.Value = domain
+		var err error
+		if parts[2] != "" {
+			if m.CIDR4, err = parseCIDR(parts[2], 32); err != nil {
+				return nil, errors.New("invalid mechanism " + term + ": " + err.Error())
+			}
+		}
+		if parts[3] != "" {
+			if m.CIDR6, err = parseCIDR(parts[3], 128); err != nil {
+				return nil, errors.New("invalid mechanism " + term + ": " + err.Error())
+			}
+		}
+	case "ip4", "ip6":
+		if !hasValue || value == "" {
+			return nil, errors.New("missing network in mechanism: " + term)
+		}
+		network, length := value, ""
+		if i := strings.Index(value, "/"); i >= 0 {
+			network, length = value[:i], value[i+1:]
+		}
+		ip := net.ParseIP(network)
+		if ip == nil || (m.Name == "ip4") != (ip.To4() != nil && !strings.Contains(network, ":")) {
+			return nil, errors.New("invalid network in mechanism: " + term)
+		}
+		m.Value = network
+		if length != "" {
+			bits := 32
+			if m.Name == "ip6" {
+				bits = 128
+			}
+			n, err := parseCIDR(length, bits)
+			if err != nil {
+				return nil, errors.New("invalid mechanism " + term + ": " + err.Error())
+			}
+			if m.Name == "ip4" {
+				m.CIDR4 = n
+			} else {
+				m.CIDR6 = n
+			}
+		}
+	default:
+		return nil, errors.New("unknown mechanism: " + term)
+	}
+	return m, nil
+}
+
+func parseCIDR(s string, max int) (int, error) {
+	if len(s) > 1 && s[0] == '0' {
+		return 0, errors.New("leading zero in prefix length")
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 || n > max {
+		return 0, errors.New("invalid prefix length: " + s)
+	}
+	return n, nil
+}
+
+/*
+ * Macros (RFC 7208 section 7)
+ */
+
+// macroContext holds the values macros expand to.
+type macroContext struct {
+	sender string
+	domain string
+	ip     net.IP
+	helo   string
+}
+
+func checkMacroString(s string) error {
+	_, err := expandMacros(s, &macroContext{ip: net.IPv4zero}, true)
+	return err
+}
+
+// expandMacros expands the macros in s. The exp only letters c, r and t are
+// allowed when exp is true.
+func expandMacros(s string, ctx *macroContext, exp bool) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '%' {
+			b.WriteByte(s[i])
+			continue
+		}
+		if i+1 >= len(s) {
+			return "", errors.New("incomplete macro")
+		}
+		i++
+		switch s[i] {
+		case '%':
+			b.WriteByte('%')
+		case '_':
+			b.WriteByte(' ')
+		case '-':
+			b.WriteString("%20")
+		case '{':
+			end := strings.IndexByte(s[i:], '}')
+			if end < 0 {
+				return "", errors.New("unterminated macro")
+			}
+			value, err := expandMacro(s[i+1:i+end], ctx, exp)
+			if err != nil {
+				return "", err
+			}
+			b.WriteString(value)
+			i += end
+		default:
+			return "", errors.New("invalid macro: %" + string(s[i]))
+		}
+	}
+	return b.String(), nil
+}
+
+func expandMacro(macro string, ctx *macroContext, exp bool) (string, error) {
+	if macro == "" {
+		return "", errors.New("empty macro")
+	}
+	letter := macro[0]
+	escape := letter >= 'A' && letter <= 'Z'
+	if escape {
+		letter += 'a' - 'A'
+	}
+
+	local, senderDomain := splitSender(ctx.sender, ctx.domain)
+	var value string
+	switch letter {
+	case 's':
+		value = local + "@" + senderDomain
+	case 'l':
+		value = local
+	case 'o':
+		value = senderDomain
+	case 'd':
+		value = ctx.domain
+	case 'i':
+		value = macroIP(ctx.ip)
+	case 'p':
+		value = "unknown"
+	case 'v':
+		value = "in-addr"
+		if ctx.ip.To4() == nil {
+			value = "ip6"
+		}
+	case 'h':
+		value = ctx.helo
+		if value == "" {
+			value = ctx.domain
+		}
+	case 'c', 'r', 't':
+		if !exp {
+			return "", errors.New("macro %{" + macro + "} only allowed in explanations")
+		}
+		switch letter {
+		case 'c':
+			value = ctx.ip.String()
+		case 'r':
+			value = "unknown"
+		case 't':
+			value = strconv.FormatInt(time.Now().Unix(), 10)
+		}
+	default:
+		return "", errors.New("invalid macro letter in %{" + macro + "}")
+	}
+
+	// Transformers: an optional number of parts to keep and "r" to reverse,
+	// followed by the delimiters to split on.
+	rest := macro[1:]
+	n := 0
+	for n < len(rest) && rest[n] >= '0' && rest[n] <= '9' {
+		n++
+	}
+	keep := 0
+	if n > 0 {
+		var err error
+		keep, err = strconv.Atoi(rest[:n])
+		if err != nil || keep == 0 {
+			return "", errors.New("invalid transformer in %{" + macro + "}")
+		}
+	}
+	rest = rest[n:]
+	reverse := false
+	if rest != "" && (rest[0] == 'r' || rest[0] == 'R') {
+		reverse = true
+		rest = rest[1:]
+	}
+	delimiters := "."
+	if rest != "" {
+		if strings.Trim(rest, ".-+,/_=") != "" {
+			return "", errors.New("invalid delimiter in %{" + macro + "}")
+		}
+		delimiters = rest
+	}
+
+	if keep > 0 || reverse || delimiters != "." {
+		parts := strings.FieldsFunc(value, func(r rune) bool {
+			return strings.ContainsRune(delimiters, r)
+		})
+		if reverse {
+			for i, j := 0, len(parts)-1; i < j; i, j = i+1, j-1 {
+				parts[i], parts[j] = parts[j], parts[i]
+			}
+		}
+		if keep > 0 && keep < len(parts) {
+			parts = parts[len(parts)-keep:]
+		}
+		value = strings.Join(parts, ".")
+	}
+
+	if escape {
+		value = url.QueryEscape(value)
+		value = strings.Replace(value, "+", "%20", -1)
+	}
+	return value, nil
+}
+
+// splitSender returns the local-part and domain of sender, defaulting to
+// "postmaster" and domain as RFC 7208 section 4.3 describes.
+func splitSender(sender string, domain string) (string, string) {
+	i := strings.LastIndex(sender, "@")
+	switch {
+	case sender == "":
+		return "postmaster", domain
+	case i < 0:
+		return "postmaster", sender
+	case i == 0:
+		return "postmaster", sender[1:]
+	}
+	return sender[:i], sender[i+1:]
+}
+
+// macroIP formats ip for the %{i} macro: dotted quad for IPv4, dot
+// separated nibbles for IPv6.
+func macroIP(ip net.IP) string {
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4.String()
+	}
+	ip16 := ip.To16()
+	if ip16 == nil {
+		return ""
+	}
+	const hex = "0123456789abcdef"
+	nibbles := make([]string, 0, 32)
+	for _, b := range ip16 {
+		nibbles = append(nibbles, string(hex[b>>4]), string(hex[b&0x0f]))
+	}
+	return strings.Join(nibbles, ".")
+}
+
+// truncateDomain shortens an expanded domain-spec to at most 253 characters
+// by removing labels from the left.
+func truncateDomain(domain string) string {
+	domain = strings.TrimSuffix(domain, ".")
+	for len(domain) > 253 {
+		i := strings.IndexByte(domain, '.')
+		if i < 0 {
+			break
+		}
+		domain = domain[i+1:]
+	}
+	return domain
+}
@@ -3,20 +3,28 @@ package emailspf
 import (
 	"strings"
 
+	dnsresolver "github.com/binaryfigments/goharvest/dns/resolver"
 	"github.com/miekg/dns"
 	"golang.org/x/net/publicsuffix"
 )
 
 // Data struct
 type Data struct {
-	Record       string   `json:"domain,omitempty"`
-	SPF          []string `json:"spf,omitempty"`
-	Error        string   `json:"error,omitempty"`
-	ErrorMessage string   `json:"errormessage,omitempty"`
+	Record       string    `json:"domain,omitempty"`
+	SPF          []string  `json:"spf,omitempty"`
+	Parsed       []*Record `json:"parsed,omitempty"`
+	Audit        *Audit    `json:"audit,omitempty"`
+	Error        string    `json:"error,omitempty"`
+	ErrorMessage string    `json:"errormessage,omitempty"`
 }
 
 // Get function of this package.
 func Get(domain string, nameserver string) *Data {
+	return GetWithResolver(domain, dnsresolver.Parse(nameserver))
+}
+
+// GetWithResolver is Get with a custom resolver.
+func GetWithResolver(domain string, resolver dnsresolver.Resolver) *Data {
 	r := new(Data)
 
 	domain, err := publicsuffix.EffectiveTLDPlusOne(domain)
@@ -31,8 +39,7 @@ func Get(domain string, nameserver string) *Data {
 	m.SetQuestion(dns.Fqdn(r.Record), dns.TypeTXT)
 	m.SetEdns0(4096, true)
 	m.MsgHdr.RecursionDesired = true
-	c := new(dns.Client)
-	in, _, err := c.Exchange(m, nameserver+":53")
+	in, err := resolver.Exchange(m)
 	if err != nil {
 		r.Error = "Failed"
 		r.ErrorMessage = err.Error()
@@ -46,8 +53,10 @@ func Get(domain string, nameserver string) *Data {
 				// SPF records zijn langer en kunnen dus in meerdere delen teruggegeven worden.
 				// strings.Join plakt ze weer aan elkaar.
 				record := strings.Join(a.Txt, "")
-				if caseInsenstiveContains(record, "v=spf1") == true {
+				if IsSPF(record) {
 					r.SPF = append(r.SPF, record)
+					parsed, _ := Parse(record)
+					r.Parsed = append(r.Parsed, parsed)
 				}
 
 			}
@@ -65,9 +74,13 @@ func Get(domain string, nameserver string) *Data {
 		return r
 	}
 
-	return r
-}
+	r.Audit = GetAuditWithResolver(r.Record, resolver)
 
-func caseInsenstiveContains(a, b string) bool {
-	return strings.Contains(strings.ToUpper(a), strings.ToUpper(b))
+	if len(r.SPF) > 1 {
+		r.Error = "Failed"
+		r.ErrorMessage = "Multiple SPF records."
+		return r
+	}
+
+	return r
 }
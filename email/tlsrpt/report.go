@@ -0,0 +1,170 @@
+package emailtlsrpt
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Report struct for an aggregate report (RFC 8460 section 4.4)
+type Report struct {
+	OrganizationName string          `json:"organization-name"`
+	DateRange        DateRange       `json:"date-range"`
+	ContactInfo      string          `json:"contact-info"`
+	ReportID         string          `json:"report-id"`
+	Policies         []*PolicyResult `json:"policies"`
+}
+
+// DateRange struct for the period a report covers
+type DateRange struct {
+	Start time.Time `json:"start-datetime"`
+	End   time.Time `json:"end-datetime"`
+}
+
+// PolicyResult struct for the sessions of one policy
+type PolicyResult struct {
+	Policy         Policy           `json:"policy"`
+	Summary        Summary          `json:"summary"`
+	FailureDetails []*FailureDetail `json:"failure-details,omitempty"`
+}
+
+// Policy struct for the policy the sender applied
+type Policy struct {
+	Type   string   `json:"policy-type"`
+	String []string `json:"policy-string,omitempty"`
+	Domain string   `json:"policy-domain"`
+	MXHost []string `json:"mx-host,omitempty"`
+}
+
+// Summary struct with the session counts of a policy
+type Summary struct {
+	Successful int64 `json:"total-successful-session-count"`
+	Failed     int64 `json:"total-failure-session-count"`
+}
+
+// FailureDetail struct for a group of failed sessions
+type FailureDetail struct {
+	ResultType            string `json:"result-type"`
+	SendingMTAIP          string `json:"sending-mta-ip,omitempty"`
+	ReceivingMXHostname   string `json:"receiving-mx-hostname,omitempty"`
+	ReceivingMXHelo       string `json:"receiving-mx-helo,omitempty"`
+	ReceivingIP           string `json:"receiving-ip,omitempty"`
+	FailedSessionCount    int64  `json:"failed-session-count"`
+	AdditionalInformation string `json:"additional-information,omitempty"`
+	FailureReasonCode     string `json:"failure-reason-code,omitempty"`
+}
+
+// Policy types
+const (
+	PolicySTS           = "sts"
+	PolicyTLSA          = "tlsa"
+	PolicyNoPolicyFound = "no-policy-found"
+)
+
+// TypeSummary struct with the session counts of all policies of a type
+type TypeSummary struct {
+	Type        string           `json:"type"`
+	Successful  int64            `json:"successful"`
+	Failed      int64            `json:"failed"`
+	ResultTypes map[string]int64 `json:"resulttypes,omitempty"`
+}
+
+// MaxReportSize limits the size of a report, compressed and decompressed.
+// A larger report is an error.
+const MaxReportSize = 64 * 1024 * 1024
+
+var errReportTooLarge = errors.New("report too large, more than " + strconv.Itoa(MaxReportSize) + " bytes")
+
+// ParseReportFile parses the report in file.
+func ParseReportFile(file string) (*Report, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParseReport(f)
+}
+
+// ParseReport parses a JSON report, gzip compressed reports are detected
+// by their magic number and decompressed.
+func ParseReport(r io.Reader) (*Report, error) {
+	data, err := readReport(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if bytes.HasPrefix(data, []byte{0x1f, 0x8b}) {
+		zr, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		data, err = readReport(zr)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	report := new(Report)
+	if err := json.Unmarshal(data, report); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// Summarize adds up the sessions of the report per policy type, with the
+// failed sessions per result type.
+func (report *Report) Summarize() []*TypeSummary {
+	return Summarize(report)
+}
+
+// Summarize adds up the sessions of reports per policy type.
+func Summarize(reports ...*Report) []*TypeSummary {
+	types := make(map[string]*TypeSummary)
+	for _, report := range reports {
+		for _, p := range report.Policies {
+			s := types[p.Policy.Type]
+			if s == nil {
+				s = &TypeSummary{Type: p.Policy.Type, ResultTypes: make(map[string]int64)}
+				types[p.Policy.Type] = s
+			}
+			s.Successful += p.Summary.Successful
+			s.Failed += p.Summary.Failed
+			for _, detail := range p.FailureDetails {
+				s.ResultTypes[detail.ResultType] += detail.FailedSessionCount
+			}
+		}
+	}
+
+	var summaries []*TypeSummary
+	for _, s := range types {
+		summaries = append(summaries, s)
+	}
+	sort.Slice(summaries, func(i, j int) bool {
+		return summaries[i].Type < summaries[j].Type
+	})
+	return summaries
+}
+
+/*
+ * Used functions
+ */
+
+// readReport reads r up to MaxReportSize.
+func readReport(r io.Reader) ([]byte, error) {
+	data, err := ioutil.ReadAll(io.LimitReader(r, MaxReportSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > MaxReportSize {
+		return nil, errReportTooLarge
+	}
+	return data, nil
+}
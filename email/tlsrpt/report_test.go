@@ -0,0 +1,100 @@
+package emailtlsrpt
+
+import (
+	"bytes"
+	"compress/gzip"
+	"strings"
+	"testing"
+	"time"
+)
+
+// report is the example of RFC 8460 appendix B.
+const report = `{
+  "organization-name": "Company-X",
+  "date-range": {"start-datetime": "2016-04-01T00:00:00Z", "end-datetime": "2016-04-01T23:59:59Z"},
+  "contact-info": "sts-reporting@company-x.example",
+  "report-id": "5065427c-23d3-47ca-b6e0-946ea0e8c4be",
+  "policies": [{
+    "policy": {"policy-type": "sts", "policy-string": ["version: STSv1", "mode: testing", "mx: *.mail.company-y.example", "max_age: 86400"], "policy-domain": "company-y.example", "mx-host": ["*.mail.company-y.example"]},
+    "summary": {"total-successful-session-count": 5326, "total-failure-session-count": 303},
+    "failure-details": [
+      {"result-type": "certificate-expired", "sending-mta-ip": "2001:db8:abcd:0012::1", "receiving-mx-hostname": "mx1.mail.company-y.example", "failed-session-count": 100},
+      {"result-type": "starttls-not-supported", "sending-mta-ip": "2001:db8:abcd:0013::1", "receiving-mx-hostname": "mx2.mail.company-y.example", "receiving-ip": "203.0.113.56", "failed-session-count": 200},
+      {"result-type": "validation-failure", "sending-mta-ip": "198.51.100.62", "receiving-ip": "203.0.113.58", "receiving-mx-hostname": "mx-backup.mail.company-y.example", "failed-session-count": 3, "failure-reason-code": "X509_V_ERR_PROXY_PATH_LENGTH_EXCEEDED"}
+    ]
+  }]
+}`
+
+func gzipped(t *testing.T, data []byte) []byte {
+	t.Helper()
+	var b bytes.Buffer
+	w := gzip.NewWriter(&b)
+	if _, err := w.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+func TestParseReport(t *testing.T) {
+	bomb := gzipped(t, append([]byte(report), bytes.Repeat([]byte(" "), MaxReportSize)...))
+
+	tests := []struct {
+		name string
+		in   []byte
+		err  string
+	}{
+		{"json", []byte(report), ""},
+		{"gzip", gzipped(t, []byte(report)), ""},
+		{"gzip bomb", bomb, "report too large"},
+		{"broken gzip", []byte{0x1f, 0x8b, 0}, "unexpected EOF"},
+		{"not json", []byte("<feedback/>"), "invalid character"},
+	}
+	for _, tt := range tests {
+		r, err := ParseReport(bytes.NewReader(tt.in))
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%s: got error %v, want %q", tt.name, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if r.OrganizationName != "Company-X" || !r.DateRange.End.Equal(time.Date(2016, 4, 1, 23, 59, 59, 0, time.UTC)) || len(r.Policies) != 1 || len(r.Policies[0].FailureDetails) != 3 {
+			t.Errorf("%s: got %+v", tt.name, r)
+		}
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	first, err := ParseReport(strings.NewReader(report))
+	if err != nil {
+		t.Fatal(err)
+	}
+	second := &Report{Policies: []*PolicyResult{
+		{Policy: Policy{Type: PolicySTS}, Summary: Summary{Successful: 10, Failed: 1}, FailureDetails: []*FailureDetail{{ResultType: "certificate-expired", FailedSessionCount: 1}}},
+		{Policy: Policy{Type: PolicyNoPolicyFound}, Summary: Summary{Successful: 7}},
+	}}
+
+	summaries := Summarize(first, second)
+	if len(summaries) != 2 || summaries[0].Type != PolicyNoPolicyFound || summaries[1].Type != PolicySTS {
+		t.Fatalf("got %+v, want no-policy-found and sts", summaries)
+	}
+	sts := summaries[1]
+	if sts.Successful != 5336 || sts.Failed != 304 {
+		t.Errorf("got %d successful and %d failed sessions, want 5336 and 304", sts.Successful, sts.Failed)
+	}
+	want := map[string]int64{"certificate-expired": 101, "starttls-not-supported": 200, "validation-failure": 3}
+	for result, count := range want {
+		if sts.ResultTypes[result] != count {
+			t.Errorf("%s: got %d, want %d", result, sts.ResultTypes[result], count)
+		}
+	}
+	if s := first.Summarize(); len(s) != 1 || s[0].Failed != 303 {
+		t.Errorf("one report: got %+v", s)
+	}
+}
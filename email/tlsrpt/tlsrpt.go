@@ -0,0 +1,183 @@
+package emailtlsrpt
+
+import (
+	"errors"
+	"net/url"
+	"regexp"
+	"strings"
+
+	dnsresolver "github.com/binaryfigments/goharvest/dns/resolver"
+	"github.com/miekg/dns"
+	"golang.org/x/net/idna"
+)
+
+// Data struct
+type Data struct {
+	Domain       string    `json:"domain,omitempty"`
+	Record       string    `json:"record,omitempty"`
+	TLSRPT       []string  `json:"tlsrpt,omitempty"`
+	Parsed       []*Record `json:"parsed,omitempty"`
+	Error        string    `json:"error,omitempty"`
+	ErrorMessage string    `json:"errormessage,omitempty"`
+}
+
+// Record struct for a parsed TLS-RPT record (RFC 8460 section 3)
+type Record struct {
+	Raw     string   `json:"raw,omitempty"`
+	Version string   `json:"v,omitempty"`
+	RUA     []string `json:"rua,omitempty"`
+	Valid   bool     `json:"valid"`
+	Errors  []string `json:"errors,omitempty"`
+}
+
+// Get function of this package to get the TLS-RPT record
+func Get(domain string, nameserver string) *Data {
+	return GetWithResolver(domain, dnsresolver.Parse(nameserver))
+}
+
+// GetWithResolver is Get with a custom resolver.
+func GetWithResolver(domain string, resolver dnsresolver.Resolver) *Data {
+	r := new(Data)
+
+	domain, err := idna.ToASCII(strings.TrimSuffix(strings.ToLower(domain), "."))
+	if err != nil {
+		r.Error = "Failed"
+		r.ErrorMessage = err.Error()
+		return r
+	}
+	r.Domain = domain
+
+	r.Record = "_smtp._tls." + domain
+	r.TLSRPT, err = lookupTLSRPT(resolver, r.Record)
+	if err != nil {
+		r.Error = "Failed"
+		r.ErrorMessage = err.Error()
+		return r
+	}
+
+	for _, record := range r.TLSRPT {
+		r.Parsed = append(r.Parsed, Parse(record))
+	}
+
+	// Check for records
+	if len(r.TLSRPT) < 1 {
+		r.Error = "Failed"
+		r.ErrorMessage = "No TLS-RPT records."
+		return r
+	}
+
+	if len(r.TLSRPT) > 1 {
+		r.Error = "Failed"
+		r.ErrorMessage = "Multiple TLS-RPT records."
+		return r
+	}
+
+	if !r.Parsed[0].Valid {
+		r.Error = "Failed"
+		r.ErrorMessage = "Invalid TLS-RPT record: " + strings.Join(r.Parsed[0].Errors, ", ")
+		return r
+	}
+
+	return r
+}
+
+var version = regexp.MustCompile(`^v[ \t]*=[ \t]*TLSRPTv1[ \t]*(;|$)`)
+
+// IsTLSRPT reports whether a TXT record is a TLS-RPT record, that is whether
+// it starts with the v=TLSRPTv1 field.
+func IsTLSRPT(txt string) bool {
+	return version.MatchString(strings.TrimLeft(txt, " \t"))
+}
+
+// Parse parses and validates a TLS-RPT record. rua is required and takes
+// a comma separated list of mailto: and https: URIs.
+func Parse(record string) *Record {
+	r := &Record{Raw: record}
+
+	if !IsTLSRPT(record) {
+		r.addError("record does not start with v=TLSRPTv1")
+		return r
+	}
+
+	seen := make(map[string]bool)
+	for _, field := range strings.Split(record, ";") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		eq := strings.Index(field, "=")
+		if eq < 0 {
+			r.addError("invalid field: " + field)
+			continue
+		}
+		key := strings.TrimSpace(field[:eq])
+		value := strings.TrimSpace(field[eq+1:])
+		if seen[key] {
+			r.addError("duplicate field: " + key)
+			continue
+		}
+		seen[key] = true
+
+		switch key {
+		case "v":
+			r.Version = value
+		case "rua":
+			for _, uri := range strings.Split(value, ",") {
+				uri = strings.TrimSpace(uri)
+				u, err := url.Parse(uri)
+				if err != nil || (!strings.EqualFold(u.Scheme, "mailto") && !strings.EqualFold(u.Scheme, "https")) {
+					r.addError("rua must be a mailto: or https: URI: " + uri)
+					continue
+				}
+				r.RUA = append(r.RUA, uri)
+			}
+		default:
+			// Unknown fields are ignored (RFC 8460 section 3).
+		}
+	}
+
+	if !seen["rua"] {
+		r.addError("missing required field rua")
+	}
+
+	r.Valid = len(r.Errors) == 0
+	return r
+}
+
+func (r *Record) addError(err string) {
+	r.Errors = append(r.Errors, err)
+}
+
+/*
+ * Used functions
+ */
+
+// lookupTLSRPT returns the TXT records at name that start with v=TLSRPTv1,
+// a non existing name gives no records and no error.
+func lookupTLSRPT(resolver dnsresolver.Resolver, name string) ([]string, error) {
+	m := new(dns.Msg)
+	m.SetQuestion(dns.Fqdn(name), dns.TypeTXT)
+	m.SetEdns0(4096, true)
+	m.MsgHdr.RecursionDesired = true
+	in, err := resolver.Exchange(m)
+	if err != nil {
+		return nil, err
+	}
+
+	var records []string
+	switch rcode := in.MsgHdr.Rcode; rcode {
+	case dns.RcodeSuccess:
+		for _, ain := range in.Answer {
+			if a, ok := ain.(*dns.TXT); ok {
+				record := strings.Join(a.Txt, "")
+				if IsTLSRPT(record) {
+					records = append(records, record)
+				}
+			}
+		}
+	case dns.RcodeNameError:
+	default:
+		return nil, errors.New("DNS lookup for " + name + " failed: " + dns.RcodeToString[rcode])
+	}
+	return records, nil
+}
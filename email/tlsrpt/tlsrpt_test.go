@@ -0,0 +1,73 @@
+package emailtlsrpt
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	dnsresolver "github.com/binaryfigments/goharvest/dns/resolver"
+	"github.com/miekg/dns"
+)
+
+func TestGetWithResolver(t *testing.T) {
+	z := dnsresolver.MustZone(
+		`_smtp._tls.example.com. 60 IN TXT "v=TLSRPTv1; rua=mailto:tls@example.com,https://reports.example.net/v1"`,
+		`_smtp._tls.example.com. 60 IN TXT "v=spf1 -all"`,
+		`_smtp._tls.multiple.example. 60 IN TXT "v=TLSRPTv1; rua=mailto:a@example.com"`,
+		`_smtp._tls.multiple.example. 60 IN TXT "v=TLSRPTv1; rua=mailto:b@example.com"`,
+		`_smtp._tls.invalid.example. 60 IN TXT "v=TLSRPTv1; rua=ftp://reports.example.net"`,
+	)
+	servfail := dnsresolver.ResolverFunc(func(m *dns.Msg) (*dns.Msg, error) {
+		r := new(dns.Msg)
+		r.SetRcode(m, dns.RcodeServerFailure)
+		return r, nil
+	})
+	broken := dnsresolver.ResolverFunc(func(m *dns.Msg) (*dns.Msg, error) {
+		return nil, errors.New("timeout")
+	})
+
+	tests := []struct {
+		name     string
+		domain   string
+		resolver dnsresolver.Resolver
+		rua      int
+		err      string
+	}{
+		{"valid", "Example.com.", z, 2, ""},
+		{"none", "none.example", z, 0, "No TLS-RPT records."},
+		{"multiple", "multiple.example", z, 0, "Multiple TLS-RPT records."},
+		{"invalid", "invalid.example", z, 0, "Invalid TLS-RPT record: rua must be a mailto: or https: URI: ftp://reports.example.net"},
+		{"server failure", "example.com", servfail, 0, "DNS lookup for _smtp._tls.example.com failed: SERVFAIL"},
+		{"lookup error", "example.com", broken, 0, "timeout"},
+	}
+	for _, tt := range tests {
+		d := GetWithResolver(tt.domain, tt.resolver)
+		if d.ErrorMessage != tt.err {
+			t.Errorf("%s: got error %q, want %q", tt.name, d.ErrorMessage, tt.err)
+			continue
+		}
+		if tt.err == "" && (d.Record != "_smtp._tls.example.com" || len(d.TLSRPT) != 1 || len(d.Parsed[0].RUA) != tt.rua) {
+			t.Errorf("%s: got %+v", tt.name, d)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		record string
+		rua    []string
+		errors string
+	}{
+		{"v=TLSRPTv1; rua=mailto:tls@example.com", []string{"mailto:tls@example.com"}, ""},
+		{"v=TLSRPTv1;rua=https://reports.example.net/v1 , mailto:tls@example.com;ext=1;", []string{"https://reports.example.net/v1", "mailto:tls@example.com"}, ""},
+		{"v=TLSRPTv1", nil, "missing required field rua"},
+		{"v=TLSRPTv1; rua=tls@example.com", nil, "rua must be a mailto: or https: URI: tls@example.com"},
+		{"v=TLSRPTv2; rua=mailto:tls@example.com", nil, "record does not start with v=TLSRPTv1"},
+	}
+	for _, tt := range tests {
+		r := Parse(tt.record)
+		if strings.Join(r.RUA, " ") != strings.Join(tt.rua, " ") || strings.Join(r.Errors, "|") != tt.errors || r.Valid != (tt.errors == "") {
+			t.Errorf("%q: got rua %q and errors %q, want %q and %q", tt.record, r.RUA, r.Errors, tt.rua, tt.errors)
+		}
+	}
+}
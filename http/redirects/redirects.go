@@ -8,6 +8,7 @@ import (
 
 	"golang.org/x/net/idna"
 
+	dnsresolver "github.com/binaryfigments/goharvest/dns/resolver"
 	"github.com/miekg/dns"
 )
 
@@ -131,11 +132,16 @@ func hostFromURL(geturl string) (string, error) {
 }
 
 func GetHosts(geturl string) *Hosts {
+	return GetHostsWithResolver(geturl, dnsresolver.Parse("8.8.4.4"))
+}
+
+// GetHostsWithResolver is GetHosts with a custom resolver.
+func GetHostsWithResolver(geturl string, resolver dnsresolver.Resolver) *Hosts {
 	r := new(Hosts)
 
 	r.Hostname = geturl
 
-	cname, err := GetCNAME(r.Hostname, "8.8.4.4")
+	cname, err := GetCNAMEWithResolver(r.Hostname, resolver)
 	if err != nil {
 		r.Error = "Failed"
 		r.ErrorMessage = err.Error()
@@ -147,7 +153,7 @@ func GetHosts(geturl string) *Hosts {
 		return r
 	}
 
-	ar, err := GetA(r.Hostname, "8.8.4.4")
+	ar, err := GetAWithResolver(r.Hostname, resolver)
 	if err != nil {
 		r.Error = "Failed"
 		r.ErrorMessage = err.Error()
@@ -155,7 +161,7 @@ func GetHosts(geturl string) *Hosts {
 	}
 	r.IPv4 = ar
 
-	aaaar, err := GetAAAA(r.Hostname, "8.8.4.4")
+	aaaar, err := GetAAAAWithResolver(r.Hostname, resolver)
 	if err != nil {
 		r.Error = "Failed"
 		r.ErrorMessage = err.Error()
@@ -168,12 +174,16 @@ func GetHosts(geturl string) *Hosts {
 }
 
 func GetCNAME(hostname string, nameserver string) (string, error) {
+	return GetCNAMEWithResolver(hostname, dnsresolver.Parse(nameserver))
+}
+
+// GetCNAMEWithResolver is GetCNAME with a custom resolver.
+func GetCNAMEWithResolver(hostname string, resolver dnsresolver.Resolver) (string, error) {
 	var cname string
 	m := new(dns.Msg)
 	m.SetQuestion(dns.Fqdn(hostname), dns.TypeCNAME)
-	c := new(dns.Client)
 	m.MsgHdr.RecursionDesired = true
-	in, _, err := c.Exchange(m, nameserver+":53")
+	in, err := resolver.Exchange(m)
 	if err != nil {
 		return "none", err
 	}
@@ -186,12 +196,16 @@ func GetCNAME(hostname string, nameserver string) (string, error) {
 }
 
 func GetA(hostname string, nameserver string) ([]string, error) {
+	return GetAWithResolver(hostname, dnsresolver.Parse(nameserver))
+}
+
+// GetAWithResolver is GetA with a custom resolver.
+func GetAWithResolver(hostname string, resolver dnsresolver.Resolver) ([]string, error) {
 	var record []string
 	m := new(dns.Msg)
 	m.SetQuestion(dns.Fqdn(hostname), dns.TypeA)
-	c := new(dns.Client)
 	m.MsgHdr.RecursionDesired = true
-	in, _, err := c.Exchange(m, nameserver+":53")
+	in, err := resolver.Exchange(m)
 	if err != nil {
 		return nil, err
 	}
@@ -205,12 +219,16 @@ func GetA(hostname string, nameserver string) ([]string, error) {
 }
 
 func GetAAAA(hostname string, nameserver string) ([]string, error) {
+	return GetAAAAWithResolver(hostname, dnsresolver.Parse(nameserver))
+}
+
+// GetAAAAWithResolver is GetAAAA with a custom resolver.
+func GetAAAAWithResolver(hostname string, resolver dnsresolver.Resolver) ([]string, error) {
 	var record []string
 	m := new(dns.Msg)
 	m.SetQuestion(dns.Fqdn(hostname), dns.TypeAAAA)
-	c := new(dns.Client)
 	m.MsgHdr.RecursionDesired = true
-	in, _, err := c.Exchange(m, nameserver+":53")
+	in, err := resolver.Exchange(m)
 	if err != nil {
 		return nil, err
 	}
@@ -2,8 +2,10 @@ package pkicertificate
 
 import (
 	"crypto/tls"
+	"encoding/asn1"
 	"encoding/pem"
 	"errors"
+	"io"
 	"io/ioutil"
 	"net"
 	"net/http"
@@ -23,11 +25,57 @@ type Certificates struct {
 	Error        string              `json:"error,omitempty"`
 	ErrorMessage string              `json:"errormessage,omitempty"`
 	Parsed       []*x509.Certificate `json:"parsed,omitempty"`
+	Chain        *Chain              `json:"chain,omitempty"`
+	Lints        []*Lint             `json:"lints,omitempty"`
+	LintError    string              `json:"linterror,omitempty"`
 	Raw          []byte              `json:"raw,omitempty"`
 }
 
-// Get function for starting the check
+// Timeouts for connecting and for the protocol conversation before the
+// TLS handshake.
+var (
+	DialTimeout = 1000 * time.Millisecond
+	Timeout     = 10 * time.Second
+)
+
+// aiaClient fetches caIssuers certificates, maxIssuerSize caps what it
+// reads.
+var aiaClient = &http.Client{Timeout: 10 * time.Second}
+
+const maxIssuerSize = 1 << 20
+
+var oidSignedData = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+
+// Get function for starting the check. The protocol is https, imaps,
+// pop3s, smtps or ldaps for implicit TLS, or smtp, imap, pop3, xmpp,
+// xmpp-server, ldap, ftp or postgres for STARTTLS.
 func Get(fqdn string, port int, protocol string) *Certificates {
+	return GetWithOptions(fqdn, port, protocol, nil)
+}
+
+// Options struct for GetWithOptions. Verify validates the chain against
+// Roots, nil means the root store of the system, and fetches missing
+// intermediates. Lint runs zlint against every certificate with the
+// LintSources, by default CABF_BR, RFC5280 and Mozilla.
+type Options struct {
+	Verify      bool
+	Roots       *RootStore
+	Lint        bool
+	LintSources []string
+}
+
+// GetWithRoots is Get with the chain validated against roots, nil means
+// the root store of the system.
+func GetWithRoots(fqdn string, port int, protocol string, roots *RootStore) *Certificates {
+	return GetWithOptions(fqdn, port, protocol, &Options{Verify: true, Roots: roots})
+}
+
+// GetWithOptions is Get with the chain validation and linting of options,
+// nil options are the defaults.
+func GetWithOptions(fqdn string, port int, protocol string, options *Options) *Certificates {
+	if options == nil {
+		options = &Options{}
+	}
 	r := new(Certificates)
 
 	r.FQDN = fqdn
@@ -49,8 +97,9 @@ func Get(fqdn string, port int, protocol string) *Certificates {
 		return r
 	}
 
+	var state tls.ConnectionState
 	switch protocol {
-	case "https":
+	case "https", "imaps", "pop3s", "smtps", "ldaps":
 		fqdnport := fqdn + ":" + strconv.Itoa(port)
 
 		tlsconf := &tls.Config{
@@ -58,7 +107,7 @@ func Get(fqdn string, port int, protocol string) *Certificates {
 		}
 
 		dialconf := &net.Dialer{
-			Timeout: 1000 * time.Millisecond,
+			Timeout: DialTimeout,
 		}
 
 		conn, err := tls.DialWithDialer(dialconf, "tcp", fqdnport, tlsconf)
@@ -69,66 +118,133 @@ func Get(fqdn string, port int, protocol string) *Certificates {
 			return r
 		}
 
-		connState := conn.ConnectionState()
-		peerChain := connState.PeerCertificates
-		if len(peerChain) == 0 {
-			r.Error = "Failed"
-			r.ErrorMessage = err.Error()
-			return r
-		}
+		state = conn.ConnectionState()
 		conn.Close()
-		for _, peer := range peerChain {
-			parsed, err := x509.ParseCertificate(peer.Raw)
-			if err != nil {
-				r.Error = "Failed"
-				r.ErrorMessage = err.Error()
-				return r
-			}
-			r.Parsed = append(r.Parsed, parsed)
-		}
 	case "smtp":
 		tlsconfig := &tls.Config{
 			InsecureSkipVerify: true,
 			ServerName:         fqdn,
 		}
 
-		c, err := smtp.Dial(fqdn + ":" + strconv.Itoa(port))
+		conn, err := net.DialTimeout("tcp", net.JoinHostPort(fqdn, strconv.Itoa(port)), DialTimeout)
+		if err != nil {
+			r.Error = "Failed"
+			r.ErrorMessage = err.Error()
+			return r
+		}
+		conn.SetDeadline(time.Now().Add(Timeout))
+
+		c, err := smtp.NewClient(conn, fqdn)
 		if err != nil {
+			conn.Close()
 			r.Error = "Failed"
 			r.ErrorMessage = err.Error()
 			return r
 		}
 
-		c.StartTLS(tlsconfig)
+		if err := c.StartTLS(tlsconfig); err != nil {
+			c.Close()
+			r.Error = "Failed"
+			r.ErrorMessage = err.Error()
+			return r
+		}
 
 		cs, ok := c.TLSConnectionState()
 		if !ok {
+			c.Close()
 			r.Error = "Failed"
-			r.ErrorMessage = err.Error()
+			r.ErrorMessage = "No TLS connection."
 			return r
 		}
 		c.Quit()
+		state = cs
+	default:
+		upgrade, ok := startTLS[protocol]
+		if !ok {
+			r.Error = "Failed"
+			r.ErrorMessage = "Unknown protocol " + protocol + "."
+			return r
+		}
+		state, err = dialStartTLS(fqdn, port, upgrade)
+		if err != nil {
+			r.Error = "Failed"
+			r.ErrorMessage = err.Error()
+			return r
+		}
+	}
 
-		peerChain := cs.PeerCertificates
-		if len(peerChain) == 0 {
+	peerChain := state.PeerCertificates
+	if len(peerChain) == 0 {
+		r.Error = "Failed"
+		r.ErrorMessage = "No certificates."
+		return r
+	}
+	for _, peer := range peerChain {
+		parsed, err := x509.ParseCertificate(peer.Raw)
+		if err != nil {
 			r.Error = "Failed"
 			r.ErrorMessage = err.Error()
 			return r
 		}
-		for _, peer := range peerChain {
-			parsed, err := x509.ParseCertificate(peer.Raw)
-			if err != nil {
-				r.Error = "Failed"
-				r.ErrorMessage = err.Error()
-				return r
-			}
-			r.Parsed = append(r.Parsed, parsed)
+		r.Parsed = append(r.Parsed, parsed)
+	}
+
+	if options.Lint {
+		sources := options.LintSources
+		if len(sources) == 0 {
+			sources = defaultLintSources()
+		}
+		r.Lints, err = LintChain(r.Parsed, sources)
+		if err != nil {
+			r.LintError = err.Error()
+		}
+	}
+
+	if !options.Verify {
+		return r
+	}
+	roots := options.Roots
+	if roots == nil {
+		roots, err = SystemRoots()
+		if err != nil {
+			r.Chain = &Chain{RootStore: "system", VerifyError: err.Error()}
+			return r
 		}
 	}
+	r.Chain = VerifyChain(r.Parsed, fqdn, roots)
 
 	return r
 }
 
+// ParseChain parses every CERTIFICATE block of a PEM bundle, in the order
+// of the bundle. Input without PEM blocks is parsed as one DER certificate.
+func ParseChain(in []byte) ([]*x509.Certificate, error) {
+	var chain []*x509.Certificate
+	for {
+		var p *pem.Block
+		p, in = pem.Decode(in)
+		if p == nil {
+			break
+		}
+		if p.Type != "CERTIFICATE" {
+			continue
+		}
+		parsed, err := x509.ParseCertificate(p.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, parsed)
+	}
+	if len(chain) == 0 {
+		parsed, err := x509.ParseCertificate(in)
+		if err != nil {
+			return nil, errors.New("invalid certificate")
+		}
+		chain = append(chain, parsed)
+	}
+	return chain, nil
+}
+
 // For later use
 func parseCert(in []byte) (*x509.Certificate, error) {
 	p, _ := pem.Decode(in)
@@ -141,17 +257,63 @@ func parseCert(in []byte) (*x509.Certificate, error) {
 	return x509.ParseCertificate(in)
 }
 
-func fetchRemote(url string) (*x509.Certificate, error) {
-	resp, err := http.Get(url)
+// fetchRemote downloads the certificates at a caIssuers URL, a DER or PEM
+// certificate or a PKCS#7 (.p7c) bundle.
+func fetchRemote(url string) ([]*x509.Certificate, error) {
+	resp, err := aiaClient.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New("caIssuers " + url + " returned " + resp.Status)
+	}
+
+	in, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxIssuerSize+1))
 	if err != nil {
 		return nil, err
 	}
+	if len(in) > maxIssuerSize {
+		return nil, errors.New("caIssuers " + url + " is larger than " + strconv.Itoa(maxIssuerSize) + " bytes")
+	}
 
-	in, err := ioutil.ReadAll(resp.Body)
+	if certs, err := parsePKCS7(in); err == nil {
+		return certs, nil
+	}
+	cert, err := parseCert(in)
 	if err != nil {
 		return nil, err
 	}
-	resp.Body.Close()
+	return []*x509.Certificate{cert}, nil
+}
 
-	return parseCert(in)
+// parsePKCS7 returns the certificates of a degenerate PKCS#7 SignedData
+// as served for caIssuers (RFC 5280 section 4.2.2.1).
+func parsePKCS7(in []byte) ([]*x509.Certificate, error) {
+	if p, _ := pem.Decode(in); p != nil {
+		in = p.Bytes
+	}
+	var info struct {
+		ContentType asn1.ObjectIdentifier
+		Content     asn1.RawValue `asn1:"explicit,tag:0"`
+	}
+	if _, err := asn1.Unmarshal(in, &info); err != nil {
+		return nil, err
+	}
+	if !info.ContentType.Equal(oidSignedData) {
+		return nil, errors.New("not a PKCS#7 SignedData")
+	}
+	var signed struct {
+		Version          int
+		DigestAlgorithms asn1.RawValue
+		ContentInfo      asn1.RawValue
+		Certificates     asn1.RawValue `asn1:"optional,tag:0"`
+	}
+	if _, err := asn1.Unmarshal(info.Content.Bytes, &signed); err != nil {
+		return nil, err
+	}
+	if len(signed.Certificates.Bytes) == 0 {
+		return nil, errors.New("no certificates in PKCS#7")
+	}
+	return x509.ParseCertificates(signed.Certificates.Bytes)
 }
@@ -0,0 +1,110 @@
+package pkicertificate
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	stdx509 "crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+)
+
+// testCert is a certificate with its key, issued by issue.
+type testCert struct {
+	cert *stdx509.Certificate
+	der  []byte
+	key  crypto.Signer
+}
+
+// issue returns a certificate for cn signed by parent, self-signed when
+// parent is nil. A leaf is valid for localhost, aia is its caIssuers URL.
+func issue(t *testing.T, cn string, parent *testCert, isCA bool, aia string) *testCert {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &stdx509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: cn},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		BasicConstraintsValid: true,
+		IsCA:                  isCA,
+	}
+	if isCA {
+		template.KeyUsage = stdx509.KeyUsageCertSign
+	} else {
+		template.DNSNames = []string{"localhost"}
+	}
+	if aia != "" {
+		template.IssuingCertificateURL = []string{aia}
+	}
+	c := &testCert{cert: template, key: key}
+	if parent == nil {
+		parent = c
+	}
+	c.der, err = stdx509.CreateCertificate(rand.Reader, template, parent.cert, &key.PublicKey, parent.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.cert, err = stdx509.ParseCertificate(c.der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// tlsServer serves leaf followed by chain on localhost and returns the
+// port.
+func tlsServer(t *testing.T, leaf *testCert, chain ...[]byte) int {
+	t.Helper()
+	l, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: append([][]byte{leaf.der}, chain...), PrivateKey: leaf.key}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			conn.(*tls.Conn).Handshake()
+			conn.Close()
+		}
+	}()
+	return l.Addr().(*net.TCPAddr).Port
+}
+
+func TestGetWithOptions(t *testing.T) {
+	leaf := issue(t, "localhost", nil, false, "")
+	port := tlsServer(t, leaf)
+
+	tests := []struct {
+		name    string
+		options *Options
+		lints   int
+	}{
+		{name: "nil options"},
+		{name: "no lint", options: &Options{}},
+		{name: "lint", options: &Options{Lint: true}, lints: 1},
+	}
+	for _, tt := range tests {
+		r := GetWithOptions("localhost", port, "https", tt.options)
+		if r.Error != "" {
+			t.Errorf("%s: %s", tt.name, r.ErrorMessage)
+			continue
+		}
+		if len(r.Parsed) != 1 || len(r.Lints) != tt.lints || r.LintError != "" {
+			t.Errorf("%s: got %d certificates and %d lints (%s)", tt.name, len(r.Parsed), len(r.Lints), r.LintError)
+		}
+	}
+}
@@ -0,0 +1,233 @@
+package pkicertificate
+
+import (
+	"crypto/sha256"
+	stdx509 "crypto/x509"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/zmap/zcrypto/x509"
+)
+
+// Chain struct with the validation of a served chain against a root store
+type Chain struct {
+	RootStore     string         `json:"rootstore,omitempty"`
+	Trusted       bool           `json:"trusted"`
+	VerifyError   string         `json:"verifyerror,omitempty"`
+	HostnameMatch bool           `json:"hostnamematch"`
+	HostnameError string         `json:"hostnameerror,omitempty"`
+	Complete      bool           `json:"complete"`
+	OutOfOrder    bool           `json:"outoforder"`
+	ExtraCerts    []*ChainCert   `json:"extracerts,omitempty"`
+	NeedsAIA      bool           `json:"needsaia"`
+	AIAFetched    []string       `json:"aiafetched,omitempty"`
+	Paths         [][]*ChainCert `json:"paths,omitempty"`
+}
+
+// ChainCert struct for a certificate in a trust path
+type ChainCert struct {
+	Subject     string    `json:"subject,omitempty"`
+	Issuer      string    `json:"issuer,omitempty"`
+	Fingerprint string    `json:"sha256,omitempty"`
+	NotAfter    time.Time `json:"notafter"`
+	Served      bool      `json:"served"`
+	AIA         bool      `json:"aia"`
+	Root        bool      `json:"root"`
+}
+
+// RootStore struct for a named set of trusted roots
+type RootStore struct {
+	Name string
+	Pool *stdx509.CertPool
+}
+
+// MozillaBundleURL is the Mozilla root store as PEM, published by curl.
+var MozillaBundleURL = "https://curl.se/ca/cacert.pem"
+
+// MaxAIAFetches limits the intermediates fetched for one chain.
+const MaxAIAFetches = 5
+
+// bundleClient fetches the Mozilla root store, maxBundleSize caps what it
+// reads.
+var bundleClient = &http.Client{Timeout: 30 * time.Second}
+
+const maxBundleSize = 4 << 20
+
+// SystemRoots returns the root store of the operating system.
+func SystemRoots() (*RootStore, error) {
+	pool, err := stdx509.SystemCertPool()
+	if err != nil {
+		return nil, err
+	}
+	return &RootStore{Name: "system", Pool: pool}, nil
+}
+
+// MozillaRoots fetches the Mozilla root store from MozillaBundleURL.
+func MozillaRoots() (*RootStore, error) {
+	resp, err := bundleClient.Get(MozillaBundleURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New("fetch of " + MozillaBundleURL + " returned " + resp.Status)
+	}
+	in, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxBundleSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(in) > maxBundleSize {
+		return nil, errors.New(MozillaBundleURL + " is larger than " + strconv.Itoa(maxBundleSize) + " bytes")
+	}
+	return RootsFromPEM("mozilla", in)
+}
+
+// RootsFromPEM returns a root store with the certificates of a PEM bundle.
+func RootsFromPEM(name string, in []byte) (*RootStore, error) {
+	pool := stdx509.NewCertPool()
+	if !pool.AppendCertsFromPEM(in) {
+		return nil, errors.New("no certificates in PEM bundle")
+	}
+	return &RootStore{Name: name, Pool: pool}, nil
+}
+
+// VerifyChain validates served, leaf first, for fqdn against roots. When
+// the served chain does not lead to a root the missing intermediates are
+// fetched from the AIA caIssuers URLs.
+func VerifyChain(served []*x509.Certificate, fqdn string, roots *RootStore) *Chain {
+	c := &Chain{RootStore: roots.Name}
+	if len(served) == 0 {
+		c.VerifyError = "no certificates"
+		return c
+	}
+
+	var certs []*stdx509.Certificate
+	for _, cert := range served {
+		parsed, err := stdx509.ParseCertificate(cert.Raw)
+		if err != nil {
+			c.VerifyError = err.Error()
+			return c
+		}
+		certs = append(certs, parsed)
+	}
+	leaf := certs[0]
+
+	if err := leaf.VerifyHostname(fqdn); err != nil {
+		c.HostnameError = err.Error()
+	} else {
+		c.HostnameMatch = true
+	}
+
+	intermediates := stdx509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+	opts := stdx509.VerifyOptions{
+		Roots:         roots.Pool,
+		Intermediates: intermediates,
+		KeyUsages:     []stdx509.ExtKeyUsage{stdx509.ExtKeyUsageAny},
+	}
+
+	paths, err := leaf.Verify(opts)
+	c.Complete = err == nil
+	fetched := make(map[string]bool)
+	if err != nil {
+		// Follow caIssuers from the certificates we have until a path
+		// to a root is found.
+		pending := certs
+		for len(c.AIAFetched) < MaxAIAFetches && len(pending) > 0 {
+			cert := pending[0]
+			pending = pending[1:]
+			for _, url := range cert.IssuingCertificateURL {
+				if fetched[url] || len(c.AIAFetched) >= MaxAIAFetches {
+					continue
+				}
+				fetched[url] = true
+				issuers, ferr := fetchRemote(url)
+				if ferr != nil {
+					continue
+				}
+				c.AIAFetched = append(c.AIAFetched, url)
+				for _, issuer := range issuers {
+					parsed, perr := stdx509.ParseCertificate(issuer.Raw)
+					if perr != nil {
+						continue
+					}
+					intermediates.AddCert(parsed)
+					pending = append(pending, parsed)
+				}
+			}
+		}
+		if len(c.AIAFetched) > 0 {
+			paths, err = leaf.Verify(opts)
+			c.NeedsAIA = err == nil
+		}
+	}
+	if err != nil {
+		c.VerifyError = err.Error()
+		return c
+	}
+	c.Trusted = true
+
+	// Report every path, which served certificates are in none and whether
+	// the served order follows the first path.
+	inPath := make(map[string]bool)
+	for _, path := range paths {
+		var p []*ChainCert
+		for i, cert := range path {
+			fp := fingerprint(cert)
+			inPath[fp] = true
+			p = append(p, &ChainCert{
+				Subject:     cert.Subject.String(),
+				Issuer:      cert.Issuer.String(),
+				Fingerprint: fp,
+				NotAfter:    cert.NotAfter,
+				Served:      indexOf(certs, fp) >= 0,
+				AIA:         indexOf(certs, fp) < 0 && i < len(path)-1,
+				Root:        i == len(path)-1,
+			})
+		}
+		c.Paths = append(c.Paths, p)
+	}
+
+	last := 0
+	for _, cert := range certs {
+		fp := fingerprint(cert)
+		if !inPath[fp] {
+			c.ExtraCerts = append(c.ExtraCerts, &ChainCert{
+				Subject:     cert.Subject.String(),
+				Issuer:      cert.Issuer.String(),
+				Fingerprint: fp,
+				NotAfter:    cert.NotAfter,
+				Served:      true,
+			})
+			continue
+		}
+		if i := indexOf(paths[0], fp); i >= 0 {
+			if i < last {
+				c.OutOfOrder = true
+			}
+			last = i
+		}
+	}
+	return c
+}
+
+func fingerprint(cert *stdx509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return hex.EncodeToString(sum[:])
+}
+
+func indexOf(certs []*stdx509.Certificate, fp string) int {
+	for i, cert := range certs {
+		if fingerprint(cert) == fp {
+			return i
+		}
+	}
+	return -1
+}
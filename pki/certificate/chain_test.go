@@ -0,0 +1,259 @@
+package pkicertificate
+
+import (
+	"bytes"
+	"encoding/asn1"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/zmap/zcrypto/x509"
+)
+
+// pkcs7 returns a degenerate PKCS#7 SignedData with ders, as served for
+// caIssuers.
+func pkcs7(t *testing.T, ders ...[]byte) []byte {
+	t.Helper()
+	signed, err := asn1.Marshal(struct {
+		Version          int
+		DigestAlgorithms asn1.RawValue
+		ContentInfo      struct{ ContentType asn1.ObjectIdentifier }
+		Certificates     asn1.RawValue
+	}{
+		Version:          1,
+		DigestAlgorithms: asn1.RawValue{Tag: asn1.TagSet, IsCompound: true},
+		ContentInfo:      struct{ ContentType asn1.ObjectIdentifier }{asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}},
+		Certificates:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: bytes.Join(ders, nil)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := asn1.Marshal(struct {
+		ContentType asn1.ObjectIdentifier
+		Content     asn1.RawValue
+	}{oidSignedData, asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: signed}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return info
+}
+
+func pemBlock(typ string, der []byte) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der})
+}
+
+func TestParseChain(t *testing.T) {
+	root := issue(t, "Root", nil, true, "")
+	leaf := issue(t, "localhost", root, false, "")
+
+	tests := []struct {
+		name  string
+		in    []byte
+		want  []string
+		error string
+	}{
+		{"PEM bundle", bytes.Join([][]byte{pemBlock("CERTIFICATE", leaf.der), pemBlock("PRIVATE KEY", []byte{1}), pemBlock("CERTIFICATE", root.der)}, nil), []string{"CN=localhost", "CN=Root"}, ""},
+		{"DER", root.der, []string{"CN=Root"}, ""},
+		{"garbage", []byte("not a certificate"), nil, "invalid certificate"},
+	}
+	for _, tt := range tests {
+		chain, err := ParseChain(tt.in)
+		if tt.error != "" {
+			if err == nil || err.Error() != tt.error {
+				t.Errorf("%s: got error %v, want %q", tt.name, err, tt.error)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		var got []string
+		for _, cert := range chain {
+			got = append(got, cert.Subject.String())
+		}
+		if strings.Join(got, "|") != strings.Join(tt.want, "|") {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestParsePKCS7(t *testing.T) {
+	root := issue(t, "Root", nil, true, "")
+	inter := issue(t, "Intermediate", root, true, "")
+	bundle := pkcs7(t, inter.der, root.der)
+	data, err := asn1.Marshal(struct {
+		ContentType asn1.ObjectIdentifier
+		Content     asn1.RawValue
+	}{asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}, asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: []byte{4, 0}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		in    []byte
+		certs int
+		error string
+	}{
+		{"DER", bundle, 2, ""},
+		{"PEM", pemBlock("PKCS7", bundle), 2, ""},
+		{"no certificates", pkcs7(t), 0, "no certificates in PKCS#7"},
+		{"data", data, 0, "not a PKCS#7 SignedData"},
+	}
+	for _, tt := range tests {
+		certs, err := parsePKCS7(tt.in)
+		if tt.error != "" {
+			if err == nil || err.Error() != tt.error {
+				t.Errorf("%s: got error %v, want %q", tt.name, err, tt.error)
+			}
+			continue
+		}
+		if err != nil || len(certs) != tt.certs {
+			t.Errorf("%s: got %d certificates (%v), want %d", tt.name, len(certs), err, tt.certs)
+		}
+	}
+}
+
+func TestVerifyChain(t *testing.T) {
+	root := issue(t, "Root", nil, true, "")
+	inter := issue(t, "Intermediate", root, true, "")
+	other := issue(t, "Other Root", nil, true, "")
+	aia := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/inter.cer":
+			w.Write(inter.der)
+		case "/inter.p7c":
+			w.Write(pkcs7(t, inter.der))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer aia.Close()
+
+	leaf := issue(t, "localhost", inter, false, "")
+	viaDER := issue(t, "localhost", inter, false, aia.URL+"/inter.cer")
+	viaPKCS7 := issue(t, "localhost", inter, false, aia.URL+"/inter.p7c")
+	missing := issue(t, "localhost", inter, false, aia.URL+"/missing.cer")
+	roots, err := RootsFromPEM("test", pemBlock("CERTIFICATE", root.der))
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherRoots, err := RootsFromPEM("other", pemBlock("CERTIFICATE", other.der))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	served := func(certs ...*testCert) []*x509.Certificate {
+		var chain []*x509.Certificate
+		for _, c := range certs {
+			parsed, err := x509.ParseCertificate(c.der)
+			if err != nil {
+				t.Fatal(err)
+			}
+			chain = append(chain, parsed)
+		}
+		return chain
+	}
+
+	tests := []struct {
+		name   string
+		served []*x509.Certificate
+		fqdn   string
+		roots  *RootStore
+		check  func(c *Chain) bool
+	}{
+		{"complete", served(leaf, inter), "localhost", roots, func(c *Chain) bool {
+			return c.Trusted && c.Complete && !c.NeedsAIA && !c.OutOfOrder && c.HostnameMatch && len(c.ExtraCerts) == 0 &&
+				len(c.Paths) == 1 && len(c.Paths[0]) == 3 && c.Paths[0][1].Served && c.Paths[0][2].Root && !c.Paths[0][2].Served
+		}},
+		{"AIA certificate", served(viaDER), "localhost", roots, func(c *Chain) bool {
+			return c.Trusted && !c.Complete && c.NeedsAIA && len(c.AIAFetched) == 1 && c.Paths[0][1].AIA
+		}},
+		{"AIA PKCS#7", served(viaPKCS7), "localhost", roots, func(c *Chain) bool {
+			return c.Trusted && c.NeedsAIA && len(c.AIAFetched) == 1
+		}},
+		{"AIA missing", served(missing), "localhost", roots, func(c *Chain) bool {
+			return !c.Trusted && !c.NeedsAIA && len(c.AIAFetched) == 0 && c.VerifyError != ""
+		}},
+		{"out of order", served(leaf, root, other, inter), "localhost", roots, func(c *Chain) bool {
+			return c.Trusted && c.Complete && c.OutOfOrder && len(c.ExtraCerts) == 1 && c.ExtraCerts[0].Subject == "CN=Other Root"
+		}},
+		{"other roots", served(leaf, inter), "localhost", otherRoots, func(c *Chain) bool {
+			return !c.Trusted && c.RootStore == "other" && strings.Contains(c.VerifyError, "unknown authority")
+		}},
+		{"other name", served(leaf, inter), "www.example.com", roots, func(c *Chain) bool {
+			return c.Trusted && !c.HostnameMatch && c.HostnameError != ""
+		}},
+		{"no certificates", nil, "localhost", roots, func(c *Chain) bool {
+			return !c.Trusted && c.VerifyError == "no certificates"
+		}},
+	}
+	for _, tt := range tests {
+		if c := VerifyChain(tt.served, tt.fqdn, tt.roots); !tt.check(c) {
+			t.Errorf("%s: got %+v", tt.name, c)
+		}
+	}
+}
+
+func TestGetWithRoots(t *testing.T) {
+	root := issue(t, "Root", nil, true, "")
+	inter := issue(t, "Intermediate", root, true, "")
+	port := tlsServer(t, issue(t, "localhost", inter, false, ""), inter.der)
+	roots, err := RootsFromPEM("test", pemBlock("CERTIFICATE", root.der))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if r := Get("localhost", port, "https"); r.Error != "" || len(r.Parsed) != 2 || r.Chain != nil {
+		t.Errorf("Get: got %+v, want the certificates without a chain", r)
+	}
+	r := GetWithRoots("localhost", port, "https", roots)
+	if r.Error != "" || r.Chain == nil || !r.Chain.Trusted || r.Chain.RootStore != "test" {
+		t.Errorf("GetWithRoots: got %+v, chain %+v", r, r.Chain)
+	}
+	r = GetWithOptions("localhost", port, "https", &Options{Verify: true})
+	if r.Chain == nil || r.Chain.Trusted || r.Chain.RootStore != "system" {
+		t.Errorf("system roots: got chain %+v", r.Chain)
+	}
+}
+
+func TestMozillaRoots(t *testing.T) {
+	root := issue(t, "Root", nil, true, "")
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/cacert.pem":
+			w.Write(pemBlock("CERTIFICATE", root.der))
+		case "/large.pem":
+			w.Write(bytes.Repeat([]byte("#"), maxBundleSize+1))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer s.Close()
+	defer func(url string) { MozillaBundleURL = url }(MozillaBundleURL)
+
+	tests := []struct {
+		path  string
+		error string
+	}{
+		{"/cacert.pem", ""},
+		{"/large.pem", "is larger than"},
+		{"/missing.pem", "returned 404 Not Found"},
+	}
+	for _, tt := range tests {
+		MozillaBundleURL = s.URL + tt.path
+		roots, err := MozillaRoots()
+		if tt.error != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.error) {
+				t.Errorf("%s: got error %v, want %q", tt.path, err, tt.error)
+			}
+			continue
+		}
+		if err != nil || roots.Name != "mozilla" {
+			t.Errorf("%s: got %+v, %v", tt.path, roots, err)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package pkicertificate
+
+import (
+	"errors"
+	"sort"
+
+	"github.com/zmap/zcrypto/x509"
+	"github.com/zmap/zlint/v3"
+	"github.com/zmap/zlint/v3/lint"
+)
+
+// Lint struct with the zlint results of one certificate. Only lints that
+// found something are listed.
+type Lint struct {
+	Subject  string        `json:"subject,omitempty"`
+	Errors   int           `json:"errors"`
+	Warnings int           `json:"warnings"`
+	Notices  int           `json:"notices"`
+	Results  []*LintResult `json:"results,omitempty"`
+}
+
+// LintResult struct for one lint
+type LintResult struct {
+	Name     string `json:"name"`
+	Source   string `json:"source"`
+	Status   string `json:"status"`
+	Details  string `json:"details,omitempty"`
+	Citation string `json:"citation,omitempty"`
+}
+
+// defaultLintSources are the zlint sources Options.Lint uses without
+// LintSources: the CA/Browser Forum Baseline Requirements, RFC 5280 and the
+// Mozilla root store policy.
+func defaultLintSources() []string {
+	return []string{
+		string(lint.CABFBaselineRequirements),
+		string(lint.RFC5280),
+		string(lint.MozillaRootStorePolicy),
+	}
+}
+
+// LintChain runs the lints of sources against every certificate of chain.
+// Empty sources means every source.
+func LintChain(chain []*x509.Certificate, sources []string) ([]*Lint, error) {
+	registry := lint.GlobalRegistry()
+	if len(sources) > 0 {
+		known := make(map[lint.LintSource]bool)
+		for _, source := range registry.Sources() {
+			known[source] = true
+		}
+		var include lint.SourceList
+		for _, source := range sources {
+			if !known[lint.LintSource(source)] {
+				return nil, errors.New("unknown lint source " + source)
+			}
+			include = append(include, lint.LintSource(source))
+		}
+		filtered, err := registry.Filter(lint.FilterOptions{IncludeSources: include})
+		if err != nil {
+			return nil, err
+		}
+		registry = filtered
+	}
+
+	var lints []*Lint
+	for _, cert := range chain {
+		l := &Lint{Subject: cert.Subject.String()}
+		results := zlint.LintCertificateEx(cert, registry)
+		for name, result := range results.Results {
+			switch result.Status {
+			case lint.Notice:
+				l.Notices++
+			case lint.Warn:
+				l.Warnings++
+			case lint.Error, lint.Fatal:
+				l.Errors++
+			default:
+				continue
+			}
+			r := &LintResult{Name: name, Status: result.Status.String(), Details: result.Details}
+			if meta := registry.ByName(name); meta != nil {
+				r.Source = string(meta.Source)
+				r.Citation = meta.Citation
+			}
+			l.Results = append(l.Results, r)
+		}
+		sort.Slice(l.Results, func(i, j int) bool { return l.Results[i].Name < l.Results[j].Name })
+		lints = append(lints, l)
+	}
+	return lints, nil
+}
@@ -0,0 +1,47 @@
+package pkicertificate
+
+import (
+	"testing"
+
+	"github.com/zmap/zcrypto/x509"
+)
+
+func TestLintChain(t *testing.T) {
+	leaf, err := x509.ParseCertificate(issue(t, "localhost", nil, false, "").der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chain := []*x509.Certificate{leaf}
+
+	lints, err := LintChain(chain, defaultLintSources())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lints) != 1 || lints[0].Subject != "CN=localhost" || lints[0].Errors == 0 {
+		t.Fatalf("got %+v, want errors for a bare self-signed certificate", lints)
+	}
+	sources := make(map[string]bool)
+	for _, s := range defaultLintSources() {
+		sources[s] = true
+	}
+	for _, r := range lints[0].Results {
+		if !sources[r.Source] {
+			t.Errorf("%s: got source %s", r.Name, r.Source)
+		}
+		if r.Status == "pass" || r.Status == "NA" {
+			t.Errorf("%s: got status %s", r.Name, r.Status)
+		}
+	}
+
+	all, err := LintChain(chain, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all[0].Results) < len(lints[0].Results) {
+		t.Errorf("every source: got %d results, want at least %d", len(all[0].Results), len(lints[0].Results))
+	}
+
+	if _, err := LintChain(chain, []string{"nope"}); err == nil || err.Error() != "unknown lint source nope" {
+		t.Errorf("unknown source: got %v", err)
+	}
+}
@@ -0,0 +1,280 @@
+package pkicertificate
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// upgrader speaks a protocol on conn up to the point where the server
+// expects the TLS handshake.
+type upgrader func(conn net.Conn, fqdn string) error
+
+var startTLS = map[string]upgrader{
+	"imap":        imapStartTLS,
+	"pop3":        pop3STLS,
+	"xmpp":        xmppStartTLS("jabber:client"),
+	"xmpp-server": xmppStartTLS("jabber:server"),
+	"ldap":        ldapStartTLS,
+	"ftp":         ftpAuthTLS,
+	"postgres":    postgresSSLRequest,
+}
+
+// dialStartTLS connects, upgrades and does the TLS handshake.
+func dialStartTLS(fqdn string, port int, upgrade upgrader) (tls.ConnectionState, error) {
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(fqdn, strconv.Itoa(port)), DialTimeout)
+	if err != nil {
+		return tls.ConnectionState{}, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(Timeout))
+
+	if err := upgrade(conn, fqdn); err != nil {
+		return tls.ConnectionState{}, err
+	}
+
+	tlsConn := tls.Client(conn, &tls.Config{
+		InsecureSkipVerify: true,
+		ServerName:         fqdn,
+	})
+	if err := tlsConn.Handshake(); err != nil {
+		return tls.ConnectionState{}, err
+	}
+	return tlsConn.ConnectionState(), nil
+}
+
+// imapStartTLS implements RFC 3501 section 6.2.1.
+func imapStartTLS(conn net.Conn, fqdn string) error {
+	r := bufio.NewReader(conn)
+	greeting, err := r.ReadString('\n')
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(greeting, "* OK") {
+		return errors.New("IMAP greeting: " + strings.TrimSpace(greeting))
+	}
+	if _, err := io.WriteString(conn, "a001 STARTTLS\r\n"); err != nil {
+		return err
+	}
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		if strings.HasPrefix(line, "a001 ") {
+			if !strings.HasPrefix(line, "a001 OK") {
+				return errors.New("IMAP STARTTLS: " + strings.TrimSpace(line))
+			}
+			return nil
+		}
+	}
+}
+
+// pop3STLS implements RFC 2595 section 4.
+func pop3STLS(conn net.Conn, fqdn string) error {
+	r := bufio.NewReader(conn)
+	greeting, err := r.ReadString('\n')
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(greeting, "+OK") {
+		return errors.New("POP3 greeting: " + strings.TrimSpace(greeting))
+	}
+	if _, err := io.WriteString(conn, "STLS\r\n"); err != nil {
+		return err
+	}
+	reply, err := r.ReadString('\n')
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(reply, "+OK") {
+		return errors.New("POP3 STLS: " + strings.TrimSpace(reply))
+	}
+	return nil
+}
+
+// xmppStartTLS implements RFC 6120 section 5 for a client or server
+// stream namespace.
+func xmppStartTLS(namespace string) upgrader {
+	return func(conn net.Conn, fqdn string) error {
+		stream := "<?xml version='1.0'?><stream:stream to='" + fqdn + "' xmlns='" + namespace + "' xmlns:stream='http://etherx.jabber.org/streams' version='1.0'>"
+		if _, err := io.WriteString(conn, stream); err != nil {
+			return err
+		}
+		features, err := readUntil(conn, "</stream:features>", "</stream:stream>")
+		if err != nil {
+			return err
+		}
+		if !strings.Contains(features, "urn:ietf:params:xml:ns:xmpp-tls") {
+			return errors.New("XMPP server does not offer STARTTLS")
+		}
+		if _, err := io.WriteString(conn, "<starttls xmlns='urn:ietf:params:xml:ns:xmpp-tls'/>"); err != nil {
+			return err
+		}
+		reply, err := readUntil(conn, "<proceed", "<failure")
+		if err != nil {
+			return err
+		}
+		if !strings.Contains(reply, "<proceed") {
+			return errors.New("XMPP STARTTLS failed")
+		}
+		return nil
+	}
+}
+
+// readUntil reads from conn until one of the markers is seen. It reads byte
+// by byte so nothing of the TLS handshake that follows is consumed.
+func readUntil(conn net.Conn, markers ...string) (string, error) {
+	var buf bytes.Buffer
+	b := make([]byte, 1)
+	for buf.Len() < 64*1024 {
+		if _, err := conn.Read(b); err != nil {
+			return buf.String(), err
+		}
+		buf.WriteByte(b[0])
+		if b[0] != '>' {
+			continue
+		}
+		for _, marker := range markers {
+			if strings.Contains(buf.String(), marker) {
+				return buf.String(), nil
+			}
+		}
+	}
+	return buf.String(), errors.New("no reply within 64 KB")
+}
+
+// ldapStartTLS sends the StartTLS extended request (RFC 4511 section 4.14)
+// and checks the result code of the extended response.
+func ldapStartTLS(conn net.Conn, fqdn string) error {
+	oid := "1.3.6.1.4.1.1466.20037"
+	request := berTLV(0x30, append(
+		berTLV(0x02, []byte{1}),
+		berTLV(0x77, berTLV(0x80, []byte(oid)))...,
+	))
+	if _, err := conn.Write(request); err != nil {
+		return err
+	}
+
+	tag, message, err := readBER(conn)
+	if err != nil {
+		return err
+	}
+	if tag != 0x30 {
+		return errors.New("LDAP: invalid response")
+	}
+	// The message holds the messageID and the ExtendedResponse, which
+	// starts with the resultCode.
+	_, _, rest, err := splitBER(message)
+	if err != nil {
+		return err
+	}
+	tag, response, _, err := splitBER(rest)
+	if err != nil || tag != 0x78 {
+		return errors.New("LDAP: no extended response")
+	}
+	tag, code, _, err := splitBER(response)
+	if err != nil || tag != 0x0a || len(code) != 1 {
+		return errors.New("LDAP: invalid result code")
+	}
+	if code[0] != 0 {
+		return errors.New("LDAP StartTLS: result code " + strconv.Itoa(int(code[0])))
+	}
+	return nil
+}
+
+func berTLV(tag byte, value []byte) []byte {
+	out := []byte{tag}
+	if len(value) < 0x80 {
+		out = append(out, byte(len(value)))
+	} else {
+		length := make([]byte, 4)
+		binary.BigEndian.PutUint32(length, uint32(len(value)))
+		out = append(out, 0x84)
+		out = append(out, length...)
+	}
+	return append(out, value...)
+}
+
+// readBER reads one BER element from r.
+func readBER(r io.Reader) (byte, []byte, error) {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return 0, nil, err
+	}
+	length := int(header[1])
+	if length&0x80 != 0 {
+		n := length & 0x7f
+		if n == 0 || n > 4 {
+			return 0, nil, errors.New("unsupported BER length")
+		}
+		b := make([]byte, n)
+		if _, err := io.ReadFull(r, b); err != nil {
+			return 0, nil, err
+		}
+		length = 0
+		for _, c := range b {
+			length = length<<8 | int(c)
+		}
+	}
+	if length > 1024*1024 {
+		return 0, nil, errors.New("BER element too large")
+	}
+	value := make([]byte, length)
+	if _, err := io.ReadFull(r, value); err != nil {
+		return 0, nil, err
+	}
+	return header[0], value, nil
+}
+
+// splitBER returns the tag and value of the first element of data and the
+// data after it.
+func splitBER(data []byte) (byte, []byte, []byte, error) {
+	r := bytes.NewReader(data)
+	tag, value, err := readBER(r)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	return tag, value, data[len(data)-r.Len():], nil
+}
+
+// ftpAuthTLS implements RFC 4217 section 4.
+func ftpAuthTLS(conn net.Conn, fqdn string) error {
+	text := textproto.NewConn(conn)
+	if _, _, err := text.ReadResponse(220); err != nil {
+		return err
+	}
+	if err := text.PrintfLine("AUTH TLS"); err != nil {
+		return err
+	}
+	if _, _, err := text.ReadResponse(234); err != nil {
+		return err
+	}
+	return nil
+}
+
+// postgresSSLRequest sends an SSLRequest and expects an S.
+func postgresSSLRequest(conn net.Conn, fqdn string) error {
+	request := make([]byte, 8)
+	binary.BigEndian.PutUint32(request[0:4], 8)
+	binary.BigEndian.PutUint32(request[4:8], 80877103)
+	if _, err := conn.Write(request); err != nil {
+		return err
+	}
+	reply := make([]byte, 1)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return err
+	}
+	if reply[0] != 'S' {
+		return errors.New("PostgreSQL server does not support SSL")
+	}
+	return nil
+}
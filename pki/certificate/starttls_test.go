@@ -0,0 +1,197 @@
+package pkicertificate
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"io"
+	"net"
+	"strings"
+	"testing"
+)
+
+// standIn runs a server on localhost that speaks a protocol through
+// upgrade and starts TLS with leaf when it returns true, and returns its
+// port.
+func standIn(t *testing.T, leaf *testCert, upgrade func(conn net.Conn, r *bufio.Reader) bool) int {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	config := &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{leaf.der}, PrivateKey: leaf.key}}}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				if upgrade(conn, bufio.NewReader(conn)) {
+					tls.Server(conn, config).Handshake()
+				}
+			}()
+		}
+	}()
+	return l.Addr().(*net.TCPAddr).Port
+}
+
+// lineServer greets, expects command and replies with reply, it starts TLS
+// when ok is set.
+func lineServer(greeting, command, reply string, ok bool) func(conn net.Conn, r *bufio.Reader) bool {
+	return func(conn net.Conn, r *bufio.Reader) bool {
+		io.WriteString(conn, greeting)
+		line, err := r.ReadString('\n')
+		if err != nil || strings.TrimSpace(line) != command {
+			return false
+		}
+		io.WriteString(conn, reply)
+		return ok
+	}
+}
+
+// smtpServer offers STARTTLS after EHLO and answers the EHLO that follows
+// the handshake with leaf.
+func smtpServer(leaf *testCert) func(conn net.Conn, r *bufio.Reader) bool {
+	return func(conn net.Conn, r *bufio.Reader) bool {
+		io.WriteString(conn, "220 mx.example.com ESMTP\r\n")
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return false
+			}
+			switch strings.TrimSpace(line) {
+			case "EHLO localhost":
+				io.WriteString(conn, "250-mx.example.com\r\n250 STARTTLS\r\n")
+			case "STARTTLS":
+				io.WriteString(conn, "220 2.0.0 Ready to start TLS\r\n")
+				tlsConn := tls.Server(conn, &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{leaf.der}, PrivateKey: leaf.key}}})
+				conn, r = tlsConn, bufio.NewReader(tlsConn)
+			case "QUIT":
+				io.WriteString(conn, "221 2.0.0 Bye\r\n")
+				return false
+			default:
+				io.WriteString(conn, "502 5.5.2 Error: command not recognized\r\n")
+			}
+		}
+	}
+}
+
+// ldapServer answers the StartTLS extended request with resultCode.
+func ldapServer(resultCode byte) func(conn net.Conn, r *bufio.Reader) bool {
+	return func(conn net.Conn, r *bufio.Reader) bool {
+		tag, message, err := readBER(r)
+		if err != nil || tag != 0x30 || !bytes.Contains(message, []byte("1.3.6.1.4.1.1466.20037")) {
+			return false
+		}
+		response := append(berTLV(0x0a, []byte{resultCode}), berTLV(0x04, nil)...)
+		response = append(response, berTLV(0x04, nil)...)
+		conn.Write(berTLV(0x30, append(berTLV(0x02, []byte{1}), berTLV(0x78, response)...)))
+		return resultCode == 0
+	}
+}
+
+// xmppServer offers STARTTLS when offer is set and answers it with reply.
+func xmppServer(namespace string, offer bool, reply string) func(conn net.Conn, r *bufio.Reader) bool {
+	return func(conn net.Conn, r *bufio.Reader) bool {
+		stream, err := r.ReadString('>')
+		if err == nil && !strings.Contains(stream, "<?xml") {
+			return false
+		}
+		stream, err = r.ReadString('>')
+		if err != nil || !strings.Contains(stream, "xmlns='"+namespace+"'") {
+			return false
+		}
+		features := "<stream:features></stream:features>"
+		if offer {
+			features = "<stream:features><starttls xmlns='urn:ietf:params:xml:ns:xmpp-tls'><required/></starttls></stream:features>"
+		}
+		io.WriteString(conn, "<?xml version='1.0'?><stream:stream xmlns='"+namespace+"' xmlns:stream='http://etherx.jabber.org/streams' version='1.0'>"+features)
+		if !offer {
+			io.WriteString(conn, "</stream:stream>")
+			return false
+		}
+		starttls, err := r.ReadString('>')
+		if err != nil || !strings.HasPrefix(starttls, "<starttls") {
+			return false
+		}
+		io.WriteString(conn, reply)
+		return strings.HasPrefix(reply, "<proceed")
+	}
+}
+
+// postgresServer answers the SSLRequest with reply.
+func postgresServer(reply string) func(conn net.Conn, r *bufio.Reader) bool {
+	return func(conn net.Conn, r *bufio.Reader) bool {
+		request := make([]byte, 8)
+		if _, err := io.ReadFull(r, request); err != nil || !bytes.Equal(request, []byte{0, 0, 0, 8, 0x04, 0xd2, 0x16, 0x2f}) {
+			return false
+		}
+		io.WriteString(conn, reply)
+		return reply == "S"
+	}
+}
+
+func TestStartTLS(t *testing.T) {
+	leaf := issue(t, "localhost", nil, false, "")
+
+	tests := []struct {
+		protocol string
+		upgrade  func(conn net.Conn, r *bufio.Reader) bool
+		error    string
+	}{
+		{"imap", lineServer("* OK IMAP4rev1 ready\r\n", "a001 STARTTLS", "* CAPABILITY IMAP4rev1\r\na001 OK Begin TLS negotiation now\r\n", true), ""},
+		{"imap", lineServer("* OK IMAP4rev1 ready\r\n", "a001 STARTTLS", "a001 BAD unknown command\r\n", false), "IMAP STARTTLS: a001 BAD unknown command"},
+		{"imap", lineServer("* BYE too many connections\r\n", "", "", false), "IMAP greeting: * BYE too many connections"},
+		{"pop3", lineServer("+OK POP3 ready\r\n", "STLS", "+OK Begin TLS negotiation\r\n", true), ""},
+		{"pop3", lineServer("+OK POP3 ready\r\n", "STLS", "-ERR command not permitted\r\n", false), "POP3 STLS: -ERR command not permitted"},
+		{"xmpp", xmppServer("jabber:client", true, "<proceed xmlns='urn:ietf:params:xml:ns:xmpp-tls'/>"), ""},
+		{"xmpp-server", xmppServer("jabber:server", true, "<proceed xmlns='urn:ietf:params:xml:ns:xmpp-tls'/>"), ""},
+		{"xmpp", xmppServer("jabber:client", false, ""), "XMPP server does not offer STARTTLS"},
+		{"xmpp", xmppServer("jabber:client", true, "<failure xmlns='urn:ietf:params:xml:ns:xmpp-tls'/>"), "XMPP STARTTLS failed"},
+		{"ldap", ldapServer(0), ""},
+		{"ldap", ldapServer(2), "LDAP StartTLS: result code 2"},
+		{"ftp", lineServer("220-Welcome\r\n220 FTP server ready\r\n", "AUTH TLS", "234 AUTH TLS successful\r\n", true), ""},
+		{"ftp", lineServer("220 FTP server ready\r\n", "AUTH TLS", "502 Command not implemented\r\n", false), "Command not implemented"},
+		{"postgres", postgresServer("S"), ""},
+		{"postgres", postgresServer("N"), "PostgreSQL server does not support SSL"},
+		{"smtp", smtpServer(leaf), ""},
+	}
+	for _, tt := range tests {
+		r := Get("localhost", standIn(t, leaf, tt.upgrade), tt.protocol)
+		if tt.error != "" {
+			if !strings.Contains(r.ErrorMessage, tt.error) {
+				t.Errorf("%s: got error %q, want %q", tt.protocol, r.ErrorMessage, tt.error)
+			}
+			continue
+		}
+		if r.Error != "" || len(r.Parsed) != 1 || r.Parsed[0].Subject.CommonName != "localhost" {
+			t.Errorf("%s: got %d certificates (%s)", tt.protocol, len(r.Parsed), r.ErrorMessage)
+		}
+	}
+
+	if r := Get("localhost", tlsServer(t, leaf), "imaps"); r.Error != "" || len(r.Parsed) != 1 {
+		t.Errorf("imaps: got %d certificates (%s)", len(r.Parsed), r.ErrorMessage)
+	}
+	if r := Get("localhost", tlsServer(t, leaf), "gopher"); r.ErrorMessage != "Unknown protocol gopher." {
+		t.Errorf("unknown protocol: got %q", r.ErrorMessage)
+	}
+}
+
+func TestBER(t *testing.T) {
+	for _, size := range []int{0, 1, 127, 128, 300, 70000} {
+		value := bytes.Repeat([]byte{7}, size)
+		data := append(berTLV(0x04, value), 0xff)
+		tag, got, rest, err := splitBER(data)
+		if err != nil || tag != 0x04 || !bytes.Equal(got, value) || !bytes.Equal(rest, []byte{0xff}) {
+			t.Errorf("%d bytes: got tag %x, %d bytes, rest %x, %v", size, tag, len(got), rest, err)
+		}
+	}
+	for _, data := range [][]byte{{0x04}, {0x04, 0x80}, {0x04, 0x85, 1, 1, 1, 1, 1}, {0x04, 0x84, 0, 0x20, 0, 0}, {0x04, 0x02, 1}} {
+		if _, _, _, err := splitBER(data); err == nil {
+			t.Errorf("%x: got no error", data)
+		}
+	}
+}
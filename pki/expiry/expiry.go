@@ -0,0 +1,298 @@
+package pkiexpiry
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	pkicertificate "github.com/binaryfigments/goharvest/pki/certificate"
+)
+
+// Target struct for one host of the inventory
+type Target struct {
+	FQDN     string `json:"fqdn"`
+	Port     int    `json:"port"`
+	Protocol string `json:"protocol"`
+}
+
+// Report struct with the expiry of every certificate of the inventory and
+// the alerts of this run
+type Report struct {
+	CheckTime    time.Time `json:"time"`
+	Hosts        []*Host   `json:"hosts,omitempty"`
+	Alerts       []*Alert  `json:"alerts,omitempty"`
+	NotifyErrors []string  `json:"notifyerrors,omitempty"`
+	Error        string    `json:"error,omitempty"`
+	ErrorMessage string    `json:"errormessage,omitempty"`
+}
+
+// Host struct with the certificates of one target
+type Host struct {
+	Target
+	Certificates []*Certificate `json:"certificates,omitempty"`
+	Error        string         `json:"error,omitempty"`
+	ErrorMessage string         `json:"errormessage,omitempty"`
+}
+
+// Certificate struct with the expiry of a leaf or intermediate certificate
+type Certificate struct {
+	Subject       string    `json:"subject,omitempty"`
+	Fingerprint   string    `json:"sha256"`
+	Leaf          bool      `json:"leaf"`
+	NotAfter      time.Time `json:"notafter"`
+	DaysRemaining int       `json:"daysremaining"`
+}
+
+// Alert struct for a certificate that reached a threshold. Threshold 0 is
+// an expired certificate.
+type Alert struct {
+	Target
+	Certificate
+	Threshold int `json:"threshold"`
+}
+
+// DefaultThresholds are the days before expiry that alert.
+var DefaultThresholds = []int{30, 14, 7, 1}
+
+// Monitor struct with the settings of the expiry monitor. Every threshold
+// alerts once per certificate, the alerts sent are kept in StateFile. An
+// empty StateFile keeps no state, so every run alerts again.
+type Monitor struct {
+	Thresholds  []int
+	Notifiers   []Notifier
+	StateFile   string
+	Concurrency int
+}
+
+// Run harvests the certificates of every target, alerts and saves the
+// state. Alerts are only recorded as sent when every notifier succeeded,
+// a failed notification is repeated in the next run.
+func (m *Monitor) Run(targets []*Target) *Report {
+	r := new(Report)
+	r.CheckTime = time.Now()
+
+	st, err := loadState(m.StateFile)
+	if err != nil {
+		r.Error = "Failed"
+		r.ErrorMessage = err.Error()
+		return r
+	}
+
+	r.Hosts = harvest(targets, m.Concurrency, r.CheckTime)
+
+	thresholds := m.Thresholds
+	if len(thresholds) == 0 {
+		thresholds = DefaultThresholds
+	}
+	seen := make(map[string]int)
+	for _, h := range r.Hosts {
+		if h.Error != "" {
+			// Keep the state of a host that could not be checked, an
+			// outage should not repeat its alerts.
+			for key, threshold := range st.Alerted {
+				if strings.HasPrefix(key, stateKey(&h.Target, "")) {
+					seen[key] = threshold
+				}
+			}
+			continue
+		}
+		for _, c := range h.Certificates {
+			threshold, ok := crossed(thresholds, c.DaysRemaining)
+			key := stateKey(&h.Target, c.Fingerprint)
+			previous, alerted := st.Alerted[key]
+			switch {
+			case !ok:
+				continue
+			case alerted && previous <= threshold:
+				seen[key] = previous
+				continue
+			}
+			seen[key] = threshold
+			r.Alerts = append(r.Alerts, &Alert{Target: h.Target, Certificate: *c, Threshold: threshold})
+		}
+	}
+
+	if len(r.Alerts) > 0 {
+		for _, n := range m.Notifiers {
+			if err := n.Notify(r.Alerts); err != nil {
+				r.NotifyErrors = append(r.NotifyErrors, err.Error())
+			}
+		}
+	}
+	if len(r.NotifyErrors) > 0 {
+		for _, a := range r.Alerts {
+			key := stateKey(&a.Target, a.Fingerprint)
+			if previous, ok := st.Alerted[key]; ok {
+				seen[key] = previous
+			} else {
+				delete(seen, key)
+			}
+		}
+	}
+
+	// Certificates that are gone, renewed or no longer near a threshold
+	// are dropped from the state.
+	st.Alerted = seen
+	if err := st.save(m.StateFile); err != nil {
+		r.Error = "Failed"
+		r.ErrorMessage = err.Error()
+	}
+	return r
+}
+
+// LoadInventory reads targets from a file with one target per line: a
+// host, optionally followed by a port (default 443) and a protocol
+// (default https) as pkicertificate.Get takes them. Empty lines and lines
+// starting with # are skipped.
+func LoadInventory(path string) ([]*Target, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var targets []*Target
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		t := &Target{FQDN: fields[0], Port: 443, Protocol: "https"}
+		if len(fields) > 1 {
+			t.Port, err = strconv.Atoi(fields[1])
+			if err != nil {
+				return nil, errors.New("line " + strconv.Itoa(n) + ": invalid port " + fields[1])
+			}
+		}
+		if len(fields) > 2 {
+			t.Protocol = fields[2]
+		}
+		targets = append(targets, t)
+	}
+	return targets, scanner.Err()
+}
+
+/*
+ * Used functions
+ */
+
+// harvest gets the certificates of the targets, concurrency at a time.
+func harvest(targets []*Target, concurrency int, now time.Time) []*Host {
+	if concurrency < 1 {
+		concurrency = 10
+	}
+	hosts := make([]*Host, len(targets))
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i, t := range targets {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, t *Target) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			hosts[i] = check(t, now)
+		}(i, t)
+	}
+	wg.Wait()
+	return hosts
+}
+
+func check(t *Target, now time.Time) *Host {
+	h := &Host{Target: *t}
+	certs := pkicertificate.Get(t.FQDN, t.Port, t.Protocol)
+	if certs.Error != "" {
+		h.Error = certs.Error
+		h.ErrorMessage = certs.ErrorMessage
+		return h
+	}
+	for i, cert := range certs.Parsed {
+		// Self-signed roots sent along are not monitored.
+		if i > 0 && cert.Subject.String() == cert.Issuer.String() {
+			continue
+		}
+		sum := sha256.Sum256(cert.Raw)
+		h.Certificates = append(h.Certificates, &Certificate{
+			Subject:       cert.Subject.String(),
+			Fingerprint:   hex.EncodeToString(sum[:]),
+			Leaf:          i == 0,
+			NotAfter:      cert.NotAfter,
+			DaysRemaining: int(math.Floor(cert.NotAfter.Sub(now).Hours() / 24)),
+		})
+	}
+	return h
+}
+
+// crossed returns the smallest threshold at or above days, or 0 for an
+// expired certificate.
+func crossed(thresholds []int, days int) (int, bool) {
+	if days < 0 {
+		return 0, true
+	}
+	sorted := append([]int(nil), thresholds...)
+	sort.Ints(sorted)
+	for _, t := range sorted {
+		if days <= t {
+			return t, true
+		}
+	}
+	return 0, false
+}
+
+// stateKey returns the state key of a certificate of a target, or the
+// prefix of the keys of a target for an empty fingerprint.
+func stateKey(t *Target, fingerprint string) string {
+	return t.FQDN + ":" + strconv.Itoa(t.Port) + " " + fingerprint
+}
+
+// state holds the smallest threshold alerted per host and certificate.
+type state struct {
+	Alerted map[string]int `json:"alerted"`
+}
+
+func loadState(path string) (*state, error) {
+	st := &state{Alerted: make(map[string]int)}
+	if path == "" {
+		return st, nil
+	}
+	in, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return st, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(in, st); err != nil {
+		return nil, errors.New("state file " + path + ": " + err.Error())
+	}
+	if st.Alerted == nil {
+		st.Alerted = make(map[string]int)
+	}
+	return st, nil
+}
+
+// save writes the state to a temporary file and renames it, so a crash
+// does not leave a broken state file.
+func (st *state) save(path string) error {
+	if path == "" {
+		return nil
+	}
+	out, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(path+".tmp", out, 0600); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
@@ -0,0 +1,216 @@
+package pkiexpiry
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCrossed(t *testing.T) {
+	tests := []struct {
+		days      int
+		threshold int
+		ok        bool
+	}{
+		{-1, 0, true},
+		{0, 1, true},
+		{1, 1, true},
+		{5, 7, true},
+		{10, 14, true},
+		{30, 30, true},
+		{31, 0, false},
+		{40, 0, false},
+	}
+	for _, tt := range tests {
+		threshold, ok := crossed([]int{30, 7, 14, 1}, tt.days)
+		if threshold != tt.threshold || ok != tt.ok {
+			t.Errorf("%d days: got %d %v, want %d %v", tt.days, threshold, ok, tt.threshold, tt.ok)
+		}
+	}
+}
+
+func TestLoadInventory(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	targets, err := LoadInventory(write("ok", "# hosts\nwww.example.com\n\nmail.example.com 25 smtp\n  ldap.example.com 636\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, t := range targets {
+		got = append(got, t.FQDN+" "+strconv.Itoa(t.Port)+" "+t.Protocol)
+	}
+	want := "www.example.com 443 https|mail.example.com 25 smtp|ldap.example.com 636 https"
+	if strings.Join(got, "|") != want {
+		t.Errorf("got %q, want %q", strings.Join(got, "|"), want)
+	}
+
+	if _, err := LoadInventory(write("bad", "www.example.com\nmail.example.com smtp\n")); err == nil || err.Error() != "line 2: invalid port smtp" {
+		t.Errorf("invalid port: got %v", err)
+	}
+	if _, err := LoadInventory(filepath.Join(dir, "missing")); err == nil {
+		t.Error("missing file: got no error")
+	}
+}
+
+// tlsServer serves a leaf certificate that expires in days, with its self-
+// signed issuer, and returns the port. Connections are dropped before the
+// handshake while down is set.
+func tlsServer(t *testing.T, days int, down *atomic.Bool) int {
+	t.Helper()
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().AddDate(1, 0, 0),
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, ca, ca, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Duration(days)*24*time.Hour + time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	leafDER, err := x509.CreateCertificate(rand.Reader, leaf, ca, &key.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{leafDER, caDER}, PrivateKey: key}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			if !down.Load() {
+				conn.(*tls.Conn).Handshake()
+			}
+			conn.Close()
+		}
+	}()
+	return l.Addr().(*net.TCPAddr).Port
+}
+
+// recorder is a Notifier that keeps the thresholds it was sent and fails
+// with err.
+type recorder struct {
+	thresholds []int
+	err        error
+}
+
+func (n *recorder) Notify(alerts []*Alert) error {
+	for _, a := range alerts {
+		n.thresholds = append(n.thresholds, a.Threshold)
+	}
+	return n.err
+}
+
+func TestMonitorRun(t *testing.T) {
+	var down atomic.Bool
+	targets := []*Target{{FQDN: "127.0.0.1", Port: tlsServer(t, 10, &down), Protocol: "https"}}
+
+	// Every step runs against the state the previous steps left.
+	tests := []struct {
+		name       string
+		down       bool
+		thresholds []int
+		err        error
+		alerts     []int
+		state      int
+	}{
+		{name: "first alert", alerts: []int{14}, state: 1},
+		{name: "alerted before", state: 1},
+		{name: "lower threshold", thresholds: []int{30, 11, 10}, alerts: []int{10}, state: 1},
+		{name: "no longer crossed", thresholds: []int{5}, state: 0},
+		{name: "notify failed", err: errors.New("mail server down"), alerts: []int{14}, state: 0},
+		{name: "repeated after failure", alerts: []int{14}, state: 1},
+		{name: "host down", down: true, state: 1},
+		{name: "back up", state: 1},
+	}
+	stateFile := filepath.Join(t.TempDir(), "state.json")
+	for _, tt := range tests {
+		n := &recorder{err: tt.err}
+		m := &Monitor{Thresholds: tt.thresholds, Notifiers: []Notifier{n}, StateFile: stateFile}
+		down.Store(tt.down)
+		r := m.Run(targets)
+		if r.Error != "" {
+			t.Fatalf("%s: %s", tt.name, r.ErrorMessage)
+		}
+		if h := r.Hosts[0]; tt.down != (h.Error != "") || !tt.down && (len(h.Certificates) != 1 || !h.Certificates[0].Leaf) {
+			t.Fatalf("%s: got host %+v", tt.name, h)
+		}
+		if itoas(n.thresholds) != itoas(tt.alerts) {
+			t.Errorf("%s: got alerts %v, want %v", tt.name, n.thresholds, tt.alerts)
+		}
+		if (len(r.NotifyErrors) > 0) != (tt.err != nil) {
+			t.Errorf("%s: got notify errors %v", tt.name, r.NotifyErrors)
+		}
+		st, err := loadState(stateFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(st.Alerted) != tt.state {
+			t.Errorf("%s: got state %v, want %d entries", tt.name, st.Alerted, tt.state)
+		}
+	}
+
+	if err := ioutil.WriteFile(stateFile, []byte("{"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	m := &Monitor{StateFile: stateFile}
+	if r := m.Run(targets); !strings.HasPrefix(r.ErrorMessage, "state file ") {
+		t.Errorf("broken state: got %q", r.ErrorMessage)
+	}
+}
+
+func itoas(n []int) string {
+	var s []string
+	for _, i := range n {
+		s = append(s, strconv.Itoa(i))
+	}
+	return strings.Join(s, " ")
+}
@@ -0,0 +1,103 @@
+package pkiexpiry
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/smtp"
+	"os"
+	"strings"
+	"time"
+)
+
+// Notifier sends the alerts of a run.
+type Notifier interface {
+	Notify(alerts []*Alert) error
+}
+
+// StdoutNotifier writes one line per alert to Writer, os.Stdout when nil.
+type StdoutNotifier struct {
+	Writer io.Writer
+}
+
+// Notify writes the alerts.
+func (n *StdoutNotifier) Notify(alerts []*Alert) error {
+	w := n.Writer
+	if w == nil {
+		w = os.Stdout
+	}
+	for _, a := range alerts {
+		if _, err := fmt.Fprintln(w, a.String()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WebhookNotifier posts the alerts as JSON to URL.
+type WebhookNotifier struct {
+	URL    string
+	Client *http.Client
+}
+
+// Notify posts {"alerts": [...]}, any status other than 2xx is an error.
+func (n *WebhookNotifier) Notify(alerts []*Alert) error {
+	body, err := json.Marshal(struct {
+		Alerts []*Alert `json:"alerts"`
+	}{alerts})
+	if err != nil {
+		return err
+	}
+	client := n.Client
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	resp, err := client.Post(n.URL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("webhook %s returned %s", n.URL, resp.Status)
+	}
+	return nil
+}
+
+// SMTPNotifier mails the alerts through the server at Addr (host:port).
+// Auth may be nil.
+type SMTPNotifier struct {
+	Addr string
+	Auth smtp.Auth
+	From string
+	To   []string
+}
+
+// Notify sends one message with every alert.
+func (n *SMTPNotifier) Notify(alerts []*Alert) error {
+	var body strings.Builder
+	fmt.Fprintf(&body, "From: %s\r\n", n.From)
+	fmt.Fprintf(&body, "To: %s\r\n", strings.Join(n.To, ", "))
+	fmt.Fprintf(&body, "Subject: %d certificate expiry alert(s)\r\n", len(alerts))
+	fmt.Fprintf(&body, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	body.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	for _, a := range alerts {
+		body.WriteString(a.String() + "\r\n")
+	}
+	return smtp.SendMail(n.Addr, n.Auth, n.From, n.To, []byte(body.String()))
+}
+
+// String describes the alert in one line.
+func (a *Alert) String() string {
+	kind := "intermediate"
+	if a.Leaf {
+		kind = "leaf"
+	}
+	when := fmt.Sprintf("expires in %d day(s)", a.DaysRemaining)
+	if a.DaysRemaining < 0 {
+		when = fmt.Sprintf("expired %d day(s) ago", -a.DaysRemaining)
+	}
+	return fmt.Sprintf("%s:%d %s certificate %s %s on %s (threshold %d)",
+		a.FQDN, a.Port, kind, a.Subject, when, a.NotAfter.Format("2006-01-02"), a.Threshold)
+}
@@ -0,0 +1,71 @@
+package pkiexpiry
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testAlerts() []*Alert {
+	notAfter := time.Date(2030, 1, 2, 12, 0, 0, 0, time.UTC)
+	return []*Alert{
+		{
+			Target:      Target{FQDN: "www.example.com", Port: 443, Protocol: "https"},
+			Certificate: Certificate{Subject: "CN=www.example.com", Fingerprint: "aa", Leaf: true, NotAfter: notAfter, DaysRemaining: 10},
+			Threshold:   14,
+		},
+		{
+			Target:      Target{FQDN: "mail.example.com", Port: 25, Protocol: "smtp"},
+			Certificate: Certificate{Subject: "CN=Example CA", Fingerprint: "bb", NotAfter: notAfter, DaysRemaining: -3},
+			Threshold:   0,
+		},
+	}
+}
+
+func TestStdoutNotifier(t *testing.T) {
+	var buf bytes.Buffer
+	if err := (&StdoutNotifier{Writer: &buf}).Notify(testAlerts()); err != nil {
+		t.Fatal(err)
+	}
+	want := "www.example.com:443 leaf certificate CN=www.example.com expires in 10 day(s) on 2030-01-02 (threshold 14)\n" +
+		"mail.example.com:25 intermediate certificate CN=Example CA expired 3 day(s) ago on 2030-01-02 (threshold 0)\n"
+	if buf.String() != want {
+		t.Errorf("got %q, want %q", buf.String(), want)
+	}
+}
+
+func TestWebhookNotifier(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		err    string
+	}{
+		{"ok", http.StatusOK, ""},
+		{"accepted", http.StatusAccepted, ""},
+		{"server error", http.StatusInternalServerError, "returned 500 Internal Server Error"},
+	}
+	for _, tt := range tests {
+		var got struct {
+			Alerts []*Alert `json:"alerts"`
+		}
+		var contentType string
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			contentType = r.Header.Get("Content-Type")
+			json.NewDecoder(r.Body).Decode(&got)
+			w.WriteHeader(tt.status)
+		}))
+		err := (&WebhookNotifier{URL: srv.URL}).Notify(testAlerts())
+		srv.Close()
+
+		if tt.err == "" && err != nil || tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+			t.Errorf("%s: got error %v, want %q", tt.name, err, tt.err)
+		}
+		if contentType != "application/json" || len(got.Alerts) != 2 || got.Alerts[0].FQDN != "www.example.com" || got.Alerts[1].Threshold != 0 {
+			t.Errorf("%s: got %s %+v", tt.name, contentType, got.Alerts)
+		}
+	}
+}
@@ -0,0 +1,285 @@
+package pkigrade
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	httpheaders "github.com/binaryfigments/goharvest/http/headers"
+	pkicertificate "github.com/binaryfigments/goharvest/pki/certificate"
+	pkiocsp "github.com/binaryfigments/goharvest/pki/ocsp"
+	pkitlsscan "github.com/binaryfigments/goharvest/pki/tlsscan"
+	"github.com/zmap/zcrypto/rsa"
+	"github.com/zmap/zcrypto/x509"
+)
+
+// Input struct with the checks a grade is computed from. Scan and
+// Certificates are required, caps and deductions that need a missing
+// check are skipped. Time is when the checks ran, the validity of the
+// certificate is judged at that time.
+type Input struct {
+	Time            time.Time
+	Certificates    *pkicertificate.Certificates
+	Scan            *pkitlsscan.Report
+	Vulnerabilities *pkitlsscan.Vulnerabilities
+	OCSP            *pkiocsp.OCSPInfo
+	HSTS            *httpheaders.HTTPHeaders
+}
+
+// Result struct with a grade and how it was reached
+type Result struct {
+	FQDN             string     `json:"fqdn,omitempty"`
+	Port             int        `json:"port,omitempty"`
+	CheckTime        time.Time  `json:"time"`
+	Ruleset          string     `json:"ruleset"`
+	Grade            string     `json:"grade,omitempty"`
+	Score            int        `json:"score"`
+	ProtocolScore    int        `json:"protocolscore"`
+	KeyExchangeScore int        `json:"keyexchangescore"`
+	CipherScore      int        `json:"cipherscore"`
+	Caps             []*Applied `json:"caps,omitempty"`
+	Deductions       []*Applied `json:"deductions,omitempty"`
+	Error            string     `json:"error,omitempty"`
+	ErrorMessage     string     `json:"errormessage,omitempty"`
+}
+
+// Applied struct for a cap or deduction that applied
+type Applied struct {
+	ID          string `json:"id"`
+	Description string `json:"description"`
+	Grade       string `json:"grade,omitempty"`
+	Points      int    `json:"points,omitempty"`
+	Evidence    string `json:"evidence,omitempty"`
+}
+
+// Grades from best to worst
+var Grades = []string{"A+", "A", "A-", "B", "C", "D", "E", "F"}
+
+// Get function of this package to run the checks of a HTTPS server and
+// grade it with the latest ruleset.
+func Get(fqdn string, port int) *Result {
+	in := new(Input)
+	in.Time = time.Now()
+	in.Certificates = pkicertificate.GetWithOptions(fqdn, port, "https", &pkicertificate.Options{Verify: true})
+	in.Scan = pkitlsscan.Get(fqdn, port)
+	in.Vulnerabilities = pkitlsscan.CheckVulnerabilities(in.Scan)
+
+	in.OCSP = pkiocsp.RunWithPort(fqdn, port)
+
+	url := "https://" + fqdn
+	if port != 443 {
+		url += ":" + strconv.Itoa(port)
+	}
+	in.HSTS = httpheaders.GetHTTPHeader(url+"/", "Strict-Transport-Security", "GET")
+	return Grade(in, LatestRuleset)
+}
+
+// Grade computes the grade of in with a ruleset version. The same input
+// and version always give the same grade. A+ is an A without deductions
+// and with HSTS for at least the HSTSMinAge of the ruleset.
+func Grade(in *Input, version string) *Result {
+	r := new(Result)
+	r.CheckTime = time.Now()
+	r.Ruleset = version
+
+	rules, ok := Rulesets[version]
+	if !ok {
+		r.Error = "Failed"
+		r.ErrorMessage = "Unknown ruleset " + version + "."
+		return r
+	}
+	if in.Scan == nil || in.Certificates == nil {
+		r.Error = "Failed"
+		r.ErrorMessage = "A scan and the certificates are required."
+		return r
+	}
+	r.FQDN = in.Scan.FQDN
+	r.Port = in.Scan.Port
+	if in.Scan.Error != "" {
+		r.Error = in.Scan.Error
+		r.ErrorMessage = in.Scan.ErrorMessage
+		return r
+	}
+
+	c := &check{Input: in, now: in.Time}
+	if c.now.IsZero() {
+		c.now = r.CheckTime
+	}
+	c.leaf, c.leafErr = leafCertificate(in.Certificates)
+
+	r.ProtocolScore = rules.protocolScore(in.Scan)
+	r.KeyExchangeScore = rules.keyExchangeScore(c.keyStrength())
+	r.CipherScore = rules.cipherScore(in.Scan)
+	score := (30*r.ProtocolScore + 30*r.KeyExchangeScore + 40*r.CipherScore) / 100
+
+	for _, d := range rules.Deductions {
+		if evidence := d.check(c); evidence != "" {
+			score -= d.Points
+			r.Deductions = append(r.Deductions, &Applied{ID: d.ID, Description: d.Description, Points: d.Points, Evidence: evidence})
+		}
+	}
+	if score < 0 {
+		score = 0
+	}
+	r.Score = score
+	r.Grade = rules.scoreGrade(score)
+
+	for _, limit := range rules.Caps {
+		if evidence := limit.check(c); evidence != "" {
+			r.Caps = append(r.Caps, &Applied{ID: limit.ID, Description: limit.Description, Grade: limit.Grade, Evidence: evidence})
+			r.Grade = worst(r.Grade, limit.Grade)
+		}
+	}
+
+	if r.Grade == "A" && len(r.Deductions) == 0 {
+		if maxAge := hstsMaxAge(in.HSTS); maxAge >= rules.HSTSMinAge {
+			r.Grade = "A+"
+		}
+	}
+	return r
+}
+
+/*
+ * Used functions
+ */
+
+// check holds the input and what the rules derive from it.
+type check struct {
+	*Input
+	now     time.Time
+	leaf    *x509.Certificate
+	leafErr error
+}
+
+func leafCertificate(certs *pkicertificate.Certificates) (*x509.Certificate, error) {
+	if certs.Error != "" {
+		return nil, errors.New(certs.ErrorMessage)
+	}
+	if len(certs.Parsed) == 0 {
+		return nil, errors.New("no certificates")
+	}
+	return certs.Parsed[0], nil
+}
+
+// keyStrength returns the strength of the leaf key in RSA bits, ECDSA and
+// EdDSA keys by their RSA equivalent (NIST SP 800-57).
+func (c *check) keyStrength() int {
+	if c.leaf == nil {
+		return 0
+	}
+	switch key := c.leaf.PublicKey.(type) {
+	case *rsa.PublicKey:
+		return key.N.BitLen()
+	case *x509.AugmentedECDSA:
+		return ecdsaStrength(key.Pub)
+	case *ecdsa.PublicKey:
+		return ecdsaStrength(key)
+	case ed25519.PublicKey:
+		return 3072
+	}
+	return 0
+}
+
+func ecdsaStrength(key *ecdsa.PublicKey) int {
+	switch bits := key.Curve.Params().BitSize; {
+	case bits >= 512:
+		return 15360
+	case bits >= 384:
+		return 7680
+	case bits >= 256:
+		return 3072
+	case bits >= 224:
+		return 2048
+	default:
+		return 1024
+	}
+}
+
+// finding returns the evidence of a vulnerable finding, or "".
+func (c *check) finding(id string) string {
+	if c.Vulnerabilities == nil {
+		return ""
+	}
+	for _, f := range c.Vulnerabilities.Findings {
+		if f.ID == id && f.Vulnerable {
+			return f.Evidence
+		}
+	}
+	return ""
+}
+
+func (c *check) supports(version string) bool {
+	for _, v := range c.Scan.Versions {
+		if v.Version == version && v.Supported {
+			return true
+		}
+	}
+	return false
+}
+
+// ciphers returns the supported cipher suites for which keep is true.
+func (c *check) ciphers(keep func(name string) bool) []string {
+	seen := make(map[string]bool)
+	var names []string
+	for _, v := range c.Scan.Versions {
+		for _, cipher := range v.Ciphers {
+			if keep(cipher.Name) && !seen[cipher.Name] {
+				seen[cipher.Name] = true
+				names = append(names, cipher.Name)
+			}
+		}
+	}
+	return names
+}
+
+// cipherBits returns the strength of the encryption of a cipher suite.
+func cipherBits(name string) int {
+	switch {
+	case strings.Contains(name, "_NULL_"):
+		return 0
+	case strings.Contains(name, "EXPORT_"), strings.Contains(name, "_40_"), strings.Contains(name, "DES40"):
+		return 40
+	case strings.Contains(name, "_DES_"), strings.Contains(name, "_56_"):
+		return 56
+	case strings.Contains(name, "3DES"):
+		return 112
+	case strings.Contains(name, "_256_"), strings.Contains(name, "CHACHA20"):
+		return 256
+	}
+	return 128
+}
+
+func hstsMaxAge(hsts *httpheaders.HTTPHeaders) int {
+	if hsts == nil || hsts.Error != "" {
+		return 0
+	}
+	for _, directive := range strings.Split(hsts.Result, ";") {
+		directive = strings.TrimSpace(directive)
+		if strings.HasPrefix(strings.ToLower(directive), "max-age=") {
+			age, err := strconv.Atoi(strings.Trim(directive[8:], `"`))
+			if err == nil {
+				return age
+			}
+		}
+	}
+	return 0
+}
+
+func worst(a, b string) string {
+	if gradeIndex(b) > gradeIndex(a) {
+		return b
+	}
+	return a
+}
+
+func gradeIndex(grade string) int {
+	for i, g := range Grades {
+		if g == grade {
+			return i
+		}
+	}
+	return len(Grades) - 1
+}
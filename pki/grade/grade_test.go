@@ -0,0 +1,225 @@
+package pkigrade
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	cryptox509 "crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	httpheaders "github.com/binaryfigments/goharvest/http/headers"
+	pkicertificate "github.com/binaryfigments/goharvest/pki/certificate"
+	pkiocsp "github.com/binaryfigments/goharvest/pki/ocsp"
+	pkitlsscan "github.com/binaryfigments/goharvest/pki/tlsscan"
+	"github.com/zmap/zcrypto/x509"
+)
+
+// certificate returns a self-signed certificate for key, valid from
+// notBefore to notAfter, as zcrypto parses it.
+func certificate(t *testing.T, key crypto.Signer, notBefore, notAfter time.Time) *x509.Certificate {
+	t.Helper()
+	template := &cryptox509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "www.example.com"},
+		DNSNames:     []string{"www.example.com"},
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+	}
+	der, err := cryptox509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func scan(versions map[string][]string) *pkitlsscan.Report {
+	r := &pkitlsscan.Report{FQDN: "www.example.com", Port: 443}
+	for _, name := range []string{"SSLv3", "TLSv1.0", "TLSv1.1", "TLSv1.2", "TLSv1.3"} {
+		v := &pkitlsscan.Version{Version: name}
+		for _, cipher := range versions[name] {
+			v.Supported = true
+			v.Ciphers = append(v.Ciphers, &pkitlsscan.Cipher{Name: cipher})
+		}
+		r.Versions = append(r.Versions, v)
+	}
+	return r
+}
+
+func vulnerable(ids ...string) *pkitlsscan.Vulnerabilities {
+	v := new(pkitlsscan.Vulnerabilities)
+	for _, id := range ids {
+		v.Findings = append(v.Findings, &pkitlsscan.Finding{ID: id, Vulnerable: true, Evidence: id + " evidence"})
+	}
+	return v
+}
+
+// TestGradeRuleset1 pins the scores, caps and grades of ruleset 1, which
+// must never change once released.
+func TestGradeRuleset1(t *testing.T) {
+	now := time.Date(2030, 6, 1, 0, 0, 0, 0, time.UTC)
+	p256, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsa1024, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	valid := certificate(t, p256, now.AddDate(0, -1, 0), now.AddDate(0, 2, 0))
+	expired := certificate(t, p256, now.AddDate(0, -3, 0), now.AddDate(0, 0, -1))
+	weak := certificate(t, rsa1024, now.AddDate(0, -1, 0), now.AddDate(0, 2, 0))
+
+	modern := map[string][]string{
+		"TLSv1.2": {"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"},
+		"TLSv1.3": {"TLS_AES_256_GCM_SHA384"},
+	}
+	legacy := map[string][]string{
+		"TLSv1.0": {"TLS_RSA_WITH_AES_128_CBC_SHA"},
+	}
+	withSSLv3 := map[string][]string{
+		"SSLv3":   {"TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA"},
+		"TLSv1.2": {"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"},
+		"TLSv1.3": {"TLS_AES_256_GCM_SHA384"},
+	}
+	trusted := &pkicertificate.Chain{Trusted: true, HostnameMatch: true}
+	hsts := &httpheaders.HTTPHeaders{Result: "max-age=31536000; includeSubDomains"}
+
+	tests := []struct {
+		name     string
+		versions map[string][]string
+		leaf     *x509.Certificate
+		chain    *pkicertificate.Chain
+		vulns    *pkitlsscan.Vulnerabilities
+		stapled  string
+		hsts     *httpheaders.HTTPHeaders
+		scores   [4]int
+		grade    string
+		caps     string
+	}{
+		{name: "modern", versions: modern, leaf: valid, chain: trusted, stapled: "Yes", scores: [4]int{100, 90, 90, 93}, grade: "A"},
+		{name: "modern with HSTS", versions: modern, leaf: valid, chain: trusted, stapled: "Yes", hsts: hsts, scores: [4]int{100, 90, 90, 93}, grade: "A+"},
+		{name: "short HSTS", versions: modern, leaf: valid, chain: trusted, stapled: "Yes", hsts: &httpheaders.HTTPHeaders{Result: "max-age=300"}, scores: [4]int{100, 90, 90, 93}, grade: "A"},
+		{name: "no stapling", versions: modern, leaf: valid, chain: trusted, stapled: "No", hsts: hsts, scores: [4]int{100, 90, 90, 88}, grade: "A"},
+		{name: "fallback SCSV", versions: modern, leaf: valid, chain: trusted, vulns: vulnerable(pkitlsscan.CheckFallbackSCSV), hsts: hsts, scores: [4]int{100, 90, 90, 93}, grade: "A-", caps: "fallback-scsv"},
+		{name: "sslv3", versions: withSSLv3, leaf: valid, chain: trusted, scores: [4]int{90, 90, 90, 90}, grade: "C", caps: "sslv3"},
+		{name: "heartbleed", versions: modern, leaf: valid, chain: trusted, vulns: vulnerable(pkitlsscan.CheckHeartbleed), scores: [4]int{100, 90, 90, 93}, grade: "F", caps: "heartbleed"},
+		{name: "legacy", versions: legacy, leaf: valid, chain: trusted, scores: [4]int{90, 90, 80, 86}, grade: "C", caps: "no-tls12 tls10-tls11 no-forward-secrecy no-aead"},
+		{name: "RSA 1024", versions: modern, leaf: weak, chain: trusted, scores: [4]int{100, 80, 90, 90}, grade: "B", caps: "key-under-2048"},
+		{name: "expired", versions: modern, leaf: expired, chain: trusted, scores: [4]int{100, 90, 90, 93}, grade: "F", caps: "certificate-expired"},
+		{name: "untrusted", versions: modern, leaf: valid, chain: &pkicertificate.Chain{HostnameMatch: true, VerifyError: "unknown authority"}, scores: [4]int{100, 90, 90, 93}, grade: "F", caps: "certificate-untrusted"},
+		{name: "no certificate", versions: modern, scores: [4]int{100, 20, 90, 72}, grade: "F", caps: "no-certificate"},
+	}
+	for _, tt := range tests {
+		certs := &pkicertificate.Certificates{Chain: tt.chain}
+		if tt.leaf != nil {
+			certs.Parsed = []*x509.Certificate{tt.leaf}
+		}
+		in := &Input{
+			Time:            now,
+			Certificates:    certs,
+			Scan:            scan(tt.versions),
+			Vulnerabilities: tt.vulns,
+			HSTS:            tt.hsts,
+		}
+		if tt.stapled != "" {
+			in.OCSP = &pkiocsp.OCSPInfo{Stapled: tt.stapled}
+		}
+
+		r := Grade(in, "1")
+		if r.Error != "" {
+			t.Errorf("%s: %s", tt.name, r.ErrorMessage)
+			continue
+		}
+		if scores := [4]int{r.ProtocolScore, r.KeyExchangeScore, r.CipherScore, r.Score}; scores != tt.scores {
+			t.Errorf("%s: got scores %v, want %v", tt.name, scores, tt.scores)
+		}
+		var caps []string
+		for _, c := range r.Caps {
+			caps = append(caps, c.ID)
+		}
+		if r.Grade != tt.grade || strings.Join(caps, " ") != tt.caps {
+			t.Errorf("%s: got %s %v, want %s [%s]", tt.name, r.Grade, caps, tt.grade, tt.caps)
+		}
+	}
+}
+
+func TestGradeErrors(t *testing.T) {
+	certs := new(pkicertificate.Certificates)
+	tests := []struct {
+		name    string
+		in      *Input
+		version string
+		err     string
+	}{
+		{"unknown ruleset", &Input{Scan: scan(nil), Certificates: certs}, "0", "Unknown ruleset 0."},
+		{"no scan", &Input{Certificates: certs}, "1", "A scan and the certificates are required."},
+		{"scan failed", &Input{Scan: &pkitlsscan.Report{Error: "Failed", ErrorMessage: "connection refused"}, Certificates: certs}, "1", "connection refused"},
+	}
+	for _, tt := range tests {
+		if r := Grade(tt.in, tt.version); r.ErrorMessage != tt.err {
+			t.Errorf("%s: got %q, want %q", tt.name, r.ErrorMessage, tt.err)
+		}
+	}
+}
+
+func TestKeyStrength(t *testing.T) {
+	now := time.Now()
+	generate := func(f func() (crypto.Signer, error)) crypto.Signer {
+		key, err := f()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return key
+	}
+
+	tests := []struct {
+		name string
+		key  crypto.Signer
+		want int
+	}{
+		{"RSA 2048", generate(func() (crypto.Signer, error) { return rsa.GenerateKey(rand.Reader, 2048) }), 2048},
+		{"ECDSA P-256", generate(func() (crypto.Signer, error) { return ecdsa.GenerateKey(elliptic.P256(), rand.Reader) }), 3072},
+		{"ECDSA P-384", generate(func() (crypto.Signer, error) { return ecdsa.GenerateKey(elliptic.P384(), rand.Reader) }), 7680},
+		{"Ed25519", generate(func() (crypto.Signer, error) {
+			_, key, err := ed25519.GenerateKey(rand.Reader)
+			return key, err
+		}), 3072},
+	}
+	for _, tt := range tests {
+		c := &check{leaf: certificate(t, tt.key, now.Add(-time.Hour), now.Add(time.Hour))}
+		if got := c.keyStrength(); got != tt.want {
+			t.Errorf("%s: got %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestHSTSMaxAge(t *testing.T) {
+	tests := []struct {
+		result string
+		want   int
+	}{
+		{"max-age=31536000", 31536000},
+		{`includeSubDomains; Max-Age="15552000"; preload`, 15552000},
+		{"max-age=soon", 0},
+		{"", 0},
+	}
+	for _, tt := range tests {
+		if got := hstsMaxAge(&httpheaders.HTTPHeaders{Result: tt.result}); got != tt.want {
+			t.Errorf("%q: got %d, want %d", tt.result, got, tt.want)
+		}
+	}
+	if got := hstsMaxAge(&httpheaders.HTTPHeaders{Result: "Failed", Error: "Failed"}); got != 0 {
+		t.Errorf("failed request: got %d", got)
+	}
+}
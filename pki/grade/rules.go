@@ -0,0 +1,292 @@
+package pkigrade
+
+import (
+	"strconv"
+	"strings"
+
+	pkitlsscan "github.com/binaryfigments/goharvest/pki/tlsscan"
+)
+
+// Ruleset struct with the scores, caps and deductions of one version. A
+// released ruleset is never changed, changed rules go into a new version
+// so old grades can be reproduced.
+type Ruleset struct {
+	Version           string
+	ProtocolScores    map[string]int
+	KeyExchangeScores []Threshold
+	CipherScores      []Threshold
+	GradeScores       []Threshold
+	HSTSMinAge        int
+	Caps              []*Cap
+	Deductions        []*Deduction
+}
+
+// Threshold struct, the first threshold of a list with Min at or below a
+// value gives the Score or Grade.
+type Threshold struct {
+	Min   int
+	Score int
+	Grade string
+}
+
+// Cap struct for a rule that limits the grade
+type Cap struct {
+	ID          string
+	Description string
+	Grade       string
+	check       func(c *check) string
+}
+
+// Deduction struct for a rule that subtracts points from the score
+type Deduction struct {
+	ID          string
+	Description string
+	Points      int
+	check       func(c *check) string
+}
+
+// LatestRuleset is the version Get grades with.
+const LatestRuleset = "1"
+
+// Rulesets by version
+var Rulesets = map[string]*Ruleset{
+	"1": ruleset1,
+}
+
+// ruleset1 follows the SSL Labs server rating guide: the score is 30%
+// protocol, 30% key exchange and 40% cipher strength, protocol and cipher
+// as the average of the best and the worst supported.
+var ruleset1 = &Ruleset{
+	Version: "1",
+	ProtocolScores: map[string]int{
+		"SSLv3":   80,
+		"TLSv1.0": 90,
+		"TLSv1.1": 95,
+		"TLSv1.2": 100,
+		"TLSv1.3": 100,
+	},
+	KeyExchangeScores: []Threshold{
+		{Min: 4096, Score: 100},
+		{Min: 2048, Score: 90},
+		{Min: 1024, Score: 80},
+		{Min: 512, Score: 40},
+		{Min: 0, Score: 20},
+	},
+	CipherScores: []Threshold{
+		{Min: 256, Score: 100},
+		{Min: 128, Score: 80},
+		{Min: 1, Score: 20},
+		{Min: 0, Score: 0},
+	},
+	GradeScores: []Threshold{
+		{Min: 80, Grade: "A"},
+		{Min: 65, Grade: "B"},
+		{Min: 50, Grade: "C"},
+		{Min: 35, Grade: "D"},
+		{Min: 20, Grade: "E"},
+		{Min: 0, Grade: "F"},
+	},
+	HSTSMinAge: 15552000,
+	Caps: []*Cap{
+		{ID: "no-certificate", Description: "No usable certificate", Grade: "F", check: func(c *check) string {
+			if c.leaf == nil {
+				return c.leafErr.Error()
+			}
+			return ""
+		}},
+		{ID: "certificate-expired", Description: "Certificate expired", Grade: "F", check: func(c *check) string {
+			if c.leaf != nil && c.now.After(c.leaf.NotAfter) {
+				return "Not after " + c.leaf.NotAfter.String() + "."
+			}
+			return ""
+		}},
+		{ID: "certificate-not-yet-valid", Description: "Certificate not yet valid", Grade: "F", check: func(c *check) string {
+			if c.leaf != nil && c.now.Before(c.leaf.NotBefore) {
+				return "Not before " + c.leaf.NotBefore.String() + "."
+			}
+			return ""
+		}},
+		{ID: "certificate-untrusted", Description: "Certificate chain not trusted", Grade: "F", check: func(c *check) string {
+			if chain := c.Certificates.Chain; chain != nil && !chain.Trusted {
+				return chain.VerifyError
+			}
+			return ""
+		}},
+		{ID: "hostname-mismatch", Description: "Certificate does not match the hostname", Grade: "F", check: func(c *check) string {
+			if chain := c.Certificates.Chain; chain != nil && !chain.HostnameMatch {
+				return chain.HostnameError
+			}
+			return ""
+		}},
+		{ID: "certificate-revoked", Description: "Certificate revoked", Grade: "F", check: func(c *check) string {
+			if c.OCSP != nil && c.OCSP.OCSPResponse != nil && c.OCSP.OCSPResponse.CertificateStatus == "Revoked" {
+				return "Revoked at " + c.OCSP.OCSPResponse.CertificateRevokedAt.String() + "."
+			}
+			return ""
+		}},
+		{ID: "key-under-1024", Description: "Key weaker than RSA 1024 bits", Grade: "F", check: func(c *check) string {
+			return weakKey(c, 1024)
+		}},
+		{ID: "key-under-2048", Description: "Key weaker than RSA 2048 bits", Grade: "B", check: func(c *check) string {
+			return weakKey(c, 2048)
+		}},
+		{ID: pkitlsscan.CheckHeartbleed, Description: "Vulnerable to Heartbleed", Grade: "F", check: func(c *check) string {
+			return c.finding(pkitlsscan.CheckHeartbleed)
+		}},
+		{ID: pkitlsscan.CheckROBOT, Description: "Vulnerable to ROBOT", Grade: "F", check: func(c *check) string {
+			return c.finding(pkitlsscan.CheckROBOT)
+		}},
+		{ID: pkitlsscan.CheckExportCiphers, Description: "Export cipher suites", Grade: "F", check: func(c *check) string {
+			return c.finding(pkitlsscan.CheckExportCiphers)
+		}},
+		{ID: pkitlsscan.CheckNULLCiphers, Description: "NULL cipher suites", Grade: "F", check: func(c *check) string {
+			return c.finding(pkitlsscan.CheckNULLCiphers)
+		}},
+		{ID: pkitlsscan.CheckAnonymousCiphers, Description: "Anonymous cipher suites", Grade: "F", check: func(c *check) string {
+			return c.finding(pkitlsscan.CheckAnonymousCiphers)
+		}},
+		{ID: pkitlsscan.CheckRenegotiation, Description: "No secure renegotiation", Grade: "C", check: func(c *check) string {
+			return c.finding(pkitlsscan.CheckRenegotiation)
+		}},
+		{ID: pkitlsscan.CheckCompression, Description: "TLS compression (CRIME)", Grade: "C", check: func(c *check) string {
+			return c.finding(pkitlsscan.CheckCompression)
+		}},
+		{ID: pkitlsscan.CheckRC4Ciphers, Description: "RC4 cipher suites", Grade: "C", check: func(c *check) string {
+			return c.finding(pkitlsscan.CheckRC4Ciphers)
+		}},
+		{ID: "sslv3", Description: "SSLv3 supported", Grade: "C", check: func(c *check) string {
+			if c.supports("SSLv3") {
+				return "SSLv3 supported."
+			}
+			return ""
+		}},
+		{ID: "no-tls12", Description: "Neither TLSv1.2 nor TLSv1.3 supported", Grade: "C", check: func(c *check) string {
+			if !c.supports("TLSv1.2") && !c.supports("TLSv1.3") {
+				return "TLSv1.2 and TLSv1.3 not supported."
+			}
+			return ""
+		}},
+		{ID: "tls10-tls11", Description: "TLSv1.0 or TLSv1.1 supported", Grade: "B", check: func(c *check) string {
+			var versions []string
+			for _, v := range []string{"TLSv1.0", "TLSv1.1"} {
+				if c.supports(v) {
+					versions = append(versions, v)
+				}
+			}
+			if versions != nil {
+				return strings.Join(versions, " and ") + " supported."
+			}
+			return ""
+		}},
+		{ID: pkitlsscan.CheckDESCiphers, Description: "DES and 3DES cipher suites (SWEET32)", Grade: "B", check: func(c *check) string {
+			return c.finding(pkitlsscan.CheckDESCiphers)
+		}},
+		{ID: "no-forward-secrecy", Description: "No forward secrecy", Grade: "B", check: func(c *check) string {
+			if c.ciphers(forwardSecret) == nil {
+				return "No ECDHE, DHE or TLSv1.3 cipher suites."
+			}
+			return ""
+		}},
+		{ID: "no-aead", Description: "No AEAD cipher suites", Grade: "B", check: func(c *check) string {
+			if c.ciphers(aead) == nil {
+				return "No GCM, CCM or ChaCha20-Poly1305 cipher suites."
+			}
+			return ""
+		}},
+		{ID: pkitlsscan.CheckFallbackSCSV, Description: "No TLS_FALLBACK_SCSV", Grade: "A-", check: func(c *check) string {
+			return c.finding(pkitlsscan.CheckFallbackSCSV)
+		}},
+	},
+	Deductions: []*Deduction{
+		{ID: "no-ocsp-stapling", Description: "No OCSP stapling", Points: 5, check: func(c *check) string {
+			if c.OCSP != nil && c.OCSP.Stapled == "No" {
+				return "The server does not staple an OCSP response."
+			}
+			return ""
+		}},
+		{ID: pkitlsscan.CheckExtendedMasterSecret, Description: "No extended master secret", Points: 5, check: func(c *check) string {
+			return c.finding(pkitlsscan.CheckExtendedMasterSecret)
+		}},
+	},
+}
+
+/*
+ * Used functions
+ */
+
+func (rs *Ruleset) protocolScore(scan *pkitlsscan.Report) int {
+	best, worst := -1, -1
+	for _, v := range scan.Versions {
+		score, ok := rs.ProtocolScores[v.Version]
+		if !v.Supported || !ok {
+			continue
+		}
+		if best < 0 || score > best {
+			best = score
+		}
+		if worst < 0 || score < worst {
+			worst = score
+		}
+	}
+	if best < 0 {
+		return 0
+	}
+	return (best + worst) / 2
+}
+
+func (rs *Ruleset) keyExchangeScore(strength int) int {
+	return threshold(rs.KeyExchangeScores, strength).Score
+}
+
+func (rs *Ruleset) cipherScore(scan *pkitlsscan.Report) int {
+	strongest, weakest := -1, -1
+	for _, v := range scan.Versions {
+		for _, cipher := range v.Ciphers {
+			bits := cipherBits(cipher.Name)
+			if strongest < 0 || bits > strongest {
+				strongest = bits
+			}
+			if weakest < 0 || bits < weakest {
+				weakest = bits
+			}
+		}
+	}
+	if strongest < 0 {
+		return 0
+	}
+	return (threshold(rs.CipherScores, strongest).Score + threshold(rs.CipherScores, weakest).Score) / 2
+}
+
+func (rs *Ruleset) scoreGrade(score int) string {
+	if t := threshold(rs.GradeScores, score); t.Grade != "" {
+		return t.Grade
+	}
+	return "F"
+}
+
+func threshold(thresholds []Threshold, value int) Threshold {
+	for _, t := range thresholds {
+		if value >= t.Min {
+			return t
+		}
+	}
+	return Threshold{}
+}
+
+func weakKey(c *check, bits int) string {
+	if c.leaf == nil {
+		return ""
+	}
+	if strength := c.keyStrength(); strength < bits {
+		return "Key strength of " + strconv.Itoa(strength) + " RSA bits."
+	}
+	return ""
+}
+
+func forwardSecret(name string) bool {
+	return strings.Contains(name, "_ECDHE_") || strings.Contains(name, "_DHE_") || !strings.Contains(name, "_WITH_")
+}
+
+func aead(name string) bool {
+	return strings.Contains(name, "_GCM_") || strings.Contains(name, "_CCM") || strings.Contains(name, "CHACHA20")
+}
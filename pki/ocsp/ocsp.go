@@ -17,7 +17,7 @@ import (
 	"net"
 	"net/http"
 	"os"
-	"regexp"
+	"strconv"
 	"time"
 
 	"golang.org/x/crypto/ocsp"
@@ -26,6 +26,11 @@ import (
 
 // Run function for starting the check
 func Run(cn string) *OCSPInfo {
+	return RunWithPort(cn, 443)
+}
+
+// RunWithPort is Run for a TLS server on another port than 443.
+func RunWithPort(cn string, port int) *OCSPInfo {
 	r := new(OCSPInfo)
 	r.CommonName = cn
 
@@ -37,7 +42,6 @@ func Run(cn string) *OCSPInfo {
 		ocspServer       string
 		ocspUnauthorised = []byte{0x30, 0x03, 0x0a, 0x01, 0x06}
 		ocspMalformed    = []byte{0x30, 0x03, 0x0a, 0x01, 0x01}
-		hasPort          = regexp.MustCompile(`:\d+$`)
 	)
 
 	// Valid server name (ASCII or IDN)
@@ -57,9 +61,7 @@ func Run(cn string) *OCSPInfo {
 		return r
 	}
 
-	if !hasPort.MatchString(cn) {
-		cn += ":443"
-	}
+	cn = net.JoinHostPort(cn, strconv.Itoa(port))
 
 	dialconf := &tls.Config{
 		InsecureSkipVerify: true,
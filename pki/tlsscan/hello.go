@@ -0,0 +1,441 @@
+package pkitlsscan
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"time"
+)
+
+// Record and handshake message types
+const (
+	recordChangeCipherSpec = 20
+	recordAlert            = 21
+	recordHandshake        = 22
+	recordHeartbeat        = 24
+
+	typeClientHello       = 1
+	typeServerHello       = 2
+	typeCertificate       = 11
+	typeServerKeyExchange = 12
+	typeServerHelloDone   = 14
+	typeClientKeyExchange = 16
+	typeFinished          = 20
+)
+
+// Extensions
+const (
+	extServerName           = 0
+	extSupportedGroups      = 10
+	extECPointFormats       = 11
+	extSignatureAlgorithms  = 13
+	extHeartbeat            = 15
+	extPadding              = 21
+	extExtendedMasterSecret = 23
+	extSupportedVersions    = 43
+	extKeyShare             = 51
+	extRenegotiationInfo    = 0xff01
+)
+
+// Signaling cipher suite values
+const (
+	scsvRenegotiation = 0x00ff
+	scsvFallback      = 0x5600
+)
+
+// Protocol versions
+const (
+	VersionSSL30 = 0x0300
+	VersionTLS10 = 0x0301
+	VersionTLS11 = 0x0302
+	VersionTLS12 = 0x0303
+	VersionTLS13 = 0x0304
+)
+
+// helloRetryRequest is the ServerHello random of a HelloRetryRequest
+// (RFC 8446 section 4.1.3).
+var helloRetryRequest = []byte{
+	0xcf, 0x21, 0xad, 0x74, 0xe5, 0x9a, 0x61, 0x11, 0xbe, 0x1d, 0x8c, 0x02, 0x1e, 0x65, 0xb8, 0x91,
+	0xc2, 0xa2, 0x11, 0x16, 0x7a, 0xbb, 0x8c, 0x5e, 0x07, 0x9e, 0x09, 0xe2, 0xc8, 0xa8, 0x33, 0x9c,
+}
+
+type extension struct {
+	id   uint16
+	data []byte
+}
+
+type keyShare struct {
+	group uint16
+	data  []byte
+}
+
+// clientHello is a ClientHello built byte by byte, so it can offer
+// versions, cipher suites and extensions crypto/tls never sends.
+type clientHello struct {
+	version             uint16
+	ciphers             []uint16
+	compression         []byte
+	serverName          string
+	groups              []uint16
+	signatureAlgorithms []uint16
+	supportedVersions   []uint16
+	keyShares           []keyShare
+	extensions          []extension
+	noExtensions        bool
+}
+
+// newHello returns a ClientHello for version with the default groups and
+// signature algorithms. A TLS 1.3 hello gets an X25519 key share.
+func newHello(version uint16, ciphers []uint16, serverName string) *clientHello {
+	h := &clientHello{
+		version:             version,
+		ciphers:             ciphers,
+		compression:         []byte{0},
+		serverName:          serverName,
+		groups:              defaultGroups,
+		signatureAlgorithms: defaultSignatureAlgorithms,
+	}
+	switch version {
+	case VersionSSL30:
+		h.noExtensions = true
+	case VersionTLS13:
+		h.version = VersionTLS12
+		h.supportedVersions = []uint16{VersionTLS13}
+		key, err := ecdh.X25519().GenerateKey(rand.Reader)
+		if err == nil {
+			h.keyShares = []keyShare{{group: groupX25519, data: key.PublicKey().Bytes()}}
+		}
+	}
+	return h
+}
+
+// marshal returns the ClientHello as a handshake record.
+func (h *clientHello) marshal() []byte {
+	random := make([]byte, 32)
+	rand.Read(random)
+
+	body := new(builder)
+	body.u16(h.version)
+	body.bytes(random)
+	body.prefixed(1, func(b *builder) {
+		if h.supportedVersions != nil {
+			// A TLS 1.3 hello carries a legacy session id.
+			b.bytes(random)
+		}
+	})
+	body.prefixed(2, func(b *builder) {
+		for _, c := range h.ciphers {
+			b.u16(c)
+		}
+	})
+	body.prefixed(1, func(b *builder) {
+		b.bytes(h.compression)
+	})
+
+	if !h.noExtensions {
+		exts := h.extensionList()
+		// Some servers fail on hellos from 256 to 511 bytes long
+		// (RFC 7685 section 1).
+		length := 4 + len(body.b) + 2
+		for _, e := range exts {
+			length += 4 + len(e.data)
+		}
+		if length >= 256 && length < 512 {
+			pad := 512 - length - 4
+			if pad < 1 {
+				pad = 1
+			}
+			exts = append(exts, extension{id: extPadding, data: make([]byte, pad)})
+		}
+		body.prefixed(2, func(b *builder) {
+			for _, e := range exts {
+				b.u16(e.id)
+				b.prefixed(2, func(b *builder) { b.bytes(e.data) })
+			}
+		})
+	}
+
+	message := new(builder)
+	message.u8(typeClientHello)
+	message.prefixed(3, func(b *builder) { b.bytes(body.b) })
+
+	recordVersion := uint16(VersionTLS10)
+	if h.version == VersionSSL30 {
+		recordVersion = VersionSSL30
+	}
+	return record(recordHandshake, recordVersion, message.b)
+}
+
+func (h *clientHello) extensionList() []extension {
+	var exts []extension
+	if h.serverName != "" && net.ParseIP(h.serverName) == nil {
+		b := new(builder)
+		b.prefixed(2, func(b *builder) {
+			b.u8(0)
+			b.prefixed(2, func(b *builder) { b.bytes([]byte(h.serverName)) })
+		})
+		exts = append(exts, extension{id: extServerName, data: b.b})
+	}
+	if len(h.groups) > 0 {
+		b := new(builder)
+		b.prefixed(2, func(b *builder) {
+			for _, g := range h.groups {
+				b.u16(g)
+			}
+		})
+		exts = append(exts, extension{id: extSupportedGroups, data: b.b})
+		exts = append(exts, extension{id: extECPointFormats, data: []byte{1, 0}})
+	}
+	if len(h.signatureAlgorithms) > 0 {
+		b := new(builder)
+		b.prefixed(2, func(b *builder) {
+			for _, s := range h.signatureAlgorithms {
+				b.u16(s)
+			}
+		})
+		exts = append(exts, extension{id: extSignatureAlgorithms, data: b.b})
+	}
+	if len(h.supportedVersions) > 0 {
+		b := new(builder)
+		b.prefixed(1, func(b *builder) {
+			for _, v := range h.supportedVersions {
+				b.u16(v)
+			}
+		})
+		exts = append(exts, extension{id: extSupportedVersions, data: b.b})
+		b = new(builder)
+		b.prefixed(2, func(b *builder) {
+			for _, k := range h.keyShares {
+				b.u16(k.group)
+				b.prefixed(2, func(b *builder) { b.bytes(k.data) })
+			}
+		})
+		exts = append(exts, extension{id: extKeyShare, data: b.b})
+	}
+	return append(exts, h.extensions...)
+}
+
+func record(contentType byte, version uint16, payload []byte) []byte {
+	b := new(builder)
+	b.u8(contentType)
+	b.u16(version)
+	b.prefixed(2, func(b *builder) { b.bytes(payload) })
+	return b.b
+}
+
+// serverHello holds the fields of a ServerHello the scans look at.
+type serverHello struct {
+	version     uint16
+	cipher      uint16
+	compression byte
+	extensions  map[uint16][]byte
+	retry       bool
+}
+
+func parseServerHello(body []byte) (*serverHello, error) {
+	invalid := errors.New("invalid ServerHello")
+	if len(body) < 38 {
+		return nil, invalid
+	}
+	sh := &serverHello{
+		version:    binary.BigEndian.Uint16(body[0:2]),
+		retry:      bytes.Equal(body[2:34], helloRetryRequest),
+		extensions: make(map[uint16][]byte),
+	}
+	sidLen := int(body[34])
+	rest := body[35:]
+	if len(rest) < sidLen+3 {
+		return nil, invalid
+	}
+	rest = rest[sidLen:]
+	sh.cipher = binary.BigEndian.Uint16(rest[0:2])
+	sh.compression = rest[2]
+	rest = rest[3:]
+
+	if len(rest) >= 2 {
+		extLen := int(binary.BigEndian.Uint16(rest[0:2]))
+		rest = rest[2:]
+		if len(rest) < extLen {
+			return nil, invalid
+		}
+		rest = rest[:extLen]
+		for len(rest) >= 4 {
+			id := binary.BigEndian.Uint16(rest[0:2])
+			n := int(binary.BigEndian.Uint16(rest[2:4]))
+			if len(rest) < 4+n {
+				return nil, invalid
+			}
+			sh.extensions[id] = rest[4 : 4+n]
+			rest = rest[4+n:]
+		}
+	}
+	if v, ok := sh.extensions[extSupportedVersions]; ok && len(v) == 2 {
+		sh.version = binary.BigEndian.Uint16(v)
+	}
+	return sh, nil
+}
+
+// alertError is a TLS alert from the server.
+type alertError struct {
+	level       byte
+	description byte
+}
+
+func (e alertError) Error() string {
+	if name, ok := alertNames[e.description]; ok {
+		return "alert " + name
+	}
+	return "alert " + strconv.Itoa(int(e.description))
+}
+
+var alertNames = map[byte]string{
+	0: "close_notify", 10: "unexpected_message", 20: "bad_record_mac",
+	40: "handshake_failure", 42: "bad_certificate", 47: "illegal_parameter",
+	50: "decode_error", 51: "decrypt_error", 70: "protocol_version",
+	71: "insufficient_security", 80: "internal_error", 86: "inappropriate_fallback",
+	90: "user_canceled", 109: "missing_extension", 110: "unsupported_extension",
+	112: "unrecognized_name",
+}
+
+// conn reads TLS records and handshake messages from a server.
+type conn struct {
+	net.Conn
+	pending []byte
+}
+
+func dial(host string, port int) (*conn, error) {
+	c, err := net.DialTimeout("tcp", net.JoinHostPort(host, strconv.Itoa(port)), Timeout)
+	if err != nil {
+		return nil, err
+	}
+	c.SetDeadline(time.Now().Add(Timeout))
+	return &conn{Conn: c}, nil
+}
+
+func (c *conn) readRecord() (byte, []byte, error) {
+	header := make([]byte, 5)
+	if _, err := io.ReadFull(c, header); err != nil {
+		return 0, nil, err
+	}
+	if header[0] < recordChangeCipherSpec || header[0] > recordHeartbeat {
+		return 0, nil, errors.New("not a TLS record")
+	}
+	n := int(binary.BigEndian.Uint16(header[3:5]))
+	if n > 1<<14+2048 {
+		return 0, nil, errors.New("TLS record too large")
+	}
+	payload := make([]byte, n)
+	if _, err := io.ReadFull(c, payload); err != nil {
+		return 0, nil, err
+	}
+	return header[0], payload, nil
+}
+
+// readMessage returns the next handshake message. An alert is returned
+// as alertError, change cipher spec records are skipped.
+func (c *conn) readMessage() (byte, []byte, error) {
+	for {
+		if len(c.pending) >= 4 {
+			n := int(c.pending[1])<<16 | int(c.pending[2])<<8 | int(c.pending[3])
+			if len(c.pending) >= 4+n {
+				msgType, body := c.pending[0], c.pending[4:4+n]
+				c.pending = c.pending[4+n:]
+				return msgType, body, nil
+			}
+		}
+		contentType, payload, err := c.readRecord()
+		if err != nil {
+			return 0, nil, err
+		}
+		switch contentType {
+		case recordHandshake:
+			c.pending = append(c.pending, payload...)
+		case recordAlert:
+			if len(payload) < 2 {
+				return 0, nil, errors.New("invalid alert")
+			}
+			return 0, nil, alertError{level: payload[0], description: payload[1]}
+		case recordChangeCipherSpec:
+		default:
+			return 0, nil, errors.New("unexpected record type " + strconv.Itoa(int(contentType)))
+		}
+	}
+}
+
+// handshake sends hello and reads the ServerHello. The connection is
+// returned open so the caller can read the following messages.
+func handshake(host string, port int, hello *clientHello) (*serverHello, *conn, error) {
+	c, err := dial(host, port)
+	if err != nil {
+		return nil, nil, err
+	}
+	if _, err := c.Write(hello.marshal()); err != nil {
+		c.Close()
+		return nil, nil, err
+	}
+	msgType, body, err := c.readMessage()
+	if err != nil {
+		c.Close()
+		return nil, nil, err
+	}
+	if msgType != typeServerHello {
+		c.Close()
+		return nil, nil, errors.New("expected ServerHello")
+	}
+	sh, err := parseServerHello(body)
+	if err != nil {
+		c.Close()
+		return nil, nil, err
+	}
+	return sh, c, nil
+}
+
+// readUntil reads handshake messages until one of type want, or until
+// ServerHelloDone. It returns nil when want did not come.
+func (c *conn) readUntil(want byte) ([]byte, error) {
+	for {
+		msgType, body, err := c.readMessage()
+		if err != nil {
+			return nil, err
+		}
+		if msgType == want {
+			return body, nil
+		}
+		if msgType == typeServerHelloDone {
+			return nil, nil
+		}
+	}
+}
+
+// builder appends big endian integers and length prefixed blocks.
+type builder struct {
+	b []byte
+}
+
+func (b *builder) u8(v byte) {
+	b.b = append(b.b, v)
+}
+
+func (b *builder) u16(v uint16) {
+	b.b = append(b.b, byte(v>>8), byte(v))
+}
+
+func (b *builder) bytes(v []byte) {
+	b.b = append(b.b, v...)
+}
+
+// prefixed writes what f adds, preceded by its length in n bytes.
+func (b *builder) prefixed(n int, f func(*builder)) {
+	inner := new(builder)
+	f(inner)
+	length := len(inner.b)
+	for i := n - 1; i >= 0; i-- {
+		b.b = append(b.b, byte(length>>(8*uint(i))))
+	}
+	b.b = append(b.b, inner.b...)
+}
@@ -0,0 +1,174 @@
+package pkitlsscan
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"testing"
+)
+
+// serverHelloBody returns a ServerHello body with a 32 byte session id.
+// A nil exts leaves out the extensions block.
+func serverHelloBody(version uint16, random []byte, cipher uint16, exts []extension) []byte {
+	b := new(builder)
+	b.u16(version)
+	b.bytes(random)
+	b.prefixed(1, func(b *builder) { b.bytes(make([]byte, 32)) })
+	b.u16(cipher)
+	b.u8(0)
+	if exts != nil {
+		b.prefixed(2, func(b *builder) {
+			for _, e := range exts {
+				b.u16(e.id)
+				b.prefixed(2, func(b *builder) { b.bytes(e.data) })
+			}
+		})
+	}
+	return b.b
+}
+
+func TestParseServerHello(t *testing.T) {
+	random := bytes.Repeat([]byte{0xab}, 32)
+	tls13 := []extension{{id: extSupportedVersions, data: []byte{0x03, 0x04}}, {id: extKeyShare, data: []byte{0, 29, 0, 0}}}
+	renegotiation := []extension{{id: extRenegotiationInfo, data: []byte{0}}}
+	truncated := serverHelloBody(VersionTLS12, random, 0xC02F, renegotiation)
+
+	tests := []struct {
+		name       string
+		body       []byte
+		version    uint16
+		cipher     uint16
+		retry      bool
+		extensions []uint16
+		err        bool
+	}{
+		{name: "TLS 1.2", body: serverHelloBody(VersionTLS12, random, 0xC02F, renegotiation), version: VersionTLS12, cipher: 0xC02F, extensions: []uint16{extRenegotiationInfo}},
+		{name: "no extensions", body: serverHelloBody(VersionTLS10, random, 0x002F, nil), version: VersionTLS10, cipher: 0x002F},
+		{name: "TLS 1.3", body: serverHelloBody(VersionTLS12, random, 0x1301, tls13), version: VersionTLS13, cipher: 0x1301, extensions: []uint16{extSupportedVersions, extKeyShare}},
+		{name: "HelloRetryRequest", body: serverHelloBody(VersionTLS12, helloRetryRequest, 0x1301, tls13), version: VersionTLS13, cipher: 0x1301, retry: true, extensions: []uint16{extSupportedVersions, extKeyShare}},
+		{name: "short", body: truncated[:37], err: true},
+		{name: "truncated extension", body: truncated[:len(truncated)-1], err: true},
+	}
+	for _, tt := range tests {
+		sh, err := parseServerHello(tt.body)
+		if tt.err {
+			if err == nil {
+				t.Errorf("%s: got no error", tt.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if sh.version != tt.version || sh.cipher != tt.cipher || sh.retry != tt.retry {
+			t.Errorf("%s: got %s %s retry %v, want %s %s retry %v", tt.name, VersionName(sh.version), CipherName(sh.cipher), sh.retry, VersionName(tt.version), CipherName(tt.cipher), tt.retry)
+		}
+		if len(sh.extensions) != len(tt.extensions) {
+			t.Errorf("%s: got %d extensions, want %d", tt.name, len(sh.extensions), len(tt.extensions))
+		}
+		for _, id := range tt.extensions {
+			if _, ok := sh.extensions[id]; !ok {
+				t.Errorf("%s: extension %d missing", tt.name, id)
+			}
+		}
+	}
+}
+
+// TestClientHelloPadding checks no hello is sent with a handshake message
+// of 256 to 511 bytes.
+func TestClientHelloPadding(t *testing.T) {
+	for n := 0; n < 200; n++ {
+		h := newHello(VersionTLS12, legacyCiphers[:n], "www.example.com")
+		rec := h.marshal()
+		if rec[0] != recordHandshake || int(binary.BigEndian.Uint16(rec[3:5])) != len(rec)-5 {
+			t.Fatalf("%d ciphers: invalid record header % x", n, rec[:5])
+		}
+		if length := len(rec) - 5; length >= 256 && length < 512 {
+			t.Errorf("%d ciphers: handshake message of %d bytes", n, length)
+		}
+	}
+
+	rec := newHello(VersionSSL30, legacyCiphers[:10], "www.example.com").marshal()
+	if binary.BigEndian.Uint16(rec[1:3]) != VersionSSL30 {
+		t.Errorf("SSLv3: got record version %x", rec[1:3])
+	}
+	// 4 byte header, version, random, empty session id, ciphers and
+	// compression methods, without extensions.
+	if want := 4 + 2 + 32 + 1 + 2 + 20 + 2; len(rec)-5 != want {
+		t.Errorf("SSLv3: got %d bytes, want %d without extensions", len(rec)-5, want)
+	}
+}
+
+func TestReadMessage(t *testing.T) {
+	hello := serverHelloBody(VersionTLS12, bytes.Repeat([]byte{1}, 32), 0xC02F, []extension{})
+	message := append([]byte{typeServerHello, 0, 0, byte(len(hello))}, hello...)
+	done := []byte{typeServerHelloDone, 0, 0, 0}
+
+	tests := []struct {
+		name    string
+		records [][]byte
+		types   []byte
+		err     string
+	}{
+		{
+			name:    "split over records",
+			records: [][]byte{record(recordHandshake, VersionTLS12, message[:10]), record(recordHandshake, VersionTLS12, append(message[10:], done...))},
+			types:   []byte{typeServerHello, typeServerHelloDone},
+		},
+		{
+			name:    "change cipher spec skipped",
+			records: [][]byte{record(recordChangeCipherSpec, VersionTLS12, []byte{1}), record(recordHandshake, VersionTLS12, done)},
+			types:   []byte{typeServerHelloDone},
+		},
+		{
+			name:    "alert",
+			records: [][]byte{record(recordAlert, VersionTLS12, []byte{2, 40})},
+			err:     "alert handshake_failure",
+		},
+		{
+			name:    "not TLS",
+			records: [][]byte{[]byte("HTTP/1.1 400 Bad Request\r\n")},
+			err:     "not a TLS record",
+		},
+	}
+	for _, tt := range tests {
+		client, server := net.Pipe()
+		go func() {
+			for _, r := range tt.records {
+				server.Write(r)
+			}
+			server.Close()
+		}()
+		c := &conn{Conn: client}
+		for _, want := range tt.types {
+			msgType, _, err := c.readMessage()
+			if err != nil || msgType != want {
+				t.Errorf("%s: got type %d (%v), want %d", tt.name, msgType, err, want)
+			}
+		}
+		if tt.err != "" {
+			if _, _, err := c.readMessage(); err == nil || err.Error() != tt.err {
+				t.Errorf("%s: got error %v, want %q", tt.name, err, tt.err)
+			}
+		}
+		client.Close()
+	}
+}
+
+func TestAlertError(t *testing.T) {
+	tests := []struct {
+		description byte
+		want        string
+	}{
+		{40, "alert handshake_failure"},
+		{70, "alert protocol_version"},
+		{86, "alert inappropriate_fallback"},
+		{200, "alert 200"},
+	}
+	for _, tt := range tests {
+		if got := (alertError{level: 2, description: tt.description}).Error(); got != tt.want {
+			t.Errorf("%d: got %q, want %q", tt.description, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package pkitlsscan
+
+import "testing"
+
+func TestNames(t *testing.T) {
+	tests := []struct {
+		got  string
+		want string
+	}{
+		{CipherName(0xC02F), "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"},
+		{CipherName(0x1301), "TLS_AES_128_GCM_SHA256"},
+		{CipherName(0xabcd), "0xABCD"},
+		{GroupName(29), "x25519"},
+		{GroupName(23), "secp256r1"},
+		{GroupName(9999), "9999"},
+		{SignatureAlgorithmName(0x0804), "rsa_pss_rsae_sha256"},
+		{SignatureAlgorithmName(0x0403), "ecdsa_secp256r1_sha256"},
+		{SignatureAlgorithmName(0xabcd), "0xabcd"},
+		{VersionName(VersionSSL30), "SSLv3"},
+		{VersionName(VersionTLS13), "TLSv1.3"},
+		{VersionName(0x7f1c), "0x7f1c"},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("got %q, want %q", tt.got, tt.want)
+		}
+	}
+}
+
+func TestCipherLists(t *testing.T) {
+	for _, id := range legacyCiphers {
+		if id>>8 == 0x13 {
+			t.Errorf("legacy ciphers contain TLS 1.3 cipher %s", CipherName(id))
+		}
+	}
+	for _, id := range tls13Ciphers {
+		if id>>8 != 0x13 {
+			t.Errorf("TLS 1.3 ciphers contain %s", CipherName(id))
+		}
+	}
+	for i := 1; i < len(allGroups); i++ {
+		if allGroups[i-1] >= allGroups[i] {
+			t.Errorf("groups not sorted at %d", i)
+		}
+	}
+}
+
+func TestSKESignatureAlgorithm(t *testing.T) {
+	ecdhe := []byte{3, 0, 29, 2, 0xaa, 0xbb, 0x08, 0x04}
+	dhe := []byte{0, 1, 0xff, 0, 1, 2, 0, 2, 0xaa, 0xbb, 0x04, 0x01}
+
+	tests := []struct {
+		name   string
+		cipher uint16
+		ske    []byte
+		want   uint16
+		ok     bool
+	}{
+		{"ECDHE", 0xC02F, ecdhe, 0x0804, true},
+		{"ECDHE truncated", 0xC02F, ecdhe[:5], 0, false},
+		{"DHE", 0x009E, dhe, 0x0401, true},
+		{"DHE truncated", 0x009E, dhe[:7], 0, false},
+		{"RSA", 0x002F, ecdhe, 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := skeSignatureAlgorithm(tt.cipher, tt.ske)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("%s: got %#04x %v, want %#04x %v", tt.name, got, ok, tt.want, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,315 @@
+package pkitlsscan
+
+import (
+	"encoding/binary"
+	"errors"
+	"strings"
+	"time"
+
+	"golang.org/x/net/idna"
+)
+
+// Report struct with the protocol versions, cipher suites, groups and
+// signature algorithms of a TLS server
+type Report struct {
+	FQDN                string     `json:"fqdn,omitempty"`
+	Port                int        `json:"port,omitempty"`
+	CheckTime           time.Time  `json:"time"`
+	Versions            []*Version `json:"versions,omitempty"`
+	Groups              []string   `json:"groups,omitempty"`
+	SignatureAlgorithms []string   `json:"signaturealgorithms,omitempty"`
+	Error               string     `json:"error,omitempty"`
+	ErrorMessage        string     `json:"errormessage,omitempty"`
+
+	host string
+}
+
+// Version struct with the cipher suites of one protocol version. The
+// ciphers are in the order of the server when it has a preference.
+type Version struct {
+	Version          string    `json:"version"`
+	Supported        bool      `json:"supported"`
+	ServerPreference bool      `json:"serverpreference"`
+	Ciphers          []*Cipher `json:"ciphers,omitempty"`
+
+	id uint16
+}
+
+// Cipher struct for a cipher suite
+type Cipher struct {
+	ID   uint16 `json:"id"`
+	Name string `json:"name"`
+}
+
+// Timeout is the timeout of one handshake.
+var Timeout = 5 * time.Second
+
+// Versions are the protocol versions the scan tries, oldest first.
+var Versions = []uint16{VersionSSL30, VersionTLS10, VersionTLS11, VersionTLS12, VersionTLS13}
+
+// Get function of this package to scan a TLS server. Every probe is a
+// separate connection that ends after the ServerHello, or after the
+// ServerKeyExchange for groups and signature algorithms. Signature
+// algorithms are those of TLS 1.2, TLS 1.3 sends them encrypted.
+func Get(fqdn string, port int) *Report {
+	r := new(Report)
+	r.FQDN = fqdn
+	r.Port = port
+	r.CheckTime = time.Now()
+
+	// Valid server name (ASCII or IDN)
+	host, err := idna.ToASCII(fqdn)
+	if err != nil {
+		r.Error = "Failed"
+		r.ErrorMessage = err.Error()
+		return r
+	}
+	r.host = host
+
+	c, err := dial(host, port)
+	if err != nil {
+		r.Error = "Failed"
+		r.ErrorMessage = err.Error()
+		return r
+	}
+	c.Close()
+
+	supported := false
+	for _, version := range Versions {
+		v := r.scanVersion(version)
+		supported = supported || v.Supported
+		r.Versions = append(r.Versions, v)
+	}
+	if !supported {
+		r.Error = "Failed"
+		r.ErrorMessage = "No SSL or TLS version supported."
+		return r
+	}
+
+	for _, g := range r.scanGroups() {
+		r.Groups = append(r.Groups, GroupName(g))
+	}
+	for _, s := range r.scanSignatureAlgorithms() {
+		r.SignatureAlgorithms = append(r.SignatureAlgorithms, SignatureAlgorithmName(s))
+	}
+	return r
+}
+
+/*
+ * Used functions
+ */
+
+// scanVersion offers all cipher suites and removes the one the server
+// picks until it picks none. Offering the first two in reverse tells
+// whether the server or the client order counts.
+func (r *Report) scanVersion(version uint16) *Version {
+	v := &Version{Version: VersionName(version), id: version}
+
+	offered := legacyCiphers
+	if version == VersionTLS13 {
+		offered = tls13Ciphers
+	}
+	remaining := append([]uint16(nil), offered...)
+	for len(remaining) > 0 {
+		sh, err := r.hello(newHello(version, remaining, r.host))
+		if err != nil || sh.version != version || !contains(remaining, sh.cipher) {
+			break
+		}
+		v.Ciphers = append(v.Ciphers, &Cipher{ID: sh.cipher, Name: CipherName(sh.cipher)})
+		remaining = remove(remaining, sh.cipher)
+	}
+	v.Supported = len(v.Ciphers) > 0
+
+	if len(v.Ciphers) > 1 {
+		first, second := v.Ciphers[0].ID, v.Ciphers[1].ID
+		sh, err := r.hello(newHello(version, []uint16{second, first}, r.host))
+		v.ServerPreference = err == nil && sh.cipher == first
+	}
+	return v
+}
+
+// scanGroups offers one group at a time. With TLS 1.3 the hello has no
+// key share, so the server names the group in a HelloRetryRequest. Older
+// versions name the curve in the ECDHE ServerKeyExchange.
+func (r *Report) scanGroups() []uint16 {
+	found := make(map[uint16]bool)
+
+	if v := r.version(VersionTLS13); v != nil && v.Supported {
+		for _, g := range allGroups {
+			h := newHello(VersionTLS13, tls13Ciphers, r.host)
+			h.groups = []uint16{g}
+			h.keyShares = nil
+			sh, err := r.hello(h)
+			if err != nil || sh.version != VersionTLS13 {
+				continue
+			}
+			if share := sh.extensions[extKeyShare]; len(share) >= 2 && binary.BigEndian.Uint16(share) == g {
+				found[g] = true
+			}
+		}
+	}
+
+	if version, ciphers := r.kexCiphers("_ECDHE_"); ciphers != nil {
+		for _, g := range ecGroups {
+			if found[g] {
+				continue
+			}
+			h := newHello(version, ciphers, r.host)
+			h.groups = []uint16{g}
+			_, ske, err := r.serverKeyExchange(h)
+			// ECParameters with curve_type named_curve (3)
+			if err == nil && len(ske) >= 3 && ske[0] == 3 && binary.BigEndian.Uint16(ske[1:3]) == g {
+				found[g] = true
+			}
+		}
+	}
+
+	var groups []uint16
+	for _, g := range allGroups {
+		if found[g] {
+			groups = append(groups, g)
+		}
+	}
+	return groups
+}
+
+// scanSignatureAlgorithms offers one signature algorithm at a time with
+// TLS 1.2 and reads the one that signed the ServerKeyExchange.
+func (r *Report) scanSignatureAlgorithms() []uint16 {
+	v := r.version(VersionTLS12)
+	if v == nil || !v.Supported {
+		return nil
+	}
+	var ciphers []uint16
+	for _, c := range v.Ciphers {
+		if strings.Contains(c.Name, "_ECDHE_") || strings.Contains(c.Name, "_DHE_") {
+			ciphers = append(ciphers, c.ID)
+		}
+	}
+	if ciphers == nil {
+		return nil
+	}
+
+	var algorithms []uint16
+	for _, s := range allSignatureAlgorithms {
+		h := newHello(VersionTLS12, ciphers, r.host)
+		h.signatureAlgorithms = []uint16{s}
+		sh, ske, err := r.serverKeyExchange(h)
+		if err != nil {
+			continue
+		}
+		if used, ok := skeSignatureAlgorithm(sh.cipher, ske); ok && used == s {
+			algorithms = append(algorithms, s)
+		}
+	}
+	return algorithms
+}
+
+func (r *Report) version(id uint16) *Version {
+	for _, v := range r.Versions {
+		if v.id == id {
+			return v
+		}
+	}
+	return nil
+}
+
+// kexCiphers returns the newest version below TLS 1.3 with supported
+// cipher suites of a key exchange, and those cipher suites.
+func (r *Report) kexCiphers(kex string) (uint16, []uint16) {
+	for i := len(r.Versions) - 1; i >= 0; i-- {
+		v := r.Versions[i]
+		if v.id == VersionTLS13 || v.id == VersionSSL30 {
+			continue
+		}
+		var ciphers []uint16
+		for _, c := range v.Ciphers {
+			if strings.Contains(c.Name, kex) {
+				ciphers = append(ciphers, c.ID)
+			}
+		}
+		if ciphers != nil {
+			return v.id, ciphers
+		}
+	}
+	return 0, nil
+}
+
+// hello sends h and returns the ServerHello.
+func (r *Report) hello(h *clientHello) (*serverHello, error) {
+	sh, c, err := handshake(r.host, r.Port, h)
+	if err != nil {
+		return nil, err
+	}
+	c.Close()
+	return sh, nil
+}
+
+// serverKeyExchange sends h and returns the ServerHello and the body of
+// the ServerKeyExchange.
+func (r *Report) serverKeyExchange(h *clientHello) (*serverHello, []byte, error) {
+	sh, c, err := handshake(r.host, r.Port, h)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer c.Close()
+	if sh.version != h.version {
+		return nil, nil, errors.New("server chose " + VersionName(sh.version))
+	}
+	ske, err := c.readUntil(typeServerKeyExchange)
+	if err != nil {
+		return nil, nil, err
+	}
+	if ske == nil {
+		return nil, nil, errors.New("no ServerKeyExchange")
+	}
+	return sh, ske, nil
+}
+
+// skeSignatureAlgorithm returns the signature algorithm of a TLS 1.2
+// ECDHE or DHE ServerKeyExchange, which follows the key exchange params.
+func skeSignatureAlgorithm(cipher uint16, ske []byte) (uint16, bool) {
+	name := CipherName(cipher)
+	offset := 0
+	switch {
+	case strings.Contains(name, "_ECDHE_"):
+		// curve_type, named_curve and the point
+		if len(ske) < 4 {
+			return 0, false
+		}
+		offset = 4 + int(ske[3])
+	case strings.Contains(name, "_DHE_"):
+		// dh_p, dh_g and dh_Ys
+		for i := 0; i < 3; i++ {
+			if len(ske) < offset+2 {
+				return 0, false
+			}
+			offset += 2 + int(binary.BigEndian.Uint16(ske[offset:]))
+		}
+	default:
+		return 0, false
+	}
+	if len(ske) < offset+2 {
+		return 0, false
+	}
+	return binary.BigEndian.Uint16(ske[offset:]), true
+}
+
+func contains(ids []uint16, id uint16) bool {
+	for _, i := range ids {
+		if i == id {
+			return true
+		}
+	}
+	return false
+}
+
+func remove(ids []uint16, id uint16) []uint16 {
+	var out []uint16
+	for _, i := range ids {
+		if i != id {
+			out = append(out, i)
+		}
+	}
+	return out
+}
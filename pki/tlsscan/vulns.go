@@ -0,0 +1,487 @@
+package pkitlsscan
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"errors"
+	"io"
+	"math/big"
+	"net"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Vulnerabilities struct with the weaknesses of a TLS server
+type Vulnerabilities struct {
+	FQDN         string     `json:"fqdn,omitempty"`
+	Port         int        `json:"port,omitempty"`
+	CheckTime    time.Time  `json:"time"`
+	Vulnerable   bool       `json:"vulnerable"`
+	Findings     []*Finding `json:"findings,omitempty"`
+	Error        string     `json:"error,omitempty"`
+	ErrorMessage string     `json:"errormessage,omitempty"`
+}
+
+// Finding struct for one check. Severity is the severity when the server
+// is vulnerable, Evidence tells what the server did.
+type Finding struct {
+	ID         string `json:"id"`
+	Name       string `json:"name"`
+	Severity   string `json:"severity"`
+	Vulnerable bool   `json:"vulnerable"`
+	Evidence   string `json:"evidence,omitempty"`
+}
+
+// Severities of findings
+const (
+	SeverityCritical = "critical"
+	SeverityHigh     = "high"
+	SeverityMedium   = "medium"
+	SeverityLow      = "low"
+)
+
+// Checks
+const (
+	CheckRenegotiation        = "insecure-renegotiation"
+	CheckCompression          = "compression"
+	CheckHeartbleed           = "heartbleed"
+	CheckROBOT                = "robot"
+	CheckExportCiphers        = "export-ciphers"
+	CheckNULLCiphers          = "null-ciphers"
+	CheckAnonymousCiphers     = "anonymous-ciphers"
+	CheckRC4Ciphers           = "rc4-ciphers"
+	CheckDESCiphers           = "des-ciphers"
+	CheckFallbackSCSV         = "fallback-scsv"
+	CheckExtendedMasterSecret = "extended-master-secret"
+)
+
+// GetVulnerabilities function of this package to scan a TLS server and
+// check it for well known weaknesses.
+func GetVulnerabilities(fqdn string, port int) *Vulnerabilities {
+	return CheckVulnerabilities(Get(fqdn, port))
+}
+
+// CheckVulnerabilities checks the server of a scan report. The cipher
+// suite checks use the report, the other checks send crafted handshakes.
+func CheckVulnerabilities(r *Report) *Vulnerabilities {
+	v := new(Vulnerabilities)
+	v.FQDN = r.FQDN
+	v.Port = r.Port
+	v.CheckTime = time.Now()
+	if r.Error != "" {
+		v.Error = r.Error
+		v.ErrorMessage = r.ErrorMessage
+		return v
+	}
+
+	v.Findings = []*Finding{
+		r.checkRenegotiation(),
+		r.checkCompression(),
+		r.checkHeartbleed(),
+		r.checkROBOT(),
+		r.checkCiphers(CheckExportCiphers, "Export cipher suites", SeverityHigh, "EXPORT"),
+		r.checkCiphers(CheckNULLCiphers, "NULL cipher suites", SeverityHigh, "_NULL_"),
+		r.checkCiphers(CheckAnonymousCiphers, "Anonymous cipher suites", SeverityHigh, "_anon_"),
+		r.checkCiphers(CheckRC4Ciphers, "RC4 cipher suites", SeverityMedium, "_RC4_"),
+		r.checkCiphers(CheckDESCiphers, "DES and 3DES cipher suites (SWEET32)", SeverityLow, "DES"),
+		r.checkFallbackSCSV(),
+		r.checkExtendedMasterSecret(),
+	}
+	for _, f := range v.Findings {
+		v.Vulnerable = v.Vulnerable || f.Vulnerable
+	}
+	return v
+}
+
+/*
+ * Used functions
+ */
+
+// legacy returns the newest supported version below TLS 1.3, the checks
+// of the handshake before TLS 1.3 use it.
+func (r *Report) legacy() *Version {
+	for i := len(r.Versions) - 1; i >= 0; i-- {
+		v := r.Versions[i]
+		if v.id != VersionTLS13 && v.Supported {
+			return v
+		}
+	}
+	return nil
+}
+
+func (v *Version) cipherIDs() []uint16 {
+	var ids []uint16
+	for _, c := range v.Ciphers {
+		ids = append(ids, c.ID)
+	}
+	return ids
+}
+
+// legacyHello returns a hello for the legacy version, or a finding that
+// the check does not apply.
+func (r *Report) legacyHello(f *Finding) (*clientHello, bool) {
+	v := r.legacy()
+	if v == nil {
+		f.Evidence = "Only TLSv1.3 is supported."
+		return nil, false
+	}
+	return newHello(v.id, v.cipherIDs(), r.host), true
+}
+
+// checkRenegotiation offers secure renegotiation (RFC 5746) with the SCSV.
+// A server that supports it answers with the renegotiation_info extension.
+func (r *Report) checkRenegotiation() *Finding {
+	f := &Finding{ID: CheckRenegotiation, Name: "Insecure renegotiation", Severity: SeverityMedium}
+	h, ok := r.legacyHello(f)
+	if !ok {
+		return f
+	}
+	h.ciphers = append(h.ciphers, scsvRenegotiation)
+	sh, err := r.hello(h)
+	if err != nil {
+		f.Evidence = "Handshake failed: " + err.Error()
+		return f
+	}
+	if _, ok := sh.extensions[extRenegotiationInfo]; !ok {
+		f.Vulnerable = true
+		f.Evidence = "The " + VersionName(sh.version) + " ServerHello has no renegotiation_info extension."
+		return f
+	}
+	f.Evidence = "The server supports secure renegotiation."
+	return f
+}
+
+// checkCompression offers DEFLATE, which makes CRIME possible.
+func (r *Report) checkCompression() *Finding {
+	f := &Finding{ID: CheckCompression, Name: "TLS compression (CRIME)", Severity: SeverityMedium}
+	h, ok := r.legacyHello(f)
+	if !ok {
+		return f
+	}
+	h.compression = []byte{1, 0}
+	sh, err := r.hello(h)
+	if err != nil {
+		f.Evidence = "Handshake failed: " + err.Error()
+		return f
+	}
+	if sh.compression != 0 {
+		f.Vulnerable = true
+		f.Evidence = "The server chose compression method " + strconv.Itoa(int(sh.compression)) + "."
+		return f
+	}
+	f.Evidence = "The server chose no compression."
+	return f
+}
+
+// checkHeartbleed sends a heartbeat request that claims 16384 bytes of
+// payload and has none (CVE-2014-0160). A patched server drops it, a
+// vulnerable one echoes its memory.
+func (r *Report) checkHeartbleed() *Finding {
+	f := &Finding{ID: CheckHeartbleed, Name: "Heartbleed", Severity: SeverityCritical}
+	h, ok := r.legacyHello(f)
+	if !ok {
+		return f
+	}
+	// peer_allowed_to_send (RFC 6520 section 2)
+	h.extensions = append(h.extensions, extension{id: extHeartbeat, data: []byte{1}})
+	sh, c, err := handshake(r.host, r.Port, h)
+	if err != nil {
+		f.Evidence = "Handshake failed: " + err.Error()
+		return f
+	}
+	defer c.Close()
+	if _, ok := sh.extensions[extHeartbeat]; !ok {
+		f.Evidence = "The server does not support heartbeats."
+		return f
+	}
+	if _, err := c.readUntil(typeServerHelloDone); err != nil {
+		f.Evidence = "Handshake failed: " + err.Error()
+		return f
+	}
+
+	if _, err := c.Write(record(recordHeartbeat, sh.version, []byte{1, 0x40, 0x00})); err != nil {
+		f.Evidence = "Heartbeat request failed: " + err.Error()
+		return f
+	}
+	for {
+		contentType, payload, err := c.readRecord()
+		if err != nil {
+			f.Evidence = "No heartbeat response (" + response(0, nil, err) + ")."
+			return f
+		}
+		if contentType == recordHeartbeat {
+			if len(payload) > 3 {
+				f.Vulnerable = true
+				f.Evidence = "The server returned " + strconv.Itoa(len(payload)) + " bytes for an empty heartbeat request."
+				return f
+			}
+			f.Evidence = "The server answered the heartbeat request without payload."
+			return f
+		}
+		if contentType == recordAlert {
+			f.Evidence = "The server answered with " + response(contentType, payload, nil) + "."
+			return f
+		}
+	}
+}
+
+// robotVariants are the premaster secret encodings of the ROBOT test: a
+// valid one and ones with a broken PKCS #1 v1.5 padding.
+var robotVariants = []string{
+	"valid padding",
+	"wrong first bytes",
+	"no zero separator",
+	"zero separator at wrong position",
+	"wrong version in premaster secret",
+}
+
+// checkROBOT sends a ClientKeyExchange with each variant followed by a
+// garbage Finished. When the server answers the variants differently it
+// is a Bleichenbacher oracle. The round is repeated, only consistent
+// differences count.
+func (r *Report) checkROBOT() *Finding {
+	f := &Finding{ID: CheckROBOT, Name: "ROBOT (Bleichenbacher oracle)", Severity: SeverityHigh}
+	version, ciphers := r.kexCiphers("TLS_RSA_WITH_")
+	if ciphers == nil {
+		f.Evidence = "No RSA key exchange cipher suites."
+		return f
+	}
+
+	var rounds [2][]string
+	for round := range rounds {
+		for variant := range robotVariants {
+			reply, err := r.robotAttempt(version, ciphers, variant)
+			if err != nil {
+				f.Evidence = "Handshake failed: " + err.Error()
+				return f
+			}
+			rounds[round] = append(rounds[round], reply)
+		}
+	}
+
+	differs := false
+	for variant := range robotVariants {
+		if rounds[0][variant] != rounds[0][0] {
+			differs = true
+		}
+		if rounds[0][variant] != rounds[1][variant] {
+			f.Evidence = "Inconsistent responses, no oracle detected."
+			return f
+		}
+	}
+	var evidence []string
+	for variant, name := range robotVariants {
+		evidence = append(evidence, name+": "+rounds[0][variant])
+	}
+	f.Vulnerable = differs
+	f.Evidence = strings.Join(evidence, "; ")
+	return f
+}
+
+// robotAttempt runs one handshake up to the Finished and returns how the
+// server responded.
+func (r *Report) robotAttempt(version uint16, ciphers []uint16, variant int) (string, error) {
+	sh, c, err := handshake(r.host, r.Port, newHello(version, ciphers, r.host))
+	if err != nil {
+		return "", err
+	}
+	defer c.Close()
+	body, err := c.readUntil(typeCertificate)
+	if err != nil {
+		return "", err
+	}
+	key, err := rsaKey(body)
+	if err != nil {
+		return "", err
+	}
+	if _, err := c.readUntil(typeServerHelloDone); err != nil {
+		return "", err
+	}
+
+	encrypted := rsaEncrypt(key, robotPremaster(key.Size(), version, variant))
+	kex := new(builder)
+	kex.u8(typeClientKeyExchange)
+	kex.prefixed(3, func(b *builder) {
+		b.prefixed(2, func(b *builder) { b.bytes(encrypted) })
+	})
+	finished := make([]byte, 40)
+	rand.Read(finished)
+
+	var out []byte
+	out = append(out, record(recordHandshake, sh.version, kex.b)...)
+	out = append(out, record(recordChangeCipherSpec, sh.version, []byte{1})...)
+	out = append(out, record(recordHandshake, sh.version, finished)...)
+	if _, err := c.Write(out); err != nil {
+		return "", err
+	}
+	contentType, payload, err := c.readRecord()
+	return response(contentType, payload, err), nil
+}
+
+// robotPremaster returns the padded premaster secret of a variant for a
+// key of size bytes.
+func robotPremaster(size int, version uint16, variant int) []byte {
+	// No zero bytes, so a separator can only be where the variant puts it.
+	premaster := append([]byte{byte(version >> 8), byte(version)}, nonZero(46)...)
+
+	padding := nonZero(size - 3 - len(premaster))
+	out := []byte{0x00, 0x02}
+	switch variant {
+	case 1:
+		out = []byte{0x41, 0x17}
+	case 2:
+		return append(append(append(out, padding...), 0x11), premaster...)
+	case 3:
+		padding[len(padding)-8] = 0x00
+		return append(append(append(out, padding...), 0x11), premaster...)
+	case 4:
+		premaster[0], premaster[1] = 0x02, 0x02
+	}
+	return append(append(append(out, padding...), 0x00), premaster...)
+}
+
+func nonZero(n int) []byte {
+	b := make([]byte, n)
+	rand.Read(b)
+	for i := range b {
+		for b[i] == 0 {
+			rand.Read(b[i : i+1])
+		}
+	}
+	return b
+}
+
+// rsaKey returns the RSA key of the first certificate of a Certificate
+// message.
+func rsaKey(body []byte) (*rsa.PublicKey, error) {
+	if len(body) < 6 {
+		return nil, errors.New("invalid Certificate message")
+	}
+	n := int(body[3])<<16 | int(body[4])<<8 | int(body[5])
+	if len(body) < 6+n {
+		return nil, errors.New("invalid Certificate message")
+	}
+	cert, err := x509.ParseCertificate(body[6 : 6+n])
+	if err != nil {
+		return nil, err
+	}
+	key, ok := cert.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		return nil, errors.New("certificate has no RSA key")
+	}
+	return key, nil
+}
+
+// rsaEncrypt is textbook RSA, the padding is already in m.
+func rsaEncrypt(key *rsa.PublicKey, m []byte) []byte {
+	c := new(big.Int).Exp(new(big.Int).SetBytes(m), big.NewInt(int64(key.E)), key.N)
+	out := make([]byte, key.Size())
+	return c.FillBytes(out)
+}
+
+// response describes the first record a server sent, or why there was
+// none, without details that differ between connections.
+func response(contentType byte, payload []byte, err error) string {
+	var netErr net.Error
+	switch {
+	case err == io.EOF || errors.Is(err, io.ErrUnexpectedEOF):
+		return "connection closed"
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return "timeout"
+	case errors.Is(err, syscall.ECONNRESET):
+		return "connection reset"
+	case err != nil:
+		return "connection error"
+	case contentType == recordAlert && len(payload) >= 2:
+		return alertError{level: payload[0], description: payload[1]}.Error()
+	}
+	return "record type " + strconv.Itoa(int(contentType))
+}
+
+// checkCiphers reports the supported cipher suites with marker in their
+// name.
+func (r *Report) checkCiphers(id, name, severity, marker string) *Finding {
+	f := &Finding{ID: id, Name: name, Severity: severity}
+	var evidence []string
+	for _, v := range r.Versions {
+		var names []string
+		for _, c := range v.Ciphers {
+			if strings.Contains(c.Name, marker) {
+				names = append(names, c.Name)
+			}
+		}
+		if names != nil {
+			evidence = append(evidence, v.Version+": "+strings.Join(names, ", "))
+		}
+	}
+	if evidence == nil {
+		f.Evidence = "None supported."
+		return f
+	}
+	f.Vulnerable = true
+	f.Evidence = strings.Join(evidence, "; ")
+	return f
+}
+
+// checkFallbackSCSV connects with the version below the newest and the
+// TLS_FALLBACK_SCSV (RFC 7507). The server should refuse the downgrade
+// with an inappropriate_fallback alert.
+func (r *Report) checkFallbackSCSV() *Finding {
+	f := &Finding{ID: CheckFallbackSCSV, Name: "Missing TLS_FALLBACK_SCSV", Severity: SeverityMedium}
+	var supported []*Version
+	for _, v := range r.Versions {
+		if v.Supported {
+			supported = append(supported, v)
+		}
+	}
+	if len(supported) < 2 {
+		f.Evidence = "Only one version is supported, there is nothing to fall back to."
+		return f
+	}
+	newest, fallback := supported[len(supported)-1], supported[len(supported)-2]
+
+	h := newHello(fallback.id, append(fallback.cipherIDs(), scsvFallback), r.host)
+	sh, err := r.hello(h)
+	var alert alertError
+	if errors.As(err, &alert) && alert.description == 86 {
+		f.Evidence = "The server refused a fallback to " + fallback.Version + " with inappropriate_fallback."
+		return f
+	}
+	if err != nil {
+		f.Evidence = "Handshake failed: " + err.Error()
+		return f
+	}
+	f.Vulnerable = true
+	f.Evidence = "The server supports " + newest.Version + " and accepted a fallback to " + VersionName(sh.version) + "."
+	return f
+}
+
+// checkExtendedMasterSecret offers the extended master secret (RFC 7627).
+func (r *Report) checkExtendedMasterSecret() *Finding {
+	f := &Finding{ID: CheckExtendedMasterSecret, Name: "Missing extended master secret", Severity: SeverityLow}
+	h, ok := r.legacyHello(f)
+	if !ok {
+		return f
+	}
+	if h.noExtensions {
+		f.Vulnerable = true
+		f.Evidence = "SSLv3 has no extensions."
+		return f
+	}
+	h.extensions = append(h.extensions, extension{id: extExtendedMasterSecret})
+	sh, err := r.hello(h)
+	if err != nil {
+		f.Evidence = "Handshake failed: " + err.Error()
+		return f
+	}
+	if _, ok := sh.extensions[extExtendedMasterSecret]; !ok {
+		f.Vulnerable = true
+		f.Evidence = "The " + VersionName(sh.version) + " ServerHello has no extended_master_secret extension."
+		return f
+	}
+	f.Evidence = "The server supports the extended master secret."
+	return f
+}
@@ -0,0 +1,270 @@
+package pkitlsscan
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"math/big"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeServer answers ClientHellos with a ServerHello, Certificate and
+// ServerHelloDone for TLS 1.0 to 1.2 and the first of its cipher suites
+// the client offers. By default it is hardened, every other field turns
+// on one misconfiguration.
+type fakeServer struct {
+	key  *rsa.PrivateKey
+	cert []byte
+
+	noRenegotiationInfo bool // no renegotiation_info in the ServerHello
+	compression         bool // picks DEFLATE when offered
+	heartbeat           bool // supports heartbeats, drops malformed requests
+	heartbleed          bool // supports heartbeats and leaks memory
+	oracle              bool // alerts decrypt_error for a broken padding only
+	noFallbackSCSV      bool // accepts a TLS_FALLBACK_SCSV downgrade
+	noEMS               bool // no extended_master_secret in the ServerHello
+}
+
+// rsaCertificate returns an RSA key with a self-signed certificate.
+func rsaCertificate(t *testing.T) (*rsa.PrivateKey, []byte) {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key, der
+}
+
+// listen runs the server on localhost and returns its port.
+func (f *fakeServer) listen(t *testing.T) int {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(&conn{Conn: c})
+		}
+	}()
+	return l.Addr().(*net.TCPAddr).Port
+}
+
+// clientHelloFields holds what the fake server looks at in a ClientHello.
+type clientHelloFields struct {
+	version     uint16
+	ciphers     []uint16
+	compression []byte
+	extensions  map[uint16]bool
+}
+
+func parseClientHello(body []byte) (*clientHelloFields, bool) {
+	h := &clientHelloFields{extensions: make(map[uint16]bool)}
+	ok := true
+	// next returns the next block with a prefix of n bytes.
+	next := func(n int) []byte {
+		if len(body) < n {
+			ok = false
+			return nil
+		}
+		length := 0
+		for _, b := range body[:n] {
+			length = length<<8 | int(b)
+		}
+		if len(body) < n+length {
+			ok = false
+			return nil
+		}
+		block := body[n : n+length]
+		body = body[n+length:]
+		return block
+	}
+
+	if len(body) < 34 {
+		return nil, false
+	}
+	h.version = binary.BigEndian.Uint16(body)
+	body = body[34:]
+	next(1) // session id
+	ciphers := next(2)
+	for i := 0; i+1 < len(ciphers); i += 2 {
+		h.ciphers = append(h.ciphers, binary.BigEndian.Uint16(ciphers[i:]))
+	}
+	h.compression = next(1)
+	if len(body) == 0 {
+		// SSLv3 hellos have no extensions.
+		return h, ok
+	}
+	body = next(2)
+	for ok && len(body) >= 2 {
+		h.extensions[binary.BigEndian.Uint16(body)] = true
+		body = body[2:]
+		next(2) // extension data
+	}
+	return h, ok
+}
+
+func (f *fakeServer) serve(c *conn) {
+	defer c.Close()
+	c.SetDeadline(time.Now().Add(Timeout))
+	alert := func(description byte) {
+		c.Write(record(recordAlert, VersionTLS10, []byte{2, description}))
+	}
+
+	msgType, body, err := c.readMessage()
+	if err != nil || msgType != typeClientHello {
+		return
+	}
+	h, ok := parseClientHello(body)
+	if !ok {
+		alert(50)
+		return
+	}
+	version := h.version
+	if version < VersionTLS10 {
+		alert(70)
+		return
+	}
+	if version > VersionTLS12 {
+		version = VersionTLS12
+	}
+	if version < VersionTLS12 && contains(h.ciphers, scsvFallback) && !f.noFallbackSCSV {
+		alert(86)
+		return
+	}
+	if !contains(h.ciphers, 0x002F) {
+		alert(40)
+		return
+	}
+	var compression byte
+	if f.compression && strings.IndexByte(string(h.compression), 1) >= 0 {
+		compression = 1
+	}
+	var extensions []extension
+	if (contains(h.ciphers, scsvRenegotiation) || h.extensions[extRenegotiationInfo]) && !f.noRenegotiationInfo {
+		extensions = append(extensions, extension{id: extRenegotiationInfo, data: []byte{0}})
+	}
+	if h.extensions[extExtendedMasterSecret] && !f.noEMS {
+		extensions = append(extensions, extension{id: extExtendedMasterSecret})
+	}
+	heartbeat := h.extensions[extHeartbeat] && (f.heartbeat || f.heartbleed)
+	if heartbeat {
+		extensions = append(extensions, extension{id: extHeartbeat, data: []byte{1}})
+	}
+
+	b := new(builder)
+	handshakeMessage(b, typeServerHello, func(b *builder) {
+		b.u16(version)
+		b.bytes(make([]byte, 32))
+		b.prefixed(1, func(b *builder) {})
+		b.u16(0x002F)
+		b.u8(compression)
+		b.prefixed(2, func(b *builder) {
+			for _, e := range extensions {
+				b.u16(e.id)
+				b.prefixed(2, func(b *builder) { b.bytes(e.data) })
+			}
+		})
+	})
+	handshakeMessage(b, typeCertificate, func(b *builder) {
+		b.prefixed(3, func(b *builder) {
+			b.prefixed(3, func(b *builder) { b.bytes(f.cert) })
+		})
+	})
+	handshakeMessage(b, typeServerHelloDone, func(b *builder) {})
+	c.Write(record(recordHandshake, version, b.b))
+
+	for {
+		contentType, payload, err := c.readRecord()
+		if err != nil {
+			return
+		}
+		switch {
+		case contentType == recordHeartbeat && heartbeat:
+			if !f.heartbleed {
+				return
+			}
+			c.Write(record(recordHeartbeat, version, append([]byte{2, 0x40, 0x00}, make([]byte, 1024)...)))
+		case contentType == recordHandshake && len(payload) > 6 && payload[0] == typeClientKeyExchange:
+			m := new(big.Int).Exp(new(big.Int).SetBytes(payload[6:]), f.key.D, f.key.N).FillBytes(make([]byte, f.key.Size()))
+			if f.oracle && (m[0] != 0x00 || m[1] != 0x02) {
+				alert(51)
+			} else {
+				alert(20)
+			}
+			return
+		}
+	}
+}
+
+func handshakeMessage(b *builder, msgType byte, body func(*builder)) {
+	b.u8(msgType)
+	b.prefixed(3, body)
+}
+
+func TestChecks(t *testing.T) {
+	key, cert := rsaCertificate(t)
+	cipher := []*Cipher{{ID: 0x002F, Name: CipherName(0x002F)}}
+	tls13 := []*Version{{Version: "TLSv1.3", Supported: true, id: VersionTLS13}}
+
+	tests := []struct {
+		name       string
+		server     fakeServer
+		versions   []*Version
+		check      func(*Report) *Finding
+		vulnerable bool
+		evidence   string
+	}{
+		{name: "secure renegotiation", check: (*Report).checkRenegotiation, evidence: "The server supports secure renegotiation."},
+		{name: "insecure renegotiation", server: fakeServer{noRenegotiationInfo: true}, check: (*Report).checkRenegotiation, vulnerable: true, evidence: "The TLSv1.2 ServerHello has no renegotiation_info extension."},
+		{name: "renegotiation with TLS 1.3 only", versions: tls13, check: (*Report).checkRenegotiation, evidence: "Only TLSv1.3 is supported."},
+		{name: "no compression", check: (*Report).checkCompression, evidence: "The server chose no compression."},
+		{name: "compression", server: fakeServer{compression: true}, check: (*Report).checkCompression, vulnerable: true, evidence: "The server chose compression method 1."},
+		{name: "no heartbeats", check: (*Report).checkHeartbleed, evidence: "The server does not support heartbeats."},
+		{name: "patched heartbeats", server: fakeServer{heartbeat: true}, check: (*Report).checkHeartbleed, evidence: "No heartbeat response (connection closed)."},
+		{name: "Heartbleed", server: fakeServer{heartbleed: true}, check: (*Report).checkHeartbleed, vulnerable: true, evidence: "The server returned 1027 bytes for an empty heartbeat request."},
+		{name: "no oracle", check: (*Report).checkROBOT, evidence: "valid padding: alert bad_record_mac; wrong first bytes: alert bad_record_mac;"},
+		{name: "ROBOT", server: fakeServer{oracle: true}, check: (*Report).checkROBOT, vulnerable: true, evidence: "valid padding: alert bad_record_mac; wrong first bytes: alert decrypt_error;"},
+		{name: "ROBOT without RSA key exchange", versions: tls13, check: (*Report).checkROBOT, evidence: "No RSA key exchange cipher suites."},
+		{name: "fallback refused", check: (*Report).checkFallbackSCSV, evidence: "The server refused a fallback to TLSv1.1 with inappropriate_fallback."},
+		{name: "fallback accepted", server: fakeServer{noFallbackSCSV: true}, check: (*Report).checkFallbackSCSV, vulnerable: true, evidence: "The server supports TLSv1.2 and accepted a fallback to TLSv1.1."},
+		{name: "fallback with one version", versions: tls13, check: (*Report).checkFallbackSCSV, evidence: "Only one version is supported, there is nothing to fall back to."},
+		{name: "extended master secret", check: (*Report).checkExtendedMasterSecret, evidence: "The server supports the extended master secret."},
+		{name: "no extended master secret", server: fakeServer{noEMS: true}, check: (*Report).checkExtendedMasterSecret, vulnerable: true, evidence: "The TLSv1.2 ServerHello has no extended_master_secret extension."},
+	}
+	for _, tt := range tests {
+		tt.server.key, tt.server.cert = key, cert
+		r := &Report{FQDN: "127.0.0.1", Port: tt.server.listen(t), host: "127.0.0.1", Versions: tt.versions}
+		if r.Versions == nil {
+			r.Versions = []*Version{
+				{Version: "TLSv1.1", Supported: true, Ciphers: cipher, id: VersionTLS11},
+				{Version: "TLSv1.2", Supported: true, Ciphers: cipher, id: VersionTLS12},
+			}
+		}
+		f := tt.check(r)
+		if f.Vulnerable != tt.vulnerable || !strings.HasPrefix(f.Evidence, tt.evidence) {
+			t.Errorf("%s: got %v %q, want %v %q", tt.name, f.Vulnerable, f.Evidence, tt.vulnerable, tt.evidence)
+		}
+	}
+}